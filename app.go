@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -19,6 +20,12 @@ import (
 	"github.com/wailsapp/wails/v2/pkg/runtime"
 )
 
+// Сколько OnShutdown ждет, пока очереди вернут прерванные фото и загрузки в ожидание
+const shutdownTimeout = 15 * time.Second
+
+// Сколько StopUploadQueue ждет остановки worker'ов загрузки
+const stopUploadQueueTimeout = 10 * time.Second
+
 // App struct
 type App struct {
 	ctx                context.Context
@@ -97,13 +104,32 @@ func (a *App) OnStartup(ctx context.Context) {
 		log.Printf("Warning: Failed to set temp store: %v", err)
 	}
 
+	// Ключ шифрования паролей стоков и API ключей
+	existingSecrets, err := services.HasEncryptedSecrets(db)
+	if err != nil {
+		log.Fatal("Failed to check stored secrets:", err)
+	}
+	secrets, err := services.NewSecretStore("./secret.key", existingSecrets)
+	if err != nil {
+		a.logger.LogError("Failed to initialize secret store: %v", err)
+		log.Fatal("Failed to initialize secret store:", err)
+	}
+
 	// Инициализация сервисов
-	a.dbService = services.NewDatabaseService(db)
+	a.dbService = services.NewDatabaseServiceWithSecrets(db, secrets)
 	a.aiService = services.NewAIServiceWithLogger(a.logger)
 	a.imageProc = services.NewImageProcessorWithLogger("./temp", a.logger)
 	a.uploaderManager = uploaders.NewUploaderManager(a.dbService)
 	a.queueManager = services.NewQueueManager(db, a.dbService, a.aiService, a.imageProc)
 	a.uploadQueueManager = services.NewUploadQueueManager(a.uploaderManager, a.dbService)
+	a.uploadQueueManager.SetEventEmitter(func(name string, data interface{}) {
+		runtime.EventsEmit(a.ctx, name, data)
+	})
+	a.queueManager.SetEventEmitter(func(name string, data interface{}) {
+		runtime.EventsEmit(a.ctx, name, data)
+	})
+	a.aiService.SetUsageRecorder(a.dbService.RecordAIUsage)
+	a.aiService.SetResultCache(a.dbService)
 
 	// Создание таблиц БД
 	err = a.dbService.InitializeTables()
@@ -112,10 +138,22 @@ func (a *App) OnStartup(ctx context.Context) {
 	}
 
 	// Загружаем настройки при старте
-	_, err = a.dbService.GetSettings()
+	settings, err := a.dbService.GetSettings()
 	if err != nil {
 		log.Printf("Warning: Failed to load settings on startup: %v", err)
 	}
+	a.imageProc.SetMetadataBackend(settings.MetadataBackend)
+
+	// Лимиты запросов к AI провайдерам общие для всех worker'ов
+	if err := a.loadAIRateLimits(); err != nil {
+		log.Printf("Warning: Failed to load AI rate limits: %v", err)
+	}
+
+	// Продолжаем загрузки, оставшиеся в очереди с прошлого запуска
+	err = a.uploadQueueManager.ResumePendingUploads()
+	if err != nil {
+		log.Printf("Warning: Failed to resume upload queue: %v", err)
+	}
 
 	log.Println("App initialized successfully")
 }
@@ -130,20 +168,57 @@ func (a *App) OnDomReady(ctx context.Context) {
 // either by clicking the window close button or calling runtime.Quit.
 // Returning true will cause the application to continue, false will continue shutdown as normal.
 func (a *App) OnBeforeClose(ctx context.Context) (prevent bool) {
-	a.db.Close()
+	return false
+}
+
+// OnShutdown is called during shutdown after OnBeforeClose.
+// Прерывает AI обработку и загрузки, ждет не дольше shutdownTimeout, пока незавершенные фото
+// и задачи загрузки вернутся в ожидание, и только затем закрывает базу и лог.
+func (a *App) OnShutdown(ctx context.Context) {
+	shutdownCtx, cancel := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancel()
+
+	if a.queueManager != nil {
+		if err := a.queueManager.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+	if a.uploadQueueManager != nil {
+		if err := a.uploadQueueManager.Stop(shutdownCtx); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+	}
+
+	if a.db != nil {
+		a.db.Close()
+	}
 	if a.logger != nil {
 		a.logger.Close()
 	}
-	return false
 }
 
-// OnShutdown is called during shutdown after OnBeforeClose
-func (a *App) OnShutdown(ctx context.Context) {
-	// Perform your teardown here
+// FindFolderDuplicates возвращает фото папки, которые совпадают с другим фото этой папки
+// или с фото, уже добавленным в приложение (поле Duplicate). Вызывается перед ProcessPhotoFolder.
+func (a *App) FindFolderDuplicates(folderPath string) ([]models.Photo, error) {
+	photos, err := a.imageProc.ScanFolder(folderPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan folder: %w", err)
+	}
+
+	a.queueManager.DetectDuplicates(photos)
+
+	duplicates := []models.Photo{}
+	for _, photo := range photos {
+		if photo.Duplicate != nil {
+			duplicates = append(duplicates, photo)
+		}
+	}
+	return duplicates, nil
 }
 
-// ProcessPhotoFolder - основной метод для обработки папки с фотографиями
-func (a *App) ProcessPhotoFolder(folderPath string, description string, photoType string) error {
+// ProcessPhotoFolder - основной метод для обработки папки с фотографиями.
+// skipDuplicates - не добавлять в батч дубликаты (см. FindFolderDuplicates), чтобы не тратить на них AI запросы.
+func (a *App) ProcessPhotoFolder(folderPath string, description string, photoType string, skipDuplicates bool) error {
 	log.Printf("Processing folder: %s, type: %s", folderPath, photoType)
 
 	// Создаем новый батч
@@ -163,6 +238,25 @@ func (a *App) ProcessPhotoFolder(folderPath string, description string, photoTyp
 		return fmt.Errorf("failed to scan folder: %w", err)
 	}
 
+	// Пропускаем дубликаты до AI анализа
+	var skipped []string
+	if skipDuplicates {
+		a.queueManager.DetectDuplicates(photos)
+
+		var unique []models.Photo
+		for _, photo := range photos {
+			if photo.Duplicate != nil {
+				skipped = append(skipped, services.DuplicateMessage(photo))
+				continue
+			}
+			unique = append(unique, photo)
+		}
+		if len(unique) == 0 {
+			return fmt.Errorf("все фото в папке %s - дубликаты уже добавленных фото", folderPath)
+		}
+		photos = unique
+	}
+
 	// Устанавливаем ContentType для всех фотографий
 	for i := range photos {
 		photos[i].ContentType = photoType
@@ -176,6 +270,12 @@ func (a *App) ProcessPhotoFolder(folderPath string, description string, photoTyp
 		return fmt.Errorf("failed to add batch to queue: %w", err)
 	}
 
+	if len(skipped) > 0 {
+		log.Printf("Skipped %d duplicate photos in %s", len(skipped), folderPath)
+		a.dbService.LogEvent(batch.ID, "", "duplicate_check", "warning",
+			fmt.Sprintf("Пропущено дубликатов: %d", len(skipped)), strings.Join(skipped, "\n"), 0)
+	}
+
 	// Запускаем обработку очереди если она не запущена
 	settings, err := a.dbService.GetSettings()
 	if err != nil {
@@ -204,14 +304,42 @@ func (a *App) GetQueueStatus() ([]models.BatchStatus, error) {
 	return a.queueManager.GetQueueStatus()
 }
 
-// GetStockConfigs возвращает все конфигурации стоков
+// GetStockConfigs возвращает все конфигурации стоков. Пароли и ключи заменены маской.
+// Нерасшифрованные секреты тоже маскируются: их нужно ввести заново или восстановить ключ.
 func (a *App) GetStockConfigs() ([]models.StockConfig, error) {
-	return a.dbService.GetAllStockConfigs()
+	configs, err := a.dbService.GetAllStockConfigs()
+	if errors.Is(err, services.ErrSecretDecrypt) {
+		log.Printf("Warning: %v", err)
+	} else if err != nil {
+		return nil, err
+	}
+
+	for i := range configs {
+		configs[i] = services.MaskStockConfigSecrets(configs[i])
+	}
+
+	return configs, nil
 }
 
-// SaveStockConfig сохраняет конфигурацию стока
+// SaveStockConfig сохраняет конфигурацию стока.
+// Поля с маской сохраняют прежнее значение секрета.
 func (a *App) SaveStockConfig(config models.StockConfig) error {
-	return a.dbService.SaveStockConfig(config)
+	return a.dbService.SaveStockConfig(a.withStoredStockSecrets(config))
+}
+
+// withStoredStockSecrets подставляет сохраненные секреты стока вместо маски из frontend
+func (a *App) withStoredStockSecrets(config models.StockConfig) models.StockConfig {
+	if config.ID == "" {
+		return config
+	}
+
+	// Нерасшифрованный секрет подставляется в зашифрованном виде и сохраняется без изменений
+	stored, err := a.dbService.GetStockConfig(config.ID)
+	if err != nil && !errors.Is(err, services.ErrSecretDecrypt) {
+		return config
+	}
+
+	return services.MergeStockConfigSecrets(config, stored)
 }
 
 // DeleteStockConfig удаляет конфигурацию стока
@@ -226,16 +354,34 @@ func (a *App) DeleteStockConfig(stockID string) error {
 	return nil
 }
 
+// ResetStockHostKey снимает закрепление SSH ключа сервера стока: при следующем подключении
+// будет закреплен текущий ключ сервера
+func (a *App) ResetStockHostKey(stockID string) error {
+	if err := a.dbService.SaveStockHostKey(stockID, ""); err != nil {
+		return err
+	}
+	a.dbService.LogEvent("", "", "host_key_reset", "success",
+		fmt.Sprintf("Закрепленный ключ SSH сервера стока %s сброшен", stockID), "", 0)
+	return nil
+}
+
 // TestStockConnection тестирует подключение к стоку
 func (a *App) TestStockConnection(config models.StockConfig) error {
+	config = a.withStoredStockSecrets(config)
+	for _, secret := range []string{config.Connection.Password, config.Connection.APIKey, config.Connection.PrivateKey, config.Connection.Passphrase} {
+		if services.IsEncryptedSecret(secret) {
+			return fmt.Errorf("%w: enter the stock credentials again", services.ErrSecretDecrypt)
+		}
+	}
 	return a.uploaderManager.TestConnection(config)
 }
 
 // ToggleStockActive переключает активность стока
 func (a *App) ToggleStockActive(stockID string) error {
 	// Получаем текущую конфигурацию
+	// Нерасшифрованные секреты сохраняются как есть
 	stocks, err := a.dbService.GetAllStockConfigs()
-	if err != nil {
+	if err != nil && !errors.Is(err, services.ErrSecretDecrypt) {
 		return fmt.Errorf("failed to get stock configs: %w", err)
 	}
 
@@ -250,14 +396,30 @@ func (a *App) ToggleStockActive(stockID string) error {
 	return fmt.Errorf("stock with ID %s not found", stockID)
 }
 
-// GetSettings возвращает настройки приложения
+// GetSettings возвращает настройки приложения. API ключ заменен маской.
 func (a *App) GetSettings() (models.AppSettings, error) {
-	return a.dbService.GetSettings()
+	settings, err := a.dbService.GetSettings()
+	if errors.Is(err, services.ErrSecretDecrypt) {
+		log.Printf("Warning: %v", err)
+	} else if err != nil {
+		return settings, err
+	}
+
+	return services.MaskSettingsSecrets(settings), nil
 }
 
-// SaveSettings сохраняет настройки приложения
+// SaveSettings сохраняет настройки приложения. Маска вместо API ключа сохраняет прежний ключ.
 func (a *App) SaveSettings(settings models.AppSettings) error {
-	return a.dbService.SaveSettings(settings)
+	stored, err := a.dbService.GetSettings()
+	if err == nil || errors.Is(err, services.ErrSecretDecrypt) {
+		settings = services.MergeSettingsSecrets(settings, stored)
+	}
+
+	if err := a.dbService.SaveSettings(settings); err != nil {
+		return err
+	}
+	a.imageProc.SetMetadataBackend(settings.MetadataBackend)
+	return nil
 }
 
 // UpdateAIPrompt обновляет промпт для AI
@@ -267,9 +429,9 @@ func (a *App) UpdateAIPrompt(photoType string, prompt string) error {
 
 // ForceUpdateDefaultPrompts принудительно обновляет дефолтные промпты
 func (a *App) ForceUpdateDefaultPrompts() error {
-	// Получаем текущие настройки
+	// Получаем текущие настройки. Нерасшифрованный API ключ сохраняется обратно без изменений
 	settings, err := a.dbService.GetSettings()
-	if err != nil {
+	if err != nil && !errors.Is(err, services.ErrSecretDecrypt) {
 		return fmt.Errorf("failed to get settings: %w", err)
 	}
 
@@ -370,12 +532,96 @@ func (a *App) StartQueueProcessing() error {
 	return a.queueManager.StartProcessing(settings)
 }
 
-// StopQueueProcessing останавливает обработку очереди
+// StopQueueProcessing останавливает обработку очереди: запросы к AI прерываются,
+// незавершенные фото возвращаются в pending, а батч встает на паузу
 func (a *App) StopQueueProcessing() error {
 	a.queueManager.StopProcessing()
 	return nil
 }
 
+// ResumeBatchProcessing продолжает батч, остановленный по лимиту стоимости AI или пользователем
+func (a *App) ResumeBatchProcessing(batchID string) error {
+	settings, err := a.dbService.GetSettings()
+	if err != nil {
+		return fmt.Errorf("failed to get settings: %w", err)
+	}
+
+	return a.queueManager.ResumeBatch(batchID, settings)
+}
+
+// GetBatchAIUsage возвращает токены и стоимость AI запросов батча с лимитом на батч
+func (a *App) GetBatchAIUsage(batchID string) (models.AIUsageSummary, error) {
+	usage, err := a.dbService.GetBatchAIUsage(batchID)
+	if err != nil {
+		return usage, err
+	}
+
+	if settings, err := a.dbService.GetSettings(); err == nil {
+		usage.Budget = settings.AIBatchBudget
+	}
+	return usage, nil
+}
+
+// GetMonthlyAIUsage возвращает токены и стоимость AI запросов за месяц "2006-01" с месячным лимитом;
+// пустая строка - текущий месяц
+func (a *App) GetMonthlyAIUsage(month string) (models.AIUsageSummary, error) {
+	usage, err := a.dbService.GetMonthlyAIUsage(month)
+	if err != nil {
+		return usage, err
+	}
+
+	if settings, err := a.dbService.GetSettings(); err == nil {
+		usage.Budget = settings.AIMonthlyBudget
+	}
+	return usage, nil
+}
+
+// GetModelPrices возвращает таблицу цен AI моделей
+func (a *App) GetModelPrices() ([]models.AIModelPrice, error) {
+	return a.dbService.GetModelPrices()
+}
+
+// SaveModelPrice добавляет или обновляет цену AI модели
+func (a *App) SaveModelPrice(price models.AIModelPrice) error {
+	return a.dbService.SaveModelPrice(price)
+}
+
+// DeleteModelPrice удаляет цену AI модели
+func (a *App) DeleteModelPrice(model string) error {
+	return a.dbService.DeleteModelPrice(model)
+}
+
+// GetAIRateLimits возвращает лимиты запросов к AI провайдерам
+func (a *App) GetAIRateLimits() ([]models.AIRateLimit, error) {
+	return a.dbService.GetAIRateLimits()
+}
+
+// SaveAIRateLimit добавляет или обновляет лимит запросов и применяет его к очереди
+func (a *App) SaveAIRateLimit(limit models.AIRateLimit) error {
+	if err := a.dbService.SaveAIRateLimit(limit); err != nil {
+		return err
+	}
+	return a.loadAIRateLimits()
+}
+
+// DeleteAIRateLimit удаляет лимит запросов и применяет изменения к очереди
+func (a *App) DeleteAIRateLimit(provider, model string) error {
+	if err := a.dbService.DeleteAIRateLimit(provider, model); err != nil {
+		return err
+	}
+	return a.loadAIRateLimits()
+}
+
+// loadAIRateLimits передает лимиты запросов из БД в AI сервис
+func (a *App) loadAIRateLimits() error {
+	limits, err := a.dbService.GetAIRateLimits()
+	if err != nil {
+		return err
+	}
+	a.aiService.SetRateLimits(limits)
+	return nil
+}
+
 // GetBatchDetails возвращает детали конкретного батча
 func (a *App) GetBatchDetails(batchID string) (*models.PhotoBatch, error) {
 	batches, err := a.dbService.GetBatchHistory(100)
@@ -392,16 +638,17 @@ func (a *App) GetBatchDetails(batchID string) (*models.PhotoBatch, error) {
 	return nil, fmt.Errorf("batch not found: %s", batchID)
 }
 
-// ApprovePhoto подтверждает фото для загрузки и записывает EXIF метаданные
+// ApprovePhoto подтверждает фото для загрузки. EXIF метаданные записываются в исходный файл,
+// только если в настройках включен WriteOriginals.
 func (a *App) ApprovePhoto(photoID string) error {
 	log.Printf("ApprovePhoto called for photoID: %s", photoID)
 
 	// Получаем данные фото и его AI результаты
-	var originalPath, aiResultJSON string
+	var batchID, originalPath, aiResultJSON, renditionsJSON string
 	err := a.db.QueryRow(`
-		SELECT original_path, ai_results 
+		SELECT batch_id, original_path, ai_results, COALESCE(renditions, '') 
 		FROM photos 
-		WHERE id = ?`, photoID).Scan(&originalPath, &aiResultJSON)
+		WHERE id = ?`, photoID).Scan(&batchID, &originalPath, &aiResultJSON, &renditionsJSON)
 	if err != nil {
 		log.Printf("Failed to get photo data for %s: %v", photoID, err)
 		return fmt.Errorf("failed to get photo data: %w", err)
@@ -421,6 +668,14 @@ func (a *App) ApprovePhoto(photoID string) error {
 
 	log.Printf("Photo %s status updated to approved", photoID)
 
+	// Исходный файл не изменяем: метаданные встраиваются в копии для стоков при загрузке.
+	// В оригинал они пишутся, только если это включено в настройках.
+	settings, err := a.dbService.GetSettings()
+	if err != nil || !settings.WriteOriginals {
+		log.Printf("Photo %s approved for upload successfully", photoID)
+		return nil
+	}
+
 	// Записываем EXIF метаданные в оригинальный файл
 	if aiResultJSON != "" && originalPath != "" {
 		log.Printf("Starting EXIF write for %s", originalPath)
@@ -431,11 +686,19 @@ func (a *App) ApprovePhoto(photoID string) error {
 			log.Printf("Warning: failed to unmarshal AI result for photo %s: %v", photoID, err)
 		} else {
 			log.Printf("AI result unmarshaled successfully, writing EXIF...")
-			err = a.imageProc.WriteExifToImage(originalPath, aiResult)
-			if err != nil {
-				log.Printf("Warning: failed to write EXIF to %s: %v", originalPath, err)
-			} else {
-				log.Printf("EXIF metadata written successfully to %s", originalPath)
+			photo := models.Photo{ID: photoID, BatchID: batchID, OriginalPath: originalPath}
+			if renditionsJSON != "" {
+				json.Unmarshal([]byte(renditionsJSON), &photo.Renditions)
+			}
+			// Метаданные пишутся во все файлы фото: JPEG/TIFF и XMP sidecar RAW
+			for _, target := range services.MetadataTargets(photo) {
+				err = a.imageProc.WriteExifToImage(target, aiResult)
+				if err != nil {
+					log.Printf("Warning: failed to write EXIF to %s: %v", target, err)
+				} else {
+					log.Printf("EXIF metadata written successfully to %s", target)
+				}
+				a.dbService.RecordMetadataVerification(batchID, photoID, a.imageProc.VerifyExifData(target, aiResult))
 			}
 		}
 	} else {
@@ -488,8 +751,19 @@ func (a *App) UpdatePhotoMetadata(photoID string, aiResult models.AIResult) erro
 	return nil
 }
 
-// RegeneratePhotoMetadata повторно генерирует метаданные для фото
-func (a *App) RegeneratePhotoMetadata(photoID string, customPrompt string) error {
+// ResetPhotoStockMetadata удаляет метаданные фото, созданные по промпту стока; сток получит общие метаданные фото
+func (a *App) ResetPhotoStockMetadata(photoID string, stockID string) error {
+	if err := a.dbService.DeletePhotoStockAIResult(photoID, stockID); err != nil {
+		return fmt.Errorf("failed to reset stock metadata: %w", err)
+	}
+
+	log.Printf("Photo %s stock metadata for %s reset", photoID, stockID)
+	return nil
+}
+
+// RegeneratePhotoMetadata повторно генерирует метаданные для фото.
+// forceFresh - запросить AI заново, даже если такой же запрос есть в кеше.
+func (a *App) RegeneratePhotoMetadata(photoID string, customPrompt string, forceFresh bool) error {
 	// Получаем данные фото
 	var photo models.Photo
 	var exifJSON, uploadStatusJSON string
@@ -564,7 +838,7 @@ func (a *App) RegeneratePhotoMetadata(photoID string, customPrompt string) error
 		settings.AIPrompts[batchType] = dialogPrompt
 
 		// Анализируем фото с новым промптом
-		aiResult, err := a.aiService.AnalyzePhoto(photo, batchDescription, batchType, settings)
+		aiResult, err := a.aiService.AnalyzePhoto(a.ctx, photo, batchDescription, batchType, settings, forceFresh)
 
 		// Восстанавливаем оригинальный промпт
 		settings.AIPrompts[batchType] = originalPrompt
@@ -572,21 +846,31 @@ func (a *App) RegeneratePhotoMetadata(photoID string, customPrompt string) error
 		if err != nil {
 			return fmt.Errorf("failed to regenerate metadata: %w", err)
 		}
+		a.logAICacheHit(photo, aiResult)
 
 		// Сохраняем результаты
 		return a.UpdatePhotoMetadata(photoID, *aiResult)
 	} else {
 		// Используем стандартный промпт для полной регенерации
-		aiResult, err := a.aiService.AnalyzePhoto(photo, batchDescription, batchType, settings)
+		aiResult, err := a.aiService.AnalyzePhoto(a.ctx, photo, batchDescription, batchType, settings, forceFresh)
 		if err != nil {
 			return fmt.Errorf("failed to regenerate metadata: %w", err)
 		}
+		a.logAICacheHit(photo, aiResult)
 
 		// Сохраняем результаты
 		return a.UpdatePhotoMetadata(photoID, *aiResult)
 	}
 }
 
+// logAICacheHit отмечает в журнале событий, что метаданные взяты из кеша AI
+func (a *App) logAICacheHit(photo models.Photo, aiResult *models.AIResult) {
+	if aiResult.FromCache {
+		a.dbService.LogEvent(photo.BatchID, photo.ID, "ai_cache", "hit",
+			fmt.Sprintf("Метаданные фото %s взяты из кеша AI без запроса к провайдеру", photo.FileName), "", 100)
+	}
+}
+
 // SetPhotoStatus устанавливает статус фотографии
 func (a *App) SetPhotoStatus(photoID string, status string) error {
 	log.Printf("Setting photo %s status to %s", photoID, status)
@@ -622,7 +906,7 @@ func (a *App) SetPhotoStatus(photoID string, status string) error {
 // GetDefaultLanguage возвращает сохраненный язык или "en" по умолчанию
 func (a *App) GetDefaultLanguage() string {
 	settings, err := a.dbService.GetSettings()
-	if err != nil {
+	if err != nil && !errors.Is(err, services.ErrSecretDecrypt) {
 		log.Printf("Failed to get language from settings: %v", err)
 		return "en"
 	}
@@ -634,16 +918,25 @@ func (a *App) GetDefaultLanguage() string {
 	return settings.Language
 }
 
-// GetAIModels возвращает список доступных моделей для указанного провайдера
+// GetAIModels возвращает список доступных моделей для указанного провайдера.
+// Если API ключ не расшифровался, список запрашивается без ключа (статический список облачных моделей).
 func (a *App) GetAIModels(provider string) ([]models.AIModel, error) {
 	settings, err := a.dbService.GetSettings()
-	if err != nil {
+	if errors.Is(err, services.ErrSecretDecrypt) {
+		log.Printf("Warning: %v", err)
+		settings.AIAPIKey = ""
+	} else if err != nil {
 		return nil, fmt.Errorf("failed to get settings: %w", err)
 	}
 
 	return a.aiService.GetAvailableModels(provider, settings)
 }
 
+// GetAIProviders возвращает список зарегистрированных AI провайдеров
+func (a *App) GetAIProviders() []string {
+	return a.aiService.GetSupportedProviders()
+}
+
 // SelectFolder открывает диалог выбора папки
 func (a *App) SelectFolder() (string, error) {
 	folderPath, err := runtime.OpenDirectoryDialog(a.ctx, runtime.OpenDialogOptions{
@@ -684,7 +977,7 @@ func (a *App) GetAvailableUploaders() []models.UploaderInfo {
 
 // ValidateStockConfig валидирует конфигурацию стока
 func (a *App) ValidateStockConfig(config models.StockConfig) error {
-	return a.uploaderManager.ValidateStockConfig(config)
+	return a.uploaderManager.ValidateStockConfig(a.withStoredStockSecrets(config))
 }
 
 func getCurrentTimestamp() int64 {
@@ -756,15 +1049,22 @@ func (a *App) GetProcessedBatches() ([]models.PhotoBatch, error) {
 // GetPhoto возвращает данные фотографии по ID
 func (a *App) GetPhoto(photoID string) (models.Photo, error) {
 	var photo models.Photo
-	var exifJSON, uploadStatusJSON, aiResultJSON string
+	var exifJSON, uploadStatusJSON, aiResultJSON, verificationJSON, renditionsJSON, duplicateJSON, stockAIResultsJSON string
 
 	err := a.db.QueryRow(`
 		SELECT id, batch_id, original_path, thumbnail_path, file_name, file_size,
-		       exif_data, upload_status, ai_results, status, created_at, updated_at
+		       exif_data, upload_status, ai_results, status, created_at, updated_at,
+		       COALESCE(metadata_verification, ''), COALESCE(metadata_override, 0), COALESCE(renditions, ''),
+		       COALESCE(content_hash, ''), COALESCE(perceptual_hash, ''), COALESCE(duplicate_of, ''),
+		       COALESCE(series_id, ''), COALESCE(quality_score, 0), COALESCE(captured_at, ''),
+		       COALESCE(stock_ai_results, '')
 		FROM photos WHERE id = ?`, photoID).Scan(
 		&photo.ID, &photo.BatchID, &photo.OriginalPath, &photo.ThumbnailPath,
 		&photo.FileName, &photo.FileSize, &exifJSON, &uploadStatusJSON,
-		&aiResultJSON, &photo.Status, &photo.CreatedAt, &photo.UpdatedAt)
+		&aiResultJSON, &photo.Status, &photo.CreatedAt, &photo.UpdatedAt,
+		&verificationJSON, &photo.MetadataOverride, &renditionsJSON,
+		&photo.ContentHash, &photo.PerceptualHash, &duplicateJSON,
+		&photo.SeriesID, &photo.QualityScore, &photo.CapturedAt, &stockAIResultsJSON)
 	if err != nil {
 		return photo, fmt.Errorf("failed to get photo: %w", err)
 	}
@@ -794,6 +1094,28 @@ func (a *App) GetPhoto(photoID string) (models.Photo, error) {
 		}
 	}
 
+	if verificationJSON != "" {
+		var verification models.MetadataVerification
+		if json.Unmarshal([]byte(verificationJSON), &verification) == nil {
+			photo.MetadataVerification = &verification
+		}
+	}
+
+	if renditionsJSON != "" {
+		json.Unmarshal([]byte(renditionsJSON), &photo.Renditions)
+	}
+
+	if duplicateJSON != "" {
+		var duplicate models.PhotoDuplicate
+		if json.Unmarshal([]byte(duplicateJSON), &duplicate) == nil {
+			photo.Duplicate = &duplicate
+		}
+	}
+
+	if stockAIResultsJSON != "" {
+		json.Unmarshal([]byte(stockAIResultsJSON), &photo.StockAIResults)
+	}
+
 	return photo, nil
 }
 
@@ -830,7 +1152,11 @@ func (a *App) GetPhotoThumbnail(photoID string) (string, error) {
 func (a *App) GetBatchPhotos(batchID string) ([]models.Photo, error) {
 	query := `
 		SELECT id, batch_id, original_path, thumbnail_path, file_name, file_size,
-		       ai_results, exif_data, upload_status, status, created_at, updated_at
+		       ai_results, exif_data, upload_status, status, created_at, updated_at,
+		       COALESCE(metadata_verification, ''), COALESCE(metadata_override, 0), COALESCE(renditions, ''),
+		       COALESCE(content_hash, ''), COALESCE(perceptual_hash, ''), COALESCE(duplicate_of, ''),
+		       COALESCE(series_id, ''), COALESCE(quality_score, 0), COALESCE(captured_at, ''),
+		       COALESCE(stock_ai_results, '')
 		FROM photos 
 		WHERE batch_id = ?
 		ORDER BY file_name ASC`
@@ -845,13 +1171,16 @@ func (a *App) GetBatchPhotos(batchID string) ([]models.Photo, error) {
 
 	for rows.Next() {
 		var photo models.Photo
-		var aiResultsJSON, exifJSON, uploadStatusJSON string
+		var aiResultsJSON, exifJSON, uploadStatusJSON, verificationJSON, renditionsJSON, duplicateJSON, stockAIResultsJSON string
 		var updatedAt sql.NullTime
 
 		err := rows.Scan(
 			&photo.ID, &photo.BatchID, &photo.OriginalPath, &photo.ThumbnailPath,
 			&photo.FileName, &photo.FileSize, &aiResultsJSON, &exifJSON,
 			&uploadStatusJSON, &photo.Status, &photo.CreatedAt, &updatedAt,
+			&verificationJSON, &photo.MetadataOverride, &renditionsJSON,
+			&photo.ContentHash, &photo.PerceptualHash, &duplicateJSON,
+			&photo.SeriesID, &photo.QualityScore, &photo.CapturedAt, &stockAIResultsJSON,
 		)
 		if err != nil {
 			return nil, fmt.Errorf("failed to scan photo row: %w", err)
@@ -895,6 +1224,32 @@ func (a *App) GetBatchPhotos(batchID string) ([]models.Photo, error) {
 			photo.UploadStatus = make(map[string]string)
 		}
 
+		// Десериализуем результат проверки записанных метаданных
+		if verificationJSON != "" {
+			var verification models.MetadataVerification
+			if json.Unmarshal([]byte(verificationJSON), &verification) == nil {
+				photo.MetadataVerification = &verification
+			}
+		}
+
+		// Десериализуем файлы фото (RAW, JPEG, XMP sidecar, релиз)
+		if renditionsJSON != "" {
+			json.Unmarshal([]byte(renditionsJSON), &photo.Renditions)
+		}
+
+		// Десериализуем найденный дубликат (ссылка "похоже на" в ревью)
+		if duplicateJSON != "" {
+			var duplicate models.PhotoDuplicate
+			if json.Unmarshal([]byte(duplicateJSON), &duplicate) == nil {
+				photo.Duplicate = &duplicate
+			}
+		}
+
+		// Десериализуем метаданные, созданные по промптам стоков
+		if stockAIResultsJSON != "" {
+			json.Unmarshal([]byte(stockAIResultsJSON), &photo.StockAIResults)
+		}
+
 		// Проверяем статус выбора для загрузки
 		photo.SelectedForUpload = photo.ExifData["_selected_for_upload"] == "true"
 
@@ -909,6 +1264,30 @@ func (a *App) GetBatchPhotos(batchID string) ([]models.Photo, error) {
 	return photos, nil
 }
 
+// GetBatchSeries возвращает серии кадров батча (серийная съемка) с лучшим кадром каждой серии
+func (a *App) GetBatchSeries(batchID string) ([]models.PhotoSeries, error) {
+	return a.dbService.GetBatchSeries(batchID)
+}
+
+// SetSeriesBestPhoto назначает лучший кадр серии вместо выбранного по оценке качества
+func (a *App) SetSeriesBestPhoto(seriesID string, photoID string) error {
+	return a.dbService.SetSeriesBestPhoto(seriesID, photoID)
+}
+
+// UpdateSeriesPhotos задает состав серии; серия меньше чем из двух кадров удаляется
+func (a *App) UpdateSeriesPhotos(seriesID string, photoIDs []string) error {
+	return a.dbService.UpdateSeriesPhotos(seriesID, photoIDs)
+}
+
+// PropagateSeriesMetadata копирует метаданные лучшего кадра серии остальным кадрам и возвращает их число
+func (a *App) PropagateSeriesMetadata(seriesID string) (int, error) {
+	settings, err := a.dbService.GetSettings()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get settings: %w", err)
+	}
+	return a.queueManager.PropagateSeriesMetadata(seriesID, settings)
+}
+
 // UploadApprovedPhotos загружает одобренные фото на все активные стоки
 func (a *App) UploadApprovedPhotos(batchID string) error {
 	// Получаем информацию о батче
@@ -962,15 +1341,61 @@ func (a *App) UploadSelectedPhotos(batchID string, photoIDs []string) error {
 	return a.uploadQueueManager.QueuePhotosForUpload(batchID, photoIDs)
 }
 
+// RetryFailedUploads повторно ставит в очередь загрузки батча, остановленные после ошибок.
+// Пустой stockID перезапускает загрузки на все стоки. Возвращает число фото.
+func (a *App) RetryFailedUploads(batchID string, stockID string) (int, error) {
+	return a.uploadQueueManager.RetryFailedUploads(batchID, stockID)
+}
+
+// OverrideMetadataVerification разрешает загрузку фото, метаданные которого не прошли проверку
+// после записи в файл. Остановленные из-за проверки загрузки перезапускаются через RetryFailedUploads.
+func (a *App) OverrideMetadataVerification(photoID string) error {
+	var batchID string
+	if err := a.db.QueryRow("SELECT batch_id FROM photos WHERE id = ?", photoID).Scan(&batchID); err != nil {
+		return fmt.Errorf("failed to get photo: %w", err)
+	}
+
+	if err := a.dbService.SetPhotoMetadataOverride(photoID, true); err != nil {
+		return err
+	}
+
+	a.dbService.LogEvent(batchID, photoID, "exif_verify", "overridden",
+		"Загрузка разрешена пользователем несмотря на расхождения метаданных", "", 100)
+	log.Printf("Metadata verification overridden for photo %s", photoID)
+	return nil
+}
+
+// ExportBatchCSV сохраняет CSV метаданных батча в формате агентства стока и возвращает число фото.
+// Если path пустой, путь выбирается в диалоге сохранения; отмена диалога возвращает 0 без ошибки.
+func (a *App) ExportBatchCSV(batchID string, stockID string, path string) (int, error) {
+	if path == "" {
+		selected, err := runtime.SaveFileDialog(a.ctx, runtime.SaveDialogOptions{
+			Title:           "Export metadata CSV",
+			DefaultFilename: fmt.Sprintf("metadata_%s_%s.csv", stockID, batchID),
+			Filters:         []runtime.FileFilter{{DisplayName: "CSV (*.csv)", Pattern: "*.csv"}},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to open save dialog: %w", err)
+		}
+		if selected == "" {
+			return 0, nil
+		}
+		path = selected
+	}
+
+	return services.NewMetadataCSVExporter(a.uploaderManager, a.dbService).ExportBatch(batchID, stockID, path)
+}
+
 // GetUploadQueueStatus возвращает статус очереди загрузки
 func (a *App) GetUploadQueueStatus() map[string]interface{} {
 	return a.uploadQueueManager.GetStatus()
 }
 
-// StopUploadQueue останавливает очередь загрузки
+// StopUploadQueue останавливает очередь загрузки; прерванные загрузки возвращаются в очередь
 func (a *App) StopUploadQueue() error {
-	a.uploadQueueManager.Stop()
-	return nil
+	ctx, cancel := context.WithTimeout(context.Background(), stopUploadQueueTimeout)
+	defer cancel()
+	return a.uploadQueueManager.Stop(ctx)
 }
 
 // SetPhotoSelectedForUpload устанавливает статус выбора фотографии для загрузки
@@ -1286,6 +1711,12 @@ func (a *App) DeleteBatch(batchID string) error {
 	}
 	defer tx.Rollback()
 
+	// Удаляем задачи загрузки батча
+	_, err = tx.Exec("DELETE FROM upload_jobs WHERE batch_id = ?", batchID)
+	if err != nil {
+		return fmt.Errorf("failed to delete upload jobs: %w", err)
+	}
+
 	// Удаляем все фото батча
 	_, err = tx.Exec("DELETE FROM photos WHERE batch_id = ?", batchID)
 	if err != nil {
@@ -1307,13 +1738,15 @@ func (a *App) DeleteBatch(batchID string) error {
 	return nil
 }
 
-// GetUploadProgress возвращает прогресс загрузки для батча
+// GetUploadProgress возвращает прогресс загрузки для батча по таблице upload_jobs
 func (a *App) GetUploadProgress(batchID string) (map[string]interface{}, error) {
-	// Получаем все фото из батча
+	// Фото с задачами загрузки и одобренные фото, которые еще не ставились в очередь
 	rows, err := a.db.Query(`
-		SELECT id, file_name, upload_status 
-		FROM photos 
-		WHERE batch_id = ? AND status = 'approved'`, batchID)
+		SELECT p.id, p.file_name, COALESCE(j.stock_id, ''), COALESCE(j.state, ''), COALESCE(j.attempts, 0)
+		FROM photos p
+		LEFT JOIN upload_jobs j ON j.photo_id = p.id
+		WHERE p.batch_id = ? AND (j.id IS NOT NULL OR p.status = 'approved')
+		ORDER BY p.file_name`, batchID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get photos: %w", err)
 	}
@@ -1325,46 +1758,59 @@ func (a *App) GetUploadProgress(batchID string) (map[string]interface{}, error)
 		Stocks   map[string]string `json:"stocks"`
 	}
 
-	var photos []PhotoProgress
-	totalPhotos := 0
+	var photos []*PhotoProgress
+	photoIndex := make(map[string]*PhotoProgress)
+	queuedCount := 0
 	uploadingCount := 0
 	uploadedCount := 0
 	failedCount := 0
+	retryingCount := 0
 
 	for rows.Next() {
-		var photo PhotoProgress
-		var uploadStatusJSON string
+		var photoID, fileName, stockID, state string
+		var attempts int
 
-		err := rows.Scan(&photo.ID, &photo.FileName, &uploadStatusJSON)
+		err := rows.Scan(&photoID, &fileName, &stockID, &state, &attempts)
 		if err != nil {
 			continue
 		}
 
-		// Десериализуем статус загрузки
-		photo.Stocks = make(map[string]string)
-		if uploadStatusJSON != "" && uploadStatusJSON != "null" {
-			json.Unmarshal([]byte(uploadStatusJSON), &photo.Stocks)
+		photo, exists := photoIndex[photoID]
+		if !exists {
+			photo = &PhotoProgress{ID: photoID, FileName: fileName, Stocks: make(map[string]string)}
+			photoIndex[photoID] = photo
+			photos = append(photos, photo)
+		}
+
+		if stockID == "" {
+			continue
 		}
+		photo.Stocks[stockID] = state
 
 		// Подсчитываем статистику
-		totalPhotos++
-		for _, status := range photo.Stocks {
-			switch status {
-			case "uploading":
-				uploadingCount++
-			case "uploaded":
-				uploadedCount++
-			case "failed":
-				failedCount++
+		switch state {
+		case "pending":
+			if attempts > 0 {
+				photo.Stocks[stockID] = "retrying"
+				retryingCount++
+			} else {
+				queuedCount++
 			}
+		case "uploading":
+			uploadingCount++
+		case "uploaded":
+			uploadedCount++
+		case "dead_letter", "failed":
+			photo.Stocks[stockID] = "failed"
+			failedCount++
 		}
-
-		photos = append(photos, photo)
 	}
 
 	return map[string]interface{}{
 		"photos":         photos,
-		"totalPhotos":    totalPhotos,
+		"totalPhotos":    len(photos),
+		"queuedCount":    queuedCount,
+		"retryingCount":  retryingCount,
 		"uploadingCount": uploadingCount,
 		"uploadedCount":  uploadedCount,
 		"failedCount":    failedCount,
@@ -1438,7 +1884,7 @@ func (a *App) GetProcessingProgress(batchID string) (models.ProcessingProgress,
 	return progress, nil
 }
 
-// CheckExifToolStatus проверяет статус ExifTool
+// CheckExifToolStatus проверяет статус ExifTool. Он не обязателен: без него метаданные пишет встроенный модуль.
 func (a *App) CheckExifToolStatus() map[string]interface{} {
 	available := a.imageProc.CheckExifToolAvailable()
 
@@ -1448,9 +1894,9 @@ func (a *App) CheckExifToolStatus() map[string]interface{} {
 	}
 
 	if !available {
-		result["message"] = "ExifTool не установлен. Метаданные не будут записываться в EXIF файлов. Установите ExifTool для полной функциональности."
+		result["message"] = "ExifTool не установлен. Метаданные записываются встроенным модулем (XMP и IPTC для JPEG и TIFF)."
 	} else {
-		result["message"] = "ExifTool доступен. Метаданные будут автоматически записываться в EXIF файлов."
+		result["message"] = "ExifTool доступен. Его можно выбрать для записи метаданных вместо встроенного модуля."
 	}
 
 	return result
@@ -1464,6 +1910,18 @@ func (a *App) CleanOldLogs(daysToKeep int) error {
 	return a.logger.CleanOldLogs(daysToKeep)
 }
 
+// PruneAICache удаляет результаты из кеша AI, не использованные дольше указанного количества дней;
+// 0 - очистить кеш полностью
+func (a *App) PruneAICache(olderThanDays int) (int, error) {
+	deleted, err := a.dbService.PruneAICache(olderThanDays)
+	if err != nil {
+		return 0, err
+	}
+
+	log.Printf("AI cache pruned: %d entries older than %d days", deleted, olderThanDays)
+	return deleted, nil
+}
+
 // truncateString обрезает строку до указанной длины
 func truncateString(s string, maxLen int) string {
 	if len(s) <= maxLen {
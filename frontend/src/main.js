@@ -279,6 +279,26 @@ class StockPhotoApp {
             this.forceUpdatePrompts();
         });
 
+        document.getElementById('addModelPriceBtn').addEventListener('click', () => {
+            this.addModelPriceRow();
+        });
+
+        document.getElementById('addRateLimitBtn').addEventListener('click', () => {
+            this.addRateLimitRow();
+        });
+
+        document.getElementById('pruneAICacheBtn').addEventListener('click', () => {
+            this.pruneAICache();
+        });
+
+        // Очередь AI остановлена по лимиту стоимости
+        if (window.runtime) {
+            EventsOn('queue:paused', (data) => {
+                this.showNotification(window.i18n.t('queue.budgetPaused', { reason: data.reason }), 'warning');
+                this.updateQueue();
+            });
+        }
+
         // Подписываемся на изменения языка
         window.i18n.subscribe((language) => {
             this.updateLanguageSelectors(language);
@@ -528,8 +548,16 @@ class StockPhotoApp {
         processBtn.innerHTML = `<i class="fas fa-spinner fa-spin mr-2"></i><span>${window.i18n.t(photoType + '.processing')}</span>`;
 
         try {
+            // Проверяем дубликаты до AI анализа и предлагаем их пропустить
+            let skipDuplicates = false;
+            const duplicates = await window.go.main.App.FindFolderDuplicates(this.selectedFolder.path);
+            if (duplicates && duplicates.length > 0) {
+                const files = duplicates.map(photo => `${photo.fileName} → ${photo.duplicate.fileName}`).join('\n');
+                skipDuplicates = confirm(window.i18n.t('notifications.duplicatesFound', { count: duplicates.length, files }));
+            }
+
             // Вызываем Go метод, передаем описание как есть (может быть пустым)
-            await window.go.main.App.ProcessPhotoFolder(this.selectedFolder.path, description, photoType);
+            await window.go.main.App.ProcessPhotoFolder(this.selectedFolder.path, description, photoType, skipDuplicates);
             
             this.showNotification(window.i18n.t('notifications.photosQueued'), 'success');
             this.switchTab('queue');
@@ -618,6 +646,239 @@ class StockPhotoApp {
         
         // Проверяем статус ExifTool
         this.checkExifToolStatus();
+
+        this.loadAIUsage();
+        this.loadModelPrices();
+        this.loadRateLimits();
+    }
+
+    // Показывает расходы на AI за текущий месяц
+    async loadAIUsage() {
+        const container = document.getElementById('aiMonthlyUsage');
+        if (!this.isWailsMode) {
+            container.textContent = '';
+            return;
+        }
+
+        try {
+            const usage = await window.go.main.App.GetMonthlyAIUsage('');
+            let text = window.i18n.t('settings.ai.monthlyUsage', {
+                requests: usage.requests,
+                tokens: (usage.promptTokens + usage.completionTokens).toLocaleString(),
+                cost: usage.cost.toFixed(2)
+            });
+            if (usage.unpricedRequests > 0) {
+                text += ' • ' + window.i18n.t('settings.ai.unpricedUsage', { count: usage.unpricedRequests });
+            }
+            container.textContent = text;
+        } catch (error) {
+            console.error('Error loading AI usage:', error);
+        }
+    }
+
+    async pruneAICache() {
+        if (!this.isWailsMode) return;
+
+        const days = parseInt(document.getElementById('aiCacheDays').value) || 0;
+        try {
+            const deleted = await window.go.main.App.PruneAICache(days);
+            this.showNotification(window.i18n.t('settings.ai.cachePruned', { count: deleted }), 'success');
+        } catch (error) {
+            console.error('Error pruning AI cache:', error);
+            this.showNotification('Error: ' + error.message, 'error');
+        }
+    }
+
+    async loadModelPrices() {
+        if (!this.isWailsMode) return;
+
+        try {
+            this.modelPrices = await window.go.main.App.GetModelPrices() || [];
+            this.renderModelPrices();
+        } catch (error) {
+            console.error('Error loading model prices:', error);
+        }
+    }
+
+    renderModelPrices() {
+        const container = document.getElementById('modelPricesContainer');
+        const rows = (this.modelPrices || []).map((price, index) => `
+            <tr>
+                <td class="pr-2 py-1"><input type="text" id="priceModel-${index}" value="${this.escapeHtml(price.model)}" ${price.model ? 'readonly' : ''}
+                           class="w-full border-gray-300 rounded-md text-sm"></td>
+                <td class="pr-2 py-1"><input type="text" id="priceProvider-${index}" value="${this.escapeHtml(price.provider || '')}"
+                           class="w-full border-gray-300 rounded-md text-sm"></td>
+                <td class="pr-2 py-1"><input type="number" id="priceInput-${index}" value="${price.inputPrice}" min="0" step="0.01"
+                           class="w-full border-gray-300 rounded-md text-sm"></td>
+                <td class="pr-2 py-1"><input type="number" id="priceOutput-${index}" value="${price.outputPrice}" min="0" step="0.01"
+                           class="w-full border-gray-300 rounded-md text-sm"></td>
+                <td class="py-1 whitespace-nowrap">
+                    <button type="button" onclick="app.saveModelPrice(${index})" class="text-green-600 hover:text-green-800 p-1">
+                        <i class="fas fa-save"></i>
+                    </button>
+                    <button type="button" onclick="app.deleteModelPrice(${index})" class="text-red-600 hover:text-red-800 p-1">
+                        <i class="fas fa-trash"></i>
+                    </button>
+                </td>
+            </tr>
+        `).join('');
+
+        container.innerHTML = `
+            <table class="w-full text-sm">
+                <thead>
+                    <tr class="text-left text-gray-500">
+                        <th class="font-medium">${window.i18n.t('settings.ai.priceModel')}</th>
+                        <th class="font-medium">${window.i18n.t('settings.ai.priceProvider')}</th>
+                        <th class="font-medium">${window.i18n.t('settings.ai.priceInput')}</th>
+                        <th class="font-medium">${window.i18n.t('settings.ai.priceOutput')}</th>
+                        <th></th>
+                    </tr>
+                </thead>
+                <tbody>${rows}</tbody>
+            </table>
+        `;
+    }
+
+    addModelPriceRow() {
+        this.modelPrices = this.modelPrices || [];
+        this.modelPrices.push({ model: '', provider: document.getElementById('aiProvider').value, inputPrice: 0, outputPrice: 0 });
+        this.renderModelPrices();
+        document.getElementById(`priceModel-${this.modelPrices.length - 1}`).focus();
+    }
+
+    async saveModelPrice(index) {
+        const price = {
+            model: document.getElementById(`priceModel-${index}`).value.trim(),
+            provider: document.getElementById(`priceProvider-${index}`).value.trim(),
+            inputPrice: parseFloat(document.getElementById(`priceInput-${index}`).value) || 0,
+            outputPrice: parseFloat(document.getElementById(`priceOutput-${index}`).value) || 0
+        };
+
+        try {
+            await window.go.main.App.SaveModelPrice(price);
+            this.showNotification(window.i18n.t('settings.ai.priceSaved'), 'success');
+            this.loadModelPrices();
+        } catch (error) {
+            console.error('Error saving model price:', error);
+            this.showNotification('Error: ' + error.message, 'error');
+        }
+    }
+
+    async deleteModelPrice(index) {
+        const price = this.modelPrices[index];
+        // Еще не сохраненная строка просто убирается из таблицы
+        if (!price.model) {
+            this.modelPrices.splice(index, 1);
+            this.renderModelPrices();
+            return;
+        }
+        if (!confirm(window.i18n.t('settings.ai.priceDeleteConfirm', { model: price.model }))) return;
+
+        try {
+            await window.go.main.App.DeleteModelPrice(price.model);
+            this.showNotification(window.i18n.t('settings.ai.priceDeleted'), 'success');
+            this.loadModelPrices();
+        } catch (error) {
+            console.error('Error deleting model price:', error);
+            this.showNotification('Error: ' + error.message, 'error');
+        }
+    }
+
+    async loadRateLimits() {
+        if (!this.isWailsMode) return;
+
+        try {
+            const limits = await window.go.main.App.GetAIRateLimits() || [];
+            this.rateLimits = limits.map(limit => ({ ...limit, saved: true }));
+            this.renderRateLimits();
+        } catch (error) {
+            console.error('Error loading AI rate limits:', error);
+        }
+    }
+
+    renderRateLimits() {
+        const container = document.getElementById('rateLimitsContainer');
+        const rows = (this.rateLimits || []).map((limit, index) => `
+            <tr>
+                <td class="pr-2 py-1"><input type="text" id="limitProvider-${index}" value="${this.escapeHtml(limit.provider)}" ${limit.saved ? 'readonly' : ''}
+                           class="w-full border-gray-300 rounded-md text-sm"></td>
+                <td class="pr-2 py-1"><input type="text" id="limitModel-${index}" value="${this.escapeHtml(limit.model || '')}" ${limit.saved ? 'readonly' : ''}
+                           placeholder="${window.i18n.t('settings.ai.rateLimitAllModels')}" class="w-full border-gray-300 rounded-md text-sm"></td>
+                <td class="pr-2 py-1"><input type="number" id="limitRPM-${index}" value="${limit.requestsPerMinute}" min="0" step="1"
+                           class="w-full border-gray-300 rounded-md text-sm"></td>
+                <td class="pr-2 py-1"><input type="number" id="limitTPM-${index}" value="${limit.tokensPerMinute}" min="0" step="1000"
+                           class="w-full border-gray-300 rounded-md text-sm"></td>
+                <td class="py-1 whitespace-nowrap">
+                    <button type="button" onclick="app.saveRateLimit(${index})" class="text-green-600 hover:text-green-800 p-1">
+                        <i class="fas fa-save"></i>
+                    </button>
+                    <button type="button" onclick="app.deleteRateLimit(${index})" class="text-red-600 hover:text-red-800 p-1">
+                        <i class="fas fa-trash"></i>
+                    </button>
+                </td>
+            </tr>
+        `).join('');
+
+        container.innerHTML = `
+            <table class="w-full text-sm">
+                <thead>
+                    <tr class="text-left text-gray-500">
+                        <th class="font-medium">${window.i18n.t('settings.ai.priceProvider')}</th>
+                        <th class="font-medium">${window.i18n.t('settings.ai.priceModel')}</th>
+                        <th class="font-medium">${window.i18n.t('settings.ai.rateLimitRPM')}</th>
+                        <th class="font-medium">${window.i18n.t('settings.ai.rateLimitTPM')}</th>
+                        <th></th>
+                    </tr>
+                </thead>
+                <tbody>${rows}</tbody>
+            </table>
+        `;
+    }
+
+    addRateLimitRow() {
+        this.rateLimits = this.rateLimits || [];
+        this.rateLimits.push({ provider: document.getElementById('aiProvider').value, model: '', requestsPerMinute: 0, tokensPerMinute: 0, saved: false });
+        this.renderRateLimits();
+        document.getElementById(`limitModel-${this.rateLimits.length - 1}`).focus();
+    }
+
+    async saveRateLimit(index) {
+        const limit = {
+            provider: document.getElementById(`limitProvider-${index}`).value.trim(),
+            model: document.getElementById(`limitModel-${index}`).value.trim(),
+            requestsPerMinute: parseInt(document.getElementById(`limitRPM-${index}`).value) || 0,
+            tokensPerMinute: parseInt(document.getElementById(`limitTPM-${index}`).value) || 0
+        };
+
+        try {
+            await window.go.main.App.SaveAIRateLimit(limit);
+            this.showNotification(window.i18n.t('settings.ai.rateLimitSaved'), 'success');
+            this.loadRateLimits();
+        } catch (error) {
+            console.error('Error saving AI rate limit:', error);
+            this.showNotification('Error: ' + error.message, 'error');
+        }
+    }
+
+    async deleteRateLimit(index) {
+        const limit = this.rateLimits[index];
+        // Еще не сохраненная строка просто убирается из таблицы
+        if (!limit.saved) {
+            this.rateLimits.splice(index, 1);
+            this.renderRateLimits();
+            return;
+        }
+        const name = limit.model ? `${limit.provider}/${limit.model}` : limit.provider;
+        if (!confirm(window.i18n.t('settings.ai.rateLimitDeleteConfirm', { name }))) return;
+
+        try {
+            await window.go.main.App.DeleteAIRateLimit(limit.provider, limit.model);
+            this.showNotification(window.i18n.t('settings.ai.rateLimitDeleted'), 'success');
+            this.loadRateLimits();
+        } catch (error) {
+            console.error('Error deleting AI rate limit:', error);
+            this.showNotification('Error: ' + error.message, 'error');
+        }
     }
 
     closeSettings() {
@@ -731,11 +992,19 @@ class StockPhotoApp {
         document.getElementById('tempDirectory').value = this.settings.tempDirectory || './temp';
         document.getElementById('thumbnailSize').value = this.settings.thumbnailSize || 512;
         document.getElementById('maxConcurrentJobs').value = this.settings.maxConcurrentJobs || 3;
+        document.getElementById('uploadMaxAttempts').value = this.settings.uploadMaxAttempts || 5;
+        document.getElementById('uploadRetryDelay').value = this.settings.uploadRetryDelay || 30;
+        document.getElementById('writeOriginals').checked = this.settings.writeOriginals || false;
+        document.getElementById('seriesBestOnly').checked = this.settings.seriesBestOnly || false;
+        document.getElementById('perStockMetadata').checked = this.settings.perStockMetadata || false;
+        document.getElementById('metadataBackend').value = this.settings.metadataBackend || 'native';
         document.getElementById('aiProvider').value = this.settings.aiProvider || 'openai';
         document.getElementById('aiApiKey').value = this.settings.aiApiKey || '';
         document.getElementById('aiBaseUrl').value = this.settings.aiBaseUrl || '';
         document.getElementById('aiTimeout').value = this.settings.aiTimeout || 90;
         document.getElementById('aiMaxTokens').value = this.settings.aiMaxTokens || 2000;
+        document.getElementById('aiBatchBudget').value = this.settings.aiBatchBudget || 0;
+        document.getElementById('aiMonthlyBudget').value = this.settings.aiMonthlyBudget || 0;
         
         // Устанавливаем язык в селекторе
         const language = this.settings.language || window.i18n.getCurrentLanguage();
@@ -980,12 +1249,20 @@ class StockPhotoApp {
             tempDirectory: document.getElementById('tempDirectory').value,
             thumbnailSize: parseInt(document.getElementById('thumbnailSize').value),
             maxConcurrentJobs: parseInt(document.getElementById('maxConcurrentJobs').value),
+            uploadMaxAttempts: parseInt(document.getElementById('uploadMaxAttempts').value),
+            uploadRetryDelay: parseInt(document.getElementById('uploadRetryDelay').value),
+            writeOriginals: document.getElementById('writeOriginals').checked,
+            seriesBestOnly: document.getElementById('seriesBestOnly').checked,
+            perStockMetadata: document.getElementById('perStockMetadata').checked,
+            metadataBackend: document.getElementById('metadataBackend').value,
             aiProvider: document.getElementById('aiProvider').value,
             aiModel: selectedModelId,
             aiApiKey: document.getElementById('aiApiKey').value,
             aiBaseUrl: document.getElementById('aiBaseUrl').value,
             aiTimeout: parseInt(document.getElementById('aiTimeout').value),
             aiMaxTokens: parseInt(document.getElementById('aiMaxTokens').value),
+            aiBatchBudget: parseFloat(document.getElementById('aiBatchBudget').value) || 0,
+            aiMonthlyBudget: parseFloat(document.getElementById('aiMonthlyBudget').value) || 0,
             language: document.getElementById('settingsLanguage').value,
             aiPrompts: {
                 editorial: document.getElementById('editorialPrompt').value,
@@ -1147,6 +1424,14 @@ class StockPhotoApp {
             const openLogs = this.getOpenLogsState();
             
             const queueStatus = await window.go.main.App.GetQueueStatus();
+            // Расходы на AI по каждому батчу очереди
+            await Promise.all((queueStatus || []).map(async batch => {
+                try {
+                    batch.aiUsage = await window.go.main.App.GetBatchAIUsage(batch.batchId);
+                } catch (error) {
+                    console.error('Error loading batch AI usage:', error);
+                }
+            }));
             this.renderQueue(queueStatus);
             
             // Восстанавливаем состояние открытых журналов после обновления
@@ -1157,6 +1442,17 @@ class StockPhotoApp {
         }
     }
 
+    async resumeBatchProcessing(batchId) {
+        try {
+            await window.go.main.App.ResumeBatchProcessing(batchId);
+            this.showNotification(window.i18n.t('queue.resumed'), 'success');
+            this.updateQueue();
+        } catch (error) {
+            console.error('Error resuming batch:', error);
+            this.showNotification('Error: ' + error.message, 'error');
+        }
+    }
+
     getOpenLogsState() {
         const openLogs = [];
         const logContainers = document.querySelectorAll('[id^="queue-logs-"]');
@@ -1202,6 +1498,10 @@ class StockPhotoApp {
                         <h4 class="font-medium text-gray-900">${batch.description}</h4>
                         <p class="text-sm text-gray-500">${window.i18n.t('queue.type')}: ${batch.type} • ${window.i18n.t('queue.photos')}: ${batch.processedPhotos}/${batch.totalPhotos}</p>
                         ${batch.currentStep ? `<p class="text-sm text-blue-600">${window.i18n.t('queue.currentStep')}: ${batch.currentStep}</p>` : ''}
+                        ${batch.aiUsage && batch.aiUsage.requests > 0 ? `<p class="text-sm text-gray-500">${window.i18n.t('queue.aiCost', {
+                            cost: batch.aiUsage.cost.toFixed(3) + (batch.aiUsage.budget > 0 ? ' / $' + batch.aiUsage.budget.toFixed(2) : ''),
+                            tokens: (batch.aiUsage.promptTokens + batch.aiUsage.completionTokens).toLocaleString()
+                        })}</p>` : ''}
                         ${batch.photos && batch.photos.length > 0 ? (() => {
                             const stats = this.calculateBatchStats(batch.photos);
                             return `
@@ -1220,6 +1520,12 @@ class StockPhotoApp {
                         })() : ''}
                     </div>
                     <div class="flex items-center space-x-2">
+                        ${batch.status === 'paused' ? `
+                            <button onclick="app.resumeBatchProcessing('${batch.batchId}')"
+                                    class="text-xs bg-orange-600 text-white px-2 py-1 rounded hover:bg-orange-700">
+                                <i class="fas fa-play mr-1"></i>${window.i18n.t('queue.resume')}
+                            </button>
+                        ` : ''}
                         <button onclick="app.toggleQueueLogs('${batch.batchId}')" 
                                 class="text-gray-500 hover:text-blue-600 p-1 rounded" 
                                 title="${window.i18n.t('queue.viewLogs') || 'Показать логи'}">
@@ -1402,6 +1708,9 @@ class StockPhotoApp {
             'ai_analysis': 'AI анализ',
             'saving': 'Сохранение',
             'exif_writing': 'Запись EXIF',
+            'budget_paused': 'Лимит AI',
+            'interrupted': 'Прервано',
+            'rate_limit_wait': 'Ожидание лимита запросов',
             'completed': 'Завершено'
         };
         return stepNames[step] || step;
@@ -1659,6 +1968,10 @@ class StockPhotoApp {
 
         try {
             const photos = await window.go.main.App.GetBatchPhotos(batchId);
+            const series = await window.go.main.App.GetBatchSeries(batchId);
+            this.batchSeries = Object.fromEntries((series || []).map(item => [item.id, item]));
+            const stocks = await window.go.main.App.GetStockConfigs();
+            this.stockNames = Object.fromEntries((stocks || []).map(stock => [stock.id, stock.name]));
             this.renderPhotosForReview(photos);
             this.updateBatchActionsIfExists(batchId);
         } catch (error) {
@@ -1690,7 +2003,7 @@ class StockPhotoApp {
             const statusIcon = this.getPhotoStatusIcon(photo.status);
             
             return `
-                <div class="bg-white rounded-lg shadow-md overflow-hidden">
+                <div id="photo-card-${photo.id}" class="bg-white rounded-lg shadow-md overflow-hidden">
                     <!-- Изображение -->
                     <div class="relative">
                         <img id="thumb-${photo.id}" 
@@ -1722,6 +2035,9 @@ class StockPhotoApp {
                     <!-- Информация о фото -->
                     <div class="p-4">
                         <h3 class="font-medium text-gray-900 mb-2">${photo.fileName}</h3>
+                        ${this.renderRenditions(photo)}
+                        ${this.renderDuplicate(photo)}
+                        ${this.renderSeries(photo)}
                         
                         ${hasAI ? `
                             <!-- AI результаты -->
@@ -1780,6 +2096,9 @@ class StockPhotoApp {
                             </div>
                         `}
 
+                        ${this.renderStockMetadata(photo)}
+                        ${this.renderMetadataVerification(photo)}
+
                         <!-- Действия -->
                         <div class="mt-4 flex justify-between items-center">
                             <div class="flex space-x-2">
@@ -1865,6 +2184,197 @@ class StockPhotoApp {
         return icons[status] || 'fas fa-question-circle';
     }
 
+    // Файлы фото с одним именем (RAW, JPEG, XMP sidecar, релиз); для одиночного файла ничего не показываем
+    renderRenditions(photo) {
+        const renditions = photo.renditions || [];
+        if (renditions.length < 2) return '';
+
+        const badges = renditions.map(rendition => {
+            const ext = rendition.fileName.split('.').pop().toUpperCase();
+            const primary = rendition.path === photo.originalPath;
+            return `<span class="px-1.5 py-0.5 rounded ${primary ? 'bg-blue-100 text-blue-800' : 'bg-gray-100 text-gray-700'}"
+                          title="${this.escapeHtml(rendition.fileName)} (${window.i18n.t('review.renditions.' + rendition.kind)})">${this.escapeHtml(ext)}</span>`;
+        }).join('');
+
+        return `<div class="flex flex-wrap gap-1 mb-2 text-xs">${badges}</div>`;
+    }
+
+    // Ссылка на фото, дубликатом или почти дубликатом которого является это фото
+    renderDuplicate(photo) {
+        const duplicate = photo.duplicate;
+        if (!duplicate || !duplicate.photoId) return '';
+
+        const exact = duplicate.kind === 'exact';
+        const uploaded = duplicate.uploaded ? ` (${window.i18n.t('review.duplicate.uploaded')})` : '';
+        return `
+            <div class="mb-2 text-xs ${exact ? 'text-red-700' : 'text-yellow-700'}">
+                <i class="fas fa-clone mr-1"></i>
+                ${window.i18n.t('review.duplicate.' + (exact ? 'exact' : 'near'))}
+                <a href="#" class="underline"
+                   onclick="window.app.showSimilarPhoto('${duplicate.batchId}', '${duplicate.photoId}'); return false;">${this.escapeHtml(duplicate.fileName)}</a>${uploaded}
+            </div>
+        `;
+    }
+
+    // Кадр серии: номер кадра, оценка качества и действия с серией
+    renderSeries(photo) {
+        const series = photo.seriesId && this.batchSeries ? this.batchSeries[photo.seriesId] : null;
+        if (!series) return '';
+
+        const index = series.frames.findIndex(frame => frame.photoId === photo.id) + 1;
+        const best = series.bestPhotoId === photo.id;
+        const actions = best
+            ? `<button onclick="window.app.propagateSeriesMetadata('${series.id}')" class="underline">${window.i18n.t('review.series.propagate')}</button>`
+            : `<button onclick="window.app.setSeriesBestPhoto('${series.id}', '${photo.id}')" class="underline">${window.i18n.t('review.series.makeBest')}</button>`;
+
+        return `
+            <div class="mb-2 text-xs text-purple-700 flex flex-wrap items-center gap-2">
+                <span><i class="fas fa-layer-group mr-1"></i>${window.i18n.t('review.series.frame', { index, total: series.frames.length })}</span>
+                ${best ? `<span class="px-1.5 py-0.5 rounded bg-purple-100">${window.i18n.t('review.series.best')}</span>` : ''}
+                <span title="${window.i18n.t('review.series.qualityHelp')}">${window.i18n.t('review.series.quality', { score: (photo.qualityScore || 0).toFixed(1) })}</span>
+                ${actions}
+                <button onclick="window.app.removeFromSeries('${series.id}', '${photo.id}')" class="underline">${window.i18n.t('review.series.remove')}</button>
+            </div>
+        `;
+    }
+
+    async setSeriesBestPhoto(seriesId, photoId) {
+        try {
+            await window.go.main.App.SetSeriesBestPhoto(seriesId, photoId);
+            this.loadBatchForReview(document.getElementById('batchSelector').value);
+        } catch (error) {
+            console.error('Error setting best frame:', error);
+            this.showNotification('Error: ' + error.message, 'error');
+        }
+    }
+
+    async removeFromSeries(seriesId, photoId) {
+        const series = this.batchSeries[seriesId];
+        const photoIds = series.frames.map(frame => frame.photoId).filter(id => id !== photoId);
+        try {
+            await window.go.main.App.UpdateSeriesPhotos(seriesId, photoIds);
+            this.loadBatchForReview(document.getElementById('batchSelector').value);
+        } catch (error) {
+            console.error('Error updating series:', error);
+            this.showNotification('Error: ' + error.message, 'error');
+        }
+    }
+
+    async propagateSeriesMetadata(seriesId) {
+        if (!confirm(window.i18n.t('review.series.propagateConfirm'))) return;
+
+        try {
+            const count = await window.go.main.App.PropagateSeriesMetadata(seriesId);
+            this.showNotification(window.i18n.t('review.series.propagated', { count }), 'success');
+            this.loadBatchForReview(document.getElementById('batchSelector').value);
+        } catch (error) {
+            console.error('Error propagating series metadata:', error);
+            this.showNotification('Error: ' + error.message, 'error');
+        }
+    }
+
+    // Метаданные, созданные по промптам стоков: сток получает их вместо общих метаданных фото
+    renderStockMetadata(photo) {
+        const stockIds = Object.keys(photo.stockAiResults || {});
+        if (stockIds.length === 0) return '';
+
+        const variants = stockIds.map(stockId => {
+            const result = photo.stockAiResults[stockId];
+            const stockName = (this.stockNames && this.stockNames[stockId]) || stockId;
+            return `
+                <details class="border-t border-indigo-100 pt-1 mt-1">
+                    <summary class="cursor-pointer font-medium">${this.escapeHtml(stockName)}</summary>
+                    <p class="mt-1"><span class="font-medium">Title:</span> ${this.escapeHtml(result.title)}</p>
+                    <p><span class="font-medium">Description:</span> ${this.escapeHtml(result.description)}</p>
+                    <p class="break-words"><span class="font-medium">Keywords:</span> ${this.escapeHtml((result.keywords || []).join(', '))}</p>
+                    <button onclick="window.app.resetPhotoStockMetadata('${photo.id}', '${stockId}')" class="mt-1 underline">
+                        ${window.i18n.t('review.stockMetadata.reset')}
+                    </button>
+                </details>
+            `;
+        }).join('');
+
+        return `
+            <div class="mt-3 p-2 rounded text-xs bg-indigo-50 text-indigo-800">
+                <p class="font-medium" title="${window.i18n.t('review.stockMetadata.help')}"><i class="fas fa-store mr-1"></i>${window.i18n.t('review.stockMetadata.title', { count: stockIds.length })}</p>
+                ${variants}
+            </div>
+        `;
+    }
+
+    async resetPhotoStockMetadata(photoId, stockId) {
+        if (!confirm(window.i18n.t('review.stockMetadata.resetConfirm'))) return;
+
+        try {
+            await window.go.main.App.ResetPhotoStockMetadata(photoId, stockId);
+            this.loadBatchForReview(document.getElementById('batchSelector').value);
+        } catch (error) {
+            console.error('Error resetting stock metadata:', error);
+            this.showNotification('Error: ' + error.message, 'error');
+        }
+    }
+
+    // Открывает батч похожего фото и прокручивает ревью к его карточке
+    async showSimilarPhoto(batchId, photoId) {
+        const selector = document.getElementById('batchSelector');
+        if (selector.value !== batchId) {
+            if (![...selector.options].some(option => option.value === batchId)) {
+                this.showNotification(window.i18n.t('review.duplicate.notFound'), 'error');
+                return;
+            }
+            selector.value = batchId;
+            await this.loadBatchForReview(batchId);
+        }
+
+        const card = document.getElementById(`photo-card-${photoId}`);
+        if (!card) {
+            this.showNotification(window.i18n.t('review.duplicate.notFound'), 'error');
+            return;
+        }
+        card.scrollIntoView({ behavior: 'smooth', block: 'center' });
+        card.classList.add('ring-4', 'ring-yellow-400');
+        setTimeout(() => card.classList.remove('ring-4', 'ring-yellow-400'), 2000);
+    }
+
+    // Результат проверки метаданных, записанных в файл; при расхождениях - кнопка разрешения загрузки
+    renderMetadataVerification(photo) {
+        const verification = photo.metadataVerification;
+        if (!verification || verification.status === 'unsupported') return '';
+
+        const styles = {
+            'ok': ['bg-green-50 text-green-800', 'fas fa-check-circle'],
+            'warning': ['bg-yellow-50 text-yellow-800', 'fas fa-exclamation-circle'],
+            'mismatch': ['bg-red-50 text-red-800', 'fas fa-exclamation-triangle'],
+            'error': ['bg-red-50 text-red-800', 'fas fa-exclamation-triangle']
+        };
+        const [styleClass, icon] = styles[verification.status] || styles.error;
+        const blocked = verification.status === 'mismatch' || verification.status === 'error';
+
+        const issues = (verification.issues || []).map(issue => {
+            const values = [issue.expected, issue.actual].filter(Boolean).map(value => `"${this.escapeHtml(value)}"`).join(' → ');
+            return `<li>${this.escapeHtml([issue.source, issue.field].filter(Boolean).join(' '))}: ${this.escapeHtml(issue.kind)}${values ? ' ' + values : ''}</li>`;
+        }).join('');
+
+        let footer = '';
+        if (blocked && photo.metadataOverride) {
+            footer = `<p class="mt-1 font-medium">${window.i18n.t('review.metadataVerification.overridden')}</p>`;
+        } else if (blocked) {
+            footer = `
+                <button onclick="window.app.overrideMetadataVerification('${photo.id}')"
+                        class="mt-2 px-2 py-1 bg-red-600 text-white text-xs rounded hover:bg-red-700">
+                    <i class="fas fa-unlock mr-1"></i>${window.i18n.t('review.metadataVerification.override')}
+                </button>`;
+        }
+
+        return `
+            <div class="mt-3 p-2 rounded text-xs ${styleClass}">
+                <p class="font-medium"><i class="${icon} mr-1"></i>${window.i18n.t('review.metadataVerification.' + verification.status)}</p>
+                ${issues ? `<ul class="mt-1 list-disc list-inside break-words">${issues}</ul>` : ''}
+                ${footer}
+            </div>
+        `;
+    }
+
     escapeHtml(text) {
         if (!text) return '';
         const div = document.createElement('div');
@@ -1941,6 +2451,23 @@ class StockPhotoApp {
         }
     }
 
+    async overrideMetadataVerification(photoId) {
+        if (!confirm(window.i18n.t('review.metadataVerification.overrideConfirm'))) return;
+
+        try {
+            await window.go.main.App.OverrideMetadataVerification(photoId);
+            this.showNotification(window.i18n.t('review.metadataVerification.overrideDone'), 'success');
+
+            const batchId = document.getElementById('batchSelector').value;
+            if (batchId) {
+                this.loadBatchForReview(batchId);
+            }
+        } catch (error) {
+            console.error('Error overriding metadata verification:', error);
+            this.showNotification('Error: ' + error.message, 'error');
+        }
+    }
+
     async rejectPhoto(photoId) {
         const rejectBtn = document.querySelector(`button[onclick*="rejectPhoto('${photoId}')"]`);
         const originalText = rejectBtn ? rejectBtn.innerHTML : '';
@@ -2103,7 +2630,7 @@ class StockPhotoApp {
         const photoData = await this.getPhotoData(photoId);
         
         // Показываем диалог с текущими данными и возможностью добавить комментарий
-        this.showRegenerateDialog(photoData, async (correctionComment) => {
+        this.showRegenerateDialog(photoData, async (correctionComment, forceFresh) => {
                 try {
                     // Этап 1: Начинаем процесс
                     this.setRegenerateButtonState(regenerateBtn, regenerateIcon, 'loading', 'Preparing...');
@@ -2113,7 +2640,7 @@ class StockPhotoApp {
                     this.setRegenerateButtonState(regenerateBtn, regenerateIcon, 'processing', 'Analyzing...');
                     
                     if (this.isWailsMode) {
-                        await window.go.main.App.RegeneratePhotoMetadata(photoId, correctionComment || '');
+                        await window.go.main.App.RegeneratePhotoMetadata(photoId, correctionComment || '', forceFresh);
                     } else {
                         // Mock режим с имитацией этапов
                         await this.delay(1000);
@@ -2391,6 +2918,10 @@ class StockPhotoApp {
                             <i class="fas fa-lightbulb mr-1"></i>
                             ${window.i18n.t('review.regenerateDialog.feedbackTip')}
                         </p>
+                        <label class="inline-flex items-center mt-3">
+                            <input type="checkbox" id="forceFreshInput" checked class="rounded border-gray-300 text-blue-600 shadow-sm">
+                            <span class="ml-2 text-sm text-gray-700">${window.i18n.t('review.regenerateDialog.forceFresh')}</span>
+                        </label>
                     </div>
                     
                     <div class="flex justify-center space-x-4">
@@ -2412,8 +2943,9 @@ class StockPhotoApp {
         
         const handleConfirm = () => {
             const correctionComment = input.value.trim();
+            const forceFresh = document.getElementById('forceFreshInput').checked;
             document.body.removeChild(modal);
-            if (onConfirm) onConfirm(correctionComment);
+            if (onConfirm) onConfirm(correctionComment, forceFresh);
         };
         
         const handleCancel = () => {
@@ -1,10 +1,25 @@
 // Модуль управления загрузкой фотографий
+import { EventsOn } from '../wailsjs/runtime/runtime.js';
+
 export class UploadManager {
     constructor(app) {
         this.app = app;
         this.uploadQueueInterval = null;
+        this.isTrackingUploads = false;
+        this.uploadEventsSubscribed = false;
         this.selectedPhotos = new Set();
         this.initializeEventListeners();
+        this.subscribeToUploadEvents();
+    }
+
+    // Подписка на события очереди загрузки от backend, чтобы не опрашивать статус
+    subscribeToUploadEvents() {
+        // В браузерном режиме без Wails событий нет, остается опрос
+        if (!window.runtime) return;
+
+        EventsOn('upload:status', (status) => this.handleUploadStatusEvent(status));
+        EventsOn('upload:progress', (progress) => this.updateJobProgress(progress));
+        this.uploadEventsSubscribed = true;
     }
 
     initializeEventListeners() {
@@ -227,25 +242,21 @@ export class UploadManager {
     startUploadQueueTracking() {
         // Показываем секцию статуса
         document.getElementById('uploadQueueStatus').classList.remove('hidden');
-        
-        // Проверяем статус каждые 2 секунды
+        this.isTrackingUploads = true;
+
+        if (this.uploadEventsSubscribed) {
+            // Дальше статус приходит событиями upload:status
+            window.go.main.App.GetUploadQueueStatus()
+                .then(status => this.handleUploadStatus(status))
+                .catch(error => console.error('Error getting upload queue status:', error));
+            return;
+        }
+
+        // Без событий проверяем статус каждые 2 секунды
         this.uploadQueueInterval = setInterval(async () => {
             try {
                 const status = await window.go.main.App.GetUploadQueueStatus();
-                this.updateUploadQueueStatus(status);
-                
-                // Если очередь пуста и нет активных загрузок, останавливаем отслеживание
-                if (!status.isProcessing || (status.activeUploads === 0 && status.queueLength === 0)) {
-                    this.stopUploadQueueTracking();
-                    
-                    // Обновляем интерфейс Review через некоторое время
-                    setTimeout(() => {
-                        const batchId = document.getElementById('batchSelector').value;
-                        if (batchId) {
-                            this.app.loadBatchForReview(batchId);
-                        }
-                    }, 1000);
-                }
+                this.handleUploadStatus(status);
             } catch (error) {
                 console.error('Error tracking upload queue:', error);
                 this.stopUploadQueueTracking();
@@ -253,16 +264,49 @@ export class UploadManager {
         }, 2000);
     }
 
+    // Событие upload:status: показываем очередь, даже если загрузку запустили не из этого окна (например, продолжение после перезапуска)
+    handleUploadStatusEvent(status) {
+        if (!this.isTrackingUploads) {
+            if (status.activeUploads === 0 && status.queueLength === 0) return;
+            document.getElementById('uploadQueueStatus').classList.remove('hidden');
+            this.isTrackingUploads = true;
+        }
+        this.handleUploadStatus(status);
+    }
+
+    // Обновляет UI по статусу очереди и завершает отслеживание, когда очередь опустела
+    handleUploadStatus(status) {
+        if (!this.isTrackingUploads) return;
+
+        this.updateUploadQueueStatus(status);
+
+        // Если очередь пуста и нет активных загрузок, останавливаем отслеживание
+        if (!status.isProcessing || (status.activeUploads === 0 && status.queueLength === 0)) {
+            this.stopUploadQueueTracking();
+
+            // Обновляем интерфейс Review через некоторое время
+            setTimeout(() => {
+                const batchId = document.getElementById('batchSelector').value;
+                if (batchId) {
+                    this.app.loadBatchForReview(batchId);
+                }
+            }, 1000);
+        }
+    }
+
     // Остановить отслеживание очереди
     stopUploadQueueTracking() {
+        this.isTrackingUploads = false;
         if (this.uploadQueueInterval) {
             clearInterval(this.uploadQueueInterval);
             this.uploadQueueInterval = null;
         }
         
-        // Скрываем секцию статуса через 3 секунды
+        // Скрываем секцию статуса через 3 секунды, если за это время загрузка не возобновилась
         setTimeout(() => {
-            document.getElementById('uploadQueueStatus').classList.add('hidden');
+            if (!this.isTrackingUploads) {
+                document.getElementById('uploadQueueStatus').classList.add('hidden');
+            }
         }, 3000);
     }
 
@@ -274,11 +318,97 @@ export class UploadManager {
         
         const statusText = status.isProcessing ? 'Processing' : 'Idle';
         document.getElementById('queueStatusText').textContent = statusText;
+
+        // ETA текущего батча по суммарной скорости его загрузок
+        const batchId = document.getElementById('batchSelector').value;
+        const batch = (status.batches || []).find(b => b.batchId === batchId);
+        this.updateBatchEta(batch ? batch.etaSeconds : -1);
         
+        // Обновляем статистику по стокам
+        this.renderStockQueues(status.stocks || []);
+
         // Обновляем список активных заданий
         this.renderActiveJobs(status.activeJobs || []);
     }
 
+    // Отображение очередей по стокам
+    renderStockQueues(stocks) {
+        const container = document.getElementById('stockQueuesList');
+        if (!container) return;
+
+        container.innerHTML = stocks.map(stock => `
+            <div class="flex justify-between items-center bg-white rounded px-3 py-2 text-sm">
+                <div class="font-medium">${stock.stockName}</div>
+                <div class="text-xs text-gray-600">
+                    <span class="mr-3"><i class="fas fa-arrow-up mr-1"></i>${stock.activeUploads}/${stock.maxConnections}</span>
+                    <span class="mr-3"><i class="fas fa-list mr-1"></i>${stock.queueDepth}</span>
+                    <span class="mr-3 text-green-600"><i class="fas fa-check mr-1"></i>${stock.uploadedCount}</span>
+                    <span class="mr-3 text-red-600"><i class="fas fa-times mr-1"></i>${stock.failedCount}</span>
+                    <span class="mr-3 text-yellow-600"><i class="fas fa-redo mr-1"></i>${stock.retriedCount}</span>
+                    <span>${stock.throughputPerMinute.toFixed(1)}/min</span>
+                    ${stock.deadLetterCount > 0 ? `
+                        <button class="retry-failed-uploads-btn ml-3 text-blue-600 hover:text-blue-800" data-stock-id="${stock.stockId}">
+                            <i class="fas fa-redo mr-1"></i>Retry ${stock.deadLetterCount}
+                        </button>` : ''}
+                    <button class="export-metadata-csv-btn ml-3 text-blue-600 hover:text-blue-800" data-stock-id="${stock.stockId}" title="Export metadata CSV">
+                        <i class="fas fa-file-csv"></i>
+                    </button>
+                </div>
+            </div>
+        `).join('');
+
+        container.querySelectorAll('.retry-failed-uploads-btn').forEach(btn => {
+            btn.addEventListener('click', () => this.retryFailedUploads(btn.dataset.stockId));
+        });
+        container.querySelectorAll('.export-metadata-csv-btn').forEach(btn => {
+            btn.addEventListener('click', () => this.exportBatchCSV(btn.dataset.stockId));
+        });
+    }
+
+    // Экспорт CSV метаданных батча в формате агентства стока, путь выбирается в диалоге
+    async exportBatchCSV(stockId) {
+        const batchId = document.getElementById('batchSelector').value;
+        if (!batchId) {
+            this.app.showNotification('Please select a batch first', 'error');
+            return;
+        }
+
+        try {
+            const count = await window.go.main.App.ExportBatchCSV(batchId, stockId, '');
+            if (count > 0) {
+                this.app.showNotification(`Metadata CSV exported for ${count} photos`, 'success');
+            }
+        } catch (error) {
+            console.error('Error exporting metadata CSV:', error);
+            this.app.showNotification('Error exporting metadata CSV: ' + (error.message || error), 'error');
+        }
+    }
+
+    // Повторить загрузки, остановленные после ошибок (пустой stockId - все стоки)
+    async retryFailedUploads(stockId = '') {
+        const batchId = document.getElementById('batchSelector').value;
+        if (!batchId) {
+            this.app.showNotification('Please select a batch first', 'error');
+            return;
+        }
+
+        try {
+            const count = await window.go.main.App.RetryFailedUploads(batchId, stockId);
+            if (count === 0) {
+                this.app.showNotification('No failed uploads to retry', 'info');
+                return;
+            }
+
+            if (!this.isTrackingUploads) {
+                this.startUploadQueueTracking();
+            }
+            this.app.showNotification(`Retrying upload of ${count} photos`, 'success');
+        } catch (error) {
+            console.error('Error retrying failed uploads:', error);
+            this.app.showNotification('Error retrying uploads: ' + (error.message || error), 'error');
+        }
+    }
+
     // Отображение активных заданий
     renderActiveJobs(activeJobs) {
         const container = document.getElementById('activeJobsList');
@@ -297,8 +427,10 @@ export class UploadManager {
                 return `<span class="${statusClass}"><i class="fas ${statusIcon} mr-1"></i>${stockId.substr(0, 8)}</span>`;
             }).join(' ');
 
+            const percent = job.bytesTotal > 0 ? Math.round(job.bytesSent * 100 / job.bytesTotal) : 0;
+
             return `
-                <div class="bg-white rounded p-3 border border-gray-200">
+                <div class="bg-white rounded p-3 border border-gray-200" data-job-id="${job.jobId}">
                     <div class="flex justify-between items-center">
                         <div class="flex-1">
                             <div class="font-medium text-sm">${job.fileName}</div>
@@ -308,6 +440,10 @@ export class UploadManager {
                             ${progressStocks}
                         </div>
                     </div>
+                    <div class="mt-2 w-full bg-gray-200 rounded h-1.5">
+                        <div class="upload-job-bar bg-blue-600 h-1.5 rounded" style="width: ${percent}%"></div>
+                    </div>
+                    <div class="upload-job-bytes mt-1 text-xs text-gray-500">${this.formatTransfer(job.bytesSent, job.bytesTotal, job.bytesPerSecond)}</div>
                 </div>
             `;
         }).join('');
@@ -315,6 +451,50 @@ export class UploadManager {
         container.innerHTML = jobsHTML;
     }
 
+    // Событие upload:progress: обновляем прогресс одной загрузки без перерисовки списка
+    updateJobProgress(progress) {
+        const row = document.querySelector(`#activeJobsList [data-job-id="${progress.jobId}"]`);
+        if (row) {
+            const percent = progress.bytesTotal > 0 ? Math.round(progress.bytesSent * 100 / progress.bytesTotal) : 0;
+            row.querySelector('.upload-job-bar').style.width = `${percent}%`;
+            row.querySelector('.upload-job-bytes').textContent =
+                this.formatTransfer(progress.bytesSent, progress.bytesTotal, progress.bytesPerSecond);
+        }
+
+        if (progress.batchId === document.getElementById('batchSelector').value) {
+            this.updateBatchEta(progress.batchEtaSeconds);
+        }
+    }
+
+    // Показывает оставшееся время загрузки батча
+    updateBatchEta(etaSeconds) {
+        const etaElement = document.getElementById('uploadBatchEta');
+        if (!etaElement) return;
+
+        if (etaSeconds === undefined || etaSeconds < 0) {
+            etaElement.textContent = '';
+            return;
+        }
+
+        const minutes = Math.floor(etaSeconds / 60);
+        const seconds = etaSeconds % 60;
+        etaElement.textContent = minutes > 0 ? `ETA ${minutes}m ${seconds}s` : `ETA ${seconds}s`;
+    }
+
+    // Форматирует прогресс передачи: "12.5 MB / 200.0 MB · 1.2 MB/s"
+    formatTransfer(sent, total, bytesPerSecond) {
+        if (!total) return '';
+
+        const formatBytes = (bytes) => {
+            if (bytes >= 1024 * 1024) return `${(bytes / 1024 / 1024).toFixed(1)} MB`;
+            if (bytes >= 1024) return `${(bytes / 1024).toFixed(0)} KB`;
+            return `${bytes} B`;
+        };
+
+        const rate = bytesPerSecond > 0 ? ` · ${formatBytes(Math.round(bytesPerSecond))}/s` : '';
+        return `${formatBytes(sent)} / ${formatBytes(total)}${rate}`;
+    }
+
     // Обновить UI выбора
     updateSelectionUI() {
         const selectedCount = document.querySelectorAll('.photo-select-checkbox:checked').length;
@@ -12,26 +12,46 @@ export function CleanOldLogs(arg1:number):Promise<void>;
 
 export function ClearAllPhotoSelection(arg1:string):Promise<void>;
 
+export function DeleteAIRateLimit(arg1:string,arg2:string):Promise<void>;
+
 export function DeleteBatch(arg1:string):Promise<void>;
 
+export function DeleteModelPrice(arg1:string):Promise<void>;
+
 export function DeleteStockConfig(arg1:string):Promise<void>;
 
+export function ExportBatchCSV(arg1:string,arg2:string,arg3:string):Promise<number>;
+
+export function FindFolderDuplicates(arg1:string):Promise<Array<models.Photo>>;
+
 export function ForceUpdateDefaultPrompts():Promise<void>;
 
 export function GetAIModels(arg1:string):Promise<Array<models.AIModel>>;
 
+export function GetAIProviders():Promise<Array<string>>;
+
+export function GetAIRateLimits():Promise<Array<models.AIRateLimit>>;
+
 export function GetAvailableUploaders():Promise<Array<models.UploaderInfo>>;
 
+export function GetBatchAIUsage(arg1:string):Promise<models.AIUsageSummary>;
+
 export function GetBatchDetails(arg1:string):Promise<models.PhotoBatch>;
 
 export function GetBatchEvents(arg1:string,arg2:number):Promise<Array<models.EventLog>>;
 
 export function GetBatchPhotos(arg1:string):Promise<Array<models.Photo>>;
 
+export function GetBatchSeries(arg1:string):Promise<Array<models.PhotoSeries>>;
+
 export function GetDefaultLanguage():Promise<string>;
 
 export function GetFolderContents(arg1:string):Promise<Array<models.PhotoFile>>;
 
+export function GetModelPrices():Promise<Array<models.AIModelPrice>>;
+
+export function GetMonthlyAIUsage(arg1:string):Promise<models.AIUsageSummary>;
+
 export function GetPhoto(arg1:string):Promise<models.Photo>;
 
 export function GetPhotoEvents(arg1:string):Promise<Array<models.EventLog>>;
@@ -58,14 +78,32 @@ export function GetUploadProgress(arg1:string):Promise<Record<string, any>>;
 
 export function GetUploadQueueStatus():Promise<Record<string, any>>;
 
-export function ProcessPhotoFolder(arg1:string,arg2:string,arg3:string):Promise<void>;
+export function OverrideMetadataVerification(arg1:string):Promise<void>;
+
+export function ProcessPhotoFolder(arg1:string,arg2:string,arg3:string,arg4:boolean):Promise<void>;
 
-export function RegeneratePhotoMetadata(arg1:string,arg2:string):Promise<void>;
+export function PropagateSeriesMetadata(arg1:string):Promise<number>;
+
+export function PruneAICache(arg1:number):Promise<number>;
+
+export function RegeneratePhotoMetadata(arg1:string,arg2:string,arg3:boolean):Promise<void>;
 
 export function RejectPhoto(arg1:string):Promise<void>;
 
+export function ResetPhotoStockMetadata(arg1:string,arg2:string):Promise<void>;
+
 export function ResetPhotoToProcessed(arg1:string):Promise<void>;
 
+export function ResetStockHostKey(arg1:string):Promise<void>;
+
+export function ResumeBatchProcessing(arg1:string):Promise<void>;
+
+export function RetryFailedUploads(arg1:string,arg2:string):Promise<number>;
+
+export function SaveAIRateLimit(arg1:models.AIRateLimit):Promise<void>;
+
+export function SaveModelPrice(arg1:models.AIModelPrice):Promise<void>;
+
 export function SaveSettings(arg1:models.AppSettings):Promise<void>;
 
 export function SaveStockConfig(arg1:models.StockConfig):Promise<void>;
@@ -78,6 +116,8 @@ export function SetPhotoSelectedForUpload(arg1:string,arg2:boolean):Promise<void
 
 export function SetPhotoStatus(arg1:string,arg2:string):Promise<void>;
 
+export function SetSeriesBestPhoto(arg1:string,arg2:string):Promise<void>;
+
 export function StartQueueProcessing():Promise<void>;
 
 export function StopQueueProcessing():Promise<void>;
@@ -92,6 +132,8 @@ export function UpdateAIPrompt(arg1:string,arg2:string):Promise<void>;
 
 export function UpdatePhotoMetadata(arg1:string,arg2:models.AIResult):Promise<void>;
 
+export function UpdateSeriesPhotos(arg1:string,arg2:Array<string>):Promise<void>;
+
 export function UploadApprovedPhotos(arg1:string):Promise<void>;
 
 export function UploadSelectedPhotos(arg1:string,arg2:Array<string>):Promise<void>;
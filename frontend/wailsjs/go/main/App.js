@@ -22,14 +22,30 @@ export function ClearAllPhotoSelection(arg1) {
   return window['go']['main']['App']['ClearAllPhotoSelection'](arg1);
 }
 
+export function DeleteAIRateLimit(arg1, arg2) {
+  return window['go']['main']['App']['DeleteAIRateLimit'](arg1, arg2);
+}
+
 export function DeleteBatch(arg1) {
   return window['go']['main']['App']['DeleteBatch'](arg1);
 }
 
+export function DeleteModelPrice(arg1) {
+  return window['go']['main']['App']['DeleteModelPrice'](arg1);
+}
+
 export function DeleteStockConfig(arg1) {
   return window['go']['main']['App']['DeleteStockConfig'](arg1);
 }
 
+export function ExportBatchCSV(arg1, arg2, arg3) {
+  return window['go']['main']['App']['ExportBatchCSV'](arg1, arg2, arg3);
+}
+
+export function FindFolderDuplicates(arg1) {
+  return window['go']['main']['App']['FindFolderDuplicates'](arg1);
+}
+
 export function ForceUpdateDefaultPrompts() {
   return window['go']['main']['App']['ForceUpdateDefaultPrompts']();
 }
@@ -38,10 +54,22 @@ export function GetAIModels(arg1) {
   return window['go']['main']['App']['GetAIModels'](arg1);
 }
 
+export function GetAIProviders() {
+  return window['go']['main']['App']['GetAIProviders']();
+}
+
+export function GetAIRateLimits() {
+  return window['go']['main']['App']['GetAIRateLimits']();
+}
+
 export function GetAvailableUploaders() {
   return window['go']['main']['App']['GetAvailableUploaders']();
 }
 
+export function GetBatchAIUsage(arg1) {
+  return window['go']['main']['App']['GetBatchAIUsage'](arg1);
+}
+
 export function GetBatchDetails(arg1) {
   return window['go']['main']['App']['GetBatchDetails'](arg1);
 }
@@ -54,6 +82,10 @@ export function GetBatchPhotos(arg1) {
   return window['go']['main']['App']['GetBatchPhotos'](arg1);
 }
 
+export function GetBatchSeries(arg1) {
+  return window['go']['main']['App']['GetBatchSeries'](arg1);
+}
+
 export function GetDefaultLanguage() {
   return window['go']['main']['App']['GetDefaultLanguage']();
 }
@@ -62,6 +94,14 @@ export function GetFolderContents(arg1) {
   return window['go']['main']['App']['GetFolderContents'](arg1);
 }
 
+export function GetModelPrices() {
+  return window['go']['main']['App']['GetModelPrices']();
+}
+
+export function GetMonthlyAIUsage(arg1) {
+  return window['go']['main']['App']['GetMonthlyAIUsage'](arg1);
+}
+
 export function GetPhoto(arg1) {
   return window['go']['main']['App']['GetPhoto'](arg1);
 }
@@ -114,22 +154,58 @@ export function GetUploadQueueStatus() {
   return window['go']['main']['App']['GetUploadQueueStatus']();
 }
 
-export function ProcessPhotoFolder(arg1, arg2, arg3) {
-  return window['go']['main']['App']['ProcessPhotoFolder'](arg1, arg2, arg3);
+export function OverrideMetadataVerification(arg1) {
+  return window['go']['main']['App']['OverrideMetadataVerification'](arg1);
+}
+
+export function ProcessPhotoFolder(arg1, arg2, arg3, arg4) {
+  return window['go']['main']['App']['ProcessPhotoFolder'](arg1, arg2, arg3, arg4);
+}
+
+export function PropagateSeriesMetadata(arg1) {
+  return window['go']['main']['App']['PropagateSeriesMetadata'](arg1);
 }
 
-export function RegeneratePhotoMetadata(arg1, arg2) {
-  return window['go']['main']['App']['RegeneratePhotoMetadata'](arg1, arg2);
+export function PruneAICache(arg1) {
+  return window['go']['main']['App']['PruneAICache'](arg1);
+}
+
+export function RegeneratePhotoMetadata(arg1, arg2, arg3) {
+  return window['go']['main']['App']['RegeneratePhotoMetadata'](arg1, arg2, arg3);
 }
 
 export function RejectPhoto(arg1) {
   return window['go']['main']['App']['RejectPhoto'](arg1);
 }
 
+export function ResetPhotoStockMetadata(arg1, arg2) {
+  return window['go']['main']['App']['ResetPhotoStockMetadata'](arg1, arg2);
+}
+
 export function ResetPhotoToProcessed(arg1) {
   return window['go']['main']['App']['ResetPhotoToProcessed'](arg1);
 }
 
+export function ResetStockHostKey(arg1) {
+  return window['go']['main']['App']['ResetStockHostKey'](arg1);
+}
+
+export function ResumeBatchProcessing(arg1) {
+  return window['go']['main']['App']['ResumeBatchProcessing'](arg1);
+}
+
+export function RetryFailedUploads(arg1, arg2) {
+  return window['go']['main']['App']['RetryFailedUploads'](arg1, arg2);
+}
+
+export function SaveAIRateLimit(arg1) {
+  return window['go']['main']['App']['SaveAIRateLimit'](arg1);
+}
+
+export function SaveModelPrice(arg1) {
+  return window['go']['main']['App']['SaveModelPrice'](arg1);
+}
+
 export function SaveSettings(arg1) {
   return window['go']['main']['App']['SaveSettings'](arg1);
 }
@@ -154,6 +230,10 @@ export function SetPhotoStatus(arg1, arg2) {
   return window['go']['main']['App']['SetPhotoStatus'](arg1, arg2);
 }
 
+export function SetSeriesBestPhoto(arg1, arg2) {
+  return window['go']['main']['App']['SetSeriesBestPhoto'](arg1, arg2);
+}
+
 export function StartQueueProcessing() {
   return window['go']['main']['App']['StartQueueProcessing']();
 }
@@ -182,6 +262,10 @@ export function UpdatePhotoMetadata(arg1, arg2) {
   return window['go']['main']['App']['UpdatePhotoMetadata'](arg1, arg2);
 }
 
+export function UpdateSeriesPhotos(arg1, arg2) {
+  return window['go']['main']['App']['UpdateSeriesPhotos'](arg1, arg2);
+}
+
 export function UploadApprovedPhotos(arg1) {
   return window['go']['main']['App']['UploadApprovedPhotos'](arg1);
 }
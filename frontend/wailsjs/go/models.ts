@@ -22,10 +22,89 @@ export namespace models {
 	        this.provider = source["provider"];
 	    }
 	}
+	export class AIModelPrice {
+	    model: string;
+	    provider: string;
+	    inputPrice: number;
+	    outputPrice: number;
+	    // Go type: time
+	    updatedAt: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new AIModelPrice(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.model = source["model"];
+	        this.provider = source["provider"];
+	        this.inputPrice = source["inputPrice"];
+	        this.outputPrice = source["outputPrice"];
+	        this.updatedAt = this.convertValues(source["updatedAt"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class AIRateLimit {
+	    provider: string;
+	    model: string;
+	    requestsPerMinute: number;
+	    tokensPerMinute: number;
+	    // Go type: time
+	    updatedAt: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new AIRateLimit(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.provider = source["provider"];
+	        this.model = source["model"];
+	        this.requestsPerMinute = source["requestsPerMinute"];
+	        this.tokensPerMinute = source["tokensPerMinute"];
+	        this.updatedAt = this.convertValues(source["updatedAt"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	export class AIResult {
 	    contentType: string;
 	    title: string;
 	    keywords: string[];
+	    supplementaryKeywords?: string[];
 	    quality: number;
 	    description: string;
 	    category: string;
@@ -41,6 +120,7 @@ export namespace models {
 	        this.contentType = source["contentType"];
 	        this.title = source["title"];
 	        this.keywords = source["keywords"];
+	        this.supplementaryKeywords = source["supplementaryKeywords"];
 	        this.quality = source["quality"];
 	        this.description = source["description"];
 	        this.category = source["category"];
@@ -48,6 +128,34 @@ export namespace models {
 	        this.error = source["error"];
 	    }
 	}
+	export class AIUsageSummary {
+	    batchId?: string;
+	    month?: string;
+	    requests: number;
+	    promptTokens: number;
+	    completionTokens: number;
+	    imageTokens: number;
+	    cost: number;
+	    unpricedRequests: number;
+	    budget: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new AIUsageSummary(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.batchId = source["batchId"];
+	        this.month = source["month"];
+	        this.requests = source["requests"];
+	        this.promptTokens = source["promptTokens"];
+	        this.completionTokens = source["completionTokens"];
+	        this.imageTokens = source["imageTokens"];
+	        this.cost = source["cost"];
+	        this.unpricedRequests = source["unpricedRequests"];
+	        this.budget = source["budget"];
+	    }
+	}
 	export class AppSettings {
 	    id: string;
 	    tempDirectory: string;
@@ -58,6 +166,14 @@ export namespace models {
 	    maxConcurrentJobs: number;
 	    aiTimeout: number;
 	    aiMaxTokens: number;
+	    uploadMaxAttempts: number;
+	    uploadRetryDelay: number;
+	    writeOriginals: boolean;
+	    metadataBackend: string;
+	    seriesBestOnly: boolean;
+	    perStockMetadata: boolean;
+	    aiBatchBudget: number;
+	    aiMonthlyBudget: number;
 	    thumbnailSize: number;
 	    language: string;
 	    aiPrompts: Record<string, string>;
@@ -79,6 +195,14 @@ export namespace models {
 	        this.maxConcurrentJobs = source["maxConcurrentJobs"];
 	        this.aiTimeout = source["aiTimeout"];
 	        this.aiMaxTokens = source["aiMaxTokens"];
+	        this.uploadMaxAttempts = source["uploadMaxAttempts"];
+	        this.uploadRetryDelay = source["uploadRetryDelay"];
+	        this.writeOriginals = source["writeOriginals"];
+	        this.metadataBackend = source["metadataBackend"];
+	        this.seriesBestOnly = source["seriesBestOnly"];
+	        this.perStockMetadata = source["perStockMetadata"];
+	        this.aiBatchBudget = source["aiBatchBudget"];
+	        this.aiMonthlyBudget = source["aiMonthlyBudget"];
 	        this.thumbnailSize = source["thumbnailSize"];
 	        this.language = source["language"];
 	        this.aiPrompts = source["aiPrompts"];
@@ -206,6 +330,11 @@ export namespace models {
 	    passive?: boolean;
 	    encryption?: string;
 	    verifyCert?: boolean;
+	    privateKey?: string;
+	    privateKeyPath?: string;
+	    passphrase?: string;
+	    useAgent?: boolean;
+	    hostKeyFingerprint?: string;
 	    headers?: Record<string, string>;
 	    params?: Record<string, string>;
 	
@@ -227,6 +356,11 @@ export namespace models {
 	        this.passive = source["passive"];
 	        this.encryption = source["encryption"];
 	        this.verifyCert = source["verifyCert"];
+	        this.privateKey = source["privateKey"];
+	        this.privateKeyPath = source["privateKeyPath"];
+	        this.passphrase = source["passphrase"];
+	        this.useAgent = source["useAgent"];
+	        this.hostKeyFingerprint = source["hostKeyFingerprint"];
 	        this.headers = source["headers"];
 	        this.params = source["params"];
 	    }
@@ -278,6 +412,105 @@ export namespace models {
 		    return a;
 		}
 	}
+	export class MetadataIssue {
+	    field: string;
+	    source: string;
+	    kind: string;
+	    expected?: string;
+	    actual?: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new MetadataIssue(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.field = source["field"];
+	        this.source = source["source"];
+	        this.kind = source["kind"];
+	        this.expected = source["expected"];
+	        this.actual = source["actual"];
+	    }
+	}
+	export class MetadataVerification {
+	    status: string;
+	    path: string;
+	    stockId?: string;
+	    issues?: MetadataIssue[];
+	    // Go type: time
+	    verifiedAt: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new MetadataVerification(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.status = source["status"];
+	        this.path = source["path"];
+	        this.stockId = source["stockId"];
+	        this.issues = this.convertValues(source["issues"], MetadataIssue);
+	        this.verifiedAt = this.convertValues(source["verifiedAt"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
+	export class PhotoRendition {
+	    kind: string;
+	    path: string;
+	    fileName: string;
+	    fileSize: number;
+	
+	    static createFrom(source: any = {}) {
+	        return new PhotoRendition(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.kind = source["kind"];
+	        this.path = source["path"];
+	        this.fileName = source["fileName"];
+	        this.fileSize = source["fileSize"];
+	    }
+	}
+	export class PhotoDuplicate {
+	    photoId?: string;
+	    batchId?: string;
+	    fileName: string;
+	    kind: string;
+	    distance: number;
+	    uploaded: boolean;
+	
+	    static createFrom(source: any = {}) {
+	        return new PhotoDuplicate(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.photoId = source["photoId"];
+	        this.batchId = source["batchId"];
+	        this.fileName = source["fileName"];
+	        this.kind = source["kind"];
+	        this.distance = source["distance"];
+	        this.uploaded = source["uploaded"];
+	    }
+	}
 	export class Photo {
 	    id: string;
 	    batchId: string;
@@ -295,6 +528,17 @@ export namespace models {
 	    createdAt: any;
 	    // Go type: time
 	    updatedAt?: any;
+	    metadataVerification?: MetadataVerification;
+	    metadataOverride: boolean;
+	    sidecarPath?: string;
+	    renditions?: PhotoRendition[];
+	    contentHash?: string;
+	    perceptualHash?: string;
+	    duplicate?: PhotoDuplicate;
+	    seriesId?: string;
+	    qualityScore?: number;
+	    capturedAt?: string;
+	    stockAiResults?: Record<string, AIResult>;
 	
 	    static createFrom(source: any = {}) {
 	        return new Photo(source);
@@ -316,6 +560,17 @@ export namespace models {
 	        this.selectedForUpload = source["selectedForUpload"];
 	        this.createdAt = this.convertValues(source["createdAt"], null);
 	        this.updatedAt = this.convertValues(source["updatedAt"], null);
+	        this.metadataVerification = this.convertValues(source["metadataVerification"], MetadataVerification);
+	        this.metadataOverride = source["metadataOverride"];
+	        this.sidecarPath = source["sidecarPath"];
+	        this.renditions = this.convertValues(source["renditions"], PhotoRendition);
+	        this.contentHash = source["contentHash"];
+	        this.perceptualHash = source["perceptualHash"];
+	        this.duplicate = this.convertValues(source["duplicate"], PhotoDuplicate);
+	        this.seriesId = source["seriesId"];
+	        this.qualityScore = source["qualityScore"];
+	        this.capturedAt = source["capturedAt"];
+	        this.stockAiResults = this.convertValues(source["stockAiResults"], AIResult, true);
 	    }
 	
 		convertValues(a: any, classs: any, asMap: boolean = false): any {
@@ -384,6 +639,65 @@ export namespace models {
 		    return a;
 		}
 	}
+	export class SeriesFrame {
+	    photoId: string;
+	    fileName: string;
+	    capturedAt?: string;
+	    qualityScore: number;
+	    status: string;
+	
+	    static createFrom(source: any = {}) {
+	        return new SeriesFrame(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.photoId = source["photoId"];
+	        this.fileName = source["fileName"];
+	        this.capturedAt = source["capturedAt"];
+	        this.qualityScore = source["qualityScore"];
+	        this.status = source["status"];
+	    }
+	}
+	export class PhotoSeries {
+	    id: string;
+	    batchId: string;
+	    bestPhotoId: string;
+	    frames: SeriesFrame[];
+	    // Go type: time
+	    createdAt: any;
+	
+	    static createFrom(source: any = {}) {
+	        return new PhotoSeries(source);
+	    }
+	
+	    constructor(source: any = {}) {
+	        if ('string' === typeof source) source = JSON.parse(source);
+	        this.id = source["id"];
+	        this.batchId = source["batchId"];
+	        this.bestPhotoId = source["bestPhotoId"];
+	        this.frames = this.convertValues(source["frames"], SeriesFrame);
+	        this.createdAt = this.convertValues(source["createdAt"], null);
+	    }
+	
+		convertValues(a: any, classs: any, asMap: boolean = false): any {
+		    if (!a) {
+		        return a;
+		    }
+		    if (a.slice && a.map) {
+		        return (a as any[]).map(elem => this.convertValues(elem, classs));
+		    } else if ("object" === typeof a) {
+		        if (asMap) {
+		            for (const key of Object.keys(a)) {
+		                a[key] = new classs(a[key]);
+		            }
+		            return a;
+		        }
+		        return new classs(a);
+		    }
+		    return a;
+		}
+	}
 	export class PhotoFile {
 	    name: string;
 	    path: string;
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 // PhotoBatch представляет группу фотографий для обработки
 type PhotoBatch struct {
@@ -10,7 +13,7 @@ type PhotoBatch struct {
 	FolderPath  string      `json:"folderPath" db:"folder_path"`
 	Photos      []Photo     `json:"photos"`
 	PhotosStats *BatchStats `json:"photosStats,omitempty"` // статистика фото в батче
-	Status      string      `json:"status" db:"status"`    // "pending", "processing", "completed", "failed"
+	Status      string      `json:"status" db:"status"`    // "pending", "processing", "completed", "failed", "paused" (лимит AI)
 	CreatedAt   time.Time   `json:"createdAt" db:"created_at"`
 	UpdatedAt   time.Time   `json:"updatedAt" db:"updated_at"`
 }
@@ -39,6 +42,64 @@ type Photo struct {
 	SelectedForUpload bool              `json:"selectedForUpload"`  // выбрана ли фотография для загрузки
 	CreatedAt         time.Time         `json:"createdAt" db:"created_at"`
 	UpdatedAt         time.Time         `json:"updatedAt,omitempty"` // время последнего обновления
+	// MetadataVerification результат последней проверки метаданных, записанных в файл.
+	// MetadataOverride - пользователь разрешил загрузку, несмотря на расхождения.
+	MetadataVerification *MetadataVerification `json:"metadataVerification,omitempty"`
+	MetadataOverride     bool                  `json:"metadataOverride" db:"metadata_override"`
+	// SidecarPath XMP sidecar, который загружается на сток вместе с RAW файлом
+	SidecarPath string `json:"sidecarPath,omitempty"`
+	// Renditions файлы фото с одним именем (RAW, JPEG, XMP sidecar, релиз модели).
+	// OriginalPath указывает на файл, по которому делается анализ; на сток уходит файл, выбранный загрузчиком.
+	Renditions []PhotoRendition `json:"renditions,omitempty"`
+	// ContentHash SHA-256 исходного файла, PerceptualHash - dHash изображения (16 hex символов).
+	// Duplicate - найденный дубликат или почти дубликат среди фото батча и загруженных ранее.
+	ContentHash    string          `json:"contentHash,omitempty" db:"content_hash"`
+	PerceptualHash string          `json:"perceptualHash,omitempty" db:"perceptual_hash"`
+	Duplicate      *PhotoDuplicate `json:"duplicate,omitempty"`
+	// SeriesID серия почти одинаковых кадров, снятых подряд. QualityScore - оценка резкости и экспозиции (0-100),
+	// по которой выбирается лучший кадр серии. CapturedAt - DateTimeOriginal из EXIF.
+	SeriesID     string  `json:"seriesId,omitempty" db:"series_id"`
+	QualityScore float64 `json:"qualityScore,omitempty" db:"quality_score"`
+	CapturedAt   string  `json:"capturedAt,omitempty" db:"captured_at"`
+	// StockAIResults метаданные, созданные по промпту конкретного стока (stock_id -> результат).
+	// При загрузке на этот сток заменяют AIResult; стоки без своего промпта получают AIResult.
+	StockAIResults map[string]*AIResult `json:"stockAiResults,omitempty"`
+}
+
+// PhotoSeries серия кадров (серийная съемка): AI анализирует лучший кадр, остальные получают его метаданные
+type PhotoSeries struct {
+	ID          string        `json:"id" db:"id"`
+	BatchID     string        `json:"batchId" db:"batch_id"`
+	BestPhotoID string        `json:"bestPhotoId" db:"best_photo_id"`
+	Frames      []SeriesFrame `json:"frames"` // по времени съемки
+	CreatedAt   time.Time     `json:"createdAt" db:"created_at"`
+}
+
+// SeriesFrame кадр серии
+type SeriesFrame struct {
+	PhotoID      string  `json:"photoId"`
+	FileName     string  `json:"fileName"`
+	CapturedAt   string  `json:"capturedAt,omitempty"`
+	QualityScore float64 `json:"qualityScore"`
+	Status       string  `json:"status"`
+}
+
+// PhotoDuplicate фото, с которым совпало текущее
+type PhotoDuplicate struct {
+	PhotoID  string `json:"photoId,omitempty"` // пусто - совпадение с другим файлом той же папки до импорта
+	BatchID  string `json:"batchId,omitempty"`
+	FileName string `json:"fileName"`
+	Kind     string `json:"kind"`     // "exact" (одинаковый SHA-256) или "near" (близкий perceptual hash)
+	Distance int    `json:"distance"` // расстояние Хэмминга между perceptual hash, 0 для exact
+	Uploaded bool   `json:"uploaded"` // фото уже загружено на сток
+}
+
+// PhotoRendition один файл логического фото
+type PhotoRendition struct {
+	Kind     string `json:"kind"` // "raw", "jpeg", "tiff", "image" (PNG, WEBP, BMP), "xmp", "release" (PDF релиза модели)
+	Path     string `json:"path"`
+	FileName string `json:"fileName"`
+	FileSize int64  `json:"fileSize"`
 }
 
 // AIResult содержит результаты анализа нейросетью
@@ -51,13 +112,36 @@ type AIResult struct {
 	Category    string   `json:"category"`
 	Processed   bool     `json:"processed"`
 	Error       string   `json:"error,omitempty"`
+	// SupplementaryKeywords дополнительные ключевые слова для стоков с отдельным полем (Alamy),
+	// заполняются правилами метаданных стока перед загрузкой
+	SupplementaryKeywords []string `json:"supplementaryKeywords,omitempty"`
+	// FromCache результат взят из кеша AI без запроса к провайдеру, не сохраняется
+	FromCache bool `json:"-"`
+}
+
+// MetadataVerification результат сравнения метаданных, прочитанных из файла, с результатами AI
+type MetadataVerification struct {
+	Status     string          `json:"status"`            // "ok", "warning" (только обрезка), "mismatch", "error", "unsupported"
+	Path       string          `json:"path"`              // проверенный файл
+	StockID    string          `json:"stockId,omitempty"` // сток, для которого подготовлена копия; пусто - исходный файл
+	Issues     []MetadataIssue `json:"issues,omitempty"`
+	VerifiedAt time.Time       `json:"verifiedAt"`
+}
+
+// MetadataIssue расхождение одного поля метаданных
+type MetadataIssue struct {
+	Field    string `json:"field"`  // "title", "description", "keywords", "category", "xmp", "iptc", "file"
+	Source   string `json:"source"` // "xmp", "iptc"
+	Kind     string `json:"kind"`   // "missing", "mismatch", "truncated", "encoding", "unexpected", "error"
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
 }
 
 // StockConfig представляет конфигурацию стока
 type StockConfig struct {
 	ID             string                 `json:"id" db:"id"`
 	Name           string                 `json:"name" db:"name"`
-	Type           string                 `json:"type" db:"type"`                  // "ftp", "sftp", "api", "custom"
+	Type           string                 `json:"type" db:"type"`                  // "ftp", "sftp", "api", "adobe_stock", "shutterstock", "alamy", "custom"
 	SupportedTypes []string               `json:"supportedTypes"`                  // ["editorial", "commercial"]
 	UploadMethod   string                 `json:"uploadMethod" db:"upload_method"` // deprecated, use Type instead
 	Connection     ConnectionConfig       `json:"connection"`
@@ -83,6 +167,12 @@ type ConnectionConfig struct {
 	Passive    bool   `json:"passive,omitempty"`    // пассивный режим для FTP
 	Encryption string `json:"encryption,omitempty"` // тип шифрования: "none", "auto", "explicit", "implicit"
 	VerifyCert bool   `json:"verifyCert,omitempty"` // проверять SSL сертификаты
+	// Параметры SSH для SFTP подключений
+	PrivateKey         string `json:"privateKey,omitempty"`         // приватный ключ PEM/OpenSSH
+	PrivateKeyPath     string `json:"privateKeyPath,omitempty"`     // путь к файлу приватного ключа
+	Passphrase         string `json:"passphrase,omitempty"`         // пароль от приватного ключа
+	UseAgent           bool   `json:"useAgent,omitempty"`           // авторизация через ssh-agent
+	HostKeyFingerprint string `json:"hostKeyFingerprint,omitempty"` // закрепленный SHA256 отпечаток ключа сервера
 	// Дополнительные параметры для API подключений
 	Headers map[string]string `json:"headers,omitempty"`
 	Params  map[string]string `json:"params,omitempty"`
@@ -97,8 +187,16 @@ type AppSettings struct {
 	AIAPIKey          string            `json:"aiApiKey" db:"ai_api_key"`
 	AIBaseURL         string            `json:"aiBaseUrl" db:"ai_base_url"`
 	MaxConcurrentJobs int               `json:"maxConcurrentJobs" db:"max_concurrent_jobs"`
-	AITimeout         int               `json:"aiTimeout" db:"ai_timeout"`      // таймаут AI запросов в секундах
-	AIMaxTokens       int               `json:"aiMaxTokens" db:"ai_max_tokens"` // максимальное количество токенов в ответе
+	AITimeout         int               `json:"aiTimeout" db:"ai_timeout"`                  // таймаут AI запросов в секундах
+	AIMaxTokens       int               `json:"aiMaxTokens" db:"ai_max_tokens"`             // максимальное количество токенов в ответе
+	UploadMaxAttempts int               `json:"uploadMaxAttempts" db:"upload_max_attempts"` // попыток загрузки на сток до dead-letter
+	UploadRetryDelay  int               `json:"uploadRetryDelay" db:"upload_retry_delay"`   // базовая задержка повтора загрузки в секундах
+	WriteOriginals    bool              `json:"writeOriginals" db:"write_originals"`        // записывать метаданные и в исходные файлы, а не только в копии для стоков
+	MetadataBackend   string            `json:"metadataBackend" db:"metadata_backend"`      // "native" (встроенный модуль) или "exiftool"
+	SeriesBestOnly    bool              `json:"seriesBestOnly" db:"series_best_only"`       // AI анализирует лучший кадр серии, остальные кадры получают его метаданные
+	PerStockMetadata  bool              `json:"perStockMetadata" db:"per_stock_metadata"`   // отдельные метаданные для каждого стока с собственным промптом
+	AIBatchBudget     float64           `json:"aiBatchBudget" db:"ai_batch_budget"`         // лимит стоимости AI на батч в USD, 0 - без лимита
+	AIMonthlyBudget   float64           `json:"aiMonthlyBudget" db:"ai_monthly_budget"`     // лимит стоимости AI за календарный месяц в USD, 0 - без лимита
 	ThumbnailSize     int               `json:"thumbnailSize" db:"thumbnail_size"`
 	Language          string            `json:"language" db:"language"` // "en", "ru", etc.
 	AIPrompts         map[string]string `json:"aiPrompts"`              // "editorial" -> prompt, "commercial" -> prompt
@@ -126,10 +224,56 @@ type PhotoProcessInfo struct {
 	FileName string `json:"fileName"`
 	Status   string `json:"status"`   // "pending", "processing", "completed", "failed"
 	Progress int    `json:"progress"` // 0-100
-	Step     string `json:"step"`     // "preparation", "ai_analysis", "saving", "exif_writing", "completed"
+	Step     string `json:"step"`     // "preparation", "ai_analysis", "saving", "stock_metadata", "exif_writing", "budget_paused", "rate_limit_wait", "completed"
 	Error    string `json:"error,omitempty"`
 }
 
+// AIUsage токены и стоимость одного запроса к AI (таблица ai_usage)
+type AIUsage struct {
+	ID               string    `json:"id" db:"id"`
+	BatchID          string    `json:"batchId" db:"batch_id"`
+	PhotoID          string    `json:"photoId" db:"photo_id"`
+	Provider         string    `json:"provider" db:"provider"`
+	Model            string    `json:"model" db:"model"`
+	PromptTokens     int       `json:"promptTokens" db:"prompt_tokens"` // входные токены по ответу провайдера, включая изображение
+	CompletionTokens int       `json:"completionTokens" db:"completion_tokens"`
+	ImageTokens      int       `json:"imageTokens" db:"image_tokens"` // оценка токенов изображения по размеру миниатюры, входит в PromptTokens
+	Cost             float64   `json:"cost" db:"cost"`                // USD по таблице цен на момент запроса
+	Priced           bool      `json:"priced" db:"priced"`            // цена модели найдена в таблице цен
+	CreatedAt        time.Time `json:"createdAt" db:"created_at"`
+}
+
+// AIUsageSummary суммарное использование AI за батч или календарный месяц
+type AIUsageSummary struct {
+	BatchID          string  `json:"batchId,omitempty"`
+	Month            string  `json:"month,omitempty"` // "2006-01"
+	Requests         int     `json:"requests"`
+	PromptTokens     int     `json:"promptTokens"`
+	CompletionTokens int     `json:"completionTokens"`
+	ImageTokens      int     `json:"imageTokens"`
+	Cost             float64 `json:"cost"`
+	UnpricedRequests int     `json:"unpricedRequests"` // запросы к моделям без цены, в Cost не вошли
+	Budget           float64 `json:"budget"`           // лимит из настроек, 0 - без лимита
+}
+
+// AIModelPrice цена модели в USD за 1M токенов (таблица ai_model_prices)
+type AIModelPrice struct {
+	Model       string    `json:"model" db:"model"` // ID модели или его начало: "gpt-4o" подходит и для "gpt-4o-2024-08-06"
+	Provider    string    `json:"provider" db:"provider"`
+	InputPrice  float64   `json:"inputPrice" db:"input_price"`
+	OutputPrice float64   `json:"outputPrice" db:"output_price"`
+	UpdatedAt   time.Time `json:"updatedAt" db:"updated_at"`
+}
+
+// AIRateLimit лимит запросов к AI провайдеру (таблица ai_rate_limits)
+type AIRateLimit struct {
+	Provider          string    `json:"provider" db:"provider"`
+	Model             string    `json:"model" db:"model"`                           // ID модели или его начало, пустая строка - общий лимит провайдера
+	RequestsPerMinute int       `json:"requestsPerMinute" db:"requests_per_minute"` // 0 - без лимита
+	TokensPerMinute   int       `json:"tokensPerMinute" db:"tokens_per_minute"`     // 0 - без лимита
+	UpdatedAt         time.Time `json:"updatedAt" db:"updated_at"`
+}
+
 // AIRequest представляет запрос к AI API
 type AIRequest struct {
 	Image       string            `json:"image"` // base64 encoded
@@ -149,12 +293,18 @@ type AIResponse struct {
 	Error       string   `json:"error,omitempty"`
 }
 
-// UploadJob представляет задачу загрузки
+// UploadJob представляет задачу загрузки одного фото на один сток (таблица upload_jobs)
 type UploadJob struct {
-	PhotoID     string      `json:"photoId"`
-	BatchID     string      `json:"batchId"`
-	StockConfig StockConfig `json:"stockConfig"`
-	Priority    int         `json:"priority"`
+	ID            string    `json:"id" db:"id"`
+	PhotoID       string    `json:"photoId" db:"photo_id"`
+	BatchID       string    `json:"batchId" db:"batch_id"`
+	StockID       string    `json:"stockId" db:"stock_id"`
+	State         string    `json:"state" db:"state"` // "pending", "uploading", "uploaded", "failed"
+	Attempts      int       `json:"attempts" db:"attempts"`
+	NextAttemptAt time.Time `json:"nextAttemptAt" db:"next_attempt_at"`
+	LastError     string    `json:"lastError,omitempty" db:"last_error"`
+	CreatedAt     time.Time `json:"createdAt" db:"created_at"`
+	UpdatedAt     time.Time `json:"updatedAt" db:"updated_at"`
 }
 
 // UploadResult представляет результат загрузки
@@ -201,8 +351,8 @@ type AIModelsResponse struct {
 
 // StockUploader интерфейс для модульных загрузчиков
 type StockUploader interface {
-	// Загрузка файла
-	Upload(photo Photo, config StockConfig) (UploadResult, error)
+	// Загрузка файла; отмена ctx прерывает подключение и передачу
+	Upload(ctx context.Context, photo Photo, config StockConfig) (UploadResult, error)
 	// Тестирование соединения
 	TestConnection(config StockConfig) error
 	// Получение информации о загрузчике
@@ -234,7 +384,7 @@ type StockTemplate struct {
 // TemplateField описывает поле в шаблоне
 type TemplateField struct {
 	Name        string      `json:"name"`
-	Type        string      `json:"type"` // "text", "number", "password", "select", "checkbox", "url"
+	Type        string      `json:"type"` // "text", "number", "password", "select", "checkbox", "url", "textarea"
 	Label       string      `json:"label"`
 	Required    bool        `json:"required"`
 	Options     []string    `json:"options,omitempty"` // для select
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"stock-photo-app/models"
+)
+
+// aiCacheSchemaVersion входит в ключ кеша AI. Увеличивается при изменении photoMetadataSchema,
+// разбора ответа или applyDescriptionLimits, чтобы старые результаты не использовались.
+const aiCacheSchemaVersion = 1
+
+// AIResultCache хранилище результатов AI по ключу содержимого запроса
+type AIResultCache interface {
+	// GetCachedAIResult возвращает результат по ключу, nil - в кеше нет
+	GetCachedAIResult(key string) (*models.AIResult, error)
+
+	// SaveCachedAIResult сохраняет или заменяет результат по ключу
+	SaveCachedAIResult(key, provider, model string, result models.AIResult) error
+}
+
+// SetResultCache задает кеш результатов AI; nil - каждый анализ отправляется провайдеру
+func (s *AIService) SetResultCache(cache AIResultCache) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resultCache = cache
+}
+
+// aiCacheKey считает SHA-256 от байтов миниатюры, полного контекстного промпта, провайдера,
+// модели, типа контента и версии схемы. Одинаковый ключ - одинаковый запрос к AI.
+func (s *AIService) aiCacheKey(photo models.Photo, description, prompt, contentType string, settings models.AppSettings) (string, error) {
+	file, err := os.Open(photo.ThumbnailPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open thumbnail: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to read thumbnail: %w", err)
+	}
+
+	fullPrompt := s.exifProcessor.BuildContextualPrompt(contentType, prompt, photo.ExifData, description)
+	// Разделитель \x00 не дает склеить соседние поля в одинаковую строку
+	fmt.Fprintf(hash, "\x00%s\x00%s\x00%s\x00%s\x00%d", fullPrompt, settings.AIProvider, settings.AIModel, contentType, aiCacheSchemaVersion)
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// cachedAnalysis возвращает результат из кеша, если он есть. Ошибки кеша не мешают анализу.
+func (s *AIService) cachedAnalysis(key string, photo models.Photo) *models.AIResult {
+	s.mu.RLock()
+	cache := s.resultCache
+	s.mu.RUnlock()
+	if cache == nil || key == "" {
+		return nil
+	}
+
+	result, err := cache.GetCachedAIResult(key)
+	if err != nil {
+		log.Printf("Warning: failed to read AI cache for photo %s: %v", photo.FileName, err)
+		return nil
+	}
+	if result != nil {
+		result.FromCache = true
+		log.Printf("AI cache hit for photo %s", photo.FileName)
+	}
+	return result
+}
+
+// storeAnalysis сохраняет результат анализа в кеш
+func (s *AIService) storeAnalysis(key string, photo models.Photo, settings models.AppSettings, result *models.AIResult) {
+	s.mu.RLock()
+	cache := s.resultCache
+	s.mu.RUnlock()
+	if cache == nil || key == "" {
+		return
+	}
+
+	if err := cache.SaveCachedAIResult(key, settings.AIProvider, settings.AIModel, *result); err != nil {
+		log.Printf("Warning: failed to save AI cache for photo %s: %v", photo.FileName, err)
+	}
+}
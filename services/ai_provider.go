@@ -0,0 +1,63 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"stock-photo-app/models"
+)
+
+// VisionProvider описывает AI провайдера, умеющего анализировать изображения.
+// Новый провайдер (Gemini, Ollama, локальный OpenAI-совместимый сервер) добавляется
+// отдельным файлом и регистрируется через AIService.RegisterProvider.
+type VisionProvider interface {
+	// Analyze анализирует миниатюру фото и возвращает метаданные; отмена ctx прерывает запрос
+	Analyze(ctx context.Context, photo models.Photo, description string, prompt string, contentType string, settings models.AppSettings) (*models.AIResult, error)
+
+	// TestConnection проверяет доступность API с текущими настройками
+	TestConnection(settings models.AppSettings) error
+
+	// ListModels возвращает список моделей, поддерживающих анализ изображений
+	ListModels(settings models.AppSettings) ([]models.AIModel, error)
+}
+
+// registerBuiltinProviders регистрирует встроенных AI провайдеров
+func (s *AIService) registerBuiltinProviders() {
+	s.RegisterProvider("openai", NewOpenAIProvider(s))
+	s.RegisterProvider("claude", NewClaudeProvider(s))
+	s.RegisterProvider("local", NewLocalProvider(s))
+}
+
+// RegisterProvider регистрирует нового AI провайдера
+func (s *AIService) RegisterProvider(name string, provider VisionProvider) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.providers[name] = provider
+}
+
+// GetProvider возвращает AI провайдера по имени
+func (s *AIService) GetProvider(name string) (VisionProvider, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	provider, exists := s.providers[name]
+	if !exists {
+		return nil, fmt.Errorf("unsupported AI provider: %s", name)
+	}
+
+	return provider, nil
+}
+
+// GetSupportedProviders возвращает отсортированный список зарегистрированных провайдеров
+func (s *AIService) GetSupportedProviders() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.providers))
+	for name := range s.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,347 @@
+package services
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"stock-photo-app/models"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rateLimitWaitStep шаг PhotoProcessInfo, пока запрос фото ждет лимита провайдера
+const rateLimitWaitStep = "rate_limit_wait"
+
+// aiCall один запрос к AI для общего лимитера
+type aiCall struct {
+	photo    models.Photo
+	provider string
+	model    string
+	tokens   int     // оценка токенов: промпт, изображение и максимум ответа
+	reserved float64 // токены, занятые в лимите последней попыткой
+}
+
+// newAICall создает запрос с оценкой токенов до отправки. Текст промпта оценивается
+// как 4 байта на токен; лимиты провайдеров учитывают и max_tokens ответа.
+func newAICall(photo models.Photo, provider, model, prompt string, imageTokens, maxTokens int) *aiCall {
+	return &aiCall{
+		photo:    photo,
+		provider: provider,
+		model:    model,
+		tokens:   len(prompt)/4 + imageTokens + maxTokens,
+	}
+}
+
+// rateBucket запас запросов и токенов в минуту для провайдера или модели
+type rateBucket struct {
+	rpm, tpm             int     // лимиты из настроек, 0 - нет
+	serverRPM, serverTPM int     // лимиты из заголовков ответа провайдера
+	requests, tokens     float64 // доступно сейчас
+	updated              time.Time
+	blockedUntil         time.Time // сервер запретил запросы до этого времени (429, исчерпан остаток)
+}
+
+// aiRateLimiter общий для всех worker'ов лимитер запросов к AI. Запас пополняется
+// равномерно (лимит / 60 в секунду) и подстраивается под x-ratelimit-* и Retry-After.
+type aiRateLimiter struct {
+	mu      sync.Mutex
+	limits  []models.AIRateLimit
+	buckets map[string]*rateBucket
+	now     func() time.Time // часы лимитера, в тестах подменяются
+}
+
+func newAIRateLimiter() *aiRateLimiter {
+	return &aiRateLimiter{buckets: make(map[string]*rateBucket), now: time.Now}
+}
+
+// SetRateLimits задает лимиты запросов к AI; текущие запасы сбрасываются
+func (s *AIService) SetRateLimits(limits []models.AIRateLimit) {
+	s.rateLimiter.mu.Lock()
+	defer s.rateLimiter.mu.Unlock()
+	s.rateLimiter.limits = limits
+	s.rateLimiter.buckets = make(map[string]*rateBucket)
+}
+
+// SetRateLimitWaitHandler задает функцию, которая вызывается, когда запрос фото начинает ждать лимита.
+// Возвращенная функция вызывается после ожидания.
+func (s *AIService) SetRateLimitWaitHandler(handler func(photo models.Photo, wait time.Duration) func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimitWaitFunc = handler
+}
+
+// waitForRateLimit блокирует запрос, пока лимит провайдера не позволит его отправить.
+// При отмене ctx возвращает ошибку, запас лимита не занимается.
+func (s *AIService) waitForRateLimit(ctx context.Context, call *aiCall) error {
+	var done func()
+	for {
+		wait := s.rateLimiter.reserve(call)
+		if wait == 0 {
+			if done != nil {
+				done()
+			}
+			return nil
+		}
+
+		if done == nil {
+			log.Printf("AI rate limit of %s/%s reached, photo %s waits %s", call.provider, call.model, call.photo.FileName, wait.Round(time.Millisecond))
+			done = func() {}
+			s.mu.RLock()
+			handler := s.rateLimitWaitFunc
+			s.mu.RUnlock()
+			if handler != nil {
+				if finish := handler(call.photo, wait); finish != nil {
+					done = finish
+				}
+			}
+		}
+		if err := sleepContext(ctx, wait); err != nil {
+			if done != nil {
+				done()
+			}
+			return err
+		}
+	}
+}
+
+// bucket возвращает запас для провайдера и модели. Лимит ищется по точному ID модели или
+// самому длинному совпадающему началу, затем общий лимит провайдера; модели под одним
+// правилом делят один запас. Вызывается под l.mu.
+func (l *aiRateLimiter) bucket(call *aiCall) *rateBucket {
+	var rule models.AIRateLimit
+	found := false
+	for _, limit := range l.limits {
+		if limit.Provider != call.provider || !strings.HasPrefix(call.model, limit.Model) {
+			continue
+		}
+		if !found || len(limit.Model) > len(rule.Model) {
+			rule = limit
+			found = true
+		}
+	}
+
+	key := call.provider + "/" + call.model
+	if found {
+		key = call.provider + "/" + rule.Model
+	}
+
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &rateBucket{
+			rpm:      rule.RequestsPerMinute,
+			tpm:      rule.TokensPerMinute,
+			requests: float64(rule.RequestsPerMinute),
+			tokens:   float64(rule.TokensPerMinute),
+			updated:  l.now(),
+		}
+		l.buckets[key] = b
+	}
+	return b
+}
+
+// effectiveLimit меньший из лимитов настроек и сервера, 0 - лимит неизвестен
+func effectiveLimit(configured, server int) int {
+	if configured > 0 && (server == 0 || configured < server) {
+		return configured
+	}
+	return server
+}
+
+// refill пополняет запас за прошедшее время
+func (b *rateBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.updated).Seconds()
+	b.updated = now
+	if rpm := effectiveLimit(b.rpm, b.serverRPM); rpm > 0 {
+		b.requests = math.Min(float64(rpm), b.requests+elapsed*float64(rpm)/60)
+	}
+	if tpm := effectiveLimit(b.tpm, b.serverTPM); tpm > 0 {
+		b.tokens = math.Min(float64(tpm), b.tokens+elapsed*float64(tpm)/60)
+	}
+}
+
+// reserve занимает запрос и токены в лимите или возвращает время ожидания
+func (l *aiRateLimiter) reserve(call *aiCall) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(call)
+	now := l.now()
+	b.refill(now)
+
+	var wait time.Duration
+	if now.Before(b.blockedUntil) {
+		wait = b.blockedUntil.Sub(now)
+	}
+
+	rpm := effectiveLimit(b.rpm, b.serverRPM)
+	if rpm > 0 && b.requests < 1 {
+		wait = maxDuration(wait, time.Duration((1-b.requests)*60/float64(rpm)*float64(time.Second)))
+	}
+
+	// Запрос больше минутного лимита токенов отправляется при полном запасе
+	need := float64(call.tokens)
+	tpm := effectiveLimit(b.tpm, b.serverTPM)
+	if tpm > 0 {
+		need = math.Min(need, float64(tpm))
+		if b.tokens < need {
+			wait = maxDuration(wait, time.Duration((need-b.tokens)*60/float64(tpm)*float64(time.Second)))
+		}
+	}
+
+	if wait > 0 {
+		return wait
+	}
+
+	b.requests--
+	b.tokens -= need
+	call.reserved = need
+	return 0
+}
+
+// settle заменяет оценку токенов запроса фактическими токенами из ответа
+func (l *aiRateLimiter) settle(call *aiCall, tokens aiTokens) {
+	actual := tokens.prompt + tokens.completion
+	if actual == 0 {
+		return // сервер не вернул usage, остается оценка
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(call)
+	b.tokens += call.reserved - float64(actual)
+	if tpm := effectiveLimit(b.tpm, b.serverTPM); tpm > 0 {
+		b.tokens = math.Min(float64(tpm), b.tokens)
+	}
+	call.reserved = float64(actual)
+}
+
+// observe подстраивает запас под заголовки ответа: лимиты и остатки OpenAI (x-ratelimit-*)
+// и Anthropic (anthropic-ratelimit-*), Retry-After. Возвращает задержку перед повтором,
+// которую назвал сервер, 0 - сервер ее не назвал.
+func (l *aiRateLimiter) observe(call *aiCall, header http.Header, statusCode int) time.Duration {
+	now := l.now()
+	retryAfter := parseRetryAfter(header, now)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(call)
+	b.refill(now)
+
+	if limit, ok := headerInt(header, "x-ratelimit-limit-requests", "anthropic-ratelimit-requests-limit"); ok {
+		b.serverRPM = limit
+	}
+	if limit, ok := headerInt(header, "x-ratelimit-limit-tokens", "anthropic-ratelimit-tokens-limit"); ok {
+		b.serverTPM = limit
+	}
+
+	if remaining, ok := headerInt(header, "x-ratelimit-remaining-requests", "anthropic-ratelimit-requests-remaining"); ok {
+		b.requests = syncRemaining(b.requests, remaining, b.rpm)
+		if remaining == 0 {
+			reset := parseReset(header, now, "x-ratelimit-reset-requests", "anthropic-ratelimit-requests-reset")
+			b.blockedUntil = maxTime(b.blockedUntil, now.Add(reset))
+		}
+	}
+	if remaining, ok := headerInt(header, "x-ratelimit-remaining-tokens", "anthropic-ratelimit-tokens-remaining"); ok {
+		b.tokens = syncRemaining(b.tokens, remaining, b.tpm)
+		if remaining == 0 {
+			reset := parseReset(header, now, "x-ratelimit-reset-tokens", "anthropic-ratelimit-tokens-reset")
+			b.blockedUntil = maxTime(b.blockedUntil, now.Add(reset))
+		}
+	}
+
+	if statusCode == http.StatusTooManyRequests && retryAfter > 0 {
+		b.blockedUntil = maxTime(b.blockedUntil, now.Add(retryAfter))
+	}
+	return retryAfter
+}
+
+// block запрещает запросы к провайдеру и модели на время d
+func (l *aiRateLimiter) block(call *aiCall, d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.bucket(call)
+	b.blockedUntil = maxTime(b.blockedUntil, l.now().Add(d))
+}
+
+// syncRemaining сверяет запас с остатком сервера. При лимите из настроек берется меньшее,
+// чтобы ответ на более ранний запрос не вернул уже занятый запас; без лимита главный - сервер.
+func syncRemaining(current float64, remaining int, configured int) float64 {
+	if configured > 0 {
+		return math.Min(current, float64(remaining))
+	}
+	return float64(remaining)
+}
+
+// headerInt возвращает первое целое значение из заголовков
+func headerInt(header http.Header, names ...string) (int, bool) {
+	for _, name := range names {
+		if value := header.Get(name); value != "" {
+			if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+				return n, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// parseReset возвращает время до сброса лимита: OpenAI присылает длительность ("1s", "6m0s", "20ms"),
+// Anthropic - время RFC 3339. Без заголовка - 1 секунда.
+func parseReset(header http.Header, now time.Time, names ...string) time.Duration {
+	for _, name := range names {
+		value := strings.TrimSpace(header.Get(name))
+		if value == "" {
+			continue
+		}
+		if d, err := time.ParseDuration(value); err == nil {
+			return d
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return maxDuration(t.Sub(now), 0)
+		}
+		if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+			return time.Duration(seconds * float64(time.Second))
+		}
+	}
+	return time.Second
+}
+
+// parseRetryAfter разбирает retry-after-ms (OpenAI) и Retry-After в секундах или HTTP дате
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if value := header.Get("retry-after-ms"); value != "" {
+		if ms, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil && ms > 0 {
+			return time.Duration(ms * float64(time.Millisecond))
+		}
+	}
+
+	value := strings.TrimSpace(header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return maxDuration(t.Sub(now), 0)
+	}
+	return 0
+}
+
+func maxDuration(a, b time.Duration) time.Duration {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
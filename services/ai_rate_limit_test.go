@@ -0,0 +1,278 @@
+package services
+
+import (
+	"net/http"
+	"stock-photo-app/models"
+	"testing"
+	"time"
+)
+
+// testRateLimitNow фиксированное время тестов, с точностью до секунды для HTTP дат
+var testRateLimitNow = time.Date(2026, 3, 14, 12, 0, 0, 0, time.UTC)
+
+// fakeClock подменяемые часы лимитера
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+func newTestRateLimiter(limits ...models.AIRateLimit) (*aiRateLimiter, *fakeClock) {
+	clock := &fakeClock{now: testRateLimitNow}
+	limiter := newAIRateLimiter()
+	limiter.now = clock.Now
+	limiter.limits = limits
+	return limiter, clock
+}
+
+// testHeader собирает заголовки ответа из пар имя-значение
+func testHeader(pairs ...string) http.Header {
+	header := http.Header{}
+	for i := 0; i+1 < len(pairs); i += 2 {
+		header.Set(pairs[i], pairs[i+1])
+	}
+	return header
+}
+
+func TestParseReset(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"openai minutes", testHeader("x-ratelimit-reset-requests", "6m0s"), 6 * time.Minute},
+		{"openai fractional seconds", testHeader("x-ratelimit-reset-requests", "1.5s"), 1500 * time.Millisecond},
+		{"openai milliseconds", testHeader("x-ratelimit-reset-requests", "20ms"), 20 * time.Millisecond},
+		{"anthropic rfc3339", testHeader("anthropic-ratelimit-requests-reset", "2026-03-14T12:00:30Z"), 30 * time.Second},
+		{"anthropic rfc3339 with offset", testHeader("anthropic-ratelimit-requests-reset", "2026-03-14T15:01:00+03:00"), time.Minute},
+		{"reset in the past", testHeader("anthropic-ratelimit-requests-reset", "2026-03-14T11:59:00Z"), 0},
+		{"bare seconds", testHeader("x-ratelimit-reset-requests", "2"), 2 * time.Second},
+		{"no header", testHeader(), time.Second},
+		{"unparsable", testHeader("x-ratelimit-reset-requests", "soon"), time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseReset(tt.header, testRateLimitNow, "x-ratelimit-reset-requests", "anthropic-ratelimit-requests-reset")
+			if got != tt.want {
+				t.Errorf("parseReset = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"seconds", testHeader("Retry-After", "7"), 7 * time.Second},
+		{"fractional seconds", testHeader("Retry-After", "0.5"), 500 * time.Millisecond},
+		{"http date", testHeader("Retry-After", "Sat, 14 Mar 2026 12:02:00 GMT"), 2 * time.Minute},
+		{"http date in the past", testHeader("Retry-After", "Sat, 14 Mar 2026 11:00:00 GMT"), 0},
+		{"openai milliseconds first", testHeader("retry-after-ms", "1500", "Retry-After", "2"), 1500 * time.Millisecond},
+		{"invalid milliseconds fall back", testHeader("retry-after-ms", "abc", "Retry-After", "3"), 3 * time.Second},
+		{"zero", testHeader("Retry-After", "0"), 0},
+		{"garbage", testHeader("Retry-After", "later"), 0},
+		{"no header", testHeader(), 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.header, testRateLimitNow); got != tt.want {
+				t.Errorf("parseRetryAfter = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSyncRemaining(t *testing.T) {
+	tests := []struct {
+		name       string
+		current    float64
+		remaining  int
+		configured int
+		want       float64
+	}{
+		{"configured limit keeps the smaller local stock", 3, 10, 20, 3},
+		{"configured limit follows a smaller server stock", 8, 2, 20, 2},
+		{"server only raises the stock", 3, 10, 0, 10},
+		{"server only lowers the stock", 8, 2, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := syncRemaining(tt.current, tt.remaining, tt.configured); got != tt.want {
+				t.Errorf("syncRemaining(%v, %d, %d) = %v, want %v", tt.current, tt.remaining, tt.configured, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRateLimiterBucketLongestPrefix(t *testing.T) {
+	limiter, _ := newTestRateLimiter(
+		models.AIRateLimit{Provider: "openai", Model: "", RequestsPerMinute: 100},
+		models.AIRateLimit{Provider: "openai", Model: "gpt-4o", RequestsPerMinute: 50},
+		models.AIRateLimit{Provider: "openai", Model: "gpt-4o-mini", RequestsPerMinute: 500},
+		models.AIRateLimit{Provider: "claude", Model: "claude-3", RequestsPerMinute: 40},
+	)
+
+	tests := []struct {
+		provider, model string
+		wantRPM         int
+	}{
+		{"openai", "gpt-4o-mini-2024-07-18", 500},
+		{"openai", "gpt-4o-2024-08-06", 50},
+		{"openai", "gpt-4o", 50},
+		{"openai", "gpt-3.5-turbo", 100},
+		{"claude", "claude-3-5-sonnet", 40},
+		{"claude", "claude-opus", 0},
+		{"local", "llava", 0},
+	}
+	for _, tt := range tests {
+		if got := limiter.bucket(&aiCall{provider: tt.provider, model: tt.model}).rpm; got != tt.wantRPM {
+			t.Errorf("bucket(%s/%s).rpm = %d, want %d", tt.provider, tt.model, got, tt.wantRPM)
+		}
+	}
+
+	// Модели под одним правилом делят запас, без правила у каждой модели свой
+	shared := limiter.bucket(&aiCall{provider: "openai", model: "gpt-4o-mini-2024-07-18"})
+	if limiter.bucket(&aiCall{provider: "openai", model: "gpt-4o-mini"}) != shared {
+		t.Error("models under the gpt-4o-mini rule got different buckets")
+	}
+	if limiter.bucket(&aiCall{provider: "local", model: "llava"}) == limiter.bucket(&aiCall{provider: "local", model: "bakllava"}) {
+		t.Error("models without a rule share a bucket")
+	}
+}
+
+func TestRateLimiterReserveRefills(t *testing.T) {
+	limiter, clock := newTestRateLimiter(models.AIRateLimit{Provider: "openai", RequestsPerMinute: 2})
+	call := &aiCall{provider: "openai", model: "gpt-4o", tokens: 100}
+
+	for i := 0; i < 2; i++ {
+		if wait := limiter.reserve(call); wait != 0 {
+			t.Fatalf("request %d waits %s within the limit", i+1, wait)
+		}
+	}
+	if wait := limiter.reserve(call); wait != 30*time.Second {
+		t.Errorf("third request waits %s, want 30s for one request of a 2 rpm limit", wait)
+	}
+	clock.Advance(30 * time.Second)
+	if wait := limiter.reserve(call); wait != 0 {
+		t.Errorf("request after refill waits %s", wait)
+	}
+}
+
+func TestRateLimiterObserveRecordedHeaders(t *testing.T) {
+	tests := []struct {
+		name       string
+		header     http.Header
+		status     int
+		wantRetry  time.Duration
+		wantWait   time.Duration
+		wantRPM    int
+		wantTPM    int
+		wantTokens float64
+	}{
+		{
+			name: "openai requests exhausted",
+			header: testHeader(
+				"x-ratelimit-limit-requests", "500",
+				"x-ratelimit-limit-tokens", "30000",
+				"x-ratelimit-remaining-requests", "0",
+				"x-ratelimit-remaining-tokens", "29000",
+				"x-ratelimit-reset-requests", "6m0s",
+				"x-ratelimit-reset-tokens", "2s",
+			),
+			status:     http.StatusOK,
+			wantWait:   6 * time.Minute,
+			wantRPM:    500,
+			wantTPM:    30000,
+			wantTokens: 29000,
+		},
+		{
+			name: "anthropic tokens exhausted",
+			header: testHeader(
+				"anthropic-ratelimit-requests-limit", "50",
+				"anthropic-ratelimit-requests-remaining", "49",
+				"anthropic-ratelimit-requests-reset", "2026-03-14T12:00:01Z",
+				"anthropic-ratelimit-tokens-limit", "40000",
+				"anthropic-ratelimit-tokens-remaining", "0",
+				"anthropic-ratelimit-tokens-reset", "2026-03-14T12:00:45Z",
+			),
+			status:     http.StatusOK,
+			wantWait:   45 * time.Second,
+			wantRPM:    50,
+			wantTPM:    40000,
+			wantTokens: 0,
+		},
+		{
+			name:      "429 with retry-after",
+			header:    testHeader("Retry-After", "20"),
+			status:    http.StatusTooManyRequests,
+			wantRetry: 20 * time.Second,
+			wantWait:  20 * time.Second,
+		},
+		{
+			name:      "retry-after without 429 does not block",
+			header:    testHeader("Retry-After", "20"),
+			status:    http.StatusServiceUnavailable,
+			wantRetry: 20 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter, _ := newTestRateLimiter()
+			call := &aiCall{provider: "openai", model: "gpt-4o", tokens: 0}
+
+			if retry := limiter.observe(call, tt.header, tt.status); retry != tt.wantRetry {
+				t.Errorf("observe returned %s, want %s", retry, tt.wantRetry)
+			}
+			b := limiter.bucket(call)
+			if b.serverRPM != tt.wantRPM || b.serverTPM != tt.wantTPM {
+				t.Errorf("server limits = %d rpm, %d tpm, want %d, %d", b.serverRPM, b.serverTPM, tt.wantRPM, tt.wantTPM)
+			}
+			if tt.wantTPM > 0 && b.tokens != tt.wantTokens {
+				t.Errorf("tokens = %v, want %v", b.tokens, tt.wantTokens)
+			}
+			if wait := limiter.reserve(call); wait != tt.wantWait {
+				t.Errorf("next request waits %s, want %s", wait, tt.wantWait)
+			}
+		})
+	}
+}
+
+func TestRateLimiterSettle(t *testing.T) {
+	limiter, _ := newTestRateLimiter(models.AIRateLimit{Provider: "openai", TokensPerMinute: 1000})
+	call := &aiCall{provider: "openai", model: "gpt-4o", tokens: 400}
+	b := limiter.bucket(call)
+
+	if wait := limiter.reserve(call); wait != 0 {
+		t.Fatalf("reserve waits %s", wait)
+	}
+	if b.tokens != 600 || call.reserved != 400 {
+		t.Fatalf("after reserve tokens = %v, reserved = %v, want 600 and 400", b.tokens, call.reserved)
+	}
+
+	steps := []struct {
+		name         string
+		usage        aiTokens
+		wantTokens   float64
+		wantReserved float64
+	}{
+		{"no usage keeps the estimate", aiTokens{}, 600, 400},
+		{"smaller usage returns tokens", aiTokens{prompt: 80, completion: 20}, 900, 100},
+		{"larger usage takes more tokens", aiTokens{prompt: 500, completion: 200}, 300, 700},
+	}
+	for _, step := range steps {
+		limiter.settle(call, step.usage)
+		if b.tokens != step.wantTokens || call.reserved != step.wantReserved {
+			t.Errorf("%s: tokens = %v, reserved = %v, want %v and %v", step.name, b.tokens, call.reserved, step.wantTokens, step.wantReserved)
+		}
+	}
+
+	// Возврат оценки не поднимает запас выше лимита
+	b.tokens, call.reserved = 950, 700
+	limiter.settle(call, aiTokens{prompt: 1})
+	if b.tokens != 1000 {
+		t.Errorf("tokens after settle on a full stock = %v, want 1000", b.tokens)
+	}
+}
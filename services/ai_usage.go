@@ -0,0 +1,196 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"log"
+	"math"
+	"os"
+	"stock-photo-app/models"
+	"sync/atomic"
+	"time"
+)
+
+// errAIBudgetExceeded фото не отправлено в AI, потому что исчерпан лимит стоимости
+var errAIBudgetExceeded = errors.New("AI budget exceeded")
+
+// usageSequence номер записи использования AI: worker'ы записывают usage параллельно,
+// и одного времени в наносекундах для уникального ID недостаточно
+var usageSequence atomic.Uint64
+
+// aiTokens токены одного запроса по блоку usage ответа провайдера
+type aiTokens struct {
+	prompt     int
+	completion int
+}
+
+// SetUsageRecorder задает функцию сохранения токенов и стоимости каждого запроса к AI
+func (s *AIService) SetUsageRecorder(record func(usage models.AIUsage) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordUsageFunc = record
+}
+
+// recordUsage сохраняет использование AI для фото. Вызывается после каждого успешного
+// HTTP ответа, в том числе если ответ потом не удалось разобрать: запрос все равно оплачен.
+func (s *AIService) recordUsage(photo models.Photo, provider, model string, tokens aiTokens, imageTokens int) {
+	s.mu.RLock()
+	record := s.recordUsageFunc
+	s.mu.RUnlock()
+	if record == nil {
+		return
+	}
+
+	usage := models.AIUsage{
+		ID:               fmt.Sprintf("usage_%d_%d", time.Now().UnixNano(), usageSequence.Add(1)),
+		BatchID:          photo.BatchID,
+		PhotoID:          photo.ID,
+		Provider:         provider,
+		Model:            model,
+		PromptTokens:     tokens.prompt,
+		CompletionTokens: tokens.completion,
+		ImageTokens:      imageTokens,
+		Priced:           provider == "local", // локальные модели бесплатны
+		CreatedAt:        time.Now(),
+	}
+	if err := record(usage); err != nil {
+		log.Printf("Warning: failed to record AI usage for photo %s: %v", photo.FileName, err)
+	}
+}
+
+// thumbnailDimensions возвращает размер миниатюры без декодирования всего изображения
+func thumbnailDimensions(path string) (int, int, bool) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, false
+	}
+	defer file.Close()
+
+	config, _, err := image.DecodeConfig(file)
+	if err != nil {
+		return 0, 0, false
+	}
+	return config.Width, config.Height, true
+}
+
+// estimateOpenAIImageTokens оценивает токены изображения OpenAI (detail high): изображение
+// вписывается в 2048x2048, короткая сторона уменьшается до 768, затем 85 + 170 за тайл 512x512
+func estimateOpenAIImageTokens(path string) int {
+	width, height, ok := thumbnailDimensions(path)
+	if !ok {
+		return 0
+	}
+
+	w, h := float64(width), float64(height)
+	if scale := 2048 / math.Max(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+	if scale := 768 / math.Min(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+
+	tiles := int(math.Ceil(w/512)) * int(math.Ceil(h/512))
+	return 85 + 170*tiles
+}
+
+// estimateClaudeImageTokens оценивает токены изображения Claude: длинная сторона
+// уменьшается до 1568, токены = ширина * высота / 750
+func estimateClaudeImageTokens(path string) int {
+	width, height, ok := thumbnailDimensions(path)
+	if !ok {
+		return 0
+	}
+
+	w, h := float64(width), float64(height)
+	if scale := 1568 / math.Max(w, h); scale < 1 {
+		w, h = w*scale, h*scale
+	}
+	return int(math.Ceil(w * h / 750))
+}
+
+// defaultModelPrices цены моделей, которыми заполняется таблица ai_model_prices при первом запуске
+func defaultModelPrices() []models.AIModelPrice {
+	prices := append([]models.AIModelPrice{}, openAIModelPrices...)
+	return append(prices, claudeModelPrices...)
+}
+
+// aiBudgetExceeded проверяет лимиты стоимости AI на батч и на текущий месяц
+// и возвращает причину остановки, если один из них исчерпан
+func (q *QueueManager) aiBudgetExceeded(batchID string, settings models.AppSettings) (string, bool) {
+	if settings.AIBatchBudget > 0 {
+		usage, err := q.dbService.GetBatchAIUsage(batchID)
+		if err != nil {
+			log.Printf("Warning: failed to check AI budget of batch %s: %v", batchID, err)
+		} else if usage.Cost >= settings.AIBatchBudget {
+			return fmt.Sprintf("Исчерпан лимит AI на батч: $%.2f из $%.2f", usage.Cost, settings.AIBatchBudget), true
+		}
+	}
+
+	if settings.AIMonthlyBudget > 0 {
+		usage, err := q.dbService.GetMonthlyAIUsage("")
+		if err != nil {
+			log.Printf("Warning: failed to check monthly AI budget: %v", err)
+		} else if usage.Cost >= settings.AIMonthlyBudget {
+			return fmt.Sprintf("Исчерпан месячный лимит AI: $%.2f из $%.2f", usage.Cost, settings.AIMonthlyBudget), true
+		}
+	}
+
+	return "", false
+}
+
+// pauseForBudget останавливает очередь AI обработки из-за лимита стоимости.
+// Событие budget_exceeded пишется один раз, остальные worker'ы просто не берут новые фото.
+func (q *QueueManager) pauseForBudget(batchID, reason string) {
+	q.processingMutex.Lock()
+	if q.pauseReason != "" {
+		q.processingMutex.Unlock()
+		return
+	}
+	q.isProcessing = false
+	q.pauseReason = reason
+	q.processingMutex.Unlock()
+
+	log.Printf("Queue processing paused: %s", reason)
+	q.dbService.LogEvent(batchID, "", "budget_exceeded", "paused", reason,
+		"Увеличьте лимит в настройках и продолжите обработку батча", 0)
+	if q.emitEvent != nil {
+		q.emitEvent("queue:paused", map[string]string{"batchId": batchID, "reason": reason})
+	}
+}
+
+// PauseReason возвращает причину остановки очереди по лимиту AI, пустая строка - очередь не на паузе
+func (q *QueueManager) PauseReason() string {
+	q.processingMutex.Lock()
+	defer q.processingMutex.Unlock()
+	return q.pauseReason
+}
+
+// ResumeBatch возвращает батч, остановленный по лимиту AI или пользователем, в очередь и запускает обработку.
+// Уже обработанные фото батча повторно в AI не отправляются.
+func (q *QueueManager) ResumeBatch(batchID string, settings models.AppSettings) error {
+	var status string
+	err := q.db.QueryRow("SELECT status FROM batches WHERE id = ?", batchID).Scan(&status)
+	if err != nil {
+		return fmt.Errorf("failed to get batch %s: %w", batchID, err)
+	}
+	if status != "paused" {
+		return fmt.Errorf("batch %s is not paused (status: %s)", batchID, status)
+	}
+
+	if reason, exceeded := q.aiBudgetExceeded(batchID, settings); exceeded {
+		return errors.New(reason)
+	}
+
+	q.updateBatchStatus(batchID, "queued", "")
+	q.dbService.LogEvent(batchID, "", "batch_resume", "queued", "Обработка батча продолжена", "", 0)
+
+	q.processingMutex.Lock()
+	running := q.isProcessing
+	q.processingMutex.Unlock()
+	if running {
+		return nil
+	}
+	return q.StartProcessing(settings)
+}
@@ -0,0 +1,584 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"stock-photo-app/models"
+	"strings"
+	"time"
+)
+
+// Версия Anthropic API, которую отправляем в заголовке anthropic-version
+const claudeAPIVersion = "2023-06-01"
+
+// defaultClaudeAPIURL корневой адрес Anthropic API
+const defaultClaudeAPIURL = "https://api.anthropic.com"
+
+// claudeMetadataTool имя инструмента, через который Claude возвращает структурированный ответ
+const claudeMetadataTool = "photo_metadata"
+
+// Claude Messages API структуры
+type ClaudeRequest struct {
+	Model      string            `json:"model"`
+	MaxTokens  int               `json:"max_tokens"`
+	Messages   []ClaudeMessage   `json:"messages"`
+	Tools      []ClaudeTool      `json:"tools,omitempty"`
+	ToolChoice *ClaudeToolChoice `json:"tool_choice,omitempty"`
+}
+
+type ClaudeMessage struct {
+	Role    string          `json:"role"`
+	Content []ClaudeContent `json:"content"`
+}
+
+type ClaudeContent struct {
+	Type   string             `json:"type"`
+	Text   string             `json:"text,omitempty"`
+	Source *ClaudeImageSource `json:"source,omitempty"`
+}
+
+type ClaudeImageSource struct {
+	Type      string `json:"type"` // "base64"
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type ClaudeTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema Schema `json:"input_schema"`
+}
+
+type ClaudeToolChoice struct {
+	Type string `json:"type"` // "auto", "any", "tool"
+	Name string `json:"name,omitempty"`
+}
+
+type ClaudeResponse struct {
+	Content    []ClaudeResponseBlock `json:"content"`
+	StopReason string                `json:"stop_reason"`
+	Usage      *ClaudeUsage          `json:"usage,omitempty"`
+	Error      *ClaudeAPIError       `json:"error,omitempty"`
+}
+
+// ClaudeUsage токены запроса; input_tokens не включает токены, записанные в кеш и прочитанные из него
+type ClaudeUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+}
+
+type ClaudeResponseBlock struct {
+	Type  string          `json:"type"` // "text", "tool_use"
+	Text  string          `json:"text,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+}
+
+type ClaudeAPIError struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+// ClaudeProvider реализует VisionProvider для Anthropic Messages API
+type ClaudeProvider struct {
+	service *AIService
+}
+
+// NewClaudeProvider создает провайдер Claude, использующий общие HTTP и парсинг AIService
+func NewClaudeProvider(service *AIService) *ClaudeProvider {
+	return &ClaudeProvider{service: service}
+}
+
+// Analyze анализирует изображение через Claude API с retry логикой
+func (p *ClaudeProvider) Analyze(ctx context.Context, photo models.Photo, description string, prompt string, contentType string, settings models.AppSettings) (*models.AIResult, error) {
+	const maxRetries = 3
+
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		result, err := p.analyzeWithClaudeAttempt(ctx, photo, description, prompt, contentType, settings)
+		if err == nil {
+			return result, nil
+		}
+
+		log.Printf("Claude analysis attempt %d/%d failed for photo %s: %v", attempt, maxRetries, photo.FileName, err)
+
+		// Если это последняя попытка, критическая ошибка или анализ отменен, возвращаем ошибку
+		if attempt == maxRetries || ctx.Err() != nil || !p.service.isRetryableError(err) {
+			return nil, err
+		}
+
+		// Пауза между попытками
+		if err := sleepContext(ctx, time.Duration(attempt)*time.Second); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("all retry attempts failed")
+}
+
+// analyzeWithClaudeAttempt выполняет одну попытку анализа фото через Claude
+func (p *ClaudeProvider) analyzeWithClaudeAttempt(ctx context.Context, photo models.Photo, description string, prompt string, contentType string, settings models.AppSettings) (*models.AIResult, error) {
+	// Кодируем изображение в base64
+	imageProcessor := NewImageProcessor(settings.TempDirectory)
+	base64Image, err := imageProcessor.EncodeImageToBase64(photo.ThumbnailPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	// Формируем полный промпт с учетом типа контента
+	fullPrompt := p.service.exifProcessor.BuildContextualPrompt(contentType, prompt, photo.ExifData, description)
+
+	if p.service.logger != nil {
+		p.service.logger.LogAIPrompt(photo.FileName, fullPrompt, description)
+		p.service.logger.LogAI("EXIF data fields count: %d", len(photo.ExifData))
+	} else {
+		log.Printf("Full contextual prompt being sent to Claude:")
+		log.Printf("==== START PROMPT ====")
+		log.Printf("%s", fullPrompt)
+		log.Printf("==== END PROMPT ====")
+		log.Printf("Photo filename: %s", photo.FileName)
+	}
+
+	model := settings.AIModel
+	if model == "" {
+		model = "claude-sonnet-4-20250514" // fallback
+	}
+
+	maxTokens := 2000 // значение по умолчанию
+	if settings.AIMaxTokens > 0 {
+		maxTokens = settings.AIMaxTokens
+	}
+
+	// Структурированный вывод через принудительный вызов инструмента
+	request := ClaudeRequest{
+		Model:     model,
+		MaxTokens: maxTokens,
+		Tools: []ClaudeTool{
+			{
+				Name:        claudeMetadataTool,
+				Description: "Save stock photo metadata generated for the image",
+				InputSchema: photoMetadataSchema(),
+			},
+		},
+		ToolChoice: &ClaudeToolChoice{Type: "tool", Name: claudeMetadataTool},
+		Messages: []ClaudeMessage{
+			{
+				Role: "user",
+				Content: []ClaudeContent{
+					{
+						Type: "image",
+						Source: &ClaudeImageSource{
+							Type:      "base64",
+							MediaType: "image/jpeg", // миниатюры всегда сохраняются в JPEG
+							Data:      base64Image,
+						},
+					},
+					{
+						Type: "text",
+						Text: fullPrompt,
+					},
+				},
+			},
+		},
+	}
+
+	imageTokens := estimateClaudeImageTokens(photo.ThumbnailPath)
+	call := newAICall(photo, "claude", model, fullPrompt, imageTokens, maxTokens)
+	response, err := p.sendClaudeRequest(ctx, call, request, settings)
+	if err != nil {
+		return nil, err
+	}
+	p.service.rateLimiter.settle(call, response.Usage.tokens())
+	p.service.recordUsage(photo, "claude", model, response.Usage.tokens(), imageTokens)
+
+	result, err := p.service.parseAIResponse(claudeResponseContent(response), photo.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Устанавливаем тип контента в результате
+	result.ContentType = contentType
+
+	// Валидируем длину описания в зависимости от типа контента
+	applyDescriptionLimits(result, contentType)
+
+	return result, nil
+}
+
+// tokens возвращает все входные токены запроса, включая кеш, и выходные токены
+func (u *ClaudeUsage) tokens() aiTokens {
+	if u == nil {
+		return aiTokens{}
+	}
+	return aiTokens{
+		prompt:     u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens,
+		completion: u.OutputTokens,
+	}
+}
+
+// claudeResponseContent извлекает JSON метаданных из ответа Claude.
+// Основной путь - input блока tool_use, текстовые блоки используются как fallback.
+func claudeResponseContent(response *ClaudeResponse) string {
+	var text strings.Builder
+	for _, block := range response.Content {
+		switch block.Type {
+		case "tool_use":
+			if block.Name == claudeMetadataTool && len(block.Input) > 0 {
+				return string(block.Input)
+			}
+		case "text":
+			text.WriteString(block.Text)
+		}
+	}
+
+	if response.StopReason == "max_tokens" {
+		log.Printf("Warning: Claude response was cut off by max_tokens limit")
+	}
+
+	return text.String()
+}
+
+// sendClaudeRequest отправляет запрос к Claude Messages API
+func (p *ClaudeProvider) sendClaudeRequest(ctx context.Context, call *aiCall, request ClaudeRequest, settings models.AppSettings) (*ClaudeResponse, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	apiURL := claudeAPIURL(settings.AIBaseURL, "messages")
+
+	headers := map[string]string{
+		"x-api-key":         settings.AIAPIKey,
+		"anthropic-version": claudeAPIVersion,
+	}
+
+	statusCode, body, err := p.service.postWithRetry(ctx, call, apiURL, jsonData, headers, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var response ClaudeResponse
+	if statusCode != http.StatusOK {
+		// Anthropic возвращает ошибки в виде {"type":"error","error":{...}}
+		if json.Unmarshal(body, &response) == nil && response.Error != nil {
+			return nil, fmt.Errorf("API request failed with status %d: %s: %s", statusCode, response.Error.Type, response.Error.Message)
+		}
+		return nil, fmt.Errorf("API request failed with status %d: %s", statusCode, string(body))
+	}
+
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if response.Error != nil {
+		return nil, fmt.Errorf("API error: %s", response.Error.Message)
+	}
+
+	return &response, nil
+}
+
+// claudeAPIURL возвращает адрес метода API ("messages", "models"). В AIBaseURL можно указать
+// корень сервера, адрес с /v1 или полный адрес /v1/messages: все методы строятся от одного корня.
+func claudeAPIURL(baseURL, endpoint string) string {
+	root := strings.TrimSuffix(strings.TrimSpace(baseURL), "/")
+	if root == "" {
+		root = defaultClaudeAPIURL
+	}
+	for _, suffix := range []string{"/messages", "/models", "/v1"} {
+		root = strings.TrimSuffix(root, suffix)
+	}
+	return root + "/v1/" + endpoint
+}
+
+// claudeGet отправляет GET запрос к методу API с таймаутом AI из настроек
+func (p *ClaudeProvider) claudeGet(ctx context.Context, settings models.AppSettings, endpoint string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", claudeAPIURL(settings.AIBaseURL, endpoint), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("x-api-key", settings.AIAPIKey)
+	req.Header.Set("anthropic-version", claudeAPIVersion)
+
+	client := &http.Client{Timeout: aiRequestTimeout(settings)}
+	return client.Do(req)
+}
+
+// TestConnection тестирует подключение к Claude
+func (p *ClaudeProvider) TestConnection(settings models.AppSettings) error {
+	resp, err := p.claudeGet(context.Background(), settings, "models")
+	if err != nil {
+		return fmt.Errorf("failed to connect to AI API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("invalid API key")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// ListModels получает список моделей Claude
+func (p *ClaudeProvider) ListModels(settings models.AppSettings) ([]models.AIModel, error) {
+	// Если есть API ключ, сначала пытаемся получить актуальный список с API
+	if settings.AIAPIKey != "" {
+		actualModels, err := p.fetchClaudeModels(settings)
+		if err == nil && len(actualModels) > 0 {
+			log.Printf("Successfully fetched %d models from Claude API", len(actualModels))
+			return actualModels, nil
+		}
+		log.Printf("Failed to fetch Claude models from API: %v, using static fallback", err)
+	} else {
+		log.Printf("No API key provided, using static Claude model list")
+	}
+
+	// Fallback: статический список актуальных моделей
+	staticModels := []models.AIModel{
+		// Claude 4 Series (новейшие модели)
+		{
+			ID:             "claude-opus-4-20250514",
+			Name:           "Claude Opus 4",
+			Description:    "Most capable and intelligent model with superior reasoning capabilities",
+			MaxTokens:      200000,
+			SupportsVision: true,
+			Provider:       "claude",
+		},
+		{
+			ID:             "claude-sonnet-4-20250514",
+			Name:           "Claude Sonnet 4",
+			Description:    "High-performance model with exceptional reasoning and efficiency",
+			MaxTokens:      200000,
+			SupportsVision: true,
+			Provider:       "claude",
+		},
+
+		// Claude 3.7 Series
+		{
+			ID:             "claude-3-7-sonnet-20250219",
+			Name:           "Claude 3.7 Sonnet",
+			Description:    "High-performance model with early extended thinking capabilities",
+			MaxTokens:      200000,
+			SupportsVision: true,
+			Provider:       "claude",
+		},
+
+		// Claude 3.5 Series (актуальные версии)
+		{
+			ID:             "claude-3-5-sonnet-20241022",
+			Name:           "Claude 3.5 Sonnet v2",
+			Description:    "Most intelligent model with enhanced vision capabilities (Latest)",
+			MaxTokens:      200000,
+			SupportsVision: true,
+			Provider:       "claude",
+		},
+		{
+			ID:             "claude-3-5-sonnet-20240620",
+			Name:           "Claude 3.5 Sonnet v1",
+			Description:    "Original Claude 3.5 Sonnet with advanced capabilities",
+			MaxTokens:      200000,
+			SupportsVision: true,
+			Provider:       "claude",
+		},
+		{
+			ID:             "claude-3-5-haiku-20241022",
+			Name:           "Claude 3.5 Haiku",
+			Description:    "Fastest model with vision capabilities and high intelligence",
+			MaxTokens:      200000,
+			SupportsVision: true,
+			Provider:       "claude",
+		},
+
+		// Claude 3 Series (legacy но все еще актуальные)
+		{
+			ID:             "claude-3-opus-20240229",
+			Name:           "Claude 3 Opus",
+			Description:    "Most powerful model for complex tasks with exceptional reasoning",
+			MaxTokens:      200000,
+			SupportsVision: true,
+			Provider:       "claude",
+		},
+		{
+			ID:             "claude-3-sonnet-20240229",
+			Name:           "Claude 3 Sonnet",
+			Description:    "Balanced model for general tasks with strong performance",
+			MaxTokens:      200000,
+			SupportsVision: true,
+			Provider:       "claude",
+		},
+		{
+			ID:             "claude-3-haiku-20240307",
+			Name:           "Claude 3 Haiku",
+			Description:    "Fast and cost-effective model for quick responses",
+			MaxTokens:      200000,
+			SupportsVision: true,
+			Provider:       "claude",
+		},
+	}
+
+	return staticModels, nil
+}
+
+// fetchClaudeModels получает актуальный список моделей через API Claude
+func (p *ClaudeProvider) fetchClaudeModels(settings models.AppSettings) ([]models.AIModel, error) {
+	resp, err := p.claudeGet(context.Background(), settings, "models")
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var response struct {
+		Data []struct {
+			ID          string `json:"id"`
+			DisplayName string `json:"display_name"`
+			CreatedAt   string `json:"created_at"`
+			Type        string `json:"type"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	var aiModels []models.AIModel
+	for _, model := range response.Data {
+		// Все модели Claude поддерживают vision и text
+		aiModel := p.convertClaudeToAIModel(model.ID, model.DisplayName)
+		aiModels = append(aiModels, aiModel)
+	}
+
+	log.Printf("Fetched %d models from Claude API", len(aiModels))
+	return aiModels, nil
+}
+
+// convertClaudeToAIModel преобразует Claude модель в структуру AIModel
+func (p *ClaudeProvider) convertClaudeToAIModel(modelID, displayName string) models.AIModel {
+	model := models.AIModel{
+		ID:             modelID,
+		Name:           p.getClaudeDisplayName(modelID, displayName),
+		Description:    p.getClaudeDescription(modelID),
+		MaxTokens:      p.getClaudeMaxTokens(modelID),
+		SupportsVision: true, // Все современные модели Claude поддерживают vision
+		Provider:       "claude",
+	}
+	return model
+}
+
+// getClaudeDisplayName возвращает красивое название модели Claude
+func (p *ClaudeProvider) getClaudeDisplayName(modelID, displayName string) string {
+	// Если есть display_name из API, используем его
+	if displayName != "" && displayName != modelID {
+		return displayName
+	}
+
+	// Иначе генерируем на основе ID
+	switch {
+	case strings.Contains(modelID, "claude-opus-4"):
+		return "Claude Opus 4"
+	case strings.Contains(modelID, "claude-sonnet-4"):
+		return "Claude Sonnet 4"
+	case strings.Contains(modelID, "claude-3-7-sonnet"):
+		return "Claude 3.7 Sonnet"
+	case strings.Contains(modelID, "claude-3-5-sonnet"):
+		if strings.Contains(modelID, "20241022") {
+			return "Claude 3.5 Sonnet v2"
+		}
+		return "Claude 3.5 Sonnet"
+	case strings.Contains(modelID, "claude-3-5-haiku"):
+		return "Claude 3.5 Haiku"
+	case strings.Contains(modelID, "claude-3-opus"):
+		return "Claude 3 Opus"
+	case strings.Contains(modelID, "claude-3-sonnet"):
+		return "Claude 3 Sonnet"
+	case strings.Contains(modelID, "claude-3-haiku"):
+		return "Claude 3 Haiku"
+	default:
+		return strings.ToUpper(modelID)
+	}
+}
+
+// getClaudeDescription возвращает описание модели Claude
+func (p *ClaudeProvider) getClaudeDescription(modelID string) string {
+	switch {
+	case strings.Contains(modelID, "claude-opus-4"):
+		return "Most capable and intelligent model with superior reasoning capabilities"
+	case strings.Contains(modelID, "claude-sonnet-4"):
+		return "High-performance model with exceptional reasoning and efficiency"
+	case strings.Contains(modelID, "claude-3-7-sonnet"):
+		return "High-performance model with early extended thinking capabilities"
+	case strings.Contains(modelID, "claude-3-5-sonnet"):
+		if strings.Contains(modelID, "20241022") {
+			return "Most intelligent model with enhanced vision capabilities (Latest)"
+		}
+		return "Advanced model with superior intelligence and vision capabilities"
+	case strings.Contains(modelID, "claude-3-5-haiku"):
+		return "Fastest model with vision capabilities and high intelligence"
+	case strings.Contains(modelID, "claude-3-opus"):
+		return "Most powerful model for complex tasks with exceptional reasoning"
+	case strings.Contains(modelID, "claude-3-sonnet"):
+		return "Balanced model for general tasks with strong performance"
+	case strings.Contains(modelID, "claude-3-haiku"):
+		return "Fast and cost-effective model for quick responses"
+	default:
+		return fmt.Sprintf("Claude %s model with vision support", modelID)
+	}
+}
+
+// claudeModelPrices цены моделей Claude в USD за 1M входных и выходных токенов по умолчанию
+var claudeModelPrices = []models.AIModelPrice{
+	{Model: "claude-opus-4", Provider: "claude", InputPrice: 15, OutputPrice: 75},
+	{Model: "claude-sonnet-4", Provider: "claude", InputPrice: 3, OutputPrice: 15},
+	{Model: "claude-3-7-sonnet", Provider: "claude", InputPrice: 3, OutputPrice: 15},
+	{Model: "claude-3-5-sonnet", Provider: "claude", InputPrice: 3, OutputPrice: 15},
+	{Model: "claude-3-5-haiku", Provider: "claude", InputPrice: 0.8, OutputPrice: 4},
+	{Model: "claude-3-opus", Provider: "claude", InputPrice: 15, OutputPrice: 75},
+	{Model: "claude-3-haiku", Provider: "claude", InputPrice: 0.25, OutputPrice: 1.25},
+}
+
+// getClaudeMaxTokens возвращает максимальное количество токенов для модели Claude
+func (p *ClaudeProvider) getClaudeMaxTokens(modelID string) int {
+	switch {
+	// Claude 4 models
+	case strings.Contains(modelID, "claude-opus-4"):
+		return 200000
+	case strings.Contains(modelID, "claude-sonnet-4"):
+		return 200000
+
+	// Claude 3.7 models
+	case strings.Contains(modelID, "claude-3-7-sonnet"):
+		return 200000
+
+	// Claude 3.5 models
+	case strings.Contains(modelID, "claude-3-5"):
+		return 200000
+
+	// Claude 3 models
+	case strings.Contains(modelID, "claude-3"):
+		return 200000
+
+	default:
+		return 200000 // По умолчанию 200K для всех современных моделей Claude
+	}
+}
@@ -0,0 +1,275 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"stock-photo-app/models"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClaudeSetup создает миниатюру, сервис и настройки, направленные на тестовый сервер Claude
+func testClaudeSetup(t *testing.T, handler http.HandlerFunc) (*ClaudeProvider, models.Photo, models.AppSettings) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	thumbnail := filepath.Join(dir, "thumb.jpg")
+	file, err := os.Create(thumbnail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := jpeg.Encode(file, image.NewRGBA(image.Rect(0, 0, 64, 48)), nil); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	photo := models.Photo{ID: "photo-1", BatchID: "batch-1", FileName: "IMG_0001.JPG", ThumbnailPath: thumbnail}
+	settings := models.AppSettings{
+		AIProvider:    "claude",
+		AIModel:       "claude-sonnet-4-20250514",
+		AIAPIKey:      "test-key",
+		AIBaseURL:     server.URL + "/v1/messages",
+		TempDirectory: dir,
+	}
+
+	return NewClaudeProvider(NewAIService()), photo, settings
+}
+
+// writeClaudeToolUse отвечает блоком tool_use с метаданными
+func writeClaudeToolUse(t *testing.T, w http.ResponseWriter, input map[string]interface{}) {
+	t.Helper()
+
+	raw, _ := json.Marshal(input)
+	json.NewEncoder(w).Encode(ClaudeResponse{
+		Content: []ClaudeResponseBlock{
+			{Type: "text", Text: "Here is the metadata."},
+			{Type: "tool_use", Name: claudeMetadataTool, Input: raw},
+		},
+		StopReason: "tool_use",
+		Usage:      &ClaudeUsage{InputTokens: 100, OutputTokens: 50},
+	})
+}
+
+func TestClaudeAnalyzeForcesToolUse(t *testing.T) {
+	var request ClaudeRequest
+	var headers http.Header
+	provider, photo, settings := testClaudeSetup(t, func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header.Clone()
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("failed to decode request: %v", err)
+		}
+		writeClaudeToolUse(t, w, map[string]interface{}{
+			"title":       "Red tram on a city street",
+			"description": "A red tram moving along a city street",
+			"keywords":    []string{"tram", "city", "transport"},
+			"category":    "Transportation",
+		})
+	})
+
+	result, err := provider.Analyze(context.Background(), photo, "", "prompt", "commercial", settings)
+	if err != nil {
+		t.Fatalf("Analyze returned error: %v", err)
+	}
+
+	if headers.Get("x-api-key") != "test-key" || headers.Get("anthropic-version") != claudeAPIVersion {
+		t.Errorf("unexpected auth headers: %v", headers)
+	}
+	if request.ToolChoice == nil || request.ToolChoice.Type != "tool" || request.ToolChoice.Name != claudeMetadataTool {
+		t.Errorf("tool choice is not forced: %+v", request.ToolChoice)
+	}
+	if len(request.Tools) != 1 || request.Tools[0].Name != claudeMetadataTool {
+		t.Errorf("unexpected tools: %+v", request.Tools)
+	}
+	if len(request.Messages) != 1 || len(request.Messages[0].Content) != 2 || request.Messages[0].Content[0].Type != "image" {
+		t.Errorf("request must contain image and prompt: %+v", request.Messages)
+	}
+
+	if result.Title != "Red tram on a city street" || result.Category != "Transportation" || len(result.Keywords) != 3 {
+		t.Errorf("unexpected result: %+v", result)
+	}
+	if result.ContentType != "commercial" || !result.Processed {
+		t.Errorf("unexpected result state: %+v", result)
+	}
+}
+
+func TestClaudeAnalyzeDescriptionLimits(t *testing.T) {
+	tests := []struct {
+		contentType string
+		length      int
+		want        int
+	}{
+		{"commercial", 200, 200},
+		{"commercial", 250, 200},
+		{"editorial", 250, 250},
+		{"editorial", 600, 500},
+	}
+
+	for _, tt := range tests {
+		description := strings.Repeat("я", tt.length)
+		provider, photo, settings := testClaudeSetup(t, func(w http.ResponseWriter, r *http.Request) {
+			writeClaudeToolUse(t, w, map[string]interface{}{
+				"title":       "Title",
+				"description": description,
+				"keywords":    []string{"keyword"},
+				"category":    "Business",
+			})
+		})
+
+		result, err := provider.Analyze(context.Background(), photo, "", "prompt", tt.contentType, settings)
+		if err != nil {
+			t.Fatalf("%s/%d: Analyze returned error: %v", tt.contentType, tt.length, err)
+		}
+
+		runes := []rune(result.Description)
+		if len(runes) != tt.want {
+			t.Errorf("%s/%d: description length = %d, want %d", tt.contentType, tt.length, len(runes), tt.want)
+		}
+		if tt.length > tt.want && !strings.HasSuffix(result.Description, "...") {
+			t.Errorf("%s/%d: truncated description must end with ...", tt.contentType, tt.length)
+		}
+	}
+}
+
+func TestClaudeAnalyzeRetriesAfter429(t *testing.T) {
+	var requests int32
+	var firstAt, secondAt time.Time
+	provider, photo, settings := testClaudeSetup(t, func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			firstAt = time.Now()
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"type":"error","error":{"type":"rate_limit_error","message":"Too many requests"}}`))
+			return
+		}
+		secondAt = time.Now()
+		writeClaudeToolUse(t, w, map[string]interface{}{
+			"title":       "Title",
+			"description": "Description",
+			"keywords":    []string{"keyword"},
+			"category":    "Business",
+		})
+	})
+
+	result, err := provider.Analyze(context.Background(), photo, "", "prompt", "commercial", settings)
+	if err != nil {
+		t.Fatalf("Analyze returned error: %v", err)
+	}
+	if result.Title != "Title" {
+		t.Errorf("unexpected result: %+v", result)
+	}
+
+	if got := atomic.LoadInt32(&requests); got != 2 {
+		t.Fatalf("requests = %d, want 2", got)
+	}
+	if wait := secondAt.Sub(firstAt); wait < 900*time.Millisecond {
+		t.Errorf("retry was sent after %s, Retry-After is 1s", wait)
+	}
+}
+
+func TestClaudeAnalyzeWithoutToolUse(t *testing.T) {
+	t.Run("text fallback", func(t *testing.T) {
+		provider, photo, settings := testClaudeSetup(t, func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(ClaudeResponse{
+				Content: []ClaudeResponseBlock{
+					{Type: "text", Text: `Metadata: {"title": "From text", "description": "D", "keywords": ["k"], "category": "Business"}`},
+				},
+				StopReason: "end_turn",
+			})
+		})
+
+		result, err := provider.analyzeWithClaudeAttempt(context.Background(), photo, "", "prompt", "commercial", settings)
+		if err != nil {
+			t.Fatalf("analyze returned error: %v", err)
+		}
+		if result.Title != "From text" {
+			t.Errorf("title = %q, want %q", result.Title, "From text")
+		}
+	})
+
+	t.Run("no metadata", func(t *testing.T) {
+		provider, photo, settings := testClaudeSetup(t, func(w http.ResponseWriter, r *http.Request) {
+			json.NewEncoder(w).Encode(ClaudeResponse{
+				Content:    []ClaudeResponseBlock{{Type: "tool_use", Name: "other_tool", Input: json.RawMessage(`{"title":"x"}`)}},
+				StopReason: "tool_use",
+			})
+		})
+
+		_, err := provider.analyzeWithClaudeAttempt(context.Background(), photo, "", "prompt", "commercial", settings)
+		if err == nil {
+			t.Fatal("expected error for response without photo_metadata tool_use block")
+		}
+		if !strings.Contains(err.Error(), "empty response") {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestClaudeAPIURL(t *testing.T) {
+	tests := []struct {
+		baseURL string
+		want    string
+	}{
+		{"", "https://api.anthropic.com/v1/models"},
+		{"https://api.anthropic.com", "https://api.anthropic.com/v1/models"},
+		{"https://api.anthropic.com/", "https://api.anthropic.com/v1/models"},
+		{"https://api.anthropic.com/v1", "https://api.anthropic.com/v1/models"},
+		{"https://api.anthropic.com/v1/messages", "https://api.anthropic.com/v1/models"},
+		{" https://proxy.local/anthropic/v1/messages/ ", "https://proxy.local/anthropic/v1/models"},
+	}
+	for _, tt := range tests {
+		if got := claudeAPIURL(tt.baseURL, "models"); got != tt.want {
+			t.Errorf("claudeAPIURL(%q, models) = %q, want %q", tt.baseURL, got, tt.want)
+		}
+	}
+	if got := claudeAPIURL("https://proxy.local/anthropic", "messages"); got != "https://proxy.local/anthropic/v1/messages" {
+		t.Errorf("messages URL = %q", got)
+	}
+}
+
+func TestClaudeTestConnectionUsesBaseURLAndTimeout(t *testing.T) {
+	var path atomic.Value
+	provider, _, settings := testClaudeSetup(t, func(w http.ResponseWriter, r *http.Request) {
+		path.Store(r.URL.Path)
+		if r.Header.Get("x-api-key") != "test-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"data":[]}`))
+	})
+	if err := provider.TestConnection(settings); err != nil {
+		t.Fatalf("TestConnection: %v", err)
+	}
+	if got := path.Load(); got != "/v1/models" {
+		t.Errorf("models request went to %v, want /v1/models", got)
+	}
+
+	// Зависший сервер не блокирует проверку дольше таймаута AI
+	stalled := make(chan struct{})
+	provider, _, settings = testClaudeSetup(t, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-stalled:
+		}
+	})
+	defer close(stalled)
+	settings.AITimeout = 1
+
+	started := time.Now()
+	if err := provider.TestConnection(settings); err == nil {
+		t.Fatal("TestConnection succeeded against a stalled server")
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("TestConnection returned after %s, want about the 1s AI timeout", elapsed)
+	}
+}
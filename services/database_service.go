@@ -3,22 +3,34 @@ package services
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"stock-photo-app/models"
+	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type DatabaseService struct {
-	db *sql.DB
+	db      *sql.DB
+	secrets *SecretStore // шифрование паролей и API ключей, nil - хранение открытым текстом
+	// duplicatesMu поиск и запись дубликата выполняются атомарно, чтобы два одинаковых фото,
+	// обрабатываемых параллельно, не пометили друг друга
+	duplicatesMu sync.Mutex
 }
 
 func NewDatabaseService(db *sql.DB) *DatabaseService {
 	return &DatabaseService{db: db}
 }
 
+// NewDatabaseServiceWithSecrets создает сервис, который хранит пароли и API ключи зашифрованными
+func NewDatabaseServiceWithSecrets(db *sql.DB, secrets *SecretStore) *DatabaseService {
+	return &DatabaseService{db: db, secrets: secrets}
+}
+
 // InitializeTables создает необходимые таблицы в БД
 func (d *DatabaseService) InitializeTables() error {
 	queries := []string{
@@ -90,12 +102,86 @@ func (d *DatabaseService) InitializeTables() error {
 			FOREIGN KEY (photo_id) REFERENCES photos(id) ON DELETE CASCADE
 		)`,
 
+		`CREATE TABLE IF NOT EXISTS upload_jobs (
+			id TEXT PRIMARY KEY,
+			batch_id TEXT NOT NULL,
+			photo_id TEXT NOT NULL,
+			stock_id TEXT NOT NULL,
+			state TEXT NOT NULL DEFAULT 'pending', -- pending, uploading, uploaded, failed
+			attempts INTEGER DEFAULT 0,
+			next_attempt_at DATETIME DEFAULT (datetime('now')),
+			last_error TEXT,
+			created_at DATETIME DEFAULT (datetime('now')),
+			updated_at DATETIME DEFAULT (datetime('now')),
+			UNIQUE (photo_id, stock_id),
+			FOREIGN KEY (batch_id) REFERENCES batches(id) ON DELETE CASCADE,
+			FOREIGN KEY (photo_id) REFERENCES photos(id) ON DELETE CASCADE
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS photo_series (
+			id TEXT PRIMARY KEY,
+			batch_id TEXT NOT NULL,
+			best_photo_id TEXT,
+			created_at DATETIME DEFAULT (datetime('now')),
+			updated_at DATETIME DEFAULT (datetime('now')),
+			FOREIGN KEY (batch_id) REFERENCES batches(id) ON DELETE CASCADE
+		)`,
+
+		// Без внешних ключей: учет расходов сохраняется после удаления батча или фото
+		`CREATE TABLE IF NOT EXISTS ai_usage (
+			id TEXT PRIMARY KEY,
+			batch_id TEXT,
+			photo_id TEXT,
+			provider TEXT NOT NULL,
+			model TEXT NOT NULL,
+			prompt_tokens INTEGER DEFAULT 0,
+			completion_tokens INTEGER DEFAULT 0,
+			image_tokens INTEGER DEFAULT 0,
+			cost REAL DEFAULT 0, -- USD
+			priced INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT (datetime('now'))
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS ai_model_prices (
+			model TEXT PRIMARY KEY,
+			provider TEXT,
+			input_price REAL DEFAULT 0, -- USD за 1M токенов
+			output_price REAL DEFAULT 0,
+			updated_at DATETIME DEFAULT (datetime('now'))
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS ai_rate_limits (
+			provider TEXT NOT NULL,
+			model TEXT NOT NULL DEFAULT '', -- ID модели или его начало, '' - общий лимит провайдера
+			requests_per_minute INTEGER DEFAULT 0,
+			tokens_per_minute INTEGER DEFAULT 0,
+			updated_at DATETIME DEFAULT (datetime('now')),
+			PRIMARY KEY (provider, model)
+		)`,
+
+		`CREATE TABLE IF NOT EXISTS ai_cache (
+			key TEXT PRIMARY KEY, -- SHA-256 миниатюры, полного промпта, модели и версии схемы
+			provider TEXT,
+			model TEXT,
+			result TEXT NOT NULL, -- JSON AIResult
+			hits INTEGER DEFAULT 0,
+			created_at DATETIME DEFAULT (datetime('now')),
+			last_used_at DATETIME DEFAULT (datetime('now'))
+		)`,
+
 		`CREATE INDEX IF NOT EXISTS idx_photos_batch_id ON photos(batch_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_batches_status ON batches(status)`,
 		`CREATE INDEX IF NOT EXISTS idx_photos_status ON photos(status)`,
 		`CREATE INDEX IF NOT EXISTS idx_event_logs_batch_id ON event_logs(batch_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_event_logs_photo_id ON event_logs(photo_id)`,
 		`CREATE INDEX IF NOT EXISTS idx_event_logs_created_at ON event_logs(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_upload_jobs_stock_state ON upload_jobs(stock_id, state, next_attempt_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_upload_jobs_batch_id ON upload_jobs(batch_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_upload_jobs_photo_id ON upload_jobs(photo_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_photo_series_batch_id ON photo_series(batch_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_ai_usage_batch_id ON ai_usage(batch_id)`,
+		`CREATE INDEX IF NOT EXISTS idx_ai_usage_created_at ON ai_usage(created_at)`,
+		`CREATE INDEX IF NOT EXISTS idx_ai_cache_last_used_at ON ai_cache(last_used_at)`,
 	}
 
 	for _, query := range queries {
@@ -115,6 +201,11 @@ func (d *DatabaseService) InitializeTables() error {
 		return fmt.Errorf("failed to create default settings: %w", err)
 	}
 
+	// Заполняем таблицу цен моделей
+	if err := d.createDefaultModelPrices(); err != nil {
+		return fmt.Errorf("failed to create default model prices: %w", err)
+	}
+
 	// Создаем демо конфигурацию стока (только если нет других активных стоков)
 	// if err := d.createDemoStockConfig(); err != nil {
 	//	return fmt.Errorf("failed to create demo stock config: %w", err)
@@ -173,15 +264,20 @@ func (d *DatabaseService) savePhoto(tx *sql.Tx, photo models.Photo) error {
 		aiResultsJSON, _ = json.Marshal(photo.AIResult)
 	}
 	uploadStatusJSON, _ := json.Marshal(photo.UploadStatus)
+	var renditionsJSON []byte
+	if len(photo.Renditions) > 0 {
+		renditionsJSON, _ = json.Marshal(photo.Renditions)
+	}
 
 	_, err := tx.Exec(`
 		INSERT OR REPLACE INTO photos 
 		(id, batch_id, content_type, original_path, thumbnail_path, file_name, file_size, 
-		 exif_data, ai_results, upload_status, status, created_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		 exif_data, ai_results, upload_status, status, created_at, renditions, content_hash, perceptual_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		photo.ID, photo.BatchID, photo.ContentType, photo.OriginalPath, photo.ThumbnailPath,
 		photo.FileName, photo.FileSize, string(exifJSON), string(aiResultsJSON),
-		string(uploadStatusJSON), photo.Status, photo.CreatedAt)
+		string(uploadStatusJSON), photo.Status, photo.CreatedAt, string(renditionsJSON),
+		photo.ContentHash, photo.PerceptualHash)
 
 	return err
 }
@@ -195,13 +291,431 @@ func (d *DatabaseService) UpdatePhotoAIResults(photoID string, aiResults models.
 
 	_, err = d.db.Exec(`
 		UPDATE photos 
-		SET ai_results = ?, status = 'processed', updated_at = datetime('now') 
+		SET ai_results = ?, status = 'processed', metadata_override = 0, updated_at = datetime('now') 
 		WHERE id = ?`,
 		string(aiResultsJSON), photoID)
 
 	return err
 }
 
+// UpdatePhotoStockAIResults заменяет метаданные фото, созданные по промптам стоков.
+// Пустой results удаляет их, и все стоки получают общий AIResult.
+func (d *DatabaseService) UpdatePhotoStockAIResults(photoID string, results map[string]*models.AIResult) error {
+	var resultsJSON []byte
+	if len(results) > 0 {
+		var err error
+		resultsJSON, err = json.Marshal(results)
+		if err != nil {
+			return fmt.Errorf("failed to marshal stock AI results: %w", err)
+		}
+	}
+
+	_, err := d.db.Exec(`
+		UPDATE photos 
+		SET stock_ai_results = ?, updated_at = datetime('now') 
+		WHERE id = ?`,
+		string(resultsJSON), photoID)
+	if err != nil {
+		return fmt.Errorf("failed to update stock AI results: %w", err)
+	}
+	return nil
+}
+
+// DeletePhotoStockAIResult удаляет метаданные фото для одного стока, после этого сток получает общий AIResult
+func (d *DatabaseService) DeletePhotoStockAIResult(photoID, stockID string) error {
+	var resultsJSON string
+	err := d.db.QueryRow(`SELECT COALESCE(stock_ai_results, '') FROM photos WHERE id = ?`, photoID).Scan(&resultsJSON)
+	if err != nil {
+		return fmt.Errorf("failed to get photo: %w", err)
+	}
+
+	results := make(map[string]*models.AIResult)
+	if resultsJSON != "" {
+		if err := json.Unmarshal([]byte(resultsJSON), &results); err != nil {
+			return fmt.Errorf("failed to parse stock AI results: %w", err)
+		}
+	}
+	if _, exists := results[stockID]; !exists {
+		return fmt.Errorf("у фото нет отдельных метаданных для стока %s", stockID)
+	}
+	delete(results, stockID)
+
+	return d.UpdatePhotoStockAIResults(photoID, results)
+}
+
+// SavePhotoMetadataVerification сохраняет результат проверки метаданных, записанных в файл фото
+func (d *DatabaseService) SavePhotoMetadataVerification(photoID string, verification models.MetadataVerification) error {
+	verificationJSON, err := json.Marshal(verification)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata verification: %w", err)
+	}
+
+	_, err = d.db.Exec(`
+		UPDATE photos 
+		SET metadata_verification = ?, updated_at = datetime('now') 
+		WHERE id = ?`,
+		string(verificationJSON), photoID)
+	if err != nil {
+		return fmt.Errorf("failed to save metadata verification: %w", err)
+	}
+
+	return nil
+}
+
+// SetPhotoMetadataOverride разрешает или запрещает загрузку фото, метаданные которого не прошли проверку.
+// Разрешение сбрасывается при новых результатах AI.
+func (d *DatabaseService) SetPhotoMetadataOverride(photoID string, override bool) error {
+	result, err := d.db.Exec(`
+		UPDATE photos 
+		SET metadata_override = ?, updated_at = datetime('now') 
+		WHERE id = ?`,
+		override, photoID)
+	if err != nil {
+		return fmt.Errorf("failed to update metadata override: %w", err)
+	}
+	if rows, _ := result.RowsAffected(); rows == 0 {
+		return fmt.Errorf("photo not found: %s", photoID)
+	}
+
+	return nil
+}
+
+// RecordMetadataVerification сохраняет результат проверки метаданных и пишет событие exif_verify
+func (d *DatabaseService) RecordMetadataVerification(batchID, photoID string, verification models.MetadataVerification) {
+	if err := d.SavePhotoMetadataVerification(photoID, verification); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	status, message := "success", "Метаданные в файле совпадают с результатами AI"
+	switch verification.Status {
+	case metadataVerificationWarning:
+		status, message = "warning", "Метаданные записаны с обрезкой полей IPTC"
+	case metadataVerificationMismatch:
+		status, message = "failed", "Метаданные в файле не совпадают с результатами AI"
+	case metadataVerificationError:
+		status, message = "failed", "Не удалось проверить метаданные в файле"
+	case metadataVerificationUnsupported:
+		status, message = "skipped", "Проверка метаданных для этого формата не поддерживается"
+	}
+	if verification.StockID != "" {
+		message += fmt.Sprintf(" (копия для стока %s)", verification.StockID)
+	}
+
+	d.LogEvent(batchID, photoID, "exif_verify", status, message, metadataIssuesSummary(verification.Issues), 100)
+}
+
+// FindPhotoDuplicate ищет фото с тем же SHA-256 или близким perceptual hash среди фото того же батча
+// и фото, уже загруженных на стоки. allBatches - искать во всех батчах (проверка папки перед импортом).
+// Фото, которые сами помечены дубликатами этого фото, не учитываются.
+// Для проверки многих фото подряд хеши загружаются один раз через loadPhotoHashIndex.
+func (d *DatabaseService) FindPhotoDuplicate(photo models.Photo, allBatches bool) (*models.PhotoDuplicate, error) {
+	if photo.ContentHash == "" && photo.PerceptualHash == "" {
+		return nil, nil
+	}
+
+	// Сначала точное совпадение по индексу content_hash
+	if photo.ContentHash != "" {
+		index, err := d.queryPhotoHashIndex("p.id != ? AND p.content_hash = ?", photo.ID, photo.ContentHash)
+		if err != nil {
+			return nil, err
+		}
+		if duplicate := index.findDuplicate(photo, allBatches); duplicate != nil {
+			return duplicate, nil
+		}
+	}
+
+	// Затем перебор perceptual hash; без allBatches подходят только фото батча и загруженные
+	condition := "p.id != ? AND p.perceptual_hash IS NOT NULL AND p.perceptual_hash != ''"
+	args := []interface{}{photo.ID}
+	if !allBatches {
+		condition += ` AND (p.batch_id = ? OR EXISTS (SELECT 1 FROM upload_jobs j WHERE j.photo_id = p.id AND j.state = 'uploaded'))`
+		args = append(args, photo.BatchID)
+	}
+	index, err := d.queryPhotoHashIndex(condition, args...)
+	if err != nil {
+		return nil, err
+	}
+	return index.findDuplicate(photo, allBatches), nil
+}
+
+// loadPhotoHashIndex загружает хеши всех фото базы одним запросом
+func (d *DatabaseService) loadPhotoHashIndex() (*photoHashIndex, error) {
+	return d.queryPhotoHashIndex("(p.content_hash IS NOT NULL AND p.content_hash != '') OR (p.perceptual_hash IS NOT NULL AND p.perceptual_hash != '')")
+}
+
+// queryPhotoHashIndex загружает хеши фото, подходящих под условие
+func (d *DatabaseService) queryPhotoHashIndex(condition string, args ...interface{}) (*photoHashIndex, error) {
+	rows, err := d.db.Query(`
+		SELECT p.id, p.batch_id, p.file_name, COALESCE(p.content_hash, ''), COALESCE(p.perceptual_hash, ''),
+		       COALESCE(p.duplicate_of, ''),
+		       EXISTS (SELECT 1 FROM upload_jobs j WHERE j.photo_id = p.id AND j.state = 'uploaded')
+		FROM photos p
+		WHERE `+condition, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query photo hashes: %w", err)
+	}
+	defer rows.Close()
+
+	index := newPhotoHashIndex()
+	for rows.Next() {
+		var candidate models.Photo
+		var duplicateJSON string
+		var uploaded bool
+		if err := rows.Scan(&candidate.ID, &candidate.BatchID, &candidate.FileName, &candidate.ContentHash,
+			&candidate.PerceptualHash, &duplicateJSON, &uploaded); err != nil {
+			return nil, fmt.Errorf("failed to scan photo hashes: %w", err)
+		}
+
+		var duplicateOf string
+		if duplicateJSON != "" {
+			var candidateDuplicate models.PhotoDuplicate
+			if json.Unmarshal([]byte(duplicateJSON), &candidateDuplicate) == nil {
+				duplicateOf = candidateDuplicate.PhotoID
+			}
+		}
+		index.add(candidate, duplicateOf, uploaded)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read photo hashes: %w", err)
+	}
+	return index, nil
+}
+
+// RecordPhotoDuplicate сохраняет хеши фото и результат поиска дубликата. Возвращает найденный дубликат или nil.
+func (d *DatabaseService) RecordPhotoDuplicate(photo models.Photo) (*models.PhotoDuplicate, error) {
+	d.duplicatesMu.Lock()
+	defer d.duplicatesMu.Unlock()
+
+	duplicate, err := d.FindPhotoDuplicate(photo, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var duplicateJSON interface{}
+	if duplicate != nil {
+		data, _ := json.Marshal(duplicate)
+		duplicateJSON = string(data)
+	}
+
+	_, err = d.db.Exec(`
+		UPDATE photos 
+		SET content_hash = ?, perceptual_hash = ?, duplicate_of = ?, updated_at = datetime('now') 
+		WHERE id = ?`,
+		photo.ContentHash, photo.PerceptualHash, duplicateJSON, photo.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save photo hashes: %w", err)
+	}
+
+	return duplicate, nil
+}
+
+// SaveBatchSeries сохраняет время съемки и оценку качества фото батча и заменяет серии батча новыми
+func (d *DatabaseService) SaveBatchSeries(batchID string, photos []models.Photo, series []models.PhotoSeries) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM photo_series WHERE batch_id = ?`, batchID); err != nil {
+		return fmt.Errorf("failed to delete batch series: %w", err)
+	}
+
+	for _, photo := range photos {
+		var seriesID interface{}
+		if photo.SeriesID != "" {
+			seriesID = photo.SeriesID
+		}
+		_, err := tx.Exec(`
+			UPDATE photos 
+			SET series_id = ?, quality_score = ?, captured_at = ?, updated_at = datetime('now') 
+			WHERE id = ?`,
+			seriesID, photo.QualityScore, photo.CapturedAt, photo.ID)
+		if err != nil {
+			return fmt.Errorf("failed to save photo series: %w", err)
+		}
+	}
+
+	for _, item := range series {
+		_, err := tx.Exec(`
+			INSERT INTO photo_series (id, batch_id, best_photo_id, created_at, updated_at)
+			VALUES (?, ?, ?, ?, datetime('now'))`,
+			item.ID, batchID, item.BestPhotoID, item.CreatedAt)
+		if err != nil {
+			return fmt.Errorf("failed to insert series: %w", err)
+		}
+	}
+
+	return tx.Commit()
+}
+
+// GetBatchSeries возвращает серии кадров батча
+func (d *DatabaseService) GetBatchSeries(batchID string) ([]models.PhotoSeries, error) {
+	rows, err := d.db.Query(`
+		SELECT id FROM photo_series WHERE batch_id = ? ORDER BY created_at, id`, batchID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query batch series: %w", err)
+	}
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan series: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	seriesList := []models.PhotoSeries{}
+	for _, id := range ids {
+		series, err := d.GetPhotoSeries(id)
+		if err != nil {
+			return nil, err
+		}
+		seriesList = append(seriesList, series)
+	}
+	return seriesList, nil
+}
+
+// GetPhotoSeries возвращает серию с кадрами в порядке съемки
+func (d *DatabaseService) GetPhotoSeries(seriesID string) (models.PhotoSeries, error) {
+	var series models.PhotoSeries
+	var bestPhotoID sql.NullString
+	err := d.db.QueryRow(`
+		SELECT id, batch_id, best_photo_id, created_at FROM photo_series WHERE id = ?`, seriesID).Scan(
+		&series.ID, &series.BatchID, &bestPhotoID, &series.CreatedAt)
+	if err != nil {
+		return series, fmt.Errorf("failed to get series %s: %w", seriesID, err)
+	}
+	series.BestPhotoID = bestPhotoID.String
+
+	photos, err := d.getSeriesPhotos(seriesID)
+	if err != nil {
+		return series, fmt.Errorf("failed to get series photos: %w", err)
+	}
+	series.Frames = []models.SeriesFrame{}
+	for _, photo := range photos {
+		series.Frames = append(series.Frames, seriesFrame(photo))
+	}
+	return series, nil
+}
+
+// SetSeriesBestPhoto назначает лучший кадр серии, который анализирует AI
+func (d *DatabaseService) SetSeriesBestPhoto(seriesID, photoID string) error {
+	var photoSeriesID sql.NullString
+	err := d.db.QueryRow(`SELECT series_id FROM photos WHERE id = ?`, photoID).Scan(&photoSeriesID)
+	if err != nil {
+		return fmt.Errorf("failed to get photo: %w", err)
+	}
+	if photoSeriesID.String != seriesID {
+		return fmt.Errorf("фото %s не входит в серию %s", photoID, seriesID)
+	}
+
+	_, err = d.db.Exec(`
+		UPDATE photo_series SET best_photo_id = ?, updated_at = datetime('now') WHERE id = ?`,
+		photoID, seriesID)
+	if err != nil {
+		return fmt.Errorf("failed to update series: %w", err)
+	}
+	return nil
+}
+
+// UpdateSeriesPhotos задает состав серии: фото из того же батча добавляются (в том числе из других серий),
+// остальные кадры выходят из серии. Серия меньше чем из двух кадров удаляется. Если лучший кадр
+// вышел из серии, лучшим становится кадр с наибольшей оценкой качества.
+func (d *DatabaseService) UpdateSeriesPhotos(seriesID string, photoIDs []string) error {
+	series, err := d.GetPhotoSeries(seriesID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`UPDATE photos SET series_id = NULL WHERE series_id = ?`, seriesID); err != nil {
+		return fmt.Errorf("failed to clear series: %w", err)
+	}
+
+	for _, photoID := range photoIDs {
+		var batchID, fileName string
+		err := tx.QueryRow(`SELECT batch_id, file_name FROM photos WHERE id = ?`, photoID).Scan(&batchID, &fileName)
+		if err != nil {
+			return fmt.Errorf("failed to get photo %s: %w", photoID, err)
+		}
+		if batchID != series.BatchID {
+			return fmt.Errorf("фото %s из другого батча", fileName)
+		}
+		if _, err := tx.Exec(`UPDATE photos SET series_id = ? WHERE id = ?`, seriesID, photoID); err != nil {
+			return fmt.Errorf("failed to add photo to series: %w", err)
+		}
+	}
+
+	// Эта серия и серии, из которых забрали кадры, могли стать слишком маленькими или потерять лучший кадр
+	if err := d.cleanupBatchSeries(tx, series.BatchID); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// cleanupBatchSeries удаляет серии батча меньше чем из двух кадров и выбирает лучший кадр сериям, которые его потеряли
+func (d *DatabaseService) cleanupBatchSeries(tx *sql.Tx, batchID string) error {
+	rows, err := tx.Query(`
+		SELECT s.id, COALESCE(s.best_photo_id, ''), p.id, p.file_name, COALESCE(p.quality_score, 0)
+		FROM photo_series s LEFT JOIN photos p ON p.series_id = s.id
+		WHERE s.batch_id = ?`, batchID)
+	if err != nil {
+		return fmt.Errorf("failed to query batch series: %w", err)
+	}
+
+	best := make(map[string]string)
+	frames := make(map[string][]models.SeriesFrame)
+	var order []string
+	for rows.Next() {
+		var seriesID, bestPhotoID string
+		var photoID, fileName sql.NullString
+		var score sql.NullFloat64
+		if err := rows.Scan(&seriesID, &bestPhotoID, &photoID, &fileName, &score); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan series: %w", err)
+		}
+		if _, seen := best[seriesID]; !seen {
+			order = append(order, seriesID)
+		}
+		best[seriesID] = bestPhotoID
+		if photoID.Valid {
+			frames[seriesID] = append(frames[seriesID], models.SeriesFrame{
+				PhotoID: photoID.String, FileName: fileName.String, QualityScore: score.Float64,
+			})
+		}
+	}
+	rows.Close()
+
+	for _, seriesID := range order {
+		switch {
+		case len(frames[seriesID]) < 2:
+			if _, err := tx.Exec(`UPDATE photos SET series_id = NULL WHERE series_id = ?`, seriesID); err != nil {
+				return fmt.Errorf("failed to clear series: %w", err)
+			}
+			if _, err := tx.Exec(`DELETE FROM photo_series WHERE id = ?`, seriesID); err != nil {
+				return fmt.Errorf("failed to delete series: %w", err)
+			}
+		case !containsFrame(frames[seriesID], best[seriesID]):
+			_, err := tx.Exec(`UPDATE photo_series SET best_photo_id = ?, updated_at = datetime('now') WHERE id = ?`,
+				bestSeriesFrame(frames[seriesID]), seriesID)
+			if err != nil {
+				return fmt.Errorf("failed to update series: %w", err)
+			}
+		}
+	}
+	return nil
+}
+
 // UpdatePhotoThumbnail обновляет thumbnail path для фото
 func (d *DatabaseService) UpdatePhotoThumbnail(photoID string, thumbnailPath string) error {
 	_, err := d.db.Exec(`
@@ -283,6 +797,343 @@ func (d *DatabaseService) UpdatePhotoUploadQueueStatus(photoID string, status st
 	return nil
 }
 
+// EnqueueUploadJob ставит загрузку фото на сток в персистентную очередь.
+// Повторная постановка сбрасывает попытки, если задача сейчас не выполняется.
+func (d *DatabaseService) EnqueueUploadJob(batchID, photoID, stockID string) error {
+	jobID := fmt.Sprintf("upload_%s_%s", photoID, stockID)
+
+	_, err := d.db.Exec(`
+		INSERT INTO upload_jobs (id, batch_id, photo_id, stock_id, state, attempts, next_attempt_at, last_error, created_at, updated_at)
+		VALUES (?, ?, ?, ?, 'pending', 0, datetime('now'), '', datetime('now'), datetime('now'))
+		ON CONFLICT(photo_id, stock_id) DO UPDATE SET
+			state = 'pending',
+			attempts = 0,
+			next_attempt_at = datetime('now'),
+			last_error = '',
+			updated_at = datetime('now')
+		WHERE upload_jobs.state != 'uploading'`,
+		jobID, batchID, photoID, stockID)
+
+	if err != nil {
+		return fmt.Errorf("failed to enqueue upload job: %w", err)
+	}
+
+	return nil
+}
+
+// ClaimNextUploadJob атомарно забирает следующую готовую задачу стока и переводит ее в uploading.
+// Возвращает nil, если готовых задач нет.
+func (d *DatabaseService) ClaimNextUploadJob(stockID string) (*models.UploadJob, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var jobID string
+	err = tx.QueryRow(`
+		SELECT id FROM upload_jobs
+		WHERE stock_id = ? AND state = 'pending' AND next_attempt_at <= datetime('now')
+		ORDER BY next_attempt_at, created_at
+		LIMIT 1`, stockID).Scan(&jobID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to select upload job: %w", err)
+	}
+
+	result, err := tx.Exec(`
+		UPDATE upload_jobs
+		SET state = 'uploading', attempts = attempts + 1, updated_at = datetime('now')
+		WHERE id = ? AND state = 'pending'`, jobID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim upload job: %w", err)
+	}
+	if affected, _ := result.RowsAffected(); affected == 0 {
+		return nil, nil
+	}
+
+	job, err := scanUploadJob(tx.QueryRow(`
+		SELECT id, batch_id, photo_id, stock_id, state, attempts, next_attempt_at,
+		       COALESCE(last_error, ''), created_at, updated_at
+		FROM upload_jobs WHERE id = ?`, jobID))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit upload job claim: %w", err)
+	}
+
+	return &job, nil
+}
+
+// FinishUploadJob сохраняет итоговое состояние задачи загрузки
+func (d *DatabaseService) FinishUploadJob(jobID, state, lastError string) error {
+	_, err := d.db.Exec(`
+		UPDATE upload_jobs
+		SET state = ?, last_error = ?, updated_at = datetime('now')
+		WHERE id = ?`,
+		state, lastError, jobID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update upload job: %w", err)
+	}
+
+	return nil
+}
+
+// RescheduleUploadJob возвращает задачу в очередь с отложенной следующей попыткой
+func (d *DatabaseService) RescheduleUploadJob(jobID string, delay time.Duration, lastError string) error {
+	_, err := d.db.Exec(`
+		UPDATE upload_jobs
+		SET state = 'pending', last_error = ?,
+		    next_attempt_at = datetime('now', ?), updated_at = datetime('now')
+		WHERE id = ?`,
+		lastError, fmt.Sprintf("+%d seconds", int(delay.Seconds())), jobID)
+
+	if err != nil {
+		return fmt.Errorf("failed to reschedule upload job: %w", err)
+	}
+
+	return nil
+}
+
+// RequeueInterruptedUploadJob возвращает в очередь задачу, прерванную остановкой очереди загрузки.
+// Прерванная попытка не учитывается в счетчике попыток.
+func (d *DatabaseService) RequeueInterruptedUploadJob(jobID string) error {
+	_, err := d.db.Exec(`
+		UPDATE upload_jobs
+		SET state = 'pending', attempts = MAX(attempts - 1, 0),
+		    next_attempt_at = datetime('now'), updated_at = datetime('now')
+		WHERE id = ?`, jobID)
+
+	if err != nil {
+		return fmt.Errorf("failed to requeue interrupted upload job: %w", err)
+	}
+
+	return nil
+}
+
+// RequeueDeadLetterJobs возвращает в очередь задачи из dead-letter со сброшенным счетчиком попыток.
+// Пустой stockID означает все стоки батча. Возвращает ID фото, задачи которых были перезапущены.
+func (d *DatabaseService) RequeueDeadLetterJobs(batchID, stockID string) ([]string, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Задачи удаленных стоков в очередь не возвращаются: их никто не заберет
+	where := "batch_id = ? AND state IN ('dead_letter', 'failed') AND stock_id IN (SELECT id FROM stock_configs)"
+	args := []interface{}{batchID}
+	if stockID != "" {
+		where += " AND stock_id = ?"
+		args = append(args, stockID)
+	}
+
+	rows, err := tx.Query("SELECT DISTINCT photo_id FROM upload_jobs WHERE "+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead-letter upload jobs: %w", err)
+	}
+
+	var photoIDs []string
+	for rows.Next() {
+		var photoID string
+		if err := rows.Scan(&photoID); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan dead-letter upload job: %w", err)
+		}
+		photoIDs = append(photoIDs, photoID)
+	}
+	rows.Close()
+
+	_, err = tx.Exec(`
+		UPDATE upload_jobs
+		SET state = 'pending', attempts = 0, last_error = '',
+		    next_attempt_at = datetime('now'), updated_at = datetime('now')
+		WHERE `+where, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to requeue dead-letter upload jobs: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit dead-letter requeue: %w", err)
+	}
+
+	return photoIDs, nil
+}
+
+// ResetInterruptedUploadJobs возвращает в очередь задачи, прерванные закрытием приложения.
+// Попытка, списанная при захвате задачи, возвращается: прерванная загрузка не считается неудачной.
+func (d *DatabaseService) ResetInterruptedUploadJobs() (int64, error) {
+	result, err := d.db.Exec(`
+		UPDATE upload_jobs
+		SET state = 'pending', attempts = MAX(attempts - 1, 0), next_attempt_at = datetime('now'), updated_at = datetime('now')
+		WHERE state = 'uploading'`)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reset interrupted upload jobs: %w", err)
+	}
+
+	return result.RowsAffected()
+}
+
+// CountUploadJobs возвращает количество задач загрузки в указанном состоянии для существующих стоков
+func (d *DatabaseService) CountUploadJobs(state string) (int, error) {
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM upload_jobs WHERE state = ? AND stock_id IN (SELECT id FROM stock_configs)", state).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count upload jobs: %w", err)
+	}
+
+	return count, nil
+}
+
+// CountUploadJobsByStock возвращает количество задач в указанном состоянии по каждому существующему стоку
+func (d *DatabaseService) CountUploadJobsByStock(state string) (map[string]int, error) {
+	rows, err := d.db.Query(`
+		SELECT stock_id, COUNT(*) FROM upload_jobs
+		WHERE state = ? AND stock_id IN (SELECT id FROM stock_configs)
+		GROUP BY stock_id`, state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count upload jobs: %w", err)
+	}
+	defer rows.Close()
+
+	counts := make(map[string]int)
+	for rows.Next() {
+		var stockID string
+		var count int
+		if err := rows.Scan(&stockID, &count); err != nil {
+			return nil, fmt.Errorf("failed to scan upload job count: %w", err)
+		}
+		counts[stockID] = count
+	}
+
+	return counts, rows.Err()
+}
+
+// SumPendingUploadBytesByBatch возвращает суммарный размер файлов в ожидающих задачах загрузки по батчам
+func (d *DatabaseService) SumPendingUploadBytesByBatch() (map[string]int64, error) {
+	rows, err := d.db.Query(`
+		SELECT j.batch_id, COALESCE(SUM(p.file_size), 0)
+		FROM upload_jobs j
+		JOIN photos p ON p.id = j.photo_id
+		WHERE j.state = 'pending' AND j.stock_id IN (SELECT id FROM stock_configs)
+		GROUP BY j.batch_id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sum pending upload bytes: %w", err)
+	}
+	defer rows.Close()
+
+	sums := make(map[string]int64)
+	for rows.Next() {
+		var batchID string
+		var bytes int64
+		if err := rows.Scan(&batchID, &bytes); err != nil {
+			return nil, fmt.Errorf("failed to scan pending upload bytes: %w", err)
+		}
+		sums[batchID] = bytes
+	}
+
+	return sums, nil
+}
+
+// GetPhotoUploadJobs возвращает все задачи загрузки для фото
+func (d *DatabaseService) GetPhotoUploadJobs(photoID string) ([]models.UploadJob, error) {
+	return d.queryUploadJobs("photo_id = ?", photoID)
+}
+
+// GetBatchUploadJobs возвращает все задачи загрузки для батча
+func (d *DatabaseService) GetBatchUploadJobs(batchID string) ([]models.UploadJob, error) {
+	return d.queryUploadJobs("batch_id = ?", batchID)
+}
+
+// GetBatchStockUploadJobs возвращает задачи загрузки батча на один сток
+func (d *DatabaseService) GetBatchStockUploadJobs(batchID, stockID string) ([]models.UploadJob, error) {
+	return d.queryUploadJobs("batch_id = ? AND stock_id = ?", batchID, stockID)
+}
+
+// queryUploadJobs выбирает задачи загрузки по условию
+func (d *DatabaseService) queryUploadJobs(where string, args ...interface{}) ([]models.UploadJob, error) {
+	rows, err := d.db.Query(`
+		SELECT id, batch_id, photo_id, stock_id, state, attempts, next_attempt_at,
+		       COALESCE(last_error, ''), created_at, updated_at
+		FROM upload_jobs
+		WHERE `+where+`
+		ORDER BY created_at`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query upload jobs: %w", err)
+	}
+	defer rows.Close()
+
+	var jobs []models.UploadJob
+	for rows.Next() {
+		job, err := scanUploadJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, rows.Err()
+}
+
+// rowScanner общий интерфейс *sql.Row и *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanUploadJob читает задачу загрузки из строки результата
+func scanUploadJob(row rowScanner) (models.UploadJob, error) {
+	var job models.UploadJob
+	err := row.Scan(&job.ID, &job.BatchID, &job.PhotoID, &job.StockID, &job.State, &job.Attempts,
+		&job.NextAttemptAt, &job.LastError, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		return job, fmt.Errorf("failed to scan upload job: %w", err)
+	}
+
+	return job, nil
+}
+
+// SavePhotoUploadStatus перезаписывает JSON статусов загрузки фото по стокам
+func (d *DatabaseService) SavePhotoUploadStatus(photoID string, uploadStatus map[string]string) error {
+	statusJSON, err := json.Marshal(uploadStatus)
+	if err != nil {
+		return fmt.Errorf("failed to marshal upload status: %w", err)
+	}
+
+	_, err = d.db.Exec(`
+		UPDATE photos 
+		SET upload_status = ?, updated_at = datetime('now') 
+		WHERE id = ?`,
+		string(statusJSON), photoID)
+
+	if err != nil {
+		return fmt.Errorf("failed to update upload status: %w", err)
+	}
+
+	return nil
+}
+
+// GetStockConfig возвращает конфигурацию стока по ID.
+// Если секреты стока не расшифровались, возвращает конфигурацию вместе с ошибкой ErrSecretDecrypt.
+func (d *DatabaseService) GetStockConfig(stockID string) (models.StockConfig, error) {
+	configs, err := d.loadStockConfigs()
+	if err != nil {
+		return models.StockConfig{}, err
+	}
+
+	for _, config := range configs {
+		if config.ID == stockID {
+			return config, d.decryptConnection(config.ID, &config.Connection)
+		}
+	}
+
+	return models.StockConfig{}, fmt.Errorf("stock config %s not found", stockID)
+}
+
 // GetBatchHistory возвращает историю обработанных батчей
 func (d *DatabaseService) GetBatchHistory(limit int) ([]models.PhotoBatch, error) {
 	rows, err := d.db.Query(`
@@ -320,11 +1171,23 @@ func (d *DatabaseService) GetBatchHistory(limit int) ([]models.PhotoBatch, error
 
 // getPhotosForBatch возвращает фотографии для конкретного батча
 func (d *DatabaseService) getPhotosForBatch(batchID string) ([]models.Photo, error) {
+	return d.queryPhotos("batch_id = ?", batchID)
+}
+
+// getSeriesPhotos возвращает кадры серии в порядке съемки
+func (d *DatabaseService) getSeriesPhotos(seriesID string) ([]models.Photo, error) {
+	return d.queryPhotos("series_id = ? ORDER BY COALESCE(captured_at, ''), file_name", seriesID)
+}
+
+// queryPhotos выбирает фотографии по условию WHERE
+func (d *DatabaseService) queryPhotos(where string, args ...interface{}) ([]models.Photo, error) {
 	rows, err := d.db.Query(`
 		SELECT id, batch_id, content_type, original_path, thumbnail_path, file_name, file_size,
-		       exif_data, ai_results, upload_status, status, created_at
+		       exif_data, ai_results, upload_status, status, created_at, COALESCE(renditions, ''),
+		       COALESCE(series_id, ''), COALESCE(quality_score, 0), COALESCE(captured_at, ''),
+		       COALESCE(stock_ai_results, '')
 		FROM photos 
-		WHERE batch_id = ?`, batchID)
+		WHERE `+where, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -333,12 +1196,13 @@ func (d *DatabaseService) getPhotosForBatch(batchID string) ([]models.Photo, err
 	var photos []models.Photo
 	for rows.Next() {
 		var photo models.Photo
-		var exifJSON, aiResultsJSON, uploadStatusJSON string
+		var exifJSON, aiResultsJSON, uploadStatusJSON, renditionsJSON, stockAIResultsJSON string
 
 		err := rows.Scan(&photo.ID, &photo.BatchID, &photo.ContentType, &photo.OriginalPath,
 			&photo.ThumbnailPath, &photo.FileName, &photo.FileSize,
 			&exifJSON, &aiResultsJSON, &uploadStatusJSON,
-			&photo.Status, &photo.CreatedAt)
+			&photo.Status, &photo.CreatedAt, &renditionsJSON,
+			&photo.SeriesID, &photo.QualityScore, &photo.CapturedAt, &stockAIResultsJSON)
 		if err != nil {
 			return nil, err
 		}
@@ -357,6 +1221,12 @@ func (d *DatabaseService) getPhotosForBatch(batchID string) ([]models.Photo, err
 		if uploadStatusJSON != "" {
 			json.Unmarshal([]byte(uploadStatusJSON), &photo.UploadStatus)
 		}
+		if renditionsJSON != "" {
+			json.Unmarshal([]byte(renditionsJSON), &photo.Renditions)
+		}
+		if stockAIResultsJSON != "" {
+			json.Unmarshal([]byte(stockAIResultsJSON), &photo.StockAIResults)
+		}
 
 		photos = append(photos, photo)
 	}
@@ -366,12 +1236,17 @@ func (d *DatabaseService) getPhotosForBatch(batchID string) ([]models.Photo, err
 
 // SaveStockConfig сохраняет конфигурацию стока
 func (d *DatabaseService) SaveStockConfig(config models.StockConfig) error {
+	connection, err := d.encryptConnection(config.Connection)
+	if err != nil {
+		return err
+	}
+
 	supportedTypesJSON, _ := json.Marshal(config.SupportedTypes)
-	connectionJSON, _ := json.Marshal(config.Connection)
+	connectionJSON, _ := json.Marshal(connection)
 	promptsJSON, _ := json.Marshal(config.Prompts)
 	settingsJSON, _ := json.Marshal(config.Settings)
 
-	_, err := d.db.Exec(`
+	_, err = d.db.Exec(`
 		INSERT OR REPLACE INTO stock_configs 
 		(id, name, type, supported_types, upload_method, connection_config, prompts, settings, module_path, active, updated_at)
 		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
@@ -381,8 +1256,43 @@ func (d *DatabaseService) SaveStockConfig(config models.StockConfig) error {
 	return err
 }
 
-// GetAllStockConfigs возвращает все конфигурации стоков
+// SaveStockHostKey закрепляет отпечаток SSH ключа сервера в конфигурации стока
+func (d *DatabaseService) SaveStockHostKey(stockID, fingerprint string) error {
+	config, err := d.GetStockConfig(stockID)
+
+	// Нерасшифрованные секреты сохраняются как есть, поэтому ошибка расшифровки не мешает закрепить ключ
+	if err != nil && !errors.Is(err, ErrSecretDecrypt) {
+		return err
+	}
+
+	config.Connection.HostKeyFingerprint = fingerprint
+	if err := d.SaveStockConfig(config); err != nil {
+		return fmt.Errorf("failed to save host key: %w", err)
+	}
+
+	return nil
+}
+
+// GetAllStockConfigs возвращает все конфигурации стоков.
+// Если секреты каких-то стоков не расшифровались, возвращает все конфигурации вместе с ошибкой ErrSecretDecrypt.
 func (d *DatabaseService) GetAllStockConfigs() ([]models.StockConfig, error) {
+	configs, err := d.loadStockConfigs()
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for i := range configs {
+		if err := d.decryptConnection(configs[i].ID, &configs[i].Connection); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return configs, errors.Join(errs...)
+}
+
+// loadStockConfigs читает конфигурации стоков из БД без расшифровки секретов
+func (d *DatabaseService) loadStockConfigs() ([]models.StockConfig, error) {
 	rows, err := d.db.Query(`
 		SELECT id, name, type, supported_types, upload_method, connection_config, 
 		       prompts, settings, module_path, active, created_at, updated_at
@@ -426,8 +1336,9 @@ func (d *DatabaseService) GetAllStockConfigs() ([]models.StockConfig, error) {
 
 // GetActiveStockConfigs возвращает активные конфигурации для указанного типа
 func (d *DatabaseService) GetActiveStockConfigs(photoType string) ([]models.StockConfig, error) {
+	// Стоки с нерасшифрованными секретами тоже возвращаются: их загрузка завершится ошибкой в GetStockConfig
 	allConfigs, err := d.GetAllStockConfigs()
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrSecretDecrypt) {
 		return nil, err
 	}
 
@@ -468,24 +1379,40 @@ func (d *DatabaseService) DeleteStockConfig(stockID string) error {
 
 	log.Printf("Found stock config, proceeding with deletion...")
 
-	result, err := d.db.Exec("DELETE FROM stock_configs WHERE id = ?", stockID)
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("DELETE FROM stock_configs WHERE id = ?", stockID)
 	if err != nil {
 		log.Printf("Error deleting stock config: %v", err)
 		return fmt.Errorf("failed to delete stock config: %w", err)
 	}
 
+	// Незавершенные задачи удаленного стока никто не заберет: пулы создаются только для существующих стоков.
+	// Загруженные и dead-letter задачи остаются в истории батча.
+	if _, err := tx.Exec("DELETE FROM upload_jobs WHERE stock_id = ? AND state IN ('pending', 'failed')", stockID); err != nil {
+		return fmt.Errorf("failed to delete upload jobs of stock: %w", err)
+	}
+
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		log.Printf("Error getting rows affected: %v", err)
 		return err
 	}
 
-	log.Printf("Successfully deleted stock config. Rows affected: %d", rowsAffected)
-
 	if rowsAffected == 0 {
 		return fmt.Errorf("no stock config was deleted (ID: %s)", stockID)
 	}
 
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit stock config deletion: %w", err)
+	}
+
+	log.Printf("Successfully deleted stock config. Rows affected: %d", rowsAffected)
+
 	return nil
 }
 
@@ -496,12 +1423,15 @@ func (d *DatabaseService) GetSettings() (models.AppSettings, error) {
 
 	err := d.db.QueryRow(`
 		SELECT id, temp_directory, ai_provider, ai_model, ai_api_key, ai_base_url,
-		       max_concurrent_jobs, ai_timeout, ai_max_tokens, thumbnail_size, language, ai_prompts, updated_at
+		       max_concurrent_jobs, ai_timeout, ai_max_tokens, upload_max_attempts, upload_retry_delay,
+		       write_originals, metadata_backend, series_best_only, per_stock_metadata, ai_batch_budget, ai_monthly_budget,
+		       thumbnail_size, language, ai_prompts, updated_at
 		FROM app_settings WHERE id = 'main'`).Scan(
 		&settings.ID, &settings.TempDirectory, &settings.AIProvider,
 		&settings.AIModel, &settings.AIAPIKey, &settings.AIBaseURL,
-		&settings.MaxConcurrentJobs, &settings.AITimeout, &settings.AIMaxTokens, &settings.ThumbnailSize, &settings.Language,
-		&promptsJSON, &settings.UpdatedAt)
+		&settings.MaxConcurrentJobs, &settings.AITimeout, &settings.AIMaxTokens,
+		&settings.UploadMaxAttempts, &settings.UploadRetryDelay, &settings.WriteOriginals, &settings.MetadataBackend,
+		&settings.SeriesBestOnly, &settings.PerStockMetadata, &settings.AIBatchBudget, &settings.AIMonthlyBudget, &settings.ThumbnailSize, &settings.Language, &promptsJSON, &settings.UpdatedAt)
 
 	if err != nil {
 		return settings, err
@@ -511,29 +1441,52 @@ func (d *DatabaseService) GetSettings() (models.AppSettings, error) {
 		json.Unmarshal([]byte(promptsJSON), &settings.AIPrompts)
 	}
 
-	return settings, nil
+	// При ошибке расшифровки настройки возвращаются вместе с ошибкой, API ключ остается зашифрованным
+	settings.AIAPIKey, err = d.decryptSecret("app_settings.ai_api_key", settings.AIAPIKey)
+
+	return settings, err
 }
 
 // SaveSettings сохраняет настройки приложения
 func (d *DatabaseService) SaveSettings(settings models.AppSettings) error {
 	promptsJSON, _ := json.Marshal(settings.AIPrompts)
 
-	_, err := d.db.Exec(`
+	apiKey, err := d.encryptSecret(settings.AIAPIKey)
+	if err != nil {
+		return err
+	}
+	settings.AIAPIKey = apiKey
+
+	_, err = d.db.Exec(`
 		INSERT OR REPLACE INTO app_settings 
 		(id, temp_directory, ai_provider, ai_model, ai_api_key, ai_base_url,
-		 max_concurrent_jobs, ai_timeout, ai_max_tokens, thumbnail_size, language, ai_prompts, updated_at)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		 max_concurrent_jobs, ai_timeout, ai_max_tokens, upload_max_attempts, upload_retry_delay,
+		 write_originals, metadata_backend, series_best_only, per_stock_metadata, ai_batch_budget, ai_monthly_budget,
+		 thumbnail_size, language, ai_prompts, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		"main", settings.TempDirectory, settings.AIProvider, settings.AIModel,
 		settings.AIAPIKey, settings.AIBaseURL, settings.MaxConcurrentJobs,
-		settings.AITimeout, settings.AIMaxTokens, settings.ThumbnailSize, settings.Language, string(promptsJSON), time.Now())
+		settings.AITimeout, settings.AIMaxTokens, settings.UploadMaxAttempts, settings.UploadRetryDelay,
+		settings.WriteOriginals, settings.MetadataBackend, settings.SeriesBestOnly, settings.PerStockMetadata,
+		settings.AIBatchBudget, settings.AIMonthlyBudget, settings.ThumbnailSize, settings.Language,
+		string(promptsJSON), time.Now())
 
 	return err
 }
 
+// TempDirectory возвращает временную папку из настроек, "./temp" если она не задана
+func (d *DatabaseService) TempDirectory() string {
+	if settings, err := d.GetSettings(); (err == nil || errors.Is(err, ErrSecretDecrypt)) && settings.TempDirectory != "" {
+		return settings.TempDirectory
+	}
+	return "./temp"
+}
+
 // UpdateAIPrompt обновляет промпт для определенного типа фото
 func (d *DatabaseService) UpdateAIPrompt(photoType string, prompt string) error {
+	// Нерасшифрованный API ключ сохраняется обратно без изменений
 	settings, err := d.GetSettings()
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrSecretDecrypt) {
 		return err
 	}
 
@@ -630,6 +1583,9 @@ func (d *DatabaseService) createDefaultSettings() error {
 			MaxConcurrentJobs: 3,
 			AITimeout:         90,
 			AIMaxTokens:       2000,
+			UploadMaxAttempts: 5,
+			UploadRetryDelay:  30,
+			MetadataBackend:   MetadataBackendNative,
 			ThumbnailSize:     512,
 			Language:          "en",
 			AIPrompts:         defaultPrompts,
@@ -641,7 +1597,7 @@ func (d *DatabaseService) createDefaultSettings() error {
 
 	// Если настройки существуют, проверяем наличие промптов
 	settings, err := d.GetSettings()
-	if err != nil {
+	if err != nil && !errors.Is(err, ErrSecretDecrypt) {
 		return err
 	}
 
@@ -734,10 +1690,13 @@ func (d *DatabaseService) createDefaultSettings() error {
 func (d *DatabaseService) updateDefaultPrompts() error {
 	// Получаем текущие настройки
 	settings, err := d.GetSettings()
-	if err != nil {
+	if err == sql.ErrNoRows {
 		// Если настройки не существуют, создаем их
 		return d.createDefaultSettings()
 	}
+	if err != nil && !errors.Is(err, ErrSecretDecrypt) {
+		return err
+	}
 
 	// Если промпты уже есть, не перезаписываем их
 	if settings.AIPrompts != nil && len(settings.AIPrompts) > 0 {
@@ -810,37 +1769,177 @@ func (d *DatabaseService) updateDefaultPrompts() error {
 4. КАТЕГОРИЯ (выбери одну из для Commercial):
    Business, Lifestyle, Nature, Technology, People, Family, Food & Drink, Fashion, Travel, Health & Wellness, Education, Sport & Fitness, Animals, Architecture, Music, Art & Design, Objects, Concepts, Beauty, Shopping, Transportation, Home & Garden
 
-ИЗБЕГАЙ: конкретные имена людей/компаний, бренды, конкретные места, даты
+ИЗБЕГАЙ: конкретные имена людей/компаний, бренды, конкретные места, даты
+
+ВАЖНО: Все метаданные должны быть НА АНГЛИЙСКОМ ЯЗЫКЕ. Title, description и keywords - только английский!
+ФОРМАТ JSON: {"title": "...", "description": "...", "keywords": ["...", "..."], "category": "..."}`,
+	}
+
+	settings.AIPrompts = defaultPrompts
+	return d.SaveSettings(settings)
+}
+
+// applyMigrations применяет миграции
+func (d *DatabaseService) applyMigrations() error {
+	// Миграция для app_settings
+	err := d.migrateAppSettings()
+	if err != nil {
+		return err
+	}
+
+	// Миграция для stock_configs
+	err = d.migrateStockConfigs()
+	if err != nil {
+		return err
+	}
+
+	// Миграция для photos
+	err = d.migratePhotos()
+	if err != nil {
+		return err
+	}
+
+	// Шифрование паролей и API ключей, сохраненных открытым текстом
+	err = d.migrateSecrets()
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// migrateSecrets шифрует секреты, записанные до появления шифрования
+func (d *DatabaseService) migrateSecrets() error {
+	if d.secrets == nil {
+		return nil
+	}
+
+	rows, err := d.db.Query("SELECT id, connection_config FROM stock_configs")
+	if err != nil {
+		return fmt.Errorf("failed to read stock configs for encryption: %w", err)
+	}
+
+	pending := make(map[string]string)
+	for rows.Next() {
+		var id, connectionJSON string
+		if err := rows.Scan(&id, &connectionJSON); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan stock config: %w", err)
+		}
+
+		var connection models.ConnectionConfig
+		if err := json.Unmarshal([]byte(connectionJSON), &connection); err != nil {
+			continue
+		}
+
+		plaintext := false
+		for _, field := range connectionSecrets(&connection) {
+			if *field != "" && !IsEncryptedSecret(*field) {
+				plaintext = true
+			}
+		}
+		if !plaintext {
+			continue
+		}
+
+		encrypted, err := d.encryptConnection(connection)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		encryptedJSON, _ := json.Marshal(encrypted)
+		pending[id] = string(encryptedJSON)
+	}
+	rows.Close()
 
-ВАЖНО: Все метаданные должны быть НА АНГЛИЙСКОМ ЯЗЫКЕ. Title, description и keywords - только английский!
-ФОРМАТ JSON: {"title": "...", "description": "...", "keywords": ["...", "..."], "category": "..."}`,
+	for id, connectionJSON := range pending {
+		_, err := d.db.Exec("UPDATE stock_configs SET connection_config = ? WHERE id = ?", connectionJSON, id)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt secrets of stock config %s: %w", id, err)
+		}
+		log.Printf("Encrypted stored credentials of stock config %s", id)
 	}
 
-	settings.AIPrompts = defaultPrompts
-	return d.SaveSettings(settings)
-}
+	var apiKey sql.NullString
+	err = d.db.QueryRow("SELECT ai_api_key FROM app_settings WHERE id = 'main'").Scan(&apiKey)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read AI API key for encryption: %w", err)
+	}
+	if apiKey.String == "" || IsEncryptedSecret(apiKey.String) {
+		return nil
+	}
 
-// applyMigrations применяет миграции
-func (d *DatabaseService) applyMigrations() error {
-	// Миграция для app_settings
-	err := d.migrateAppSettings()
+	encrypted, err := d.encryptSecret(apiKey.String)
 	if err != nil {
 		return err
 	}
+	_, err = d.db.Exec("UPDATE app_settings SET ai_api_key = ? WHERE id = 'main'", encrypted)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt AI API key: %w", err)
+	}
+	log.Println("Encrypted stored AI API key")
 
-	// Миграция для stock_configs
-	err = d.migrateStockConfigs()
+	return nil
+}
+
+// encryptSecret шифрует значение для записи в БД, если задано хранилище секретов
+func (d *DatabaseService) encryptSecret(value string) (string, error) {
+	if d.secrets == nil {
+		return value, nil
+	}
+	if value == SecretMask {
+		return "", fmt.Errorf("refusing to store secret mask instead of secret")
+	}
+
+	encrypted, err := d.secrets.Encrypt(value)
 	if err != nil {
-		return err
+		return "", fmt.Errorf("failed to encrypt secret: %w", err)
+	}
+	return encrypted, nil
+}
+
+// decryptSecret расшифровывает значение из БД. Если расшифровать не удалось (например, потерян файл ключа),
+// возвращает исходный шифротекст и ошибку ErrSecretDecrypt: при повторном сохранении шифротекст
+// записывается без изменений и секрет не теряется.
+func (d *DatabaseService) decryptSecret(name, value string) (string, error) {
+	if d.secrets == nil {
+		return value, nil
 	}
 
-	// Миграция для photos
-	err = d.migratePhotos()
+	plain, err := d.secrets.Decrypt(value)
 	if err != nil {
-		return err
+		return value, fmt.Errorf("%w: %s: %v", ErrSecretDecrypt, name, err)
 	}
+	return plain, nil
+}
 
-	return nil
+// encryptConnection возвращает копию подключения с зашифрованными секретами
+func (d *DatabaseService) encryptConnection(connection models.ConnectionConfig) (models.ConnectionConfig, error) {
+	for _, field := range connectionSecrets(&connection) {
+		encrypted, err := d.encryptSecret(*field)
+		if err != nil {
+			return connection, err
+		}
+		*field = encrypted
+	}
+	return connection, nil
+}
+
+// decryptConnection расшифровывает секреты подключения стока на месте.
+// Нерасшифрованные поля остаются зашифрованными.
+func (d *DatabaseService) decryptConnection(stockID string, connection *models.ConnectionConfig) error {
+	var errs []error
+	for _, field := range connectionSecrets(connection) {
+		plain, err := d.decryptSecret("stock "+stockID, *field)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		*field = plain
+	}
+	return errors.Join(errs...)
 }
 
 // migrateAppSettings применяет миграции для app_settings
@@ -857,6 +1956,14 @@ func (d *DatabaseService) migrateAppSettings() error {
 	hasAIPromptsField := false
 	hasAITimeoutField := false
 	hasAIMaxTokensField := false
+	hasUploadMaxAttemptsField := false
+	hasUploadRetryDelayField := false
+	hasWriteOriginalsField := false
+	hasMetadataBackendField := false
+	hasSeriesBestOnlyField := false
+	hasPerStockMetadataField := false
+	hasAIBatchBudgetField := false
+	hasAIMonthlyBudgetField := false
 	for rows.Next() {
 		var cid int
 		var name, dataType string
@@ -883,6 +1990,30 @@ func (d *DatabaseService) migrateAppSettings() error {
 		if name == "ai_max_tokens" {
 			hasAIMaxTokensField = true
 		}
+		if name == "upload_max_attempts" {
+			hasUploadMaxAttemptsField = true
+		}
+		if name == "upload_retry_delay" {
+			hasUploadRetryDelayField = true
+		}
+		if name == "write_originals" {
+			hasWriteOriginalsField = true
+		}
+		if name == "metadata_backend" {
+			hasMetadataBackendField = true
+		}
+		if name == "series_best_only" {
+			hasSeriesBestOnlyField = true
+		}
+		if name == "per_stock_metadata" {
+			hasPerStockMetadataField = true
+		}
+		if name == "ai_batch_budget" {
+			hasAIBatchBudgetField = true
+		}
+		if name == "ai_monthly_budget" {
+			hasAIMonthlyBudgetField = true
+		}
 	}
 
 	// Если поле language не существует, добавляем его
@@ -936,6 +2067,77 @@ func (d *DatabaseService) migrateAppSettings() error {
 		log.Println("Added ai_max_tokens column to app_settings table")
 	}
 
+	// Если поле upload_max_attempts не существует, добавляем его
+	if !hasUploadMaxAttemptsField {
+		_, err = d.db.Exec("ALTER TABLE app_settings ADD COLUMN upload_max_attempts INTEGER DEFAULT 5")
+		if err != nil {
+			return fmt.Errorf("failed to add upload_max_attempts column: %w", err)
+		}
+		log.Println("Added upload_max_attempts column to app_settings table")
+	}
+
+	// Если поле upload_retry_delay не существует, добавляем его
+	if !hasUploadRetryDelayField {
+		_, err = d.db.Exec("ALTER TABLE app_settings ADD COLUMN upload_retry_delay INTEGER DEFAULT 30")
+		if err != nil {
+			return fmt.Errorf("failed to add upload_retry_delay column: %w", err)
+		}
+		log.Println("Added upload_retry_delay column to app_settings table")
+	}
+
+	// Если поле write_originals не существует, добавляем его.
+	// По умолчанию исходные файлы не изменяются, метаданные пишутся только в копии для стоков.
+	if !hasWriteOriginalsField {
+		_, err = d.db.Exec("ALTER TABLE app_settings ADD COLUMN write_originals INTEGER DEFAULT 0")
+		if err != nil {
+			return fmt.Errorf("failed to add write_originals column: %w", err)
+		}
+		log.Println("Added write_originals column to app_settings table")
+	}
+
+	// Если поле metadata_backend не существует, добавляем его: метаданные пишет встроенный модуль
+	if !hasMetadataBackendField {
+		_, err = d.db.Exec("ALTER TABLE app_settings ADD COLUMN metadata_backend TEXT DEFAULT 'native'")
+		if err != nil {
+			return fmt.Errorf("failed to add metadata_backend column: %w", err)
+		}
+		log.Println("Added metadata_backend column to app_settings table")
+	}
+
+	// Если поле series_best_only не существует, добавляем его: по умолчанию AI анализирует все кадры серий
+	if !hasSeriesBestOnlyField {
+		_, err = d.db.Exec("ALTER TABLE app_settings ADD COLUMN series_best_only INTEGER DEFAULT 0")
+		if err != nil {
+			return fmt.Errorf("failed to add series_best_only column: %w", err)
+		}
+		log.Println("Added series_best_only column to app_settings table")
+	}
+
+	// Если поле per_stock_metadata не существует, добавляем его: по умолчанию у фото одни метаданные для всех стоков
+	if !hasPerStockMetadataField {
+		_, err = d.db.Exec("ALTER TABLE app_settings ADD COLUMN per_stock_metadata INTEGER DEFAULT 0")
+		if err != nil {
+			return fmt.Errorf("failed to add per_stock_metadata column: %w", err)
+		}
+		log.Println("Added per_stock_metadata column to app_settings table")
+	}
+
+	// Если полей лимитов стоимости AI не существует, добавляем их: по умолчанию без лимита
+	if !hasAIBatchBudgetField {
+		_, err = d.db.Exec("ALTER TABLE app_settings ADD COLUMN ai_batch_budget REAL DEFAULT 0")
+		if err != nil {
+			return fmt.Errorf("failed to add ai_batch_budget column: %w", err)
+		}
+		log.Println("Added ai_batch_budget column to app_settings table")
+	}
+	if !hasAIMonthlyBudgetField {
+		_, err = d.db.Exec("ALTER TABLE app_settings ADD COLUMN ai_monthly_budget REAL DEFAULT 0")
+		if err != nil {
+			return fmt.Errorf("failed to add ai_monthly_budget column: %w", err)
+		}
+		log.Println("Added ai_monthly_budget column to app_settings table")
+	}
+
 	return nil
 }
 
@@ -1018,6 +2220,16 @@ func (d *DatabaseService) migratePhotos() error {
 
 	hasContentTypeField := false
 	hasUpdatedAtField := false
+	hasMetadataVerificationField := false
+	hasMetadataOverrideField := false
+	hasRenditionsField := false
+	hasContentHashField := false
+	hasPerceptualHashField := false
+	hasDuplicateOfField := false
+	hasSeriesIDField := false
+	hasQualityScoreField := false
+	hasCapturedAtField := false
+	hasStockAIResultsField := false
 
 	for rows.Next() {
 		var cid int
@@ -1035,6 +2247,26 @@ func (d *DatabaseService) migratePhotos() error {
 			hasContentTypeField = true
 		case "updated_at":
 			hasUpdatedAtField = true
+		case "metadata_verification":
+			hasMetadataVerificationField = true
+		case "metadata_override":
+			hasMetadataOverrideField = true
+		case "renditions":
+			hasRenditionsField = true
+		case "content_hash":
+			hasContentHashField = true
+		case "perceptual_hash":
+			hasPerceptualHashField = true
+		case "duplicate_of":
+			hasDuplicateOfField = true
+		case "series_id":
+			hasSeriesIDField = true
+		case "quality_score":
+			hasQualityScoreField = true
+		case "captured_at":
+			hasCapturedAtField = true
+		case "stock_ai_results":
+			hasStockAIResultsField = true
 		}
 	}
 
@@ -1078,6 +2310,97 @@ func (d *DatabaseService) migratePhotos() error {
 		}
 	}
 
+	// Результат проверки записанных в файл метаданных (JSON) и разрешение загрузки несмотря на расхождения
+	if !hasMetadataVerificationField {
+		_, err = d.db.Exec("ALTER TABLE photos ADD COLUMN metadata_verification TEXT")
+		if err != nil {
+			return fmt.Errorf("failed to add metadata_verification column: %w", err)
+		}
+		log.Println("Added metadata_verification column to photos table")
+	}
+	if !hasMetadataOverrideField {
+		_, err = d.db.Exec("ALTER TABLE photos ADD COLUMN metadata_override INTEGER DEFAULT 0")
+		if err != nil {
+			return fmt.Errorf("failed to add metadata_override column: %w", err)
+		}
+		log.Println("Added metadata_override column to photos table")
+	}
+
+	// Файлы фото с одним именем (RAW, JPEG, XMP sidecar, релиз модели), JSON
+	if !hasRenditionsField {
+		_, err = d.db.Exec("ALTER TABLE photos ADD COLUMN renditions TEXT")
+		if err != nil {
+			return fmt.Errorf("failed to add renditions column: %w", err)
+		}
+		log.Println("Added renditions column to photos table")
+	}
+
+	// Хеши для поиска дубликатов и найденный дубликат (JSON)
+	if !hasContentHashField {
+		_, err = d.db.Exec("ALTER TABLE photos ADD COLUMN content_hash TEXT")
+		if err != nil {
+			return fmt.Errorf("failed to add content_hash column: %w", err)
+		}
+		log.Println("Added content_hash column to photos table")
+	}
+	if !hasPerceptualHashField {
+		_, err = d.db.Exec("ALTER TABLE photos ADD COLUMN perceptual_hash TEXT")
+		if err != nil {
+			return fmt.Errorf("failed to add perceptual_hash column: %w", err)
+		}
+		log.Println("Added perceptual_hash column to photos table")
+	}
+	if !hasDuplicateOfField {
+		_, err = d.db.Exec("ALTER TABLE photos ADD COLUMN duplicate_of TEXT")
+		if err != nil {
+			return fmt.Errorf("failed to add duplicate_of column: %w", err)
+		}
+		log.Println("Added duplicate_of column to photos table")
+	}
+
+	// Серия кадров, оценка качества кадра и время съемки
+	if !hasSeriesIDField {
+		_, err = d.db.Exec("ALTER TABLE photos ADD COLUMN series_id TEXT")
+		if err != nil {
+			return fmt.Errorf("failed to add series_id column: %w", err)
+		}
+		log.Println("Added series_id column to photos table")
+	}
+	if !hasQualityScoreField {
+		_, err = d.db.Exec("ALTER TABLE photos ADD COLUMN quality_score REAL")
+		if err != nil {
+			return fmt.Errorf("failed to add quality_score column: %w", err)
+		}
+		log.Println("Added quality_score column to photos table")
+	}
+	if !hasCapturedAtField {
+		_, err = d.db.Exec("ALTER TABLE photos ADD COLUMN captured_at TEXT")
+		if err != nil {
+			return fmt.Errorf("failed to add captured_at column: %w", err)
+		}
+		log.Println("Added captured_at column to photos table")
+	}
+
+	// Метаданные по промптам стоков (JSON stock_id -> AIResult)
+	if !hasStockAIResultsField {
+		_, err = d.db.Exec("ALTER TABLE photos ADD COLUMN stock_ai_results TEXT")
+		if err != nil {
+			return fmt.Errorf("failed to add stock_ai_results column: %w", err)
+		}
+		log.Println("Added stock_ai_results column to photos table")
+	}
+
+	for _, index := range []string{
+		`CREATE INDEX IF NOT EXISTS idx_photos_content_hash ON photos(content_hash)`,
+		// perceptual hash сравнивается по расстоянию Хэмминга, индекс по нему не используется
+		`DROP INDEX IF EXISTS idx_photos_perceptual_hash`,
+		`CREATE INDEX IF NOT EXISTS idx_photos_series_id ON photos(series_id)`,
+	} {
+		if _, err = d.db.Exec(index); err != nil {
+			return fmt.Errorf("failed to create photos index: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -1233,3 +2556,279 @@ func (d *DatabaseService) CleanupOldEvents(olderThanDays int) error {
 
 	return nil
 }
+
+// createDefaultModelPrices заполняет таблицу цен моделей при первом запуске.
+// Удаленные и измененные пользователем цены при следующих запусках не восстанавливаются.
+func (d *DatabaseService) createDefaultModelPrices() error {
+	var count int
+	err := d.db.QueryRow("SELECT COUNT(*) FROM ai_model_prices").Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	for _, price := range defaultModelPrices() {
+		if err := d.SaveModelPrice(price); err != nil {
+			return err
+		}
+	}
+	log.Printf("Created %d default AI model prices", len(defaultModelPrices()))
+	return nil
+}
+
+// GetModelPrices возвращает таблицу цен моделей
+func (d *DatabaseService) GetModelPrices() ([]models.AIModelPrice, error) {
+	rows, err := d.db.Query(`
+		SELECT model, COALESCE(provider, ''), input_price, output_price, updated_at
+		FROM ai_model_prices ORDER BY provider, model`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query model prices: %w", err)
+	}
+	defer rows.Close()
+
+	prices := []models.AIModelPrice{}
+	for rows.Next() {
+		var price models.AIModelPrice
+		err := rows.Scan(&price.Model, &price.Provider, &price.InputPrice, &price.OutputPrice, &price.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan model price: %w", err)
+		}
+		prices = append(prices, price)
+	}
+	return prices, nil
+}
+
+// SaveModelPrice добавляет или обновляет цену модели. Стоимость уже записанных запросов не пересчитывается.
+func (d *DatabaseService) SaveModelPrice(price models.AIModelPrice) error {
+	if price.Model == "" {
+		return fmt.Errorf("model is required")
+	}
+	if price.InputPrice < 0 || price.OutputPrice < 0 {
+		return fmt.Errorf("price of model %s cannot be negative", price.Model)
+	}
+
+	_, err := d.db.Exec(`
+		INSERT OR REPLACE INTO ai_model_prices (model, provider, input_price, output_price, updated_at)
+		VALUES (?, ?, ?, ?, datetime('now'))`,
+		price.Model, price.Provider, price.InputPrice, price.OutputPrice)
+	if err != nil {
+		return fmt.Errorf("failed to save price of model %s: %w", price.Model, err)
+	}
+	return nil
+}
+
+// DeleteModelPrice удаляет цену модели; запросы к ней будут учитываться без стоимости
+func (d *DatabaseService) DeleteModelPrice(model string) error {
+	result, err := d.db.Exec("DELETE FROM ai_model_prices WHERE model = ?", model)
+	if err != nil {
+		return fmt.Errorf("failed to delete price of model %s: %w", model, err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("price of model %s not found", model)
+	}
+	return nil
+}
+
+// GetAIRateLimits возвращает лимиты запросов к AI провайдерам
+func (d *DatabaseService) GetAIRateLimits() ([]models.AIRateLimit, error) {
+	rows, err := d.db.Query(`
+		SELECT provider, model, requests_per_minute, tokens_per_minute, updated_at
+		FROM ai_rate_limits ORDER BY provider, model`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query AI rate limits: %w", err)
+	}
+	defer rows.Close()
+
+	limits := []models.AIRateLimit{}
+	for rows.Next() {
+		var limit models.AIRateLimit
+		err := rows.Scan(&limit.Provider, &limit.Model, &limit.RequestsPerMinute, &limit.TokensPerMinute, &limit.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan AI rate limit: %w", err)
+		}
+		limits = append(limits, limit)
+	}
+	return limits, nil
+}
+
+// SaveAIRateLimit добавляет или обновляет лимит запросов к провайдеру или модели
+func (d *DatabaseService) SaveAIRateLimit(limit models.AIRateLimit) error {
+	if limit.Provider == "" {
+		return fmt.Errorf("provider is required")
+	}
+	if limit.RequestsPerMinute < 0 || limit.TokensPerMinute < 0 {
+		return fmt.Errorf("rate limit of %s %s cannot be negative", limit.Provider, limit.Model)
+	}
+
+	_, err := d.db.Exec(`
+		INSERT OR REPLACE INTO ai_rate_limits (provider, model, requests_per_minute, tokens_per_minute, updated_at)
+		VALUES (?, ?, ?, ?, datetime('now'))`,
+		limit.Provider, limit.Model, limit.RequestsPerMinute, limit.TokensPerMinute)
+	if err != nil {
+		return fmt.Errorf("failed to save rate limit of %s %s: %w", limit.Provider, limit.Model, err)
+	}
+	return nil
+}
+
+// DeleteAIRateLimit удаляет лимит запросов; пустая model - общий лимит провайдера
+func (d *DatabaseService) DeleteAIRateLimit(provider, model string) error {
+	result, err := d.db.Exec("DELETE FROM ai_rate_limits WHERE provider = ? AND model = ?", provider, model)
+	if err != nil {
+		return fmt.Errorf("failed to delete rate limit of %s %s: %w", provider, model, err)
+	}
+	if rowsAffected, _ := result.RowsAffected(); rowsAffected == 0 {
+		return fmt.Errorf("rate limit of %s %s not found", provider, model)
+	}
+	return nil
+}
+
+// findModelPrice ищет цену модели: точное совпадение ID или самое длинное совпадающее начало,
+// чтобы "gpt-4o-mini-2024-07-18" получил цену "gpt-4o-mini", а не "gpt-4o"
+func (d *DatabaseService) findModelPrice(provider, model string) (models.AIModelPrice, bool) {
+	prices, err := d.GetModelPrices()
+	if err != nil {
+		log.Printf("Warning: failed to get model prices: %v", err)
+		return models.AIModelPrice{}, false
+	}
+
+	var best models.AIModelPrice
+	found := false
+	for _, price := range prices {
+		if price.Provider != "" && price.Provider != provider {
+			continue
+		}
+		if !strings.HasPrefix(model, price.Model) {
+			continue
+		}
+		if !found || len(price.Model) > len(best.Model) {
+			best = price
+			found = true
+		}
+	}
+	return best, found
+}
+
+// RecordAIUsage сохраняет токены запроса к AI и считает его стоимость по таблице цен
+func (d *DatabaseService) RecordAIUsage(usage models.AIUsage) error {
+	if price, ok := d.findModelPrice(usage.Provider, usage.Model); ok {
+		usage.Cost = (float64(usage.PromptTokens)*price.InputPrice + float64(usage.CompletionTokens)*price.OutputPrice) / 1000000
+		usage.Priced = true
+	}
+	if usage.CreatedAt.IsZero() {
+		usage.CreatedAt = time.Now()
+	}
+
+	_, err := d.db.Exec(`
+		INSERT INTO ai_usage (id, batch_id, photo_id, provider, model, prompt_tokens, completion_tokens,
+		                      image_tokens, cost, priced, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		usage.ID, usage.BatchID, usage.PhotoID, usage.Provider, usage.Model, usage.PromptTokens,
+		usage.CompletionTokens, usage.ImageTokens, usage.Cost, usage.Priced, usage.CreatedAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record AI usage: %w", err)
+	}
+	return nil
+}
+
+// GetBatchAIUsage возвращает суммарные токены и стоимость AI запросов батча
+func (d *DatabaseService) GetBatchAIUsage(batchID string) (models.AIUsageSummary, error) {
+	summary, err := d.sumAIUsage("batch_id = ?", batchID)
+	if err != nil {
+		return summary, fmt.Errorf("failed to get AI usage of batch %s: %w", batchID, err)
+	}
+	summary.BatchID = batchID
+	return summary, nil
+}
+
+// GetMonthlyAIUsage возвращает суммарные токены и стоимость AI запросов за месяц "2006-01"
+// по локальному времени; пустая строка - текущий месяц
+func (d *DatabaseService) GetMonthlyAIUsage(month string) (models.AIUsageSummary, error) {
+	if month == "" {
+		month = time.Now().Format("2006-01")
+	}
+	if _, err := time.Parse("2006-01", month); err != nil {
+		return models.AIUsageSummary{}, fmt.Errorf("invalid month %q, expected YYYY-MM", month)
+	}
+
+	summary, err := d.sumAIUsage("strftime('%Y-%m', created_at, 'localtime') = ?", month)
+	if err != nil {
+		return summary, fmt.Errorf("failed to get AI usage for %s: %w", month, err)
+	}
+	summary.Month = month
+	return summary, nil
+}
+
+// sumAIUsage суммирует записи ai_usage по условию
+func (d *DatabaseService) sumAIUsage(where string, args ...interface{}) (models.AIUsageSummary, error) {
+	var summary models.AIUsageSummary
+	err := d.db.QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(prompt_tokens), 0), COALESCE(SUM(completion_tokens), 0),
+		       COALESCE(SUM(image_tokens), 0), COALESCE(SUM(cost), 0),
+		       COALESCE(SUM(CASE WHEN priced = 0 THEN 1 ELSE 0 END), 0)
+		FROM ai_usage WHERE `+where, args...).Scan(
+		&summary.Requests, &summary.PromptTokens, &summary.CompletionTokens,
+		&summary.ImageTokens, &summary.Cost, &summary.UnpricedRequests)
+	return summary, err
+}
+
+// GetCachedAIResult возвращает результат AI из кеша и отмечает его использование; nil - в кеше нет
+func (d *DatabaseService) GetCachedAIResult(key string) (*models.AIResult, error) {
+	var resultJSON string
+	err := d.db.QueryRow("SELECT result FROM ai_cache WHERE key = ?", key).Scan(&resultJSON)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query AI cache: %w", err)
+	}
+
+	var result models.AIResult
+	if err := json.Unmarshal([]byte(resultJSON), &result); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal cached AI result: %w", err)
+	}
+
+	_, err = d.db.Exec(`
+		UPDATE ai_cache SET hits = hits + 1, last_used_at = datetime('now') WHERE key = ?`, key)
+	if err != nil {
+		log.Printf("Warning: failed to update AI cache hit: %v", err)
+	}
+	return &result, nil
+}
+
+// SaveCachedAIResult сохраняет результат AI в кеш, заменяя прежний результат того же запроса
+func (d *DatabaseService) SaveCachedAIResult(key, provider, model string, result models.AIResult) error {
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		return fmt.Errorf("failed to marshal AI result: %w", err)
+	}
+
+	_, err = d.db.Exec(`
+		INSERT OR REPLACE INTO ai_cache (key, provider, model, result, hits, created_at, last_used_at)
+		VALUES (?, ?, ?, ?, 0, datetime('now'), datetime('now'))`,
+		key, provider, model, string(resultJSON))
+	if err != nil {
+		return fmt.Errorf("failed to save AI cache: %w", err)
+	}
+	return nil
+}
+
+// PruneAICache удаляет из кеша AI результаты, не использованные дольше указанного количества дней;
+// 0 - очистить кеш полностью. Возвращает количество удаленных записей.
+func (d *DatabaseService) PruneAICache(olderThanDays int) (int, error) {
+	if olderThanDays < 0 {
+		return 0, fmt.Errorf("days must not be negative")
+	}
+
+	result, err := d.db.Exec(`
+		DELETE FROM ai_cache 
+		WHERE last_used_at <= datetime('now', '-' || ? || ' days')`,
+		olderThanDays)
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune AI cache: %w", err)
+	}
+
+	deleted, _ := result.RowsAffected()
+	return int(deleted), nil
+}
@@ -3,22 +3,32 @@ package services
 import (
 	"encoding/base64"
 	"fmt"
+	"image"
+	"io"
 	"io/fs"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"stock-photo-app/models"
+	"stock-photo-app/uploaders"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/disintegration/imaging"
 	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
 )
 
 type ImageProcessor struct {
 	tempDir string
 	logger  *Logger
+	// metadataBackend способ записи метаданных (MetadataBackendNative или MetadataBackendExifTool)
+	metadataBackend string
+	// hashCache хеши файлов по пути, размеру и времени изменения (см. ComputePhotoHashes)
+	hashCache map[string]photoHashes
+	mu        sync.RWMutex
 }
 
 func NewImageProcessor(tempDir string) *ImageProcessor {
@@ -49,16 +59,12 @@ func NewImageProcessorWithLogger(tempDir string, logger *Logger) *ImageProcessor
 	}
 }
 
-// ScanFolder сканирует папку и возвращает список изображений
+// ScanFolder сканирует папку и возвращает список фото. Файлы с одним именем в одной папке
+// (IMG_0001.CR3, IMG_0001.JPG, IMG_0001.xmp, IMG_0001.pdf) объединяются в одно фото со списком файлов,
+// чтобы анализировать кадр один раз.
 func (p *ImageProcessor) ScanFolder(folderPath string) ([]models.Photo, error) {
-	var photos []models.Photo
-	supportedExts := map[string]bool{
-		".jpg":  true,
-		".jpeg": true,
-		".png":  true,
-		".tiff": true,
-		".tif":  true,
-	}
+	groups := make(map[string][]models.PhotoRendition)
+	var keys []string
 
 	err := filepath.WalkDir(folderPath, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
@@ -69,8 +75,8 @@ func (p *ImageProcessor) ScanFolder(folderPath string) ([]models.Photo, error) {
 			return nil
 		}
 
-		ext := strings.ToLower(filepath.Ext(path))
-		if !supportedExts[ext] {
+		kind := renditionKind(path)
+		if kind == "" {
 			return nil
 		}
 
@@ -81,16 +87,16 @@ func (p *ImageProcessor) ScanFolder(folderPath string) ([]models.Photo, error) {
 			return nil
 		}
 
-		photo := models.Photo{
-			ID:           fmt.Sprintf("photo_%d_%s", time.Now().UnixNano(), d.Name()),
-			OriginalPath: path,
-			FileName:     d.Name(),
-			FileSize:     fileInfo.Size(),
-			Status:       "pending",
-			CreatedAt:    time.Now(),
+		key := renditionGroupKey(path)
+		if _, exists := groups[key]; !exists {
+			keys = append(keys, key)
 		}
-
-		photos = append(photos, photo)
+		groups[key] = append(groups[key], models.PhotoRendition{
+			Kind:     kind,
+			Path:     path,
+			FileName: d.Name(),
+			FileSize: fileInfo.Size(),
+		})
 		return nil
 	})
 
@@ -98,6 +104,28 @@ func (p *ImageProcessor) ScanFolder(folderPath string) ([]models.Photo, error) {
 		return nil, fmt.Errorf("failed to scan folder: %w", err)
 	}
 
+	var photos []models.Photo
+	for _, key := range keys {
+		renditions := groups[key]
+		primary, ok := primaryRendition(renditions)
+		if !ok {
+			log.Printf("Skipping %s: no image files with this name", renditions[0].Path)
+			continue
+		}
+
+		photo := models.Photo{
+			ID:           fmt.Sprintf("photo_%d_%s", time.Now().UnixNano(), primary.FileName),
+			OriginalPath: primary.Path,
+			FileName:     primary.FileName,
+			FileSize:     primary.FileSize,
+			Status:       "pending",
+			CreatedAt:    time.Now(),
+			Renditions:   renditions,
+		}
+
+		photos = append(photos, photo)
+	}
+
 	log.Printf("Found %d images in folder %s", len(photos), folderPath)
 	return photos, nil
 }
@@ -125,7 +153,7 @@ func (p *ImageProcessor) ScanFolderFiles(folderPath string) ([]models.PhotoFile,
 		}
 
 		ext := strings.ToLower(filepath.Ext(path))
-		isValid := supportedExts[ext]
+		isValid := supportedExts[ext] || IsRawFile(path)
 
 		// Получаем информацию о файле
 		fileInfo, err := d.Info()
@@ -165,10 +193,16 @@ func countValidFiles(files []models.PhotoFile) int {
 	return count
 }
 
-// CreateThumbnail создает миниатюру изображения
+// CreateThumbnail создает миниатюру изображения. Для RAW миниатюра строится из встроенного JPEG превью.
 func (p *ImageProcessor) CreateThumbnail(originalPath string, maxSize int) (string, error) {
 	// Открываем оригинальное изображение
-	src, err := imaging.Open(originalPath)
+	var src image.Image
+	var err error
+	if IsRawFile(originalPath) {
+		src, err = openRawPreview(originalPath)
+	} else {
+		src, err = imaging.Open(originalPath)
+	}
 	if err != nil {
 		return "", fmt.Errorf("failed to open image: %w", err)
 	}
@@ -176,8 +210,12 @@ func (p *ImageProcessor) CreateThumbnail(originalPath string, maxSize int) (stri
 	// Создаем миниатюру с сохранением пропорций
 	thumbnail := imaging.Resize(src, maxSize, 0, imaging.Lanczos)
 
-	// Генерируем имя файла для миниатюры
+	// Генерируем имя файла для миниатюры; у RAW в имя входит расширение,
+	// чтобы миниатюры IMG_0001.CR3 и IMG_0001.JPG не совпали
 	baseName := strings.TrimSuffix(filepath.Base(originalPath), filepath.Ext(originalPath))
+	if IsRawFile(originalPath) {
+		baseName += "_" + strings.ToLower(strings.TrimPrefix(filepath.Ext(originalPath), "."))
+	}
 	thumbnailName := fmt.Sprintf("thumb_%s_%d.jpg", baseName, maxSize)
 	thumbnailPath := filepath.Join(p.tempDir, thumbnailName)
 
@@ -191,19 +229,43 @@ func (p *ImageProcessor) CreateThumbnail(originalPath string, maxSize int) (stri
 	return thumbnailPath, nil
 }
 
-// ExtractExifData извлекает EXIF данные из изображения
+// ExtractExifData извлекает EXIF данные из изображения, в том числе из контейнера RAW
 func (p *ImageProcessor) ExtractExifData(imagePath string) (map[string]string, error) {
-	file, err := os.Open(imagePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open image file: %w", err)
+	var decoded []*exif.Exif
+	if IsRawFile(imagePath) {
+		var err error
+		decoded, err = decodeRawExif(imagePath)
+		if err != nil {
+			log.Printf("No EXIF data found in %s: %v", imagePath, err)
+			return make(map[string]string), nil
+		}
+	} else {
+		file, err := os.Open(imagePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open image file: %w", err)
+		}
+		defer file.Close()
+
+		exifData, err := exif.Decode(file)
+		if err != nil {
+			// Если EXIF данных нет, возвращаем пустую карту вместо ошибки
+			log.Printf("No EXIF data found in %s: %v", imagePath, err)
+			return make(map[string]string), nil
+		}
+		decoded = []*exif.Exif{exifData}
 	}
-	defer file.Close()
 
-	exifData, err := exif.Decode(file)
-	if err != nil {
-		// Если EXIF данных нет, возвращаем пустую карту вместо ошибки
-		log.Printf("No EXIF data found in %s: %v", imagePath, err)
-		return make(map[string]string), nil
+	// В CR3 теги разнесены по нескольким блокам EXIF, берем первое найденное значение
+	getTag := func(fieldName exif.FieldName) (*tiff.Tag, error) {
+		var lastErr error
+		for _, exifData := range decoded {
+			tag, err := exifData.Get(fieldName)
+			if err == nil {
+				return tag, nil
+			}
+			lastErr = err
+		}
+		return nil, lastErr
 	}
 
 	result := make(map[string]string)
@@ -229,7 +291,7 @@ func (p *ImageProcessor) ExtractExifData(imagePath string) (map[string]string, e
 	}
 
 	for fieldName, key := range tags {
-		if tag, err := exifData.Get(fieldName); err == nil {
+		if tag, err := getTag(fieldName); err == nil {
 			result[key] = strings.Trim(tag.String(), "\"")
 		}
 	}
@@ -251,10 +313,10 @@ func (p *ImageProcessor) ExtractExifData(imagePath string) (map[string]string, e
 	}
 
 	// Добавляем размеры изображения
-	if tag, err := exifData.Get(exif.PixelXDimension); err == nil {
+	if tag, err := getTag(exif.PixelXDimension); err == nil {
 		result["Width"] = tag.String()
 	}
-	if tag, err := exifData.Get(exif.PixelYDimension); err == nil {
+	if tag, err := getTag(exif.PixelYDimension); err == nil {
 		result["Height"] = tag.String()
 	}
 
@@ -297,16 +359,44 @@ func (p *ImageProcessor) ProcessPhotoForAI(photo *models.Photo, thumbnailSize in
 	}
 	photo.ExifData = exifData
 
+	// Хеши для поиска дубликатов; без них фото обрабатывается как обычно
+	if err := p.ComputePhotoHashes(photo); err != nil {
+		log.Printf("Warning: failed to compute hashes for %s: %v", photo.OriginalPath, err)
+	}
+
 	return nil
 }
 
-// WriteExifToImage записывает AI метаданные в EXIF изображения
+// SetMetadataBackend выбирает способ записи метаданных из настроек. Пустое значение - встроенный модуль.
+func (p *ImageProcessor) SetMetadataBackend(backend string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metadataBackend = backend
+}
+
+// WriteExifToImage записывает AI метаданные в XMP/IPTC изображения.
+// По умолчанию используется встроенный модуль; exiftool - только если он выбран в настройках и установлен.
 func (p *ImageProcessor) WriteExifToImage(imagePath string, aiResult models.AIResult) error {
 	log.Printf("Writing EXIF data to %s: title='%s', description='%s', keywords=%v, category='%s', quality=%d",
 		imagePath, aiResult.Title, aiResult.Description, aiResult.Keywords, aiResult.Category, aiResult.Quality)
 
-	// Используем exiftool для записи EXIF метаданных
-	return p.writeExifWithTool(imagePath, aiResult)
+	p.mu.RLock()
+	backend := p.metadataBackend
+	p.mu.RUnlock()
+
+	// RAW не изменяется ни одним из способов: метаданные идут в XMP sidecar встроенным модулем
+	if backend == MetadataBackendExifTool && !IsRawFile(imagePath) {
+		if p.findExifTool() != "" {
+			return p.writeExifWithTool(imagePath, aiResult)
+		}
+		log.Printf("Warning: exiftool not found, using built-in metadata writer")
+	}
+
+	if err := WriteEmbeddedMetadata(imagePath, aiResult); err != nil {
+		return fmt.Errorf("failed to write metadata: %w", err)
+	}
+	log.Printf("Successfully wrote XMP/IPTC metadata to %s", imagePath)
+	return nil
 }
 
 // writeExifWithTool записывает EXIF данные используя внешний exiftool
@@ -314,8 +404,7 @@ func (p *ImageProcessor) writeExifWithTool(imagePath string, aiResult models.AIR
 	// Ищем exiftool
 	exifToolPath := p.findExifTool()
 	if exifToolPath == "" {
-		log.Printf("Warning: exiftool not found, skipping EXIF writing. Install with: brew install exiftool (macOS) or apt-get install libimage-exiftool-perl (Ubuntu)")
-		return nil // Не считаем это критической ошибкой
+		return fmt.Errorf("exiftool not found. Install with: brew install exiftool (macOS) or apt-get install libimage-exiftool-perl (Ubuntu)")
 	}
 
 	// Сначала очищаем ВСЕ связанные метаданные в отдельном вызове
@@ -433,7 +522,52 @@ func (p *ImageProcessor) writeExifWithTool(imagePath string, aiResult models.AIR
 	return nil
 }
 
-// CleanupTempFiles очищает временные файлы старше указанного времени
+// Подпапка временной папки с копиями фото, подготовленными для загрузки на стоки
+const stagingDirName = "staging"
+
+// StagePhoto копирует исходный файл фото в папку подготовки стока
+// (<temp>/staging/<stockID>/<photoID>/<имя файла>) и возвращает путь к копии.
+// Имя файла сохраняется, чтобы на сток файл попал под тем же именем.
+func (p *ImageProcessor) StagePhoto(photo models.Photo, stockID string) (string, error) {
+	stagedDir := filepath.Join(p.tempDir, stagingDirName, stockID, photo.ID)
+	if err := os.MkdirAll(stagedDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create staging directory: %w", err)
+	}
+
+	stagedPath := filepath.Join(stagedDir, filepath.Base(photo.OriginalPath))
+	if err := copyFile(photo.OriginalPath, stagedPath); err != nil {
+		return "", fmt.Errorf("failed to stage %s: %w", photo.FileName, err)
+	}
+
+	// Существующий XMP sidecar RAW копируется вместе с ним, чтобы сохранить его свойства
+	if IsRawFile(photo.OriginalPath) {
+		sidecarPath := uploaders.RenditionPath(photo, uploaders.RenditionXMP)
+		if sidecarPath == "" {
+			sidecarPath = XMPSidecarPath(photo.OriginalPath)
+		}
+		if _, err := os.Stat(sidecarPath); err == nil {
+			if err := copyFile(sidecarPath, XMPSidecarPath(stagedPath)); err != nil {
+				return "", fmt.Errorf("failed to stage XMP sidecar of %s: %w", photo.FileName, err)
+			}
+		}
+	}
+
+	return stagedPath, nil
+}
+
+// CleanupStagedPhoto удаляет копию фото, подготовленную для стока
+func (p *ImageProcessor) CleanupStagedPhoto(photoID, stockID string) error {
+	stockDir := filepath.Join(p.tempDir, stagingDirName, stockID)
+	if err := os.RemoveAll(filepath.Join(stockDir, photoID)); err != nil {
+		return fmt.Errorf("failed to remove staged photo: %w", err)
+	}
+	// Папка стока больше не нужна, если в ней не осталось копий; ошибка означает, что она не пуста
+	os.Remove(stockDir)
+	return nil
+}
+
+// CleanupTempFiles очищает временные файлы старше указанного времени,
+// включая копии фото в папке подготовки, оставшиеся от неудачных загрузок
 func (p *ImageProcessor) CleanupTempFiles(olderThan time.Duration) error {
 	entries, err := os.ReadDir(p.tempDir)
 	if err != nil {
@@ -463,10 +597,60 @@ func (p *ImageProcessor) CleanupTempFiles(olderThan time.Duration) error {
 		}
 	}
 
+	// Папка подготовки: <stockID>/<photoID>/<файл>
+	stagingDir := filepath.Join(p.tempDir, stagingDirName)
+	var emptyDirs []string
+	filepath.WalkDir(stagingDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if entry.IsDir() {
+			if path != stagingDir {
+				emptyDirs = append(emptyDirs, path)
+			}
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil || now.Sub(info.ModTime()) <= olderThan {
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			log.Printf("Failed to delete staged file %s: %v", path, err)
+		} else {
+			deletedCount++
+		}
+		return nil
+	})
+	// Удаляем опустевшие папки, начиная с вложенных; непустые os.Remove не трогает
+	for i := len(emptyDirs) - 1; i >= 0; i-- {
+		os.Remove(emptyDirs[i])
+	}
+
 	log.Printf("Cleaned up %d temporary files", deletedCount)
 	return nil
 }
 
+// copyFile копирует файл. Время изменения у копии текущее, поэтому CleanupTempFiles
+// считает ее возраст от момента подготовки, а не от даты съемки.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
 // CheckExifToolAvailable проверяет, доступен ли exiftool
 func (p *ImageProcessor) CheckExifToolAvailable() bool {
 	return p.findExifTool() != ""
@@ -496,20 +680,12 @@ func (p *ImageProcessor) findExifTool() string {
 	return ""
 }
 
-// VerifyExifData проверяет, что EXIF метаданные записались корректно
-func (p *ImageProcessor) VerifyExifData(imagePath string, expectedResult models.AIResult) error {
-	exifToolPath := p.findExifTool()
-	if exifToolPath == "" {
-		return fmt.Errorf("exiftool not available")
-	}
-
-	// Читаем EXIF данные с помощью exiftool
-	cmd := exec.Command(exifToolPath, "-j", "-Title", "-Description", "-Keywords", "-XMP:Title", "-XMP:Description", "-XMP:Keywords", imagePath)
-	output, err := cmd.Output()
-	if err != nil {
-		return fmt.Errorf("failed to read EXIF data: %w", err)
+// VerifyExifData читает метаданные из файла и сравнивает их с ожидаемыми (см. VerifyEmbeddedMetadata).
+// Проверка не зависит от способа записи: встроенный модуль и exiftool пишут одни и те же поля XMP и IPTC.
+func (p *ImageProcessor) VerifyExifData(imagePath string, expectedResult models.AIResult) models.MetadataVerification {
+	verification := VerifyEmbeddedMetadata(imagePath, expectedResult)
+	if verification.Status == metadataVerificationOK {
+		log.Printf("Metadata verified for %s", imagePath)
 	}
-
-	log.Printf("EXIF verification for %s: %s", imagePath, strings.TrimSpace(string(output)))
-	return nil
+	return verification
 }
@@ -0,0 +1,316 @@
+package services
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"stock-photo-app/models"
+	"strings"
+	"unicode/utf8"
+)
+
+// Ресурсы Photoshop (APP13 в JPEG), в которых хранится IPTC
+const (
+	irbIPTC       = 0x0404 // данные IPTC IIM
+	irbIPTCDigest = 0x0425 // MD5 данных IPTC, по нему читатели определяют устаревший IPTC
+)
+
+// Наборы данных IPTC IIM (запись:номер), которые записывает приложение
+const (
+	iptcRecordEnvelope    = 1
+	iptcRecordApplication = 2
+
+	iptcCodedCharacterSet    = 90  // 1:90
+	iptcRecordVersion        = 0   // 2:00
+	iptcObjectName           = 5   // 2:05 Title
+	iptcCategory             = 15  // 2:15, не длиннее 3 байт
+	iptcSupplementalCategory = 20  // 2:20
+	iptcKeywords             = 25  // 2:25, по набору на ключевое слово
+	iptcCaptionAbstract      = 120 // 2:120 Description
+)
+
+// Ограничения длины полей IPTC IIM в байтах
+const (
+	iptcObjectNameLimit           = 64
+	iptcCategoryLimit             = 3
+	iptcSupplementalCategoryLimit = 32
+	iptcKeywordLimit              = 64
+	iptcCaptionAbstractLimit      = 2000
+)
+
+// Значение 1:90 для UTF-8 (ESC % G)
+var iptcUTF8Marker = []byte{0x1B, 0x25, 0x47}
+
+// iptcDataset набор данных IPTC IIM
+type iptcDataset struct {
+	record  byte
+	dataset byte
+	value   []byte
+}
+
+// parseIPTC разбирает поток наборов данных IPTC IIM. Разбор останавливается на первом байте,
+// не являющемся маркером 0x1C: так заканчиваются данные, выровненные нулями.
+func parseIPTC(data []byte) []iptcDataset {
+	var result []iptcDataset
+	for i := 0; i+5 <= len(data) && data[i] == 0x1C; {
+		record, dataset := data[i+1], data[i+2]
+		size := int(binary.BigEndian.Uint16(data[i+3 : i+5]))
+		i += 5
+
+		// Расширенный набор: в size длина поля длины
+		if size&0x8000 != 0 {
+			lengthSize := size & 0x7FFF
+			if lengthSize > 4 || i+lengthSize > len(data) {
+				break
+			}
+			size = 0
+			for _, b := range data[i : i+lengthSize] {
+				size = size<<8 | int(b)
+			}
+			i += lengthSize
+		}
+		if size < 0 || i+size > len(data) {
+			break
+		}
+
+		result = append(result, iptcDataset{record: record, dataset: dataset, value: data[i : i+size]})
+		i += size
+	}
+	return result
+}
+
+// encodeIPTC записывает наборы данных IPTC IIM
+func encodeIPTC(datasets []iptcDataset) []byte {
+	var buf bytes.Buffer
+	for _, ds := range datasets {
+		buf.Write([]byte{0x1C, ds.record, ds.dataset})
+		if len(ds.value) < 0x8000 {
+			binary.Write(&buf, binary.BigEndian, uint16(len(ds.value)))
+		} else {
+			buf.Write([]byte{0x80, 0x04})
+			binary.Write(&buf, binary.BigEndian, uint32(len(ds.value)))
+		}
+		buf.Write(ds.value)
+	}
+	return buf.Bytes()
+}
+
+// buildIPTC заменяет в существующих наборах данных название, описание, ключевые слова и категорию.
+// Остальные наборы сохраняются; тексты в Latin-1 переводятся в UTF-8, так как набор объявляется в UTF-8.
+func buildIPTC(existing []iptcDataset, aiResult models.AIResult) []iptcDataset {
+	managed := func(ds iptcDataset) bool {
+		if ds.record == iptcRecordEnvelope {
+			return ds.dataset == iptcCodedCharacterSet
+		}
+		if ds.record != iptcRecordApplication {
+			return false
+		}
+		switch ds.dataset {
+		case iptcRecordVersion, iptcObjectName, iptcCategory, iptcSupplementalCategory, iptcKeywords, iptcCaptionAbstract:
+			return true
+		}
+		return false
+	}
+	wasUTF8 := iptcIsUTF8(existing)
+
+	datasets := []iptcDataset{
+		{record: iptcRecordEnvelope, dataset: iptcCodedCharacterSet, value: iptcUTF8Marker},
+		{record: iptcRecordApplication, dataset: iptcRecordVersion, value: []byte{0x00, 0x04}},
+	}
+	add := func(dataset byte, value string, limit int) {
+		value = truncateUTF8Bytes(strings.TrimSpace(value), limit)
+		if value != "" {
+			datasets = append(datasets, iptcDataset{record: iptcRecordApplication, dataset: dataset, value: []byte(value)})
+		}
+	}
+
+	add(iptcObjectName, aiResult.Title, iptcObjectNameLimit)
+	// В 2:15 помещается только код из трех символов, полное название категории идет в 2:20
+	if category := strings.TrimSpace(aiResult.Category); len(category) <= iptcCategoryLimit {
+		add(iptcCategory, category, iptcCategoryLimit)
+	} else {
+		add(iptcSupplementalCategory, category, iptcSupplementalCategoryLimit)
+	}
+	for _, keyword := range aiResult.Keywords {
+		add(iptcKeywords, keyword, iptcKeywordLimit)
+	}
+	add(iptcCaptionAbstract, aiResult.Description, iptcCaptionAbstractLimit)
+
+	for _, ds := range existing {
+		if managed(ds) {
+			continue
+		}
+		if ds.record == iptcRecordApplication && !wasUTF8 && !utf8.Valid(ds.value) {
+			ds.value = latin1ToUTF8(ds.value)
+		}
+		datasets = append(datasets, ds)
+	}
+
+	// Наборы должны идти по возрастанию номера записи, внутри записи - по номеру набора
+	sort.SliceStable(datasets, func(i, j int) bool {
+		if datasets[i].record != datasets[j].record {
+			return datasets[i].record < datasets[j].record
+		}
+		return datasets[i].dataset < datasets[j].dataset
+	})
+	return datasets
+}
+
+// readIPTC извлекает из наборов данных поля, которые записывает приложение
+func readIPTC(datasets []iptcDataset) models.AIResult {
+	var result models.AIResult
+	isUTF8 := iptcIsUTF8(datasets)
+	text := func(value []byte) string {
+		if isUTF8 || utf8.Valid(value) {
+			return string(value)
+		}
+		return string(latin1ToUTF8(value))
+	}
+
+	for _, ds := range datasets {
+		if ds.record != iptcRecordApplication {
+			continue
+		}
+		switch ds.dataset {
+		case iptcObjectName:
+			result.Title = text(ds.value)
+		case iptcCaptionAbstract:
+			result.Description = text(ds.value)
+		case iptcKeywords:
+			result.Keywords = append(result.Keywords, text(ds.value))
+		case iptcCategory:
+			result.Category = text(ds.value)
+		case iptcSupplementalCategory:
+			if result.Category == "" {
+				result.Category = text(ds.value)
+			}
+		}
+	}
+	return result
+}
+
+func iptcIsUTF8(datasets []iptcDataset) bool {
+	for _, ds := range datasets {
+		if ds.record == iptcRecordEnvelope && ds.dataset == iptcCodedCharacterSet {
+			return bytes.Equal(ds.value, iptcUTF8Marker)
+		}
+	}
+	return false
+}
+
+// irbResource ресурс Photoshop Image Resource Block
+type irbResource struct {
+	id   uint16
+	name []byte
+	data []byte
+}
+
+// parseIRB разбирает ресурсы Photoshop (данные APP13 после "Photoshop 3.0\0")
+func parseIRB(data []byte) ([]irbResource, error) {
+	var result []irbResource
+	i := 0
+	for i+12 <= len(data) {
+		if string(data[i:i+4]) != "8BIM" {
+			return result, fmt.Errorf("unexpected Photoshop resource signature %q", data[i:i+4])
+		}
+		id := binary.BigEndian.Uint16(data[i+4 : i+6])
+		i += 6
+
+		// Имя - строка Pascal, дополненная до четной длины
+		nameLength := int(data[i])
+		if i+1+nameLength > len(data) {
+			return result, fmt.Errorf("truncated Photoshop resource name")
+		}
+		name := data[i+1 : i+1+nameLength]
+		i += 1 + nameLength
+		if (1+nameLength)%2 != 0 {
+			i++
+		}
+
+		if i+4 > len(data) {
+			return result, fmt.Errorf("truncated Photoshop resource")
+		}
+		size := int(binary.BigEndian.Uint32(data[i : i+4]))
+		i += 4
+		if size < 0 || i+size > len(data) {
+			return result, fmt.Errorf("truncated Photoshop resource data")
+		}
+		result = append(result, irbResource{id: id, name: name, data: data[i : i+size]})
+		i += size
+		if size%2 != 0 {
+			i++
+		}
+	}
+	return result, nil
+}
+
+// encodeIRB записывает ресурсы Photoshop
+func encodeIRB(resources []irbResource) []byte {
+	var buf bytes.Buffer
+	for _, resource := range resources {
+		buf.WriteString("8BIM")
+		binary.Write(&buf, binary.BigEndian, resource.id)
+		buf.WriteByte(byte(len(resource.name)))
+		buf.Write(resource.name)
+		if (1+len(resource.name))%2 != 0 {
+			buf.WriteByte(0)
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(len(resource.data)))
+		buf.Write(resource.data)
+		if len(resource.data)%2 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+	return buf.Bytes()
+}
+
+// setIRBIPTC заменяет ресурс IPTC и обновляет его MD5, если он был
+func setIRBIPTC(resources []irbResource, iptc []byte) []irbResource {
+	digest := md5.Sum(iptc)
+	found := false
+	for i := range resources {
+		switch resources[i].id {
+		case irbIPTC:
+			resources[i].data = iptc
+			found = true
+		case irbIPTCDigest:
+			resources[i].data = digest[:]
+		}
+	}
+	if !found {
+		resources = append(resources, irbResource{id: irbIPTC, data: iptc})
+	}
+	return resources
+}
+
+// irbIPTCData возвращает данные IPTC из ресурсов Photoshop
+func irbIPTCData(resources []irbResource) []byte {
+	for _, resource := range resources {
+		if resource.id == irbIPTC {
+			return resource.data
+		}
+	}
+	return nil
+}
+
+// truncateUTF8Bytes обрезает строку до limit байт, не разрезая символы
+func truncateUTF8Bytes(value string, limit int) string {
+	if len(value) <= limit {
+		return value
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(value[cut]) {
+		cut--
+	}
+	return value[:cut]
+}
+
+func latin1ToUTF8(value []byte) []byte {
+	runes := make([]rune, len(value))
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+	return []byte(string(runes))
+}
@@ -0,0 +1,458 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"stock-photo-app/models"
+	"strings"
+	"time"
+)
+
+// Адрес Ollama по умолчанию
+const defaultLocalAIURL = "http://localhost:11434"
+
+// Ollama native API структуры (/api/chat, /api/tags)
+type OllamaChatRequest struct {
+	Model    string          `json:"model"`
+	Messages []OllamaMessage `json:"messages"`
+	Stream   bool            `json:"stream"`
+	Format   *Schema         `json:"format,omitempty"`
+	Options  *OllamaOptions  `json:"options,omitempty"`
+}
+
+type OllamaMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"`
+}
+
+type OllamaOptions struct {
+	NumPredict int `json:"num_predict,omitempty"`
+}
+
+type OllamaChatResponse struct {
+	Message         OllamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"` // входные токены
+	EvalCount       int           `json:"eval_count"`        // сгенерированные токены
+	Error           string        `json:"error,omitempty"`
+}
+
+type OllamaTagsResponse struct {
+	Models []OllamaModelInfo `json:"models"`
+}
+
+type OllamaModelInfo struct {
+	Name    string `json:"name"`
+	Model   string `json:"model"`
+	Details struct {
+		Family            string   `json:"family"`
+		Families          []string `json:"families"`
+		ParameterSize     string   `json:"parameter_size"`
+		QuantizationLevel string   `json:"quantization_level"`
+	} `json:"details"`
+}
+
+// LocalChatRequest запрос к OpenAI-совместимому локальному серверу (llama.cpp, LM Studio, Ollama /v1).
+// Такие серверы обычно не поддерживают strict response_format, поэтому схема не передается.
+type LocalChatRequest struct {
+	Model     string    `json:"model"`
+	Messages  []Message `json:"messages"`
+	MaxTokens int       `json:"max_tokens,omitempty"`
+	Stream    bool      `json:"stream"`
+}
+
+// LocalProvider реализует VisionProvider для локального Ollama или OpenAI-совместимого сервера.
+// Если AIBaseURL содержит /v1, используется /v1/chat/completions, иначе нативный /api/chat Ollama.
+type LocalProvider struct {
+	service *AIService
+}
+
+// NewLocalProvider создает провайдер для локальных моделей
+func NewLocalProvider(service *AIService) *LocalProvider {
+	return &LocalProvider{service: service}
+}
+
+// Analyze анализирует изображение через локальную модель с retry логикой
+func (p *LocalProvider) Analyze(ctx context.Context, photo models.Photo, description string, prompt string, contentType string, settings models.AppSettings) (*models.AIResult, error) {
+	const maxRetries = 3
+
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		result, err := p.analyzeAttempt(ctx, photo, description, prompt, contentType, settings)
+		if err == nil {
+			return result, nil
+		}
+
+		log.Printf("Local AI analysis attempt %d/%d failed for photo %s: %v", attempt, maxRetries, photo.FileName, err)
+
+		// Если это последняя попытка, критическая ошибка или анализ отменен, возвращаем ошибку
+		if attempt == maxRetries || ctx.Err() != nil || !p.service.isRetryableError(err) {
+			return nil, err
+		}
+
+		// Пауза между попытками
+		if err := sleepContext(ctx, time.Duration(attempt)*time.Second); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("all retry attempts failed")
+}
+
+// analyzeAttempt выполняет одну попытку анализа фото через локальный сервер
+func (p *LocalProvider) analyzeAttempt(ctx context.Context, photo models.Photo, description string, prompt string, contentType string, settings models.AppSettings) (*models.AIResult, error) {
+	// Кодируем изображение в base64
+	imageProcessor := NewImageProcessor(settings.TempDirectory)
+	base64Image, err := imageProcessor.EncodeImageToBase64(photo.ThumbnailPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	// Локальные модели часто игнорируют схему, поэтому явно просим чистый JSON с теми же полями, что в photoMetadataSchema
+	fullPrompt := p.service.exifProcessor.BuildContextualPrompt(contentType, prompt, photo.ExifData, description)
+	fullPrompt += "\n\nОтветь ТОЛЬКО одним JSON объектом с полями title, description, keywords (массив строк), category, без пояснений и markdown."
+
+	if p.service.logger != nil {
+		p.service.logger.LogAIPrompt(photo.FileName, fullPrompt, description)
+		p.service.logger.LogAI("EXIF data fields count: %d", len(photo.ExifData))
+	} else {
+		log.Printf("Full contextual prompt being sent to local AI:")
+		log.Printf("==== START PROMPT ====")
+		log.Printf("%s", fullPrompt)
+		log.Printf("==== END PROMPT ====")
+		log.Printf("Photo filename: %s", photo.FileName)
+	}
+
+	model := settings.AIModel
+	if model == "" {
+		model = "llava" // fallback
+	}
+
+	maxTokens := 2000 // значение по умолчанию
+	if settings.AIMaxTokens > 0 {
+		maxTokens = settings.AIMaxTokens
+	}
+
+	var content string
+	var tokens aiTokens
+	call := newAICall(photo, "local", model, fullPrompt, 0, maxTokens)
+	if isOpenAICompatibleURL(settings.AIBaseURL) {
+		content, tokens, err = p.chatOpenAICompatible(ctx, call, maxTokens, fullPrompt, base64Image, settings)
+	} else {
+		content, tokens, err = p.chatOllama(ctx, call, maxTokens, fullPrompt, base64Image, settings)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.service.rateLimiter.settle(call, tokens)
+	p.service.recordUsage(photo, "local", model, tokens, 0)
+
+	// parseAIResponse умеет вытаскивать JSON из текста, если модель проигнорировала формат
+	result, err := p.service.parseAIResponse(content, photo.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Устанавливаем тип контента в результате
+	result.ContentType = contentType
+
+	// Валидируем длину описания в зависимости от типа контента
+	applyDescriptionLimits(result, contentType)
+
+	return result, nil
+}
+
+// chatOllama отправляет запрос в нативный Ollama /api/chat со схемой в поле format
+func (p *LocalProvider) chatOllama(ctx context.Context, call *aiCall, maxTokens int, prompt string, base64Image string, settings models.AppSettings) (string, aiTokens, error) {
+	schema := photoMetadataSchema()
+	request := OllamaChatRequest{
+		Model:  call.model,
+		Stream: false,
+		Format: &schema,
+		Options: &OllamaOptions{
+			NumPredict: maxTokens,
+		},
+		Messages: []OllamaMessage{
+			{
+				Role:    "user",
+				Content: prompt,
+				Images:  []string{base64Image},
+			},
+		},
+	}
+
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return "", aiTokens{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	apiURL := localServerRoot(settings.AIBaseURL) + "/api/chat"
+	statusCode, body, err := p.service.postWithRetry(ctx, call, apiURL, jsonData, localAuthHeaders(settings), settings)
+	if err != nil {
+		return "", aiTokens{}, err
+	}
+
+	var response OllamaChatResponse
+	if statusCode != http.StatusOK {
+		if json.Unmarshal(body, &response) == nil && response.Error != "" {
+			return "", aiTokens{}, fmt.Errorf("API request failed with status %d: %s", statusCode, response.Error)
+		}
+		return "", aiTokens{}, fmt.Errorf("API request failed with status %d: %s", statusCode, string(body))
+	}
+
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return "", aiTokens{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if response.Error != "" {
+		return "", aiTokens{}, fmt.Errorf("API error: %s", response.Error)
+	}
+
+	if response.DoneReason == "length" {
+		log.Printf("Warning: local model response was cut off by num_predict limit")
+	}
+
+	return response.Message.Content, aiTokens{prompt: response.PromptEvalCount, completion: response.EvalCount}, nil
+}
+
+// chatOpenAICompatible отправляет запрос в /v1/chat/completions без response_format
+func (p *LocalProvider) chatOpenAICompatible(ctx context.Context, call *aiCall, maxTokens int, prompt string, base64Image string, settings models.AppSettings) (string, aiTokens, error) {
+	request := LocalChatRequest{
+		Model:     call.model,
+		MaxTokens: maxTokens,
+		Stream:    false,
+		Messages: []Message{
+			{
+				Role: "user",
+				Content: []Content{
+					{
+						Type: "text",
+						Text: prompt,
+					},
+					{
+						Type: "image_url",
+						ImageURL: &ImageURL{
+							URL: fmt.Sprintf("data:image/jpeg;base64,%s", base64Image),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return "", aiTokens{}, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	apiURL := localServerRoot(settings.AIBaseURL) + "/v1/chat/completions"
+	statusCode, body, err := p.service.postWithRetry(ctx, call, apiURL, jsonData, localAuthHeaders(settings), settings)
+	if err != nil {
+		return "", aiTokens{}, err
+	}
+
+	if statusCode != http.StatusOK {
+		return "", aiTokens{}, fmt.Errorf("API request failed with status %d: %s", statusCode, string(body))
+	}
+
+	var response OpenAIResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return "", aiTokens{}, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if response.Error != nil {
+		return "", aiTokens{}, fmt.Errorf("API error: %s", response.Error.Message)
+	}
+
+	if len(response.Choices) == 0 {
+		return "", aiTokens{}, fmt.Errorf("AI returned empty response - no choices in local server reply")
+	}
+
+	return response.Choices[0].Message.Content, response.Usage.tokens(), nil
+}
+
+// TestConnection проверяет доступность локального сервера
+func (p *LocalProvider) TestConnection(settings models.AppSettings) error {
+	_, err := p.ListModels(settings)
+	return err
+}
+
+// ListModels получает список установленных моделей через /api/tags (Ollama)
+// с fallback на /v1/models для llama.cpp и других OpenAI-совместимых серверов
+func (p *LocalProvider) ListModels(settings models.AppSettings) ([]models.AIModel, error) {
+	root := localServerRoot(settings.AIBaseURL)
+
+	aiModels, err := p.fetchOllamaTags(root, settings)
+	if err == nil {
+		log.Printf("Successfully fetched %d models from local server %s", len(aiModels), root)
+		return aiModels, nil
+	}
+	log.Printf("Failed to fetch models from %s/api/tags: %v, trying /v1/models", root, err)
+
+	aiModels, fallbackErr := p.fetchOpenAICompatibleModels(root, settings)
+	if fallbackErr != nil {
+		return nil, fmt.Errorf("failed to connect to local AI server at %s: %w", root, err)
+	}
+
+	log.Printf("Successfully fetched %d models from local server %s/v1/models", len(aiModels), root)
+	return aiModels, nil
+}
+
+// fetchOllamaTags запрашивает список моделей Ollama
+func (p *LocalProvider) fetchOllamaTags(root string, settings models.AppSettings) ([]models.AIModel, error) {
+	body, err := p.getJSON(root+"/api/tags", settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var response OllamaTagsResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	var aiModels []models.AIModel
+	for _, info := range response.Models {
+		id := info.Model
+		if id == "" {
+			id = info.Name
+		}
+
+		var details []string
+		if info.Details.ParameterSize != "" {
+			details = append(details, info.Details.ParameterSize)
+		}
+		if info.Details.QuantizationLevel != "" {
+			details = append(details, info.Details.QuantizationLevel)
+		}
+		description := "Local model"
+		if len(details) > 0 {
+			description = fmt.Sprintf("Local model (%s)", strings.Join(details, ", "))
+		}
+
+		aiModels = append(aiModels, models.AIModel{
+			ID:             id,
+			Name:           info.Name,
+			Description:    description,
+			SupportsVision: isLocalVisionModel(id, info.Details.Families),
+			Provider:       "local",
+		})
+	}
+
+	return aiModels, nil
+}
+
+// fetchOpenAICompatibleModels запрашивает список моделей OpenAI-совместимого сервера
+func (p *LocalProvider) fetchOpenAICompatibleModels(root string, settings models.AppSettings) ([]models.AIModel, error) {
+	body, err := p.getJSON(root+"/v1/models", settings)
+	if err != nil {
+		return nil, err
+	}
+
+	var response struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	var aiModels []models.AIModel
+	for _, model := range response.Data {
+		aiModels = append(aiModels, models.AIModel{
+			ID:             model.ID,
+			Name:           model.ID,
+			Description:    "Local OpenAI-compatible model",
+			SupportsVision: isLocalVisionModel(model.ID, nil),
+			Provider:       "local",
+		})
+	}
+
+	return aiModels, nil
+}
+
+// getJSON выполняет GET запрос к локальному серверу и возвращает тело ответа
+func (p *LocalProvider) getJSON(apiURL string, settings models.AppSettings) ([]byte, error) {
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	for key, value := range localAuthHeaders(settings) {
+		req.Header.Set(key, value)
+	}
+
+	// Короткий таймаут: локальный сервер либо отвечает сразу, либо не запущен
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to AI API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return body, nil
+}
+
+// localAuthHeaders возвращает заголовки авторизации; ключ для локального сервера необязателен
+func localAuthHeaders(settings models.AppSettings) map[string]string {
+	headers := map[string]string{}
+	if settings.AIAPIKey != "" {
+		headers["Authorization"] = "Bearer " + settings.AIAPIKey
+	}
+	return headers
+}
+
+// localServerRoot возвращает корневой адрес сервера без путей API
+func localServerRoot(baseURL string) string {
+	root := strings.TrimSpace(baseURL)
+	if root == "" {
+		return defaultLocalAIURL
+	}
+
+	root = strings.TrimSuffix(root, "/")
+	for _, suffix := range []string{"/chat/completions", "/v1", "/api/chat", "/api"} {
+		root = strings.TrimSuffix(root, suffix)
+	}
+
+	return root
+}
+
+// isOpenAICompatibleURL определяет, что пользователь указал OpenAI-совместимый endpoint
+func isOpenAICompatibleURL(baseURL string) bool {
+	return strings.Contains(baseURL, "/v1")
+}
+
+// isLocalVisionModel проверяет по семейству и имени, умеет ли локальная модель работать с изображениями
+func isLocalVisionModel(modelID string, families []string) bool {
+	for _, family := range families {
+		if family == "clip" || family == "mllama" {
+			return true
+		}
+	}
+
+	modelLower := strings.ToLower(modelID)
+	visionMarkers := []string{"llava", "vision", "bakllava", "moondream", "minicpm-v", "qwen2.5vl", "qwen2-vl", "qwen2.5-vl", "gemma3", "llama4", "granite3.2-vision", "mistral-small3"}
+	for _, marker := range visionMarkers {
+		if strings.Contains(modelLower, marker) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,238 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"stock-photo-app/models"
+	"stock-photo-app/uploaders"
+	"sync"
+)
+
+// MetadataCSVExporter формирует CSV метаданных батча в формате агентства и загружает его на сток
+type MetadataCSVExporter struct {
+	uploaderManager *uploaders.UploaderManager
+	dbService       *DatabaseService
+	// uploaded сколько фото было в последнем загруженном CSV по ключу batchID/stockID,
+	// чтобы worker'ы, закончившие одновременно, не отправили один и тот же CSV дважды
+	uploaded map[string]int
+	// keyLocks сериализуют загрузку CSV по ключу batchID/stockID, не блокируя другие батчи и стоки на время передачи
+	keyLocks map[string]*sync.Mutex
+	mu       sync.Mutex
+}
+
+// NewMetadataCSVExporter создает экспортер CSV метаданных
+func NewMetadataCSVExporter(uploaderManager *uploaders.UploaderManager, dbService *DatabaseService) *MetadataCSVExporter {
+	return &MetadataCSVExporter{
+		uploaderManager: uploaderManager,
+		dbService:       dbService,
+		uploaded:        make(map[string]int),
+		keyLocks:        make(map[string]*sync.Mutex),
+	}
+}
+
+// ExportBatch записывает CSV метаданных батча для стока в path и возвращает число строк.
+// В CSV попадают фото, поставленные в очередь на этот сток, а если их нет - одобренные фото батча.
+// Для стоков без своего формата CSV используется формат generic.
+func (e *MetadataCSVExporter) ExportBatch(batchID, stockID, path string) (int, error) {
+	// Для экспорта в файл секреты не нужны
+	config, err := e.dbService.GetStockConfig(stockID)
+	if err != nil && !errors.Is(err, ErrSecretDecrypt) {
+		return 0, fmt.Errorf("failed to get stock config: %w", err)
+	}
+
+	format, ok := uploaders.MetadataCSVFormatFor(config)
+	if !ok {
+		format = "generic"
+	}
+
+	photos, err := e.batchPhotos(batchID, stockID, false)
+	if err != nil {
+		return 0, err
+	}
+
+	count, err := e.writeFile(path, format, config, photos)
+	if err != nil {
+		return 0, err
+	}
+
+	e.dbService.LogEvent(batchID, "", "metadata_csv", "exported",
+		fmt.Sprintf("CSV метаданных для %s (%s, %d фото) сохранен в %s", config.Name, format, count, path), "", 100)
+
+	return count, nil
+}
+
+// UploadAfterBatch загружает CSV метаданных на сток, когда по батчу для него не осталось активных задач.
+// Срабатывает только если в Settings стока включен uploadMetadataCSV и у стока есть формат CSV.
+// Отмена ctx прерывает загрузку CSV.
+func (e *MetadataCSVExporter) UploadAfterBatch(ctx context.Context, batchID string, config models.StockConfig) {
+	if !stockSettingBool(config, "uploadMetadataCSV") {
+		return
+	}
+	format, ok := uploaders.MetadataCSVFormatFor(config)
+	if !ok {
+		return
+	}
+
+	jobs, err := e.dbService.GetBatchStockUploadJobs(batchID, config.ID)
+	if err != nil {
+		log.Printf("Warning: failed to check upload jobs for metadata CSV: %v", err)
+		return
+	}
+	uploadedCount := 0
+	for _, job := range jobs {
+		switch job.State {
+		case "pending", "uploading":
+			return
+		case "uploaded":
+			uploadedCount++
+		}
+	}
+	if uploadedCount == 0 {
+		return
+	}
+
+	key := batchID + "/" + config.ID
+	keyLock := e.keyLock(key)
+	keyLock.Lock()
+	defer keyLock.Unlock()
+
+	e.mu.Lock()
+	alreadyUploaded := e.uploaded[key] == uploadedCount
+	e.mu.Unlock()
+	if alreadyUploaded {
+		return
+	}
+
+	if err := e.uploadCSV(ctx, batchID, config, format); err != nil {
+		log.Printf("Failed to upload metadata CSV for batch %s to %s: %v", batchID, config.Name, err)
+		e.dbService.LogEvent(batchID, "", "metadata_csv", "failed",
+			fmt.Sprintf("Ошибка загрузки CSV метаданных на %s", config.Name), err.Error(), 0)
+		return
+	}
+
+	e.mu.Lock()
+	e.uploaded[key] = uploadedCount
+	e.mu.Unlock()
+}
+
+// keyLock возвращает мьютекс загрузки CSV для ключа batchID/stockID
+func (e *MetadataCSVExporter) keyLock(key string) *sync.Mutex {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	lock, ok := e.keyLocks[key]
+	if !ok {
+		lock = &sync.Mutex{}
+		e.keyLocks[key] = lock
+	}
+	return lock
+}
+
+// uploadCSV формирует CSV по загруженным фото во временной папке и отправляет его загрузчиком стока
+func (e *MetadataCSVExporter) uploadCSV(ctx context.Context, batchID string, config models.StockConfig, format string) error {
+	photos, err := e.batchPhotos(batchID, config.ID, true)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(e.dbService.TempDirectory(), "metadata_csv", config.ID, fmt.Sprintf("metadata_%s.csv", batchID))
+
+	count, err := e.writeFile(path, format, config, photos)
+	if err != nil {
+		return err
+	}
+
+	result, err := e.uploaderManager.UploadMetadataFile(ctx, batchID, path, config)
+	if err != nil {
+		return err
+	}
+	if !result.Success {
+		return fmt.Errorf("%s", result.Message)
+	}
+
+	log.Printf("Metadata CSV for batch %s uploaded to %s (%d photos)", batchID, config.Name, count)
+	e.dbService.LogEvent(batchID, "", "metadata_csv", "success",
+		fmt.Sprintf("CSV метаданных (%d фото) загружен на %s", count, config.Name), "", 100)
+	return nil
+}
+
+// batchPhotos возвращает фото батча для CSV. onlyUploaded - только успешно загруженные на сток.
+func (e *MetadataCSVExporter) batchPhotos(batchID, stockID string, onlyUploaded bool) ([]models.Photo, error) {
+	photos, err := e.dbService.getPhotosForBatch(batchID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get batch photos: %w", err)
+	}
+
+	jobs, err := e.dbService.GetBatchStockUploadJobs(batchID, stockID)
+	if err != nil {
+		return nil, err
+	}
+
+	queued := make(map[string]bool, len(jobs))
+	for _, job := range jobs {
+		if !onlyUploaded || job.State == "uploaded" {
+			queued[job.PhotoID] = true
+		}
+	}
+
+	var result []models.Photo
+	for _, photo := range photos {
+		if len(jobs) > 0 || onlyUploaded {
+			if queued[photo.ID] {
+				result = append(result, photo)
+			}
+		} else if photo.Status == "approved" {
+			result = append(result, photo)
+		}
+	}
+
+	if len(result) == 0 {
+		return nil, fmt.Errorf("в батче нет фото для CSV метаданных")
+	}
+	return result, nil
+}
+
+// writeFile записывает CSV в файл, создавая папку при необходимости
+func (e *MetadataCSVExporter) writeFile(path, format string, config models.StockConfig, photos []models.Photo) (int, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, fmt.Errorf("failed to create directory for CSV: %w", err)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create CSV file: %w", err)
+	}
+	defer file.Close()
+
+	// В CSV указывается имя файла, который получает сток, например JPEG для пары RAW+JPEG
+	for i := range photos {
+		if selected, err := e.uploaderManager.SelectRendition(photos[i], config); err == nil {
+			photos[i] = selected
+		}
+	}
+
+	count, err := uploaders.WriteMetadataCSV(file, format, config, photos)
+	if err != nil {
+		return 0, err
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("нет фото с метаданными для CSV")
+	}
+
+	return count, nil
+}
+
+// stockSettingBool читает флаг из настроек стока; frontend может прислать его строкой
+func stockSettingBool(config models.StockConfig, key string) bool {
+	switch value := config.Settings[key].(type) {
+	case bool:
+		return value
+	case string:
+		return value == "true" || value == "1"
+	}
+	return false
+}
@@ -0,0 +1,236 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"stock-photo-app/models"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Статусы проверки метаданных (models.MetadataVerification.Status)
+const (
+	metadataVerificationOK          = "ok"
+	metadataVerificationWarning     = "warning"  // значения обрезаны по ограничениям IPTC, остальное совпадает
+	metadataVerificationMismatch    = "mismatch" // записанное отличается от ожидаемого
+	metadataVerificationError       = "error"    // файл не удалось прочитать
+	metadataVerificationUnsupported = "unsupported"
+)
+
+// VerifyEmbeddedMetadata читает XMP и IPTC файла по отдельности и сравнивает их с ожидаемыми метаданными.
+// Для IPTC учитываются ограничения длины полей: значение, совпадающее с ожидаемым после обрезки,
+// считается предупреждением, а не расхождением. Отдельно распознается порча кириллицы.
+func VerifyEmbeddedMetadata(path string, expected models.AIResult) models.MetadataVerification {
+	verification := models.MetadataVerification{Path: path, VerifiedAt: time.Now()}
+
+	// Метаданные RAW хранятся только в XMP sidecar
+	raw := IsRawFile(path)
+	var err error
+	if !raw {
+		var format string
+		format, err = detectMetadataContainer(path)
+		if err == nil && format == "" {
+			verification.Status = metadataVerificationUnsupported
+			return verification
+		}
+	}
+	var xmpData, iptcData []byte
+	if err == nil {
+		xmpData, iptcData, err = readMetadataPackets(path)
+	}
+	if err != nil {
+		verification.Status = metadataVerificationError
+		verification.Issues = []models.MetadataIssue{{Field: "file", Kind: "error", Actual: err.Error()}}
+		return verification
+	}
+
+	if len(xmpData) == 0 {
+		verification.Issues = append(verification.Issues, models.MetadataIssue{Field: "xmp", Source: "xmp", Kind: "missing"})
+	} else if doc, err := parseXMP(xmpData); err != nil {
+		verification.Issues = append(verification.Issues, models.MetadataIssue{Field: "xmp", Source: "xmp", Kind: "error", Actual: err.Error()})
+	} else {
+		xmp := readXMPMetadata(doc)
+		verification.Issues = append(verification.Issues, compareMetadataText("xmp", "title", expected.Title, xmp.Title, 0)...)
+		verification.Issues = append(verification.Issues, compareMetadataText("xmp", "description", expected.Description, xmp.Description, 0)...)
+		verification.Issues = append(verification.Issues, compareMetadataText("xmp", "category", expected.Category, xmp.Category, 0)...)
+		verification.Issues = append(verification.Issues, compareMetadataKeywords("xmp", expected.Keywords, xmp.Keywords, 0)...)
+	}
+
+	// IPTC в RAW не записывается
+	if !raw {
+		if datasets := parseIPTC(iptcData); len(datasets) == 0 {
+			verification.Issues = append(verification.Issues, models.MetadataIssue{Field: "iptc", Source: "iptc", Kind: "missing"})
+		} else {
+			iptc := readIPTC(datasets)
+			verification.Issues = append(verification.Issues, compareMetadataText("iptc", "title", expected.Title, iptc.Title, iptcObjectNameLimit)...)
+			verification.Issues = append(verification.Issues, compareMetadataText("iptc", "description", expected.Description, iptc.Description, iptcCaptionAbstractLimit)...)
+			verification.Issues = append(verification.Issues, compareIPTCCategory(expected.Category, iptc.Category)...)
+			verification.Issues = append(verification.Issues, compareMetadataKeywords("iptc", expected.Keywords, iptc.Keywords, iptcKeywordLimit)...)
+		}
+	}
+
+	verification.Status = metadataVerificationStatus(verification.Issues)
+	if verification.Status != metadataVerificationOK {
+		log.Printf("Metadata verification for %s: %s (%s)", path, verification.Status, metadataIssuesSummary(verification.Issues))
+	}
+	return verification
+}
+
+// MetadataVerificationBlocksUpload сообщает, что фото с таким результатом проверки нельзя загружать
+// без разрешения пользователя
+func MetadataVerificationBlocksUpload(verification models.MetadataVerification) bool {
+	return verification.Status == metadataVerificationMismatch || verification.Status == metadataVerificationError
+}
+
+// compareMetadataText сравнивает текстовое поле. limit - ограничение длины поля в байтах, 0 - без ограничения.
+func compareMetadataText(source, field, expected, actual string, limit int) []models.MetadataIssue {
+	expected, actual = strings.TrimSpace(expected), strings.TrimSpace(actual)
+	if actual == expected {
+		return nil
+	}
+
+	issue := models.MetadataIssue{Field: field, Source: source, Expected: expected, Actual: actual}
+	switch {
+	case limit > 0 && len(expected) > limit && actual == strings.TrimSpace(truncateUTF8Bytes(expected, limit)):
+		issue.Kind = "truncated"
+	case actual == "":
+		issue.Kind = "missing"
+	case isEncodingCorruption(expected, actual):
+		issue.Kind = "encoding"
+	default:
+		issue.Kind = "mismatch"
+	}
+	return []models.MetadataIssue{issue}
+}
+
+// compareIPTCCategory сравнивает категорию IPTC: короткий код пишется в 2:15 (3 байта),
+// полное название - в 2:20 (32 байта), а exiftool может обрезать название до кода 2:15
+func compareIPTCCategory(expected, actual string) []models.MetadataIssue {
+	issues := compareMetadataText("iptc", "category", expected, actual, iptcSupplementalCategoryLimit)
+	if len(issues) > 0 && issues[0].Kind != "truncated" &&
+		strings.TrimSpace(actual) == strings.TrimSpace(truncateUTF8Bytes(strings.TrimSpace(expected), iptcCategoryLimit)) {
+		issues[0].Kind = "truncated"
+	}
+	return issues
+}
+
+// compareMetadataKeywords сравнивает ключевые слова без учета порядка. Несовпавшие слова сопоставляются
+// по порядку, когда их число одинаково, чтобы отличить обрезку и порчу кодировки от замены слова.
+func compareMetadataKeywords(source string, expected, actual []string, limit int) []models.MetadataIssue {
+	remaining := make(map[string]int)
+	for _, keyword := range normalizeKeywords(actual) {
+		remaining[keyword]++
+	}
+
+	var unmatched []string
+	for _, keyword := range normalizeKeywords(expected) {
+		if remaining[keyword] > 0 {
+			remaining[keyword]--
+			continue
+		}
+		unmatched = append(unmatched, keyword)
+	}
+
+	var extra []string
+	for _, keyword := range normalizeKeywords(actual) {
+		if remaining[keyword] > 0 {
+			remaining[keyword]--
+			extra = append(extra, keyword)
+		}
+	}
+
+	var issues []models.MetadataIssue
+	if len(unmatched) == len(extra) {
+		for i := range unmatched {
+			issues = append(issues, compareMetadataText(source, "keywords", unmatched[i], extra[i], limit)...)
+		}
+		return issues
+	}
+
+	for _, keyword := range unmatched {
+		issues = append(issues, models.MetadataIssue{Field: "keywords", Source: source, Kind: "missing", Expected: keyword})
+	}
+	for _, keyword := range extra {
+		kind := "unexpected"
+		if !utf8.ValidString(keyword) || strings.ContainsRune(keyword, utf8.RuneError) {
+			kind = "encoding"
+		}
+		issues = append(issues, models.MetadataIssue{Field: "keywords", Source: source, Kind: kind, Actual: keyword})
+	}
+	return issues
+}
+
+// isEncodingCorruption распознает типичную порчу UTF-8 текста: символы замены, невалидный UTF-8,
+// UTF-8, прочитанный как Latin-1 или CP1251 ("ÐŸÑ€Ð¸" и "РџСЂРё" вместо "При"),
+// и кириллицу, замененную знаками вопроса
+func isEncodingCorruption(expected, actual string) bool {
+	if !utf8.ValidString(actual) || strings.ContainsRune(actual, utf8.RuneError) {
+		return true
+	}
+	if !hasCyrillic(expected) {
+		return false
+	}
+	if actual == string(latin1ToUTF8([]byte(expected))) {
+		return true
+	}
+	if !hasCyrillic(actual) {
+		return strings.ContainsAny(actual, "?ÐÑ")
+	}
+
+	// В CP1251 первый байт кириллической буквы в UTF-8 (0xD0/0xD1) читается как "Р"/"С",
+	// поэтому испорченная строка длиннее исходной и "Р"/"С" в ней не меньше половины не-ASCII символов
+	nonASCII, leadBytes := 0, 0
+	for _, r := range actual {
+		if r > unicode.MaxASCII {
+			nonASCII++
+		}
+		if r == 'Р' || r == 'С' {
+			leadBytes++
+		}
+	}
+	return utf8.RuneCountInString(actual) > utf8.RuneCountInString(expected) && leadBytes*2 >= nonASCII
+}
+
+func hasCyrillic(value string) bool {
+	for _, r := range value {
+		if unicode.Is(unicode.Cyrillic, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// metadataVerificationStatus определяет итоговый статус: только обрезанные поля дают warning
+func metadataVerificationStatus(issues []models.MetadataIssue) string {
+	status := metadataVerificationOK
+	for _, issue := range issues {
+		if issue.Kind != "truncated" {
+			return metadataVerificationMismatch
+		}
+		status = metadataVerificationWarning
+	}
+	return status
+}
+
+// metadataIssuesSummary описывает расхождения одной строкой для журнала событий
+func metadataIssuesSummary(issues []models.MetadataIssue) string {
+	parts := make([]string, 0, len(issues))
+	for _, issue := range issues {
+		part := fmt.Sprintf("%s %s: %s", issue.Source, issue.Field, issue.Kind)
+		if issue.Source == "" {
+			part = fmt.Sprintf("%s: %s", issue.Field, issue.Kind)
+		}
+		switch {
+		case issue.Expected != "" && issue.Actual != "":
+			part += fmt.Sprintf(" (ожидалось %q, записано %q)", issue.Expected, issue.Actual)
+		case issue.Expected != "":
+			part += fmt.Sprintf(" (ожидалось %q)", issue.Expected)
+		case issue.Actual != "":
+			part += fmt.Sprintf(" (%q)", issue.Actual)
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "; ")
+}
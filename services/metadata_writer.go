@@ -0,0 +1,678 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"stock-photo-app/models"
+	"strconv"
+	"strings"
+)
+
+// Способы записи метаданных в файлы (AppSettings.MetadataBackend)
+const (
+	MetadataBackendNative   = "native"   // встроенный модуль: XMP и IPTC для JPEG и TIFF
+	MetadataBackendExifTool = "exiftool" // внешний exiftool
+)
+
+// Сигнатуры сегментов JPEG с метаданными
+var (
+	jpegXMPSignature         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	jpegExtendedXMPSignature = []byte("http://ns.adobe.com/xmp/extension/\x00")
+	jpegPhotoshopSignature   = []byte("Photoshop 3.0\x00")
+	jpegExifSignature        = []byte("Exif\x00\x00")
+)
+
+// Максимальный размер данных сегмента JPEG (длина сегмента включает 2 байта самой длины)
+const jpegMaxSegmentData = 0xFFFF - 2
+
+// Теги TIFF с метаданными
+const (
+	tiffTagXMP  = 700
+	tiffTagIPTC = 33723
+)
+
+// WriteEmbeddedMetadata записывает название, описание, ключевые слова, категорию и рейтинг
+// в XMP и IPTC IIM файла без внешних утилит. Поддерживаются JPEG и TIFF, формат определяется по содержимому.
+// RAW файлы не изменяются: метаданные записываются в XMP sidecar рядом с ними.
+func WriteEmbeddedMetadata(path string, aiResult models.AIResult) error {
+	if IsRawFile(path) {
+		return writeXMPSidecar(path, aiResult)
+	}
+
+	format, err := detectMetadataContainer(path)
+	if err != nil {
+		return err
+	}
+
+	switch format {
+	case "jpeg":
+		return writeJPEGMetadata(path, aiResult)
+	case "tiff":
+		return writeTIFFMetadata(path, aiResult)
+	}
+	return fmt.Errorf("запись метаданных в формат %s не поддерживается", filepath.Ext(path))
+}
+
+// ReadEmbeddedMetadata читает метаданные, записанные WriteEmbeddedMetadata или exiftool.
+// Значения XMP приоритетнее IPTC, так как в IPTC поля обрезаются по длине.
+func ReadEmbeddedMetadata(path string) (models.AIResult, error) {
+	xmpData, iptcData, err := readMetadataPackets(path)
+	if err != nil {
+		return models.AIResult{}, err
+	}
+
+	result := readIPTC(parseIPTC(iptcData))
+	if len(xmpData) == 0 {
+		return result, nil
+	}
+
+	doc, err := parseXMP(xmpData)
+	if err != nil {
+		log.Printf("Warning: failed to parse XMP in %s: %v", path, err)
+		return result, nil
+	}
+	xmp := readXMPMetadata(doc)
+	if xmp.Title != "" {
+		result.Title = xmp.Title
+	}
+	if xmp.Description != "" {
+		result.Description = xmp.Description
+	}
+	if len(xmp.Keywords) > 0 {
+		result.Keywords = xmp.Keywords
+	}
+	if xmp.Category != "" {
+		result.Category = xmp.Category
+	}
+	if xmp.Quality > 0 {
+		result.Quality = xmp.Quality
+	}
+	return result, nil
+}
+
+// readMetadataPackets возвращает пакет XMP и данные IPTC IIM файла JPEG или TIFF.
+// Для RAW возвращается XMP sidecar, IPTC у них нет.
+func readMetadataPackets(path string) ([]byte, []byte, error) {
+	if IsRawFile(path) {
+		xmpData, err := readXMPSidecar(path)
+		return xmpData, nil, err
+	}
+
+	format, err := detectMetadataContainer(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch format {
+	case "jpeg":
+		return readJPEGMetadata(path)
+	case "tiff":
+		return readTIFFMetadata(path)
+	}
+	return nil, nil, fmt.Errorf("чтение метаданных из формата %s не поддерживается", filepath.Ext(path))
+}
+
+// readXMPMetadata извлекает из пакета XMP поля, которые записывает приложение
+func readXMPMetadata(doc *xmpDocument) models.AIResult {
+	result := models.AIResult{
+		Title:       doc.getLangAlt(xmpTitle),
+		Description: doc.getLangAlt(xmpDescription),
+		Keywords:    doc.getList(xmpSubject),
+		Category:    doc.getSimple(xmpCategory),
+	}
+	if rating, err := strconv.Atoi(doc.getSimple(xmpRating)); err == nil {
+		result.Quality = rating
+	}
+	return result
+}
+
+// normalizeKeywords убирает пробелы по краям и пустые ключевые слова
+func normalizeKeywords(keywords []string) []string {
+	var result []string
+	for _, keyword := range keywords {
+		if keyword = strings.TrimSpace(keyword); keyword != "" {
+			result = append(result, keyword)
+		}
+	}
+	return result
+}
+
+// detectMetadataContainer определяет формат файла по сигнатуре: "jpeg", "tiff" или пустая строка
+func detectMetadataContainer(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return "", fmt.Errorf("failed to read file header: %w", err)
+	}
+
+	switch {
+	case header[0] == 0xFF && header[1] == 0xD8:
+		return "jpeg", nil
+	case bytes.Equal(header, []byte("II*\x00")), bytes.Equal(header, []byte("MM\x00*")):
+		return "tiff", nil
+	}
+	return "", nil
+}
+
+// applyXMPMetadata заменяет в пакете свойства, которые записывает приложение
+func applyXMPMetadata(doc *xmpDocument, aiResult models.AIResult) {
+	for _, name := range []xml.Name{xmpTitle, xmpDescription, xmpSubject, xmpCategory, xmpRating, xmpHasExtendedXMP} {
+		doc.remove(name)
+	}
+
+	if title := strings.TrimSpace(aiResult.Title); title != "" {
+		doc.setLangAlt(xmpTitle, title)
+	}
+	if description := strings.TrimSpace(aiResult.Description); description != "" {
+		doc.setLangAlt(xmpDescription, description)
+	}
+	if keywords := normalizeKeywords(aiResult.Keywords); len(keywords) > 0 {
+		doc.setBag(xmpSubject, keywords)
+	}
+	if category := strings.TrimSpace(aiResult.Category); category != "" {
+		doc.setSimple(xmpCategory, category)
+	}
+	if aiResult.Quality > 0 {
+		doc.setSimple(xmpRating, strconv.Itoa(aiResult.Quality))
+	}
+	doc.setSimple(xmpCreatorTool, "Stock Photo App")
+}
+
+// buildMetadataPackets формирует XMP пакет и поток IPTC IIM поверх существующих в файле
+func buildMetadataPackets(existingXMP, existingIPTC []byte, aiResult models.AIResult) ([]byte, []byte) {
+	doc := newXMPDocument()
+	if len(existingXMP) > 0 {
+		if parsed, err := parseXMP(existingXMP); err == nil {
+			doc = parsed
+		} else {
+			log.Printf("Warning: existing XMP packet is invalid and will be replaced: %v", err)
+		}
+	}
+	applyXMPMetadata(doc, aiResult)
+
+	iptc := encodeIPTC(buildIPTC(parseIPTC(existingIPTC), aiResult))
+	return doc.serialize(), iptc
+}
+
+// jpegSegment маркерный сегмент JPEG до начала данных изображения
+type jpegSegment struct {
+	marker byte
+	data   []byte // без маркера и длины
+}
+
+// splitJPEG делит JPEG на сегменты заголовка и остаток, начиная с SOS (данные изображения копируются как есть)
+func splitJPEG(data []byte) ([]jpegSegment, []byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, nil, fmt.Errorf("not a JPEG file")
+	}
+
+	var segments []jpegSegment
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF {
+			return nil, nil, fmt.Errorf("invalid JPEG marker at offset %d", i)
+		}
+		// Маркеру может предшествовать заполнение байтами 0xFF
+		for i+1 < len(data) && data[i+1] == 0xFF {
+			i++
+		}
+		if i+1 >= len(data) {
+			break
+		}
+		marker := data[i+1]
+
+		switch {
+		case marker == 0xDA || marker == 0xD9: // SOS, EOI
+			return segments, data[i:], nil
+		case marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7): // маркеры без длины
+			segments = append(segments, jpegSegment{marker: marker})
+			i += 2
+			continue
+		}
+
+		if i+4 > len(data) {
+			return nil, nil, fmt.Errorf("truncated JPEG segment at offset %d", i)
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2 : i+4]))
+		if length < 2 || i+2+length > len(data) {
+			return nil, nil, fmt.Errorf("invalid JPEG segment length at offset %d", i)
+		}
+		segments = append(segments, jpegSegment{marker: marker, data: data[i+4 : i+2+length]})
+		i += 2 + length
+	}
+	return nil, nil, fmt.Errorf("JPEG image data not found")
+}
+
+// jpegMetadataSegments возвращает XMP пакет и ресурсы Photoshop из сегментов JPEG
+func jpegMetadataSegments(segments []jpegSegment) ([]byte, []byte) {
+	var xmpData, irbData []byte
+	for _, segment := range segments {
+		switch {
+		case segment.marker == 0xE1 && bytes.HasPrefix(segment.data, jpegXMPSignature) && xmpData == nil:
+			xmpData = segment.data[len(jpegXMPSignature):]
+		case segment.marker == 0xED && bytes.HasPrefix(segment.data, jpegPhotoshopSignature):
+			// Ресурсы Photoshop могут быть разбиты на несколько сегментов APP13
+			irbData = append(irbData, segment.data[len(jpegPhotoshopSignature):]...)
+		}
+	}
+	return xmpData, irbData
+}
+
+// readJPEGMetadata читает XMP и IPTC из JPEG
+func readJPEGMetadata(path string) ([]byte, []byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	segments, _, err := splitJPEG(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	xmpData, irbData := jpegMetadataSegments(segments)
+	resources, _ := parseIRB(irbData)
+	return xmpData, irbIPTCData(resources), nil
+}
+
+// writeJPEGMetadata заменяет сегменты XMP (APP1) и Photoshop/IPTC (APP13).
+// EXIF, ICC профиль и данные изображения не изменяются.
+func writeJPEGMetadata(path string, aiResult models.AIResult) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+	segments, rest, err := splitJPEG(data)
+	if err != nil {
+		return err
+	}
+
+	existingXMP, irbData := jpegMetadataSegments(segments)
+	resources, err := parseIRB(irbData)
+	if err != nil {
+		log.Printf("Warning: Photoshop resources in %s are damaged, keeping %d readable: %v", path, len(resources), err)
+	}
+
+	xmpPacket, iptc := buildMetadataPackets(existingXMP, irbIPTCData(resources), aiResult)
+
+	xmpSegment := append(append([]byte{}, jpegXMPSignature...), xmpPacket...)
+	if len(xmpSegment) > jpegMaxSegmentData {
+		return fmt.Errorf("XMP пакет слишком большой для JPEG (%d байт)", len(xmpSegment))
+	}
+	irbSegment := append(append([]byte{}, jpegPhotoshopSignature...), encodeIRB(setIRBIPTC(resources, iptc))...)
+	if len(irbSegment) > jpegMaxSegmentData {
+		return fmt.Errorf("IPTC данные слишком большие для JPEG (%d байт)", len(irbSegment))
+	}
+
+	// Удаляем старые сегменты метаданных, новые ставим после JFIF/EXIF
+	var kept []jpegSegment
+	for _, segment := range segments {
+		isXMP := segment.marker == 0xE1 && (bytes.HasPrefix(segment.data, jpegXMPSignature) ||
+			bytes.HasPrefix(segment.data, jpegExtendedXMPSignature))
+		isPhotoshop := segment.marker == 0xED && bytes.HasPrefix(segment.data, jpegPhotoshopSignature)
+		if !isXMP && !isPhotoshop {
+			kept = append(kept, segment)
+		}
+	}
+	insertAt := 0
+	for insertAt < len(kept) && (kept[insertAt].marker == 0xE0 ||
+		(kept[insertAt].marker == 0xE1 && bytes.HasPrefix(kept[insertAt].data, jpegExifSignature))) {
+		insertAt++
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(data) + len(xmpSegment) + len(irbSegment))
+	buf.Write([]byte{0xFF, 0xD8})
+	writeSegment := func(segment jpegSegment) {
+		buf.Write([]byte{0xFF, segment.marker})
+		if segment.marker == 0x01 || (segment.marker >= 0xD0 && segment.marker <= 0xD7) {
+			return
+		}
+		binary.Write(&buf, binary.BigEndian, uint16(len(segment.data)+2))
+		buf.Write(segment.data)
+	}
+	for i, segment := range kept {
+		if i == insertAt {
+			writeSegment(jpegSegment{marker: 0xE1, data: xmpSegment})
+			writeSegment(jpegSegment{marker: 0xED, data: irbSegment})
+		}
+		writeSegment(segment)
+	}
+	if insertAt == len(kept) {
+		writeSegment(jpegSegment{marker: 0xE1, data: xmpSegment})
+		writeSegment(jpegSegment{marker: 0xED, data: irbSegment})
+	}
+	buf.Write(rest)
+
+	return replaceFile(path, buf.Bytes())
+}
+
+// replaceFile записывает данные во временный файл рядом и переименовывает его поверх исходного,
+// чтобы сбой посередине записи не испортил фото
+func replaceFile(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %w", err)
+	}
+
+	tmpPath := path + ".metadata.tmp"
+	if err := os.WriteFile(tmpPath, data, info.Mode().Perm()); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace file: %w", err)
+	}
+	return nil
+}
+
+// tiffEntry запись IFD
+type tiffEntry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value [4]byte // значение или смещение, в порядке байт файла
+}
+
+// Размеры типов TIFF в байтах
+var tiffTypeSizes = map[uint16]int64{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8, 13: 4}
+
+// tiffFile заголовок и IFD0 файла TIFF
+type tiffFile struct {
+	order     binary.ByteOrder
+	ifdOffset uint32
+	entries   []tiffEntry
+	nextIFD   uint32
+}
+
+// readTIFFHeader читает порядок байт и IFD0. BigTIFF не поддерживается.
+func readTIFFHeader(file *os.File) (*tiffFile, error) {
+	header := make([]byte, 8)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("failed to read TIFF header: %w", err)
+	}
+
+	tiff := &tiffFile{}
+	switch string(header[:2]) {
+	case "II":
+		tiff.order = binary.LittleEndian
+	case "MM":
+		tiff.order = binary.BigEndian
+	default:
+		return nil, fmt.Errorf("not a TIFF file")
+	}
+	if magic := tiff.order.Uint16(header[2:4]); magic != 42 {
+		return nil, fmt.Errorf("unsupported TIFF variant %d (BigTIFF не поддерживается)", magic)
+	}
+	tiff.ifdOffset = tiff.order.Uint32(header[4:8])
+
+	entries, nextIFD, err := readTIFFIFD(file, tiff.order, tiff.ifdOffset)
+	if err != nil {
+		return nil, err
+	}
+	tiff.entries, tiff.nextIFD = entries, nextIFD
+	return tiff, nil
+}
+
+// readTIFFIFD читает записи IFD по смещению offset и смещение следующего IFD
+func readTIFFIFD(file io.ReaderAt, order binary.ByteOrder, offset uint32) ([]tiffEntry, uint32, error) {
+	countBytes := make([]byte, 2)
+	if _, err := file.ReadAt(countBytes, int64(offset)); err != nil {
+		return nil, 0, fmt.Errorf("failed to read TIFF IFD: %w", err)
+	}
+	count := int(order.Uint16(countBytes))
+
+	ifd := make([]byte, count*12+4)
+	if _, err := file.ReadAt(ifd, int64(offset)+2); err != nil {
+		return nil, 0, fmt.Errorf("failed to read TIFF IFD: %w", err)
+	}
+	entries := make([]tiffEntry, 0, count)
+	for i := 0; i < count; i++ {
+		raw := ifd[i*12 : i*12+12]
+		entry := tiffEntry{
+			tag:   order.Uint16(raw[0:2]),
+			typ:   order.Uint16(raw[2:4]),
+			count: order.Uint32(raw[4:8]),
+		}
+		copy(entry.value[:], raw[8:12])
+		entries = append(entries, entry)
+	}
+	return entries, order.Uint32(ifd[count*12:]), nil
+}
+
+// tagData читает значение тега IFD0 как байты
+func (t *tiffFile) tagData(file *os.File, tag uint16) ([]byte, error) {
+	for _, entry := range t.entries {
+		if entry.tag == tag {
+			return tiffEntryData(file, t.order, entry)
+		}
+	}
+	return nil, nil
+}
+
+// tiffEntryData читает значение записи IFD: из самой записи, если оно умещается в 4 байта, иначе по смещению
+func tiffEntryData(file *os.File, order binary.ByteOrder, entry tiffEntry) ([]byte, error) {
+	size := tiffTypeSizes[entry.typ] * int64(entry.count)
+	if size <= 4 {
+		return append([]byte{}, entry.value[:size]...), nil
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	offset := int64(order.Uint32(entry.value[:]))
+	if offset+size > info.Size() {
+		return nil, fmt.Errorf("TIFF tag %d points outside of file", entry.tag)
+	}
+	data := make([]byte, size)
+	if _, err := file.ReadAt(data, offset); err != nil {
+		return nil, fmt.Errorf("failed to read TIFF tag %d: %w", entry.tag, err)
+	}
+	return data, nil
+}
+
+// readTIFFMetadata читает XMP (тег 700) и IPTC (тег 33723) из IFD0
+func readTIFFMetadata(path string) ([]byte, []byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	tiff, err := readTIFFHeader(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	xmpData, err := tiff.tagData(file, tiffTagXMP)
+	if err != nil {
+		return nil, nil, err
+	}
+	iptcData, err := tiff.tagData(file, tiffTagIPTC)
+	if err != nil {
+		return nil, nil, err
+	}
+	return xmpData, iptcData, nil
+}
+
+// writeTIFFMetadata дописывает в конец TIFF новые XMP, IPTC и копию IFD0 с обновленными тегами,
+// затем переключает заголовок на новый IFD0. Данные изображения и остальные IFD не переносятся,
+// поэтому большие TIFF не перезаписываются целиком.
+//
+// Если хвост файла занимают только прежние XMP, IPTC и IFD0 (так их оставляет предыдущая запись),
+// новый хвост затем переносится на их место и файл обрезается: повторные записи не увеличивают TIFF.
+// Место значений, записанных другими программами внутри файла, и хвост, который стал больше
+// прежнего, не освобождаются - файл вырастает один раз на размер метаданных.
+func writeTIFFMetadata(path string, aiResult models.AIResult) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	tiff, err := readTIFFHeader(file)
+	if err != nil {
+		return err
+	}
+	existingXMP, err := tiff.tagData(file, tiffTagXMP)
+	if err != nil {
+		return err
+	}
+	existingIPTC, err := tiff.tagData(file, tiffTagIPTC)
+	if err != nil {
+		return err
+	}
+
+	xmpPacket, iptc := buildMetadataPackets(existingXMP, existingIPTC, aiResult)
+
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return fmt.Errorf("failed to seek TIFF: %w", err)
+	}
+	reclaimFrom := tiff.metadataTailStart(end)
+
+	tail, ifdOffset := tiff.metadataTail(end, xmpPacket, iptc)
+	if ifdOffset+int64(len(tail)) > 0xFFFFFFFF {
+		return fmt.Errorf("TIFF больше 4 ГБ, запись метаданных не поддерживается")
+	}
+	// Заголовок переключаем только после записи хвоста: до этого момента файл остается прежним
+	if err := writeTIFFTail(file, tiff.order, end, tail, ifdOffset); err != nil {
+		return err
+	}
+
+	if reclaimFrom < 0 {
+		return nil
+	}
+	// Прежний хвост больше не используется: переносим новый на его место, если он туда помещается
+	tail, ifdOffset = tiff.metadataTail(reclaimFrom, xmpPacket, iptc)
+	if reclaimFrom+int64(len(tail)) > end {
+		return nil
+	}
+	if err := writeTIFFTail(file, tiff.order, reclaimFrom, tail, ifdOffset); err != nil {
+		return err
+	}
+	if err := file.Truncate(reclaimFrom + int64(len(tail))); err != nil {
+		return fmt.Errorf("failed to truncate TIFF: %w", err)
+	}
+	return file.Sync()
+}
+
+// metadataTail собирает XMP, IPTC и копию IFD0 с обновленными тегами для записи по смещению base.
+// Возвращает данные и смещение нового IFD0.
+func (t *tiffFile) metadataTail(base int64, xmpPacket, iptc []byte) ([]byte, int64) {
+	var tail bytes.Buffer
+	// appendBlock дописывает значение с выравниванием по слову и возвращает его смещение
+	appendBlock := func(data []byte) int64 {
+		if (base+int64(tail.Len()))%2 != 0 {
+			tail.WriteByte(0)
+		}
+		offset := base + int64(tail.Len())
+		tail.Write(data)
+		return offset
+	}
+
+	entries := make(map[uint16]tiffEntry, len(t.entries)+2)
+	for _, entry := range t.entries {
+		entries[entry.tag] = entry
+	}
+	for _, block := range []struct {
+		tag  uint16
+		typ  uint16
+		data []byte
+	}{
+		{tiffTagXMP, 1, xmpPacket}, // BYTE
+		{tiffTagIPTC, 7, iptc},     // UNDEFINED
+	} {
+		entry := tiffEntry{tag: block.tag, typ: block.typ, count: uint32(len(block.data))}
+		if len(block.data) <= 4 {
+			copy(entry.value[:], block.data)
+		} else {
+			t.order.PutUint32(entry.value[:], uint32(appendBlock(block.data)))
+		}
+		entries[block.tag] = entry
+	}
+
+	// Записи IFD должны идти по возрастанию тега
+	tags := make([]int, 0, len(entries))
+	for tag := range entries {
+		tags = append(tags, int(tag))
+	}
+	sort.Ints(tags)
+
+	ifd := make([]byte, 2+len(tags)*12+4)
+	t.order.PutUint16(ifd[0:2], uint16(len(tags)))
+	for i, tag := range tags {
+		entry := entries[uint16(tag)]
+		raw := ifd[2+i*12 : 2+i*12+12]
+		t.order.PutUint16(raw[0:2], entry.tag)
+		t.order.PutUint16(raw[2:4], entry.typ)
+		t.order.PutUint32(raw[4:8], entry.count)
+		copy(raw[8:12], entry.value[:])
+	}
+	t.order.PutUint32(ifd[2+len(tags)*12:], t.nextIFD)
+	ifdOffset := appendBlock(ifd)
+	return tail.Bytes(), ifdOffset
+}
+
+// metadataTailStart возвращает начало хвоста файла размером size, который целиком занят IFD0
+// и значениями его тегов XMP и IPTC, или -1, если в хвосте есть другие данные
+func (t *tiffFile) metadataTailStart(size int64) int64 {
+	type span struct{ start, end int64 }
+	spans := []span{{int64(t.ifdOffset), int64(t.ifdOffset) + 2 + int64(len(t.entries))*12 + 4}}
+	for _, entry := range t.entries {
+		if entry.tag != tiffTagXMP && entry.tag != tiffTagIPTC {
+			continue
+		}
+		length := tiffTypeSizes[entry.typ] * int64(entry.count)
+		if length <= 4 {
+			continue
+		}
+		start := int64(t.order.Uint32(entry.value[:]))
+		spans = append(spans, span{start, start + length})
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].start < spans[j].start })
+
+	if spans[0].start < 8 || spans[len(spans)-1].end != size {
+		return -1
+	}
+	for i := 1; i < len(spans); i++ {
+		// Между блоками допускается только байт выравнивания
+		gap := spans[i].start - spans[i-1].end
+		if gap != 0 && (gap != 1 || spans[i-1].end%2 == 0) {
+			return -1
+		}
+	}
+	return spans[0].start
+}
+
+// writeTIFFTail записывает хвост по смещению offset и переключает заголовок на IFD0 по смещению ifdOffset
+func writeTIFFTail(file *os.File, order binary.ByteOrder, offset int64, tail []byte, ifdOffset int64) error {
+	if _, err := file.WriteAt(tail, offset); err != nil {
+		return fmt.Errorf("failed to write TIFF metadata: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync TIFF: %w", err)
+	}
+
+	header := make([]byte, 4)
+	order.PutUint32(header, uint32(ifdOffset))
+	if _, err := file.WriteAt(header, 4); err != nil {
+		return fmt.Errorf("failed to update TIFF header: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync TIFF: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"reflect"
+	"stock-photo-app/models"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// Сегменты, которые модуль не должен трогать
+var (
+	testExifSegment = append(append([]byte{}, jpegExifSignature...), []byte("MM\x00*\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00")...)
+	testICCSegment  = append([]byte("ICC_PROFILE\x00\x01\x01"), bytes.Repeat([]byte{0x42}, 128)...)
+)
+
+// Пиксели тестового TIFF 2x2 в оттенках серого
+var testTIFFPixels = []byte{0x10, 0x20, 0x30, 0x40}
+
+// writeTestMetadataJPEG создает JPEG с сегментами EXIF (APP1) и ICC профиля (APP2)
+func writeTestMetadataJPEG(t *testing.T) string {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for i := range img.Pix {
+		img.Pix[i] = byte(i)
+	}
+	var encoded bytes.Buffer
+	if err := jpeg.Encode(&encoded, img, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	buf.Write(encoded.Bytes()[:2])
+	for _, segment := range []jpegSegment{{marker: 0xE1, data: testExifSegment}, {marker: 0xE2, data: testICCSegment}} {
+		buf.Write([]byte{0xFF, segment.marker})
+		binary.Write(&buf, binary.BigEndian, uint16(len(segment.data)+2))
+		buf.Write(segment.data)
+	}
+	buf.Write(encoded.Bytes()[2:])
+
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// writeTestMetadataTIFF создает несжатый TIFF 2x2 с IFD0 сразу после заголовка и пикселями после IFD
+func writeTestMetadataTIFF(t *testing.T, order binary.ByteOrder) string {
+	t.Helper()
+	entries := []struct {
+		tag, typ uint16
+		value    uint32
+	}{
+		{256, 3, 2}, // ImageWidth
+		{257, 3, 2}, // ImageLength
+		{258, 3, 8}, // BitsPerSample
+		{259, 3, 1}, // Compression: нет
+		{262, 3, 1}, // PhotometricInterpretation: черный - 0
+		{273, 4, 0}, // StripOffsets, заполняется ниже
+		{278, 3, 2}, // RowsPerStrip
+		{279, 4, 4}, // StripByteCounts
+	}
+	stripOffset := uint32(8 + 2 + len(entries)*12 + 4)
+
+	var buf bytes.Buffer
+	if order == binary.LittleEndian {
+		buf.WriteString("II")
+	} else {
+		buf.WriteString("MM")
+	}
+	binary.Write(&buf, order, uint16(42))
+	binary.Write(&buf, order, uint32(8))
+	binary.Write(&buf, order, uint16(len(entries)))
+	for _, entry := range entries {
+		value := make([]byte, 4)
+		switch {
+		case entry.tag == 273:
+			order.PutUint32(value, stripOffset)
+		case entry.typ == 3:
+			order.PutUint16(value, uint16(entry.value))
+		default:
+			order.PutUint32(value, entry.value)
+		}
+		binary.Write(&buf, order, entry.tag)
+		binary.Write(&buf, order, entry.typ)
+		binary.Write(&buf, order, uint32(1))
+		buf.Write(value)
+	}
+	binary.Write(&buf, order, uint32(0))
+	buf.Write(testTIFFPixels)
+
+	path := filepath.Join(t.TempDir(), "photo.tif")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// readTestTIFFPixels читает пиксели по StripOffsets текущего IFD0
+func readTestTIFFPixels(t *testing.T, path string) []byte {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	tiff, err := readTIFFHeader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	offset, err := tiff.tagData(file, 273)
+	if err != nil || len(offset) != 4 {
+		t.Fatalf("StripOffsets = %v, %v", offset, err)
+	}
+	pixels := make([]byte, len(testTIFFPixels))
+	if _, err := file.ReadAt(pixels, int64(tiff.order.Uint32(offset))); err != nil {
+		t.Fatal(err)
+	}
+	return pixels
+}
+
+// readTestJPEGSegments делит файл на сегменты заголовка и данные изображения
+func readTestJPEGSegments(t *testing.T, path string) ([]jpegSegment, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	segments, rest, err := splitJPEG(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return segments, rest
+}
+
+func TestEmbeddedMetadataRoundTrip(t *testing.T) {
+	first := models.AIResult{
+		Title:       "Закат над Байкалом",
+		Description: "Лодка у берега озера, зимний вечер",
+		Keywords:    []string{"байкал", "закат", " лед ", "", "winter"},
+		Category:    "Пейзажи",
+		Quality:     4,
+	}
+	second := models.AIResult{
+		Title:       "Рассвет в горах",
+		Description: "Туман в долине",
+		Keywords:    []string{"горы", "туман"},
+		Category:    "Nature",
+		Quality:     5,
+	}
+
+	tests := []struct {
+		name   string
+		create func(t *testing.T) string
+	}{
+		{"jpeg", writeTestMetadataJPEG},
+		{"tiff little endian", func(t *testing.T) string { return writeTestMetadataTIFF(t, binary.LittleEndian) }},
+		{"tiff big endian", func(t *testing.T) string { return writeTestMetadataTIFF(t, binary.BigEndian) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := tt.create(t)
+
+			// Вторая запись идет поверх существующих XMP и IPTC и должна заменить значения, а не дополнить их
+			for _, want := range []models.AIResult{first, second} {
+				if err := WriteEmbeddedMetadata(path, want); err != nil {
+					t.Fatalf("WriteEmbeddedMetadata: %v", err)
+				}
+				got, err := ReadEmbeddedMetadata(path)
+				if err != nil {
+					t.Fatalf("ReadEmbeddedMetadata: %v", err)
+				}
+				if got.Title != want.Title || got.Description != want.Description ||
+					got.Category != want.Category || got.Quality != want.Quality {
+					t.Errorf("read back %+v, want %+v", got, want)
+				}
+				if keywords := normalizeKeywords(want.Keywords); !reflect.DeepEqual(got.Keywords, keywords) {
+					t.Errorf("keywords = %q, want %q", got.Keywords, keywords)
+				}
+
+				// Те же значения должны читаться и из IPTC
+				_, iptcData, err := readMetadataPackets(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				iptc := readIPTC(parseIPTC(iptcData))
+				if iptc.Title != want.Title || !reflect.DeepEqual(iptc.Keywords, normalizeKeywords(want.Keywords)) {
+					t.Errorf("IPTC title %q keywords %q, want %q %q", iptc.Title, iptc.Keywords, want.Title, want.Keywords)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteJPEGMetadataKeepsOtherSegments(t *testing.T) {
+	path := writeTestMetadataJPEG(t)
+	_, originalImage := readTestJPEGSegments(t, path)
+
+	for i, title := range []string{"Первая запись", "Вторая запись"} {
+		if err := WriteEmbeddedMetadata(path, models.AIResult{Title: title, Keywords: []string{"тест"}}); err != nil {
+			t.Fatalf("write %d: %v", i+1, err)
+		}
+
+		segments, imageData := readTestJPEGSegments(t, path)
+		if !bytes.Equal(imageData, originalImage) {
+			t.Errorf("write %d changed image data", i+1)
+		}
+
+		var exif, icc, xmp, photoshop int
+		for _, segment := range segments {
+			switch {
+			case segment.marker == 0xE1 && bytes.Equal(segment.data, testExifSegment):
+				exif++
+			case segment.marker == 0xE2 && bytes.Equal(segment.data, testICCSegment):
+				icc++
+			case segment.marker == 0xE1 && bytes.HasPrefix(segment.data, jpegXMPSignature):
+				xmp++
+			case segment.marker == 0xED && bytes.HasPrefix(segment.data, jpegPhotoshopSignature):
+				photoshop++
+			}
+		}
+		if exif != 1 || icc != 1 {
+			t.Errorf("write %d: EXIF segments %d, ICC segments %d, want one unchanged copy of each", i+1, exif, icc)
+		}
+		if xmp != 1 || photoshop != 1 {
+			t.Errorf("write %d: XMP segments %d, APP13 segments %d, want exactly one of each", i+1, xmp, photoshop)
+		}
+	}
+}
+
+func TestIPTCTitleTruncatedOnRuneBoundary(t *testing.T) {
+	// Латинская буква и кириллица по 2 байта: граница в 64 байта приходится на середину буквы
+	title := "N" + strings.Repeat("Ж", 40)
+	path := writeTestMetadataJPEG(t)
+	if err := WriteEmbeddedMetadata(path, models.AIResult{Title: title}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, iptcData, err := readMetadataPackets(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readIPTC(parseIPTC(iptcData)).Title
+	if len(got) > iptcObjectNameLimit || !utf8.ValidString(got) || !strings.HasPrefix(title, got) {
+		t.Errorf("IPTC title %q (%d bytes), want a valid UTF-8 prefix of at most %d bytes", got, len(got), iptcObjectNameLimit)
+	}
+	if len(got) != 63 {
+		t.Errorf("IPTC title is %d bytes, want 63", len(got))
+	}
+
+	// В XMP название хранится полностью
+	result, err := ReadEmbeddedMetadata(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Title != title {
+		t.Errorf("XMP title = %q, want %q", result.Title, title)
+	}
+}
+
+func TestTruncateUTF8Bytes(t *testing.T) {
+	tests := []struct {
+		value string
+		limit int
+		want  string
+	}{
+		{"short", 64, "short"},
+		{"abc", 3, "abc"},
+		{"Жук", 3, "Ж"},  // "у" занимает байты 2-3, не помещается
+		{"Жук", 4, "Жу"}, // ровно по границе символа
+		{"aЖ", 2, "a"},
+		{"日本", 4, "日"},
+		{"😀x", 3, ""},
+	}
+	for _, tt := range tests {
+		if got := truncateUTF8Bytes(tt.value, tt.limit); got != tt.want {
+			t.Errorf("truncateUTF8Bytes(%q, %d) = %q, want %q", tt.value, tt.limit, got, tt.want)
+		}
+	}
+}
+
+func TestWriteTIFFMetadataReusesTail(t *testing.T) {
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		t.Run(order.String(), func(t *testing.T) {
+			path := writeTestMetadataTIFF(t, order)
+			result := models.AIResult{Title: "Старая мельница", Keywords: []string{"мельница", "река"}, Quality: 3}
+
+			var sizes []int64
+			for i := 0; i < 3; i++ {
+				if err := WriteEmbeddedMetadata(path, result); err != nil {
+					t.Fatalf("write %d: %v", i+1, err)
+				}
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				sizes = append(sizes, info.Size())
+				if pixels := readTestTIFFPixels(t, path); !bytes.Equal(pixels, testTIFFPixels) {
+					t.Fatalf("write %d: pixels = %v, want %v", i+1, pixels, testTIFFPixels)
+				}
+			}
+			if sizes[1] != sizes[0] || sizes[2] != sizes[0] {
+				t.Errorf("file sizes after repeated writes = %v, want them unchanged", sizes)
+			}
+
+			// Более короткие метаданные занимают место прежних, файл уменьшается
+			if err := WriteEmbeddedMetadata(path, models.AIResult{Title: "Мельница"}); err != nil {
+				t.Fatal(err)
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Size() >= sizes[0] {
+				t.Errorf("size after a shorter write = %d, want less than %d", info.Size(), sizes[0])
+			}
+			got, err := ReadEmbeddedMetadata(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Title != "Мельница" || len(got.Keywords) != 0 {
+				t.Errorf("read back %+v after a shorter write", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,782 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"stock-photo-app/models"
+	"strings"
+	"time"
+)
+
+// OpenAI API структуры для Structured Outputs
+type ResponseFormat struct {
+	Type       string     `json:"type"`
+	JSONSchema JSONSchema `json:"json_schema"`
+}
+
+type JSONSchema struct {
+	Name   string `json:"name"`
+	Schema Schema `json:"schema"`
+	Strict bool   `json:"strict"`
+}
+
+type OpenAIRequest struct {
+	Model               string          `json:"model"`
+	Messages            []Message       `json:"messages"`
+	MaxCompletionTokens int             `json:"max_completion_tokens"`
+	ResponseFormat      *ResponseFormat `json:"response_format,omitempty"`
+}
+
+type Message struct {
+	Role    string    `json:"role"`
+	Content []Content `json:"content"`
+}
+
+type Content struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *ImageURL `json:"image_url,omitempty"`
+}
+
+type ImageURL struct {
+	URL string `json:"url"`
+}
+
+type OpenAIResponse struct {
+	Choices []Choice     `json:"choices"`
+	Usage   *OpenAIUsage `json:"usage,omitempty"`
+	Error   *APIError    `json:"error,omitempty"`
+}
+
+// OpenAIUsage токены запроса; prompt_tokens включает изображение
+type OpenAIUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+}
+
+type Choice struct {
+	Message struct {
+		Content string `json:"content"`
+	} `json:"message"`
+}
+
+type APIError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code"`
+}
+
+// OpenAIProvider реализует VisionProvider для OpenAI Chat Completions API
+type OpenAIProvider struct {
+	service *AIService
+}
+
+// NewOpenAIProvider создает провайдер OpenAI, использующий общие HTTP и парсинг AIService
+func NewOpenAIProvider(service *AIService) *OpenAIProvider {
+	return &OpenAIProvider{service: service}
+}
+
+// Analyze анализирует изображение через OpenAI API с retry логикой
+func (p *OpenAIProvider) Analyze(ctx context.Context, photo models.Photo, description string, prompt string, contentType string, settings models.AppSettings) (*models.AIResult, error) {
+	const maxRetries = 3
+
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		result, err := p.analyzePhotoAttempt(ctx, photo, description, prompt, contentType, settings)
+		if err == nil {
+			return result, nil
+		}
+
+		log.Printf("AI analysis attempt %d/%d failed for photo %s: %v", attempt, maxRetries, photo.FileName, err)
+
+		// Если это последняя попытка, критическая ошибка или анализ отменен, возвращаем ошибку
+		if attempt == maxRetries || ctx.Err() != nil || !p.service.isRetryableError(err) {
+			return nil, err
+		}
+
+		// Пауза между попытками
+		if err := sleepContext(ctx, time.Duration(attempt)*time.Second); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("all retry attempts failed")
+}
+
+// analyzePhotoAttempt выполняет одну попытку анализа фото
+func (p *OpenAIProvider) analyzePhotoAttempt(ctx context.Context, photo models.Photo, description string, prompt string, contentType string, settings models.AppSettings) (*models.AIResult, error) {
+	// Кодируем изображение в base64
+	imageProcessor := NewImageProcessor(settings.TempDirectory)
+	base64Image, err := imageProcessor.EncodeImageToBase64(photo.ThumbnailPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode image: %w", err)
+	}
+
+	// Формируем полный промпт с учетом типа контента
+	fullPrompt := p.service.exifProcessor.BuildContextualPrompt(contentType, prompt, photo.ExifData, description)
+
+	// Логируем полный промпт для отладки
+	if p.service.logger != nil {
+		p.service.logger.LogAIPrompt(photo.FileName, fullPrompt, description)
+		p.service.logger.LogAI("EXIF data fields count: %d", len(photo.ExifData))
+	} else {
+		log.Printf("Full contextual prompt being sent to AI:")
+		log.Printf("==== START PROMPT ====")
+		log.Printf("%s", fullPrompt)
+		log.Printf("==== END PROMPT ====")
+		log.Printf("Photo filename: %s", photo.FileName)
+		log.Printf("EXIF data fields count: %d", len(photo.ExifData))
+	}
+
+	// Создаем запрос
+	model := settings.AIModel
+	if model == "" {
+		model = "gpt-4o" // fallback
+	}
+
+	// Создаем JSON Schema для структурированного вывода
+	responseFormat := &ResponseFormat{
+		Type: "json_schema",
+		JSONSchema: JSONSchema{
+			Name:   "photo_metadata",
+			Strict: true,
+			Schema: photoMetadataSchema(),
+		},
+	}
+
+	// Устанавливаем максимальное количество токенов из настроек
+	maxTokens := 2000 // значение по умолчанию
+	if settings.AIMaxTokens > 0 {
+		maxTokens = settings.AIMaxTokens
+	}
+
+	request := OpenAIRequest{
+		Model:               model,
+		MaxCompletionTokens: maxTokens,
+		ResponseFormat:      responseFormat,
+		Messages: []Message{
+			{
+				Role: "user",
+				Content: []Content{
+					{
+						Type: "text",
+						Text: fullPrompt,
+					},
+					{
+						Type: "image_url",
+						ImageURL: &ImageURL{
+							URL: fmt.Sprintf("data:image/jpeg;base64,%s", base64Image),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	// Отправляем запрос
+	imageTokens := estimateOpenAIImageTokens(photo.ThumbnailPath)
+	call := newAICall(photo, "openai", model, fullPrompt, imageTokens, maxTokens)
+	response, err := p.sendOpenAIRequest(ctx, call, request, settings)
+	if err != nil {
+		return nil, err
+	}
+	p.service.rateLimiter.settle(call, response.Usage.tokens())
+	p.service.recordUsage(photo, "openai", model, response.Usage.tokens(), imageTokens)
+
+	// Парсим ответ
+	result, err := p.service.parseAIResponse(response.Choices[0].Message.Content, photo.FileName)
+	if err != nil {
+		return nil, err
+	}
+
+	// Устанавливаем тип контента в результате
+	result.ContentType = contentType
+
+	// Валидируем длину описания в зависимости от типа контента
+	applyDescriptionLimits(result, contentType)
+
+	return result, nil
+}
+
+// tokens возвращает токены из блока usage; сервер без usage дает нули
+func (u *OpenAIUsage) tokens() aiTokens {
+	if u == nil {
+		return aiTokens{}
+	}
+	return aiTokens{prompt: u.PromptTokens, completion: u.CompletionTokens}
+}
+
+// sendOpenAIRequest отправляет запрос к OpenAI API
+func (p *OpenAIProvider) sendOpenAIRequest(ctx context.Context, call *aiCall, request OpenAIRequest, settings models.AppSettings) (*OpenAIResponse, error) {
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	apiURL := settings.AIBaseURL
+	if apiURL == "" {
+		apiURL = "https://api.openai.com/v1/chat/completions"
+	}
+
+	headers := map[string]string{
+		"Authorization": "Bearer " + settings.AIAPIKey,
+	}
+
+	statusCode, body, err := p.service.postWithRetry(ctx, call, apiURL, jsonData, headers, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if statusCode != http.StatusOK {
+		return nil, fmt.Errorf("API request failed with status %d: %s", statusCode, string(body))
+	}
+
+	var response OpenAIResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	if response.Error != nil {
+		return nil, fmt.Errorf("API error: %s", response.Error.Message)
+	}
+
+	return &response, nil
+}
+
+// TestConnection тестирует подключение к OpenAI
+func (p *OpenAIProvider) TestConnection(settings models.AppSettings) error {
+	apiURL := settings.AIBaseURL
+	if apiURL == "" {
+		apiURL = "https://api.openai.com/v1/models"
+	} else {
+		apiURL = strings.TrimSuffix(apiURL, "/chat/completions") + "/models"
+	}
+
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create test request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+settings.AIAPIKey)
+
+	resp, err := p.service.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to connect to AI API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return fmt.Errorf("invalid API key")
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// ListModels получает список моделей OpenAI
+func (p *OpenAIProvider) ListModels(settings models.AppSettings) ([]models.AIModel, error) {
+	// Если есть API ключ, сначала пытаемся получить актуальный список с API
+	if settings.AIAPIKey != "" {
+		actualModels, err := p.fetchOpenAIModels(settings)
+		if err == nil && len(actualModels) > 0 {
+			log.Printf("Successfully fetched %d models from OpenAI API", len(actualModels))
+			return actualModels, nil
+		}
+		log.Printf("Failed to fetch OpenAI models from API: %v, using static fallback", err)
+	} else {
+		log.Printf("No API key provided, using static model list")
+	}
+
+	// Fallback: статический список актуальных моделей
+	staticModels := []models.AIModel{
+		// O1 Series (новейшие модели рассуждений)
+		{
+			ID:             "o1",
+			Name:           "o1",
+			Description:    "Most advanced reasoning model for complex tasks",
+			MaxTokens:      100000,
+			SupportsVision: true,
+			Provider:       "openai",
+		},
+		{
+			ID:             "o1-mini",
+			Name:           "o1-mini",
+			Description:    "Faster reasoning model for coding and math",
+			MaxTokens:      65536,
+			SupportsVision: true,
+			Provider:       "openai",
+		},
+		{
+			ID:             "o1-preview",
+			Name:           "o1-preview",
+			Description:    "Preview of advanced reasoning capabilities",
+			MaxTokens:      32768,
+			SupportsVision: true,
+			Provider:       "openai",
+		},
+		// GPT-4o Series (latest flagship models)
+		{
+			ID:             "gpt-4o",
+			Name:           "GPT-4o",
+			Description:    "High-intelligence flagship model for complex tasks",
+			MaxTokens:      128000,
+			SupportsVision: true,
+			Provider:       "openai",
+		},
+		{
+			ID:             "gpt-4o-2024-11-20",
+			Name:           "GPT-4o (November 2024)",
+			Description:    "Latest GPT-4o model with improved capabilities",
+			MaxTokens:      128000,
+			SupportsVision: true,
+			Provider:       "openai",
+		},
+		{
+			ID:             "gpt-4o-mini",
+			Name:           "GPT-4o mini",
+			Description:    "Affordable and intelligent small model for fast tasks",
+			MaxTokens:      128000,
+			SupportsVision: true,
+			Provider:       "openai",
+		},
+		{
+			ID:             "gpt-4-turbo",
+			Name:           "GPT-4 Turbo",
+			Description:    "Latest GPT-4 Turbo model with vision",
+			MaxTokens:      128000,
+			SupportsVision: true,
+			Provider:       "openai",
+		},
+	}
+
+	return staticModels, nil
+}
+
+// fetchOpenAIModels получает актуальный список моделей через API OpenAI
+func (p *OpenAIProvider) fetchOpenAIModels(settings models.AppSettings) ([]models.AIModel, error) {
+	apiURL := settings.AIBaseURL
+	if apiURL == "" {
+		apiURL = "https://api.openai.com/v1/models"
+	} else {
+		apiURL = strings.TrimSuffix(apiURL, "/chat/completions") + "/models"
+	}
+
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	req.Header.Set("Authorization", "Bearer "+settings.AIAPIKey)
+
+	resp, err := p.service.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(body))
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+
+	var response struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Object  string `json:"object"`
+			Created int64  `json:"created"`
+		} `json:"data"`
+	}
+
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
+	}
+
+	var aiModels []models.AIModel
+	for _, model := range response.Data {
+		// Фильтруем модели, подходящие для анализа изображений
+		if p.isVisionCapableModel(model.ID) {
+			aiModel := p.convertToAIModel(model.ID)
+			aiModels = append(aiModels, aiModel)
+		}
+	}
+
+	log.Printf("Fetched %d vision-capable models from OpenAI API", len(aiModels))
+	return aiModels, nil
+}
+
+// isVisionCapableModel проверяет, поддерживает ли модель анализ изображений
+func (p *OpenAIProvider) isVisionCapableModel(modelID string) bool {
+	// Исключаем модели, которые точно не подходят для анализа изображений
+	excludePatterns := []string{
+		"dall-e",         // Image generation models
+		"tts-",           // Text-to-speech models
+		"whisper",        // Speech-to-text models
+		"text-embedding", // Embedding models
+		"babbage",        // Legacy completion models
+		"davinci",        // Legacy completion models
+		"moderation",     // Moderation models
+		"instruct",       // Instruction following models (не vision)
+		"realtime",       // Realtime models (audio)
+		"audio",          // Audio models
+		"transcribe",     // Transcription models
+		"computer-use",   // Computer use models
+		"search",         // Search models
+	}
+
+	for _, pattern := range excludePatterns {
+		if strings.Contains(modelID, pattern) {
+			return false
+		}
+	}
+
+	// Включаем модели, которые поддерживают vision
+
+	// O-series (reasoning models with vision) - все новые O модели
+	if strings.HasPrefix(modelID, "o1") || strings.HasPrefix(modelID, "o3") ||
+		strings.HasPrefix(modelID, "o4") {
+		return true
+	}
+
+	// GPT-4.x series (новые модели)
+	if strings.HasPrefix(modelID, "gpt-4.") {
+		return true
+	}
+
+	// GPT-4o series (все варианты поддерживают vision)
+	if strings.Contains(modelID, "gpt-4o") && !strings.Contains(modelID, "audio") &&
+		!strings.Contains(modelID, "realtime") && !strings.Contains(modelID, "transcribe") &&
+		!strings.Contains(modelID, "search") {
+		return true
+	}
+
+	// GPT-4 Turbo models (с vision)
+	if strings.Contains(modelID, "gpt-4") && strings.Contains(modelID, "turbo") {
+		return true
+	}
+
+	// GPT-4 Vision models (explicit vision models)
+	if strings.Contains(modelID, "gpt-4") && strings.Contains(modelID, "vision") {
+		return true
+	}
+
+	// GPT-4 dated models (2024 models обычно поддерживают vision)
+	if strings.Contains(modelID, "gpt-4") && strings.Contains(modelID, "2024") {
+		return true
+	}
+
+	// Классические GPT-4 models (базовые модели с vision)
+	if modelID == "gpt-4" || modelID == "gpt-4-0613" {
+		return true
+	}
+
+	// ChatGPT-4o (специальная модель)
+	if strings.Contains(modelID, "chatgpt-4o") {
+		return true
+	}
+
+	return false
+}
+
+// convertToAIModel преобразует ID модели в структуру AIModel с правильными метаданными
+func (p *OpenAIProvider) convertToAIModel(modelID string) models.AIModel {
+	model := models.AIModel{
+		ID:             modelID,
+		Name:           p.getModelDisplayName(modelID),
+		Description:    p.getModelDescription(modelID),
+		MaxTokens:      p.getModelMaxTokens(modelID),
+		SupportsVision: true,
+		Provider:       "openai",
+	}
+	return model
+}
+
+// getModelDisplayName возвращает красивое название модели
+func (p *OpenAIProvider) getModelDisplayName(modelID string) string {
+	switch {
+	// O-series models
+	case modelID == "o1":
+		return "o1"
+	case modelID == "o1-mini":
+		return "o1-mini"
+	case modelID == "o1-preview":
+		return "o1-preview"
+	case strings.HasPrefix(modelID, "o1-pro"):
+		return "o1-pro (" + p.extractDateFromModel(modelID) + ")"
+	case strings.HasPrefix(modelID, "o1-"):
+		return "o1 (" + p.extractDateFromModel(modelID) + ")"
+	case strings.HasPrefix(modelID, "o3-mini"):
+		return "o3-mini (" + p.extractDateFromModel(modelID) + ")"
+	case strings.HasPrefix(modelID, "o4-mini"):
+		return "o4-mini (" + p.extractDateFromModel(modelID) + ")"
+
+	// GPT-4.x series (новые модели)
+	case strings.HasPrefix(modelID, "gpt-4.5"):
+		return "GPT-4.5 (" + p.extractDateFromModel(modelID) + ")"
+	case strings.HasPrefix(modelID, "gpt-4.1"):
+		if strings.Contains(modelID, "mini") {
+			return "GPT-4.1 mini (" + p.extractDateFromModel(modelID) + ")"
+		} else if strings.Contains(modelID, "nano") {
+			return "GPT-4.1 nano (" + p.extractDateFromModel(modelID) + ")"
+		}
+		return "GPT-4.1 (" + p.extractDateFromModel(modelID) + ")"
+
+	// GPT-4o series
+	case modelID == "gpt-4o":
+		return "GPT-4o"
+	case strings.Contains(modelID, "gpt-4o-mini") && !strings.Contains(modelID, "2024"):
+		return "GPT-4o mini"
+	case strings.Contains(modelID, "gpt-4o-mini"):
+		return "GPT-4o mini (" + p.extractDateFromModel(modelID) + ")"
+	case strings.Contains(modelID, "chatgpt-4o"):
+		return "ChatGPT-4o (" + p.extractDateFromModel(modelID) + ")"
+	case strings.Contains(modelID, "gpt-4o"):
+		return "GPT-4o (" + p.extractDateFromModel(modelID) + ")"
+
+	// GPT-4 Turbo series
+	case strings.Contains(modelID, "gpt-4-turbo") && !strings.Contains(modelID, "2024"):
+		return "GPT-4 Turbo"
+	case strings.Contains(modelID, "gpt-4-turbo"):
+		return "GPT-4 Turbo (" + p.extractDateFromModel(modelID) + ")"
+	case strings.Contains(modelID, "gpt-4") && strings.Contains(modelID, "preview"):
+		return "GPT-4 Turbo Preview (" + p.extractDateFromModel(modelID) + ")"
+
+	// GPT-4 Vision series
+	case strings.Contains(modelID, "gpt-4") && strings.Contains(modelID, "vision"):
+		return "GPT-4 Vision (" + p.extractDateFromModel(modelID) + ")"
+
+	// Classic GPT-4
+	case modelID == "gpt-4":
+		return "GPT-4"
+	case modelID == "gpt-4-0613":
+		return "GPT-4 (June 2023)"
+
+	// Special models
+	case strings.Contains(modelID, "gpt-image"):
+		return "GPT Image (" + p.extractDateFromModel(modelID) + ")"
+	case strings.Contains(modelID, "codex"):
+		return "Codex (" + p.extractDateFromModel(modelID) + ")"
+
+	// GPT-3.5 (включаем только если прошли фильтрацию)
+	case strings.Contains(modelID, "gpt-3.5-turbo") && !strings.Contains(modelID, "instruct"):
+		return "GPT-3.5 Turbo (" + p.extractDateFromModel(modelID) + ")"
+
+	default:
+		return strings.ToUpper(modelID)
+	}
+}
+
+// getModelDescription возвращает описание модели
+func (p *OpenAIProvider) getModelDescription(modelID string) string {
+	switch {
+	// O-series models
+	case modelID == "o1":
+		return "Most advanced reasoning model for complex tasks"
+	case modelID == "o1-mini":
+		return "Faster reasoning model for coding and math"
+	case modelID == "o1-preview":
+		return "Preview of advanced reasoning capabilities"
+	case strings.HasPrefix(modelID, "o1-pro"):
+		return "Professional-grade reasoning model with enhanced capabilities"
+	case strings.HasPrefix(modelID, "o3-mini"):
+		return "Advanced reasoning model, successor to o1-mini"
+	case strings.HasPrefix(modelID, "o4-mini"):
+		return "Next-generation reasoning model with improved performance"
+
+	// GPT-4.x series
+	case strings.HasPrefix(modelID, "gpt-4.5"):
+		return "Enhanced GPT-4 model with improved capabilities"
+	case strings.Contains(modelID, "gpt-4.1-nano"):
+		return "Ultra-lightweight GPT-4.1 model for fast tasks"
+	case strings.Contains(modelID, "gpt-4.1-mini"):
+		return "Compact GPT-4.1 model for efficient processing"
+	case strings.HasPrefix(modelID, "gpt-4.1"):
+		return "Next-generation GPT-4 model with enhanced features"
+
+	// GPT-4o series
+	case modelID == "gpt-4o":
+		return "High-intelligence flagship model for complex tasks"
+	case strings.Contains(modelID, "gpt-4o-mini"):
+		return "Affordable and intelligent small model for fast tasks"
+	case strings.Contains(modelID, "chatgpt-4o"):
+		return "ChatGPT-optimized version of GPT-4o"
+	case strings.Contains(modelID, "gpt-4o"):
+		return "GPT-4o model with vision capabilities and enhanced performance"
+
+	// GPT-4 Turbo series
+	case strings.Contains(modelID, "gpt-4-turbo"):
+		return "GPT-4 Turbo with enhanced capabilities and vision"
+	case strings.Contains(modelID, "gpt-4") && strings.Contains(modelID, "preview"):
+		return "Preview version of GPT-4 Turbo with latest improvements"
+
+	// GPT-4 Vision series
+	case strings.Contains(modelID, "gpt-4") && strings.Contains(modelID, "vision"):
+		return "GPT-4 model with vision capabilities"
+
+	// Classic GPT-4
+	case modelID == "gpt-4" || modelID == "gpt-4-0613":
+		return "Advanced GPT-4 model with multimodal capabilities"
+
+	// Special models
+	case strings.Contains(modelID, "gpt-image"):
+		return "Specialized model for image understanding and generation"
+	case strings.Contains(modelID, "codex"):
+		return "Code-specialized model for programming tasks"
+
+	// GPT-3.5
+	case strings.Contains(modelID, "gpt-3.5-turbo"):
+		return "Fast and efficient model for general tasks"
+
+	default:
+		return fmt.Sprintf("OpenAI %s model with vision support", modelID)
+	}
+}
+
+// openAIModelPrices цены моделей OpenAI в USD за 1M входных и выходных токенов по умолчанию.
+// Модель ищется по самому длинному совпадающему началу ID, как в getModelMaxTokens.
+var openAIModelPrices = []models.AIModelPrice{
+	{Model: "gpt-4o", Provider: "openai", InputPrice: 2.5, OutputPrice: 10},
+	{Model: "gpt-4o-mini", Provider: "openai", InputPrice: 0.15, OutputPrice: 0.6},
+	{Model: "gpt-4.1", Provider: "openai", InputPrice: 2, OutputPrice: 8},
+	{Model: "gpt-4.1-mini", Provider: "openai", InputPrice: 0.4, OutputPrice: 1.6},
+	{Model: "gpt-4.1-nano", Provider: "openai", InputPrice: 0.1, OutputPrice: 0.4},
+	{Model: "gpt-4-turbo", Provider: "openai", InputPrice: 10, OutputPrice: 30},
+	{Model: "o1", Provider: "openai", InputPrice: 15, OutputPrice: 60},
+	{Model: "o1-mini", Provider: "openai", InputPrice: 1.1, OutputPrice: 4.4},
+	{Model: "o4-mini", Provider: "openai", InputPrice: 1.1, OutputPrice: 4.4},
+}
+
+// getModelMaxTokens возвращает максимальное количество токенов для модели
+func (p *OpenAIProvider) getModelMaxTokens(modelID string) int {
+	switch {
+	// O-series models
+	case modelID == "o1":
+		return 100000
+	case modelID == "o1-mini":
+		return 65536
+	case modelID == "o1-preview":
+		return 32768
+	case strings.HasPrefix(modelID, "o1-pro"):
+		return 128000
+	case strings.HasPrefix(modelID, "o3-mini"):
+		return 65536
+	case strings.HasPrefix(modelID, "o4-mini"):
+		return 65536
+
+	// GPT-4.x series
+	case strings.Contains(modelID, "gpt-4.1-nano"):
+		return 32768
+	case strings.Contains(modelID, "gpt-4.1-mini"):
+		return 65536
+	case strings.HasPrefix(modelID, "gpt-4.1") || strings.HasPrefix(modelID, "gpt-4.5"):
+		return 128000
+
+	// GPT-4o series - все имеют 128K контекст
+	case strings.Contains(modelID, "gpt-4o"):
+		return 128000
+
+	// GPT-4 Turbo series - 128K контекст
+	case strings.Contains(modelID, "gpt-4-turbo") ||
+		(strings.Contains(modelID, "gpt-4") && strings.Contains(modelID, "preview")):
+		return 128000
+
+	// GPT-4 Vision models - зависит от версии
+	case strings.Contains(modelID, "gpt-4") && strings.Contains(modelID, "vision"):
+		if strings.Contains(modelID, "preview") {
+			return 4096 // Ранние vision модели
+		}
+		return 128000
+
+	// Classic GPT-4
+	case modelID == "gpt-4":
+		return 8192
+	case modelID == "gpt-4-0613":
+		return 8192
+
+	// Special models
+	case strings.Contains(modelID, "gpt-image"):
+		return 32768
+	case strings.Contains(modelID, "codex"):
+		return 8192
+
+	// GPT-3.5 series
+	case strings.Contains(modelID, "gpt-3.5-turbo"):
+		if strings.Contains(modelID, "16k") {
+			return 16384
+		}
+		return 4096
+
+	default:
+		return 4096 // Консервативная оценка
+	}
+}
+
+// extractDateFromModel извлекает дату из ID модели для отображения
+func (p *OpenAIProvider) extractDateFromModel(modelID string) string {
+	// 2025 dates
+	if strings.Contains(modelID, "2025-04") {
+		return "April 2025"
+	} else if strings.Contains(modelID, "2025-03") {
+		return "March 2025"
+	} else if strings.Contains(modelID, "2025-02") {
+		return "February 2025"
+	} else if strings.Contains(modelID, "2025-01") {
+		return "January 2025"
+	}
+
+	// 2024 dates
+	if strings.Contains(modelID, "2024-12") {
+		return "December 2024"
+	} else if strings.Contains(modelID, "2024-11") {
+		return "November 2024"
+	} else if strings.Contains(modelID, "2024-10") {
+		return "October 2024"
+	} else if strings.Contains(modelID, "2024-09") {
+		return "September 2024"
+	} else if strings.Contains(modelID, "2024-08") {
+		return "August 2024"
+	} else if strings.Contains(modelID, "2024-07") {
+		return "July 2024"
+	} else if strings.Contains(modelID, "2024-06") {
+		return "June 2024"
+	} else if strings.Contains(modelID, "2024-05") {
+		return "May 2024"
+	} else if strings.Contains(modelID, "2024-04") {
+		return "April 2024"
+	} else if strings.Contains(modelID, "2024-03") {
+		return "March 2024"
+	} else if strings.Contains(modelID, "2024-02") {
+		return "February 2024"
+	} else if strings.Contains(modelID, "2024-01") {
+		return "January 2024"
+	}
+
+	// 2023 dates
+	if strings.Contains(modelID, "1106") {
+		return "November 2023"
+	} else if strings.Contains(modelID, "0613") {
+		return "June 2023"
+	} else if strings.Contains(modelID, "0125") {
+		return "January 2024"
+	}
+
+	// Special handling for specific model patterns
+	if strings.Contains(modelID, "latest") {
+		return "latest"
+	}
+
+	return "latest"
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"io"
+	"log"
+	"math/bits"
+	"os"
+	"stock-photo-app/models"
+	"strconv"
+
+	"github.com/disintegration/imaging"
+)
+
+// Виды совпадений фото (models.PhotoDuplicate.Kind)
+const (
+	duplicateExact = "exact" // одинаковый SHA-256 файла
+	duplicateNear  = "near"  // perceptual hash отличается не больше чем на nearDuplicateMaxDistance бит
+)
+
+// nearDuplicateMaxDistance максимальное расстояние Хэмминга между dHash (из 64 бит), при котором фото
+// считаются почти одинаковыми: другой экспорт, ресайз или сжатие того же кадра
+const nearDuplicateMaxDistance = 8
+
+// photoHashes хеши файла фото
+type photoHashes struct {
+	content    string
+	perceptual string
+}
+
+// ComputePhotoHashes вычисляет SHA-256 исходного файла и dHash изображения, если они еще не заданы.
+// dHash считается по миниатюре, если она уже создана, иначе по исходному файлу (для RAW - по превью).
+// Результат кэшируется по пути, размеру и времени изменения файла.
+func (p *ImageProcessor) ComputePhotoHashes(photo *models.Photo) error {
+	if photo.ContentHash != "" && photo.PerceptualHash != "" {
+		return nil
+	}
+
+	info, err := os.Stat(photo.OriginalPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat photo: %w", err)
+	}
+	cacheKey := fmt.Sprintf("%s|%d|%d", photo.OriginalPath, info.Size(), info.ModTime().UnixNano())
+
+	p.mu.RLock()
+	hashes, cached := p.hashCache[cacheKey]
+	p.mu.RUnlock()
+
+	if !cached {
+		hashes.content, err = fileSHA256(photo.OriginalPath)
+		if err != nil {
+			return err
+		}
+
+		var img image.Image
+		_, thumbErr := os.Stat(photo.ThumbnailPath)
+		switch {
+		case photo.ThumbnailPath != "" && thumbErr == nil:
+			img, err = imaging.Open(photo.ThumbnailPath)
+		case IsRawFile(photo.OriginalPath):
+			img, err = openRawPreview(photo.OriginalPath)
+		default:
+			img, err = imaging.Open(photo.OriginalPath)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open image for perceptual hash: %w", err)
+		}
+		hashes.perceptual = fmt.Sprintf("%016x", differenceHash(img))
+
+		p.mu.Lock()
+		if p.hashCache == nil {
+			p.hashCache = make(map[string]photoHashes)
+		}
+		p.hashCache[cacheKey] = hashes
+		p.mu.Unlock()
+	}
+
+	photo.ContentHash = hashes.content
+	photo.PerceptualHash = hashes.perceptual
+	return nil
+}
+
+// fileSHA256 возвращает SHA-256 файла в hex
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to open file for hashing: %w", err)
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", fmt.Errorf("failed to hash file: %w", err)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// differenceHash вычисляет dHash: изображение уменьшается до 9x8 в оттенках серого,
+// каждый бит - ярче ли пиксель соседа справа. Хеш устойчив к ресайзу, сжатию и небольшой цветокоррекции.
+func differenceHash(img image.Image) uint64 {
+	small := imaging.Grayscale(imaging.Resize(img, 9, 8, imaging.Box))
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			left := small.Pix[y*small.Stride+x*4]
+			right := small.Pix[y*small.Stride+(x+1)*4]
+			hash <<= 1
+			if left > right {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// perceptualHashDistance возвращает расстояние Хэмминга между двумя dHash в hex; false - хеш не разобран
+func perceptualHashDistance(a, b string) (int, bool) {
+	x, errA := strconv.ParseUint(a, 16, 64)
+	y, errB := strconv.ParseUint(b, 16, 64)
+	if errA != nil || errB != nil {
+		return 0, false
+	}
+	return bits.OnesCount64(x ^ y), true
+}
+
+// matchPhotoHashes сравнивает хеши двух фото: exact при одинаковом SHA-256, near при близком dHash
+func matchPhotoHashes(photo, candidate models.Photo) (string, int, bool) {
+	if photo.ContentHash != "" && photo.ContentHash == candidate.ContentHash {
+		return duplicateExact, 0, true
+	}
+	if distance, ok := perceptualHashDistance(photo.PerceptualHash, candidate.PerceptualHash); ok && distance <= nearDuplicateMaxDistance {
+		return duplicateNear, distance, true
+	}
+	return "", 0, false
+}
+
+// photoHashCandidate фото базы для поиска дубликатов
+type photoHashCandidate struct {
+	photo       models.Photo // ID, батч, имя файла и хеши
+	perceptual  uint64
+	duplicateOf string // ID фото, дубликатом которого помечено это фото
+	uploaded    bool
+}
+
+// photoHashIndex хеши фото базы в памяти: точные совпадения ищутся по SHA-256,
+// близкие - перебором уже разобранных dHash без обращения к базе
+type photoHashIndex struct {
+	byContent  map[string][]*photoHashCandidate
+	perceptual []*photoHashCandidate
+}
+
+func newPhotoHashIndex() *photoHashIndex {
+	return &photoHashIndex{byContent: make(map[string][]*photoHashCandidate)}
+}
+
+// add добавляет фото в индекс; фото с неразобранным dHash участвуют только в точном сравнении
+func (idx *photoHashIndex) add(photo models.Photo, duplicateOf string, uploaded bool) {
+	candidate := &photoHashCandidate{photo: photo, duplicateOf: duplicateOf, uploaded: uploaded}
+	if photo.ContentHash != "" {
+		idx.byContent[photo.ContentHash] = append(idx.byContent[photo.ContentHash], candidate)
+	}
+	if hash, err := strconv.ParseUint(photo.PerceptualHash, 16, 64); err == nil {
+		candidate.perceptual = hash
+		idx.perceptual = append(idx.perceptual, candidate)
+	}
+}
+
+// findDuplicate возвращает ближайшее совпадение по правилам FindPhotoDuplicate или nil
+func (idx *photoHashIndex) findDuplicate(photo models.Photo, allBatches bool) *models.PhotoDuplicate {
+	eligible := func(candidate *photoHashCandidate) bool {
+		if candidate.photo.ID == photo.ID || (photo.ID != "" && candidate.duplicateOf == photo.ID) {
+			return false
+		}
+		return allBatches || candidate.photo.BatchID == photo.BatchID || candidate.uploaded
+	}
+	found := func(candidate *photoHashCandidate, kind string, distance int) *models.PhotoDuplicate {
+		return &models.PhotoDuplicate{
+			PhotoID:  candidate.photo.ID,
+			BatchID:  candidate.photo.BatchID,
+			FileName: candidate.photo.FileName,
+			Kind:     kind,
+			Distance: distance,
+			Uploaded: candidate.uploaded,
+		}
+	}
+
+	if photo.ContentHash != "" {
+		for _, candidate := range idx.byContent[photo.ContentHash] {
+			if eligible(candidate) {
+				return found(candidate, duplicateExact, 0)
+			}
+		}
+	}
+
+	hash, err := strconv.ParseUint(photo.PerceptualHash, 16, 64)
+	if err != nil {
+		return nil
+	}
+	var best *models.PhotoDuplicate
+	for _, candidate := range idx.perceptual {
+		distance := bits.OnesCount64(hash ^ candidate.perceptual)
+		if distance > nearDuplicateMaxDistance || (best != nil && distance >= best.Distance) || !eligible(candidate) {
+			continue
+		}
+		best = found(candidate, duplicateNear, distance)
+	}
+	return best
+}
+
+// DetectDuplicates вычисляет хеши фото новой папки и помечает в Duplicate совпадения:
+// с фото, идущим раньше в той же папке, и с фото любого батча в базе. Вызывается до AI анализа,
+// чтобы пользователь мог не тратить запросы на дубликаты. Хеши базы загружаются один раз на вызов.
+func (q *QueueManager) DetectDuplicates(photos []models.Photo) {
+	index, err := q.dbService.loadPhotoHashIndex()
+	if err != nil {
+		log.Printf("Warning: failed to load photo hashes, checking only within the folder: %v", err)
+	}
+
+	for i := range photos {
+		photo := &photos[i]
+		if err := q.imageProcessor.ComputePhotoHashes(photo); err != nil {
+			log.Printf("Warning: failed to compute hashes for %s: %v", photo.FileName, err)
+			continue
+		}
+
+		for _, earlier := range photos[:i] {
+			if earlier.ContentHash == "" {
+				continue
+			}
+			kind, distance, ok := matchPhotoHashes(*photo, earlier)
+			if !ok || (photo.Duplicate != nil && distance >= photo.Duplicate.Distance) {
+				continue
+			}
+			// Фото папки еще не сохранены, ссылка только по имени файла
+			photo.Duplicate = &models.PhotoDuplicate{FileName: earlier.FileName, Kind: kind, Distance: distance}
+		}
+		if photo.Duplicate != nil && photo.Duplicate.Kind == duplicateExact {
+			continue
+		}
+
+		if index == nil {
+			continue
+		}
+		if duplicate := index.findDuplicate(*photo, true); duplicate != nil && (photo.Duplicate == nil || duplicate.Distance < photo.Duplicate.Distance) {
+			photo.Duplicate = duplicate
+		}
+	}
+}
+
+// DuplicateMessage описывает найденный дубликат фото для журнала событий
+func DuplicateMessage(photo models.Photo) string {
+	if photo.Duplicate == nil {
+		return ""
+	}
+	target := photo.Duplicate.FileName
+	if photo.Duplicate.Uploaded {
+		target += " (уже загружено на стоки)"
+	}
+	if photo.Duplicate.Kind == duplicateExact {
+		return fmt.Sprintf("Фото %s - точная копия %s", photo.FileName, target)
+	}
+	return fmt.Sprintf("Фото %s похоже на %s (отличие dHash %d бит)", photo.FileName, target, photo.Duplicate.Distance)
+}
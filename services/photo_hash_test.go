@@ -0,0 +1,65 @@
+package services
+
+import (
+	"stock-photo-app/models"
+	"testing"
+)
+
+func TestPhotoHashIndexFindDuplicate(t *testing.T) {
+	index := newPhotoHashIndex()
+	index.add(models.Photo{ID: "a", BatchID: "b1", FileName: "a.jpg", ContentHash: "sha-a", PerceptualHash: "00000000000000ff"}, "", false)
+	index.add(models.Photo{ID: "b", BatchID: "b2", FileName: "b.jpg", ContentHash: "sha-b", PerceptualHash: "000000000000000f"}, "", true)
+	index.add(models.Photo{ID: "c", BatchID: "b2", FileName: "c.jpg", ContentHash: "sha-c", PerceptualHash: "00000000000000fe"}, "new", false)
+	index.add(models.Photo{ID: "d", BatchID: "b3", FileName: "d.jpg", ContentHash: "sha-d", PerceptualHash: "not hex"}, "", false)
+
+	tests := []struct {
+		name       string
+		photo      models.Photo
+		allBatches bool
+		want       *models.PhotoDuplicate
+	}{
+		{
+			name:  "exact match in the same batch",
+			photo: models.Photo{ID: "new", BatchID: "b1", ContentHash: "sha-a", PerceptualHash: "ffffffffffffffff"},
+			want:  &models.PhotoDuplicate{PhotoID: "a", BatchID: "b1", FileName: "a.jpg", Kind: duplicateExact},
+		},
+		{
+			name:       "nearest perceptual match across batches",
+			photo:      models.Photo{ID: "x", BatchID: "b9", ContentHash: "sha-x", PerceptualHash: "00000000000000fc"},
+			allBatches: true,
+			want:       &models.PhotoDuplicate{PhotoID: "c", BatchID: "b2", FileName: "c.jpg", Kind: duplicateNear, Distance: 1},
+		},
+		{
+			name:  "other batches count only when uploaded",
+			photo: models.Photo{ID: "x", BatchID: "b9", ContentHash: "sha-x", PerceptualHash: "000000000000001f"},
+			want:  &models.PhotoDuplicate{PhotoID: "b", BatchID: "b2", FileName: "b.jpg", Kind: duplicateNear, Distance: 1, Uploaded: true},
+		},
+		{
+			name:       "photos marked as duplicates of this photo are skipped",
+			photo:      models.Photo{ID: "new", BatchID: "b2", ContentHash: "sha-c", PerceptualHash: "00000000000000fe"},
+			allBatches: true,
+			want:       &models.PhotoDuplicate{PhotoID: "a", BatchID: "b1", FileName: "a.jpg", Kind: duplicateNear, Distance: 1},
+		},
+		{
+			name:       "exact match ignores an unparsable perceptual hash",
+			photo:      models.Photo{ID: "x", BatchID: "b9", ContentHash: "sha-d"},
+			allBatches: true,
+			want:       &models.PhotoDuplicate{PhotoID: "d", BatchID: "b3", FileName: "d.jpg", Kind: duplicateExact},
+		},
+		{
+			name:       "too far",
+			photo:      models.Photo{ID: "x", BatchID: "b1", ContentHash: "sha-x", PerceptualHash: "ffffffffffff0000"},
+			allBatches: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := index.findDuplicate(tt.photo, tt.allBatches)
+			switch {
+			case got == nil && tt.want == nil:
+			case got == nil || tt.want == nil || *got != *tt.want:
+				t.Errorf("findDuplicate = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"os"
+	"path/filepath"
+	"stock-photo-app/models"
+	"stock-photo-app/uploaders"
+	"strings"
+)
+
+// Расширения обработанных изображений, которые можно анализировать и загружать на стоки
+var renditionImageKinds = map[string]string{
+	".jpg":  uploaders.RenditionJPEG,
+	".jpeg": uploaders.RenditionJPEG,
+	".tif":  uploaders.RenditionTIFF,
+	".tiff": uploaders.RenditionTIFF,
+	".png":  uploaders.RenditionImage,
+}
+
+// Порядок выбора файла для анализа: JPEG быстрее декодируется и показывает кадр так, как его видит сток
+var primaryRenditionOrder = []string{uploaders.RenditionJPEG, uploaders.RenditionTIFF, uploaders.RenditionImage, uploaders.RenditionRAW}
+
+// renditionKind определяет вид файла фото по расширению; пустая строка - файл не относится к фото
+func renditionKind(path string) string {
+	ext := strings.ToLower(filepath.Ext(path))
+	switch {
+	case renditionImageKinds[ext] != "":
+		return renditionImageKinds[ext]
+	case IsRawFile(path):
+		return uploaders.RenditionRAW
+	case ext == ".xmp":
+		return uploaders.RenditionXMP
+	case ext == ".pdf":
+		return uploaders.RenditionRelease
+	}
+	return ""
+}
+
+// renditionGroupKey возвращает ключ, по которому файлы объединяются в одно фото: папка и имя без расширения
+// без учета регистра. У sidecar вида IMG_0001.CR3.xmp (darktable) отбрасываются оба расширения.
+func renditionGroupKey(path string) string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	if strings.EqualFold(filepath.Ext(path), ".xmp") {
+		if inner := filepath.Ext(base); renditionImageKinds[strings.ToLower(inner)] != "" || rawExtensions[strings.ToLower(inner)] {
+			base = strings.TrimSuffix(base, inner)
+		}
+	}
+	return filepath.Join(filepath.Dir(path), strings.ToLower(base))
+}
+
+// fileRendition описывает файл фото, размер берется с диска
+func fileRendition(path string) models.PhotoRendition {
+	rendition := models.PhotoRendition{
+		Kind:     renditionKind(path),
+		Path:     path,
+		FileName: filepath.Base(path),
+	}
+	if info, err := os.Stat(path); err == nil {
+		rendition.FileSize = info.Size()
+	}
+	return rendition
+}
+
+// primaryRendition выбирает файл, по которому анализируется фото. false - в группе нет изображений
+// (только XMP или PDF), такая группа фото не является.
+func primaryRendition(renditions []models.PhotoRendition) (models.PhotoRendition, bool) {
+	for _, kind := range primaryRenditionOrder {
+		for _, rendition := range renditions {
+			if rendition.Kind == kind {
+				return rendition, true
+			}
+		}
+	}
+	return models.PhotoRendition{}, false
+}
+
+// photoRenditions возвращает файлы фото; для фото, сохраненных до появления списка, - один исходный файл
+func photoRenditions(photo models.Photo) []models.PhotoRendition {
+	if len(photo.Renditions) > 0 || photo.OriginalPath == "" {
+		return photo.Renditions
+	}
+	rendition := fileRendition(photo.OriginalPath)
+	if rendition.FileSize == 0 {
+		rendition.FileSize = photo.FileSize
+	}
+	if rendition.Kind == "" {
+		rendition.Kind = uploaders.RenditionImage
+	}
+	return []models.PhotoRendition{rendition}
+}
+
+// MetadataTargets возвращает файлы фото, в которые записываются метаданные при writeOriginals:
+// все изображения группы (для RAW - его XMP sidecar), файл анализа последним
+func MetadataTargets(photo models.Photo) []string {
+	var targets []string
+	for _, rendition := range photoRenditions(photo) {
+		switch rendition.Kind {
+		case uploaders.RenditionXMP, uploaders.RenditionRelease:
+			continue
+		}
+		if rendition.Path != photo.OriginalPath {
+			targets = append(targets, rendition.Path)
+		}
+	}
+	return append(targets, photo.OriginalPath)
+}
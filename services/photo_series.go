@@ -0,0 +1,347 @@
+package services
+
+import (
+	"fmt"
+	"image"
+	"log"
+	"math"
+	"sort"
+	"stock-photo-app/models"
+	"sync"
+	"time"
+
+	"github.com/disintegration/imaging"
+)
+
+// Параметры группировки серий: соседние кадры сняты не дальше seriesMaxGap друг от друга
+// и их dHash отличается не больше чем на seriesMaxDistance бит (в серии объект может сдвигаться)
+const (
+	seriesMaxGap        = 2 * time.Second
+	seriesMaxDistance   = 12
+	exifDateTimeLayout  = "2006:01:02 15:04:05"
+	qualityAnalysisSize = 512 // оценка качества по изображению не больше 512px
+	qualityClipLow      = 5   // яркость провалившихся в черное пикселей
+	qualityClipHigh     = 250 // яркость пересвеченных пикселей
+	// sharpnessHalfScore дисперсия лапласиана, при которой резкость оценивается в половину шкалы
+	sharpnessHalfScore = 300.0
+)
+
+// QualityScore оценивает кадр от 0 до 100 по резкости (дисперсия лапласиана) и экспозиции
+// (доля пересвеченных и провалившихся в черное пикселей, отклонение средней яркости от середины).
+// Оценка нужна для сравнения кадров одной серии, а не как абсолютная мера качества.
+func (p *ImageProcessor) QualityScore(imagePath string) (float64, error) {
+	var img image.Image
+	var err error
+	if IsRawFile(imagePath) {
+		img, err = openRawPreview(imagePath)
+	} else {
+		img, err = imaging.Open(imagePath)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to open image for quality score: %w", err)
+	}
+
+	gray := imaging.Grayscale(imaging.Fit(img, qualityAnalysisSize, qualityAnalysisSize, imaging.Box))
+	width, height := gray.Bounds().Dx(), gray.Bounds().Dy()
+	if width < 3 || height < 3 {
+		return 0, fmt.Errorf("image is too small for quality score")
+	}
+	luma := func(x, y int) float64 {
+		return float64(gray.Pix[y*gray.Stride+x*4])
+	}
+
+	var lapSum, lapSumSq, lumaSum float64
+	clipped := 0
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			value := luma(x, y)
+			lumaSum += value
+			if value <= qualityClipLow || value >= qualityClipHigh {
+				clipped++
+			}
+			if x == 0 || y == 0 || x == width-1 || y == height-1 {
+				continue
+			}
+			laplacian := 4*value - luma(x-1, y) - luma(x+1, y) - luma(x, y-1) - luma(x, y+1)
+			lapSum += laplacian
+			lapSumSq += laplacian * laplacian
+		}
+	}
+
+	inner := float64((width - 2) * (height - 2))
+	total := float64(width * height)
+	variance := lapSumSq/inner - (lapSum/inner)*(lapSum/inner)
+	sharpness := variance / (variance + sharpnessHalfScore)
+	exposure := (1 - float64(clipped)/total) * (1 - math.Abs(lumaSum/total-128)/256)
+
+	return math.Round(sharpness*exposure*1000) / 10, nil
+}
+
+// photoCaptureTime разбирает время съемки фото (DateTimeOriginal)
+func photoCaptureTime(photo models.Photo) (time.Time, bool) {
+	captured, err := time.Parse(exifDateTimeLayout, photo.CapturedAt)
+	return captured, err == nil
+}
+
+// GroupPhotoSeries объединяет в серии кадры, снятые подряд одной камерой и почти одинаковые по dHash.
+// Кадры без времени съемки или без perceptual hash в серии не входят. Фото получают SeriesID,
+// у каждой серии выбирается лучший кадр по QualityScore.
+func GroupPhotoSeries(batchID string, photos []models.Photo) []models.PhotoSeries {
+	var order []int
+	for i := range photos {
+		photos[i].SeriesID = ""
+		if _, ok := photoCaptureTime(photos[i]); ok && photos[i].PerceptualHash != "" {
+			order = append(order, i)
+		}
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ta, _ := photoCaptureTime(photos[order[a]])
+		tb, _ := photoCaptureTime(photos[order[b]])
+		if !ta.Equal(tb) {
+			return ta.Before(tb)
+		}
+		return photos[order[a]].FileName < photos[order[b]].FileName
+	})
+
+	var groups [][]int
+	for n, i := range order {
+		if n > 0 && sameSeries(photos[order[n-1]], photos[i]) {
+			groups[len(groups)-1] = append(groups[len(groups)-1], i)
+			continue
+		}
+		groups = append(groups, []int{i})
+	}
+
+	var series []models.PhotoSeries
+	for _, group := range groups {
+		if len(group) < 2 {
+			continue
+		}
+		item := models.PhotoSeries{
+			ID:        fmt.Sprintf("series_%d_%s", time.Now().UnixNano(), photos[group[0]].FileName),
+			BatchID:   batchID,
+			CreatedAt: time.Now(),
+		}
+		for _, i := range group {
+			photos[i].SeriesID = item.ID
+			item.Frames = append(item.Frames, seriesFrame(photos[i]))
+		}
+		item.BestPhotoID = bestSeriesFrame(item.Frames)
+		series = append(series, item)
+	}
+	return series
+}
+
+// sameSeries сообщает, что кадр next продолжает серию кадра prev
+func sameSeries(prev, next models.Photo) bool {
+	prevTime, _ := photoCaptureTime(prev)
+	nextTime, _ := photoCaptureTime(next)
+	if nextTime.Sub(prevTime) > seriesMaxGap {
+		return false
+	}
+	if prev.ExifData["Model"] != next.ExifData["Model"] {
+		return false
+	}
+	distance, ok := perceptualHashDistance(prev.PerceptualHash, next.PerceptualHash)
+	return ok && distance <= seriesMaxDistance
+}
+
+// seriesFrame описывает фото как кадр серии
+func seriesFrame(photo models.Photo) models.SeriesFrame {
+	return models.SeriesFrame{
+		PhotoID:      photo.ID,
+		FileName:     photo.FileName,
+		CapturedAt:   photo.CapturedAt,
+		QualityScore: photo.QualityScore,
+		Status:       photo.Status,
+	}
+}
+
+// bestSeriesFrame возвращает кадр с наибольшей оценкой качества, при равенстве - более ранний
+func bestSeriesFrame(frames []models.SeriesFrame) string {
+	best := ""
+	bestScore := -1.0
+	for _, frame := range frames {
+		if frame.QualityScore > bestScore {
+			best, bestScore = frame.PhotoID, frame.QualityScore
+		}
+	}
+	return best
+}
+
+// preparePhoto создает миниатюру, извлекает EXIF, вычисляет хеши, ищет дубликат и оценивает качество кадра
+func (q *QueueManager) preparePhoto(photo *models.Photo, settings models.AppSettings, job *ProcessingJob) error {
+	log.Printf("Step 1: Preparing photo %s for AI", photo.FileName)
+	q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_processing", "progress",
+		fmt.Sprintf("Подготовка фото %s для AI анализа", photo.FileName), "", 10)
+
+	// Обновляем прогресс в job
+	q.updatePhotoProgress(job, photo.ID, func(photoInfo *models.PhotoProcessInfo) {
+		photoInfo.Step = "preparation"
+		photoInfo.Progress = 10
+	})
+
+	err := q.imageProcessor.ProcessPhotoForAI(photo, settings.ThumbnailSize)
+	if err != nil {
+		log.Printf("Failed to prepare photo %s for AI: %v", photo.FileName, err)
+		q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_processing", "failed",
+			fmt.Sprintf("Ошибка подготовки фото %s", photo.FileName), err.Error(), 0)
+		return fmt.Errorf("failed to prepare photo for AI: %w", err)
+	}
+	log.Printf("Photo %s prepared for AI successfully", photo.FileName)
+
+	// Сохраняем thumbnail path в базе данных
+	if photo.ThumbnailPath != "" {
+		err = q.dbService.UpdatePhotoThumbnail(photo.ID, photo.ThumbnailPath)
+		if err != nil {
+			log.Printf("Warning: failed to save thumbnail path for %s: %v", photo.FileName, err)
+		}
+	}
+
+	// Сохраняем хеши и помечаем дубликат фото из этого батча или уже загруженного на стоки.
+	// Обработка дубликата продолжается, решение принимает пользователь при ревью.
+	if photo.ContentHash != "" {
+		duplicate, err := q.dbService.RecordPhotoDuplicate(*photo)
+		if err != nil {
+			log.Printf("Warning: failed to check duplicates for %s: %v", photo.FileName, err)
+		} else if duplicate != nil {
+			photo.Duplicate = duplicate
+			q.dbService.LogEvent(photo.BatchID, photo.ID, "duplicate_check", "warning",
+				DuplicateMessage(*photo), "", 10)
+		}
+	}
+
+	// Время съемки и оценка качества для группировки серий
+	photo.CapturedAt = photo.ExifData["DateTimeOriginal"]
+	photo.QualityScore, err = q.imageProcessor.QualityScore(photo.ThumbnailPath)
+	if err != nil {
+		log.Printf("Warning: failed to score quality of %s: %v", photo.FileName, err)
+	}
+
+	return nil
+}
+
+// prepareBatchPhotos подготавливает все фото батча параллельно; возвращает ошибки подготовки по ID фото
+func (q *QueueManager) prepareBatchPhotos(photos []models.Photo, settings models.AppSettings, job *ProcessingJob, numWorkers int) map[string]error {
+	errs := make(map[string]error)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, numWorkers)
+
+	for i := range photos {
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(photo *models.Photo) {
+			defer wg.Done()
+			defer func() { <-semaphore }()
+
+			if err := q.preparePhoto(photo, settings, job); err != nil {
+				mu.Lock()
+				errs[photo.ID] = err
+				mu.Unlock()
+			}
+		}(&photos[i])
+	}
+	wg.Wait()
+
+	return errs
+}
+
+// detectBatchSeries группирует подготовленные фото батча в серии и сохраняет их.
+// При SeriesBestOnly возвращает ID кадров, которые не отправляются в AI (все кроме лучших).
+func (q *QueueManager) detectBatchSeries(batchID string, photos []models.Photo, prepared func(models.Photo) bool, settings models.AppSettings) map[string]bool {
+	var candidates []models.Photo
+	for _, photo := range photos {
+		if prepared(photo) {
+			candidates = append(candidates, photo)
+		}
+	}
+
+	series := GroupPhotoSeries(batchID, candidates)
+	if err := q.dbService.SaveBatchSeries(batchID, candidates, series); err != nil {
+		log.Printf("Warning: failed to save series for batch %s: %v", batchID, err)
+		return nil
+	}
+	if len(series) == 0 {
+		return nil
+	}
+
+	framesCount := 0
+	for _, item := range series {
+		framesCount += len(item.Frames)
+	}
+	q.dbService.LogEvent(batchID, "", "series_detected", "success",
+		fmt.Sprintf("Найдено серий: %d (кадров: %d)", len(series), framesCount), "", 0)
+
+	if !settings.SeriesBestOnly {
+		return nil
+	}
+	siblings := make(map[string]bool)
+	for _, item := range series {
+		for _, frame := range item.Frames {
+			if frame.PhotoID != item.BestPhotoID {
+				siblings[frame.PhotoID] = true
+			}
+		}
+	}
+	return siblings
+}
+
+// PropagateSeriesMetadata копирует метаданные лучшего кадра серии, включая метаданные для стоков, остальным кадрам
+// и возвращает число обновленных кадров. При writeOriginals метаданные записываются и в исходные файлы кадров.
+func (q *QueueManager) PropagateSeriesMetadata(seriesID string, settings models.AppSettings) (int, error) {
+	series, err := q.dbService.GetPhotoSeries(seriesID)
+	if err != nil {
+		return 0, err
+	}
+	photos, err := q.dbService.getSeriesPhotos(seriesID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get series photos: %w", err)
+	}
+
+	var best *models.Photo
+	for i := range photos {
+		if photos[i].ID == series.BestPhotoID {
+			best = &photos[i]
+		}
+	}
+	if best == nil || best.AIResult == nil || best.AIResult.Title == "" {
+		return 0, fmt.Errorf("у лучшего кадра серии нет метаданных для копирования")
+	}
+
+	updated := 0
+	for i := range photos {
+		photo := &photos[i]
+		if photo.ID == best.ID {
+			continue
+		}
+
+		// У каждого кадра свой файл, поэтому меняются только метаданные
+		aiResult := *best.AIResult
+		if err := q.dbService.UpdatePhotoAIResults(photo.ID, aiResult); err != nil {
+			return updated, fmt.Errorf("failed to save metadata for %s: %w", photo.FileName, err)
+		}
+		if err := q.dbService.UpdatePhotoStockAIResults(photo.ID, best.StockAIResults); err != nil {
+			return updated, fmt.Errorf("failed to save stock metadata for %s: %w", photo.FileName, err)
+		}
+		if settings.WriteOriginals {
+			q.writeOriginalMetadata(photo, aiResult)
+		}
+
+		q.dbService.LogEvent(photo.BatchID, photo.ID, "series_propagate", "success",
+			fmt.Sprintf("Метаданные скопированы с кадра %s", best.FileName), "", 100)
+		updated++
+	}
+
+	return updated, nil
+}
+
+// containsFrame сообщает, что фото входит в список кадров
+func containsFrame(frames []models.SeriesFrame, photoID string) bool {
+	for _, frame := range frames {
+		if frame.PhotoID == photoID {
+			return true
+		}
+	}
+	return false
+}
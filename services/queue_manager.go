@@ -1,10 +1,13 @@
 package services
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"path/filepath"
 	"stock-photo-app/models"
 	"sync"
 	"time"
@@ -22,6 +25,12 @@ type QueueManager struct {
 	maxConcurrentJobs int
 	isProcessing      bool
 	processingMutex   sync.Mutex
+	pauseReason       string                              // причина остановки по лимиту AI
+	emitEvent         func(name string, data interface{}) // отправка событий в UI, nil - события не отправляются
+	// cancel отменяет контекст текущего запуска очереди: StopProcessing прерывает запросы к AI
+	cancel     context.CancelFunc
+	processCtx context.Context
+	runs       sync.WaitGroup // запущенные циклы processQueue, их ждет Shutdown
 }
 
 // ProcessingJob представляет активную задачу обработки
@@ -43,7 +52,7 @@ type photoResult struct {
 
 // NewQueueManager создает новый менеджер очередей
 func NewQueueManager(db *sql.DB, dbService *DatabaseService, aiService *AIService, imageProcessor *ImageProcessor) *QueueManager {
-	return &QueueManager{
+	q := &QueueManager{
 		db:             db,
 		dbService:      dbService,
 		aiService:      aiService,
@@ -52,6 +61,49 @@ func NewQueueManager(db *sql.DB, dbService *DatabaseService, aiService *AIServic
 		activeJobs:     make(map[string]*ProcessingJob),
 		jobsMutex:      sync.RWMutex{},
 	}
+	aiService.SetRateLimitWaitHandler(q.rateLimitWait)
+	return q
+}
+
+// rateLimitWait показывает в прогрессе фото ожидание лимита AI провайдера и
+// возвращает функцию, которая после ожидания восстанавливает прежний шаг
+func (q *QueueManager) rateLimitWait(photo models.Photo, wait time.Duration) func() {
+	q.dbService.LogEvent(photo.BatchID, photo.ID, "rate_limit_wait", "waiting",
+		fmt.Sprintf("Фото %s ждет лимита запросов AI провайдера", photo.FileName),
+		fmt.Sprintf("Ожидание около %s", wait.Round(time.Second)), 30)
+
+	q.jobsMutex.Lock()
+	defer q.jobsMutex.Unlock()
+	job, exists := q.activeJobs[photo.BatchID]
+	if !exists {
+		return nil
+	}
+	photoInfo, exists := job.PhotoProgress[photo.ID]
+	if !exists {
+		return nil
+	}
+	previousStep := photoInfo.Step
+	photoInfo.Step = rateLimitWaitStep
+	job.PhotoProgress[photo.ID] = photoInfo
+
+	return func() {
+		q.jobsMutex.Lock()
+		defer q.jobsMutex.Unlock()
+		if photoInfo, exists := job.PhotoProgress[photo.ID]; exists && photoInfo.Step == rateLimitWaitStep {
+			photoInfo.Step = previousStep
+			job.PhotoProgress[photo.ID] = photoInfo
+		}
+	}
+}
+
+// updatePhotoProgress изменяет прогресс фото в job под jobsMutex: прогресс пишут worker'ы подготовки и AI параллельно
+func (q *QueueManager) updatePhotoProgress(job *ProcessingJob, photoID string, update func(photoInfo *models.PhotoProcessInfo)) {
+	q.jobsMutex.Lock()
+	defer q.jobsMutex.Unlock()
+	if photoInfo, exists := job.PhotoProgress[photoID]; exists {
+		update(&photoInfo)
+		job.PhotoProgress[photoID] = photoInfo
+	}
 }
 
 // StartProcessing запускает обработку очереди
@@ -69,21 +121,62 @@ func (q *QueueManager) StartProcessing(settings models.AppSettings) error {
 	}
 
 	q.isProcessing = true
-	go q.processQueue(settings)
+	q.pauseReason = ""
+
+	// После остановки по лимиту AI батч еще может дообрабатываться в прежнем контексте,
+	// поэтому новый контекст создается только после StopProcessing
+	if q.cancel == nil {
+		q.processCtx, q.cancel = context.WithCancel(context.Background())
+	}
+	q.runs.Add(1)
+	go func(ctx context.Context) {
+		defer q.runs.Done()
+		q.processQueue(ctx, settings)
+	}(q.processCtx)
 
 	log.Printf("Queue processing started with %d concurrent jobs", q.maxConcurrentJobs)
 	return nil
 }
 
-// StopProcessing останавливает обработку очереди
+// SetEventEmitter задает функцию отправки событий в UI (queue:paused)
+func (q *QueueManager) SetEventEmitter(emit func(name string, data interface{})) {
+	q.emitEvent = emit
+}
+
+// StopProcessing останавливает обработку очереди. Запросы к AI прерываются,
+// незавершенные фото возвращаются в pending, а батч встает на паузу.
 func (q *QueueManager) StopProcessing() {
 	q.processingMutex.Lock()
 	defer q.processingMutex.Unlock()
 
 	q.isProcessing = false
+	if q.cancel != nil {
+		q.cancel()
+		q.cancel = nil
+		q.processCtx = nil
+	}
 	log.Println("Queue processing stopped")
 }
 
+// Shutdown останавливает обработку и ждет, пока прерванные батчи сохранят состояние,
+// но не дольше дедлайна ctx
+func (q *QueueManager) Shutdown(ctx context.Context) error {
+	q.StopProcessing()
+
+	done := make(chan struct{})
+	go func() {
+		q.runs.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("queue processing did not stop in time: %w", ctx.Err())
+	}
+}
+
 // AddBatch добавляет батч в очередь обработки
 func (q *QueueManager) AddBatch(batch models.PhotoBatch) error {
 	// Обновляем статус батча на "queued"
@@ -118,25 +211,25 @@ func (q *QueueManager) AddBatch(batch models.PhotoBatch) error {
 	return nil
 }
 
-// processQueue основной цикл обработки очереди
-func (q *QueueManager) processQueue(settings models.AppSettings) {
-	for q.isProcessing {
+// processQueue основной цикл обработки очереди; завершается при остановке или отмене ctx
+func (q *QueueManager) processQueue(ctx context.Context, settings models.AppSettings) {
+	for q.isProcessing && ctx.Err() == nil {
 		// Получаем следующий батч для обработки
 		batch, err := q.getNextBatch()
 		if err != nil {
 			log.Printf("Error getting next batch: %v", err)
-			time.Sleep(10 * time.Second)
+			waitContext(ctx, 10*time.Second)
 			continue
 		}
 
 		if batch == nil {
 			// Нет батчей для обработки, ждем
-			time.Sleep(5 * time.Second)
+			waitContext(ctx, 5*time.Second)
 			continue
 		}
 
 		// Обрабатываем батч
-		err = q.processBatch(*batch, settings)
+		err = q.processBatch(ctx, *batch, settings)
 		if err != nil {
 			log.Printf("Error processing batch %s: %v", batch.ID, err)
 			q.updateBatchStatus(batch.ID, "failed", err.Error())
@@ -188,8 +281,20 @@ func (q *QueueManager) getNextBatch() (*models.PhotoBatch, error) {
 	return &batch, nil
 }
 
-// processBatch обрабатывает один батч
-func (q *QueueManager) processBatch(batch models.PhotoBatch, settings models.AppSettings) error {
+// waitContext ждет d или отмены ctx
+func waitContext(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+}
+
+// processBatch обрабатывает один батч. При отмене ctx начатые запросы к AI прерываются,
+// незавершенные фото возвращаются в pending, а батч встает на паузу до продолжения.
+func (q *QueueManager) processBatch(ctx context.Context, batch models.PhotoBatch, settings models.AppSettings) error {
 	log.Printf("Starting processing batch %s with %d photos", batch.ID, len(batch.Photos))
 	log.Printf("DEBUG: Photos in batch %s:", batch.ID)
 	for i, photo := range batch.Photos {
@@ -247,34 +352,78 @@ func (q *QueueManager) processBatch(batch models.PhotoBatch, settings models.App
 
 	log.Printf("Starting parallel processing with %d workers for %d photos", numWorkers, len(batch.Photos))
 
+	// Шаг 1 для всех фото сразу: миниатюры, EXIF, хеши и оценка качества нужны для группировки серий до AI
+	job.CurrentStep = "preparation"
+	prepareErrors := q.prepareBatchPhotos(batch.Photos, settings, job, numWorkers)
+	seriesSiblings := q.detectBatchSeries(batch.ID, batch.Photos, func(photo models.Photo) bool {
+		return prepareErrors[photo.ID] == nil
+	}, settings)
+	job.CurrentStep = "ai_processing"
+
 	photoChannel := make(chan models.Photo, len(batch.Photos))
 	resultChannel := make(chan photoResult, len(batch.Photos))
 
-	// Заполняем канал фотографиями
+	// Заполняем канал фотографиями. Неподготовленные фото сразу попадают в результаты с ошибкой,
+	// кадры серий (кроме лучших) при SeriesBestOnly получат метаданные лучшего кадра после AI.
+	// Фото, обработанные до остановки по лимиту AI, при продолжении батча повторно не анализируются.
+	expectedResults := 0
 	for _, photo := range batch.Photos {
-		photoChannel <- photo
+		switch {
+		case photo.Status == "processed" && photo.AIResult != nil && !seriesSiblings[photo.ID]:
+			processedCount++
+			q.updatePhotoProgress(job, photo.ID, func(photoInfo *models.PhotoProcessInfo) {
+				photoInfo.Status = "completed"
+				photoInfo.Progress = 100
+				photoInfo.Step = "completed"
+			})
+		case prepareErrors[photo.ID] != nil:
+			resultChannel <- photoResult{photo: photo, err: prepareErrors[photo.ID]}
+			expectedResults++
+		case seriesSiblings[photo.ID]:
+			q.updatePhotoProgress(job, photo.ID, func(photoInfo *models.PhotoProcessInfo) {
+				photoInfo.Step = "series"
+			})
+		default:
+			photoChannel <- photo
+			expectedResults++
+		}
 	}
 	close(photoChannel)
 
 	// Запускаем worker'ов
 	for i := 0; i < numWorkers; i++ {
-		go q.photoWorker(i, photoChannel, resultChannel, batch.ID, batch.Description, batch.Type, settings, job)
-	}
-
-	// Собираем результаты
-	for i := 0; i < len(batch.Photos); i++ {
-		// Проверяем не остановлена ли обработка
-		q.processingMutex.Lock()
-		if !q.isProcessing {
-			q.processingMutex.Unlock()
-			log.Printf("Processing stopped, batch %s interrupted", batch.ID)
-			q.dbService.LogEvent(batch.ID, "", "batch_interrupted", "failed",
-				"Обработка прервана пользователем", "", processedCount*100/len(batch.Photos))
-			return fmt.Errorf("processing was stopped")
-		}
-		q.processingMutex.Unlock()
+		go q.photoWorker(ctx, i, photoChannel, resultChannel, batch.ID, batch.Description, batch.Type, settings, job)
+	}
 
+	// Собираем результаты. При остановке результаты дочитываются: worker'ы прерывают запросы
+	// к AI и возвращают оставшиеся фото без обработки, при лимите AI - без запроса к AI.
+	budgetPaused := 0
+	interrupted := 0
+	for i := 0; i < expectedResults; i++ {
 		result := <-resultChannel
+
+		// Обработка прервана остановкой очереди: фото возвращается в pending, а не в failed
+		if errors.Is(result.err, context.Canceled) || errors.Is(result.err, context.DeadlineExceeded) {
+			interrupted++
+			q.updatePhotoStatus(result.photo.ID, "pending", "")
+			q.updatePhotoProgress(job, result.photo.ID, func(photoInfo *models.PhotoProcessInfo) {
+				photoInfo.Status = "pending"
+				photoInfo.Progress = 0
+				photoInfo.Step = "waiting"
+			})
+			continue
+		}
+
+		// Фото не отправлялось в AI из-за лимита и остается pending до продолжения батча
+		if errors.Is(result.err, errAIBudgetExceeded) {
+			budgetPaused++
+			q.updatePhotoProgress(job, result.photo.ID, func(photoInfo *models.PhotoProcessInfo) {
+				photoInfo.Status = "pending"
+				photoInfo.Step = "budget_paused"
+			})
+			continue
+		}
+
 		processedCount++
 
 		if result.err != nil {
@@ -287,11 +436,10 @@ func (q *QueueManager) processBatch(batch models.PhotoBatch, settings models.App
 			q.updatePhotoStatus(result.photo.ID, "failed", result.err.Error())
 
 			// Обновляем статус в job
-			if photoInfo, exists := job.PhotoProgress[result.photo.ID]; exists {
+			q.updatePhotoProgress(job, result.photo.ID, func(photoInfo *models.PhotoProcessInfo) {
 				photoInfo.Status = "failed"
 				photoInfo.Error = result.err.Error()
-				job.PhotoProgress[result.photo.ID] = photoInfo
-			}
+			})
 		} else {
 			// Логируем успех
 			q.dbService.LogEvent(batch.ID, result.photo.ID, "ai_processing", "success",
@@ -301,12 +449,11 @@ func (q *QueueManager) processBatch(batch models.PhotoBatch, settings models.App
 			q.updatePhotoStatus(result.photo.ID, "processed", "")
 
 			// Обновляем статус в job
-			if photoInfo, exists := job.PhotoProgress[result.photo.ID]; exists {
+			q.updatePhotoProgress(job, result.photo.ID, func(photoInfo *models.PhotoProcessInfo) {
 				photoInfo.Status = "completed"
 				photoInfo.Progress = 100
 				photoInfo.Step = "completed"
-				job.PhotoProgress[result.photo.ID] = photoInfo
-			}
+			})
 		}
 
 		// Обновляем общий прогресс
@@ -319,6 +466,33 @@ func (q *QueueManager) processBatch(batch models.PhotoBatch, settings models.App
 		log.Printf("Photo %s marked as processed. Total processed: %d/%d", result.photo.FileName, processedCount, len(batch.Photos))
 	}
 
+	if interrupted > 0 {
+		job.Status = "paused"
+		job.CurrentStep = "interrupted"
+		q.updateBatchStatus(batch.ID, "paused", "")
+		q.dbService.LogEvent(batch.ID, "", "batch_interrupted", "paused",
+			fmt.Sprintf("Обработка прервана пользователем. Обработано: %d/%d фотографий, ожидают: %d", processedCount, len(batch.Photos), interrupted+budgetPaused),
+			"Продолжите обработку батча", processedCount*100/len(batch.Photos))
+		log.Printf("Processing stopped, batch %s interrupted with %d photos returned to pending", batch.ID, interrupted)
+		return nil
+	}
+
+	if budgetPaused > 0 {
+		job.Status = "paused"
+		job.CurrentStep = "budget_paused"
+		q.updateBatchStatus(batch.ID, "paused", "")
+		q.dbService.LogEvent(batch.ID, "", "batch_paused", "paused",
+			fmt.Sprintf("Обработка батча приостановлена по лимиту AI. Обработано: %d/%d фотографий, ожидают: %d", processedCount, len(batch.Photos), budgetPaused),
+			q.PauseReason(), processedCount*100/len(batch.Photos))
+		log.Printf("Batch %s paused by AI budget with %d photos left", batch.ID, budgetPaused)
+		return nil
+	}
+
+	// Кадры серий получают метаданные лучшего кадра
+	if len(seriesSiblings) > 0 {
+		processedCount += q.propagateBatchSeries(batch.ID, settings, job)
+	}
+
 	// Завершаем обработку батча
 	job.Progress = 100
 	job.Status = "completed"
@@ -333,58 +507,36 @@ func (q *QueueManager) processBatch(batch models.PhotoBatch, settings models.App
 	return nil
 }
 
-// processPhoto обрабатывает одно фото
-func (q *QueueManager) processPhoto(photo *models.Photo, batchDescription string, contentType string, settings models.AppSettings, job *ProcessingJob) error {
+// processPhoto обрабатывает одно фото. Ошибка отмены ctx означает, что фото не обработано
+// до конца и должно вернуться в pending.
+func (q *QueueManager) processPhoto(ctx context.Context, photo *models.Photo, batchDescription string, contentType string, settings models.AppSettings, job *ProcessingJob) error {
 	log.Printf("Starting to process photo %s (content type: %s)", photo.FileName, contentType)
 
-	// Шаг 1: Подготавливаем фото для AI (создаем миниатюру, извлекаем EXIF)
-	log.Printf("Step 1: Preparing photo %s for AI", photo.FileName)
-	q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_processing", "progress",
-		fmt.Sprintf("Подготовка фото %s для AI анализа", photo.FileName), "", 10)
-
-	// Обновляем прогресс в job
-	if photoInfo, exists := job.PhotoProgress[photo.ID]; exists {
-		photoInfo.Step = "preparation"
-		photoInfo.Progress = 10
-		job.PhotoProgress[photo.ID] = photoInfo
-	}
-
-	err := q.imageProcessor.ProcessPhotoForAI(photo, settings.ThumbnailSize)
-	if err != nil {
-		log.Printf("Failed to prepare photo %s for AI: %v", photo.FileName, err)
-		q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_processing", "failed",
-			fmt.Sprintf("Ошибка подготовки фото %s", photo.FileName), err.Error(), 0)
-		return fmt.Errorf("failed to prepare photo for AI: %w", err)
-	}
-	log.Printf("Photo %s prepared for AI successfully", photo.FileName)
-
-	// Сохраняем thumbnail path в базе данных
-	if photo.ThumbnailPath != "" {
-		err = q.dbService.UpdatePhotoThumbnail(photo.ID, photo.ThumbnailPath)
-		if err != nil {
-			log.Printf("Warning: failed to save thumbnail path for %s: %v", photo.FileName, err)
-		}
-	}
-
 	// Шаг 2: Отправляем в AI для анализа
 	log.Printf("Step 2: Analyzing photo %s with AI", photo.FileName)
 	q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_processing", "progress",
 		fmt.Sprintf("Отправка фото %s на AI анализ", photo.FileName), "", 30)
 
 	// Обновляем прогресс в job
-	if photoInfo, exists := job.PhotoProgress[photo.ID]; exists {
+	q.updatePhotoProgress(job, photo.ID, func(photoInfo *models.PhotoProcessInfo) {
 		photoInfo.Step = "ai_analysis"
 		photoInfo.Progress = 30
-		job.PhotoProgress[photo.ID] = photoInfo
-	}
+	})
 
-	aiResult, err := q.aiService.AnalyzePhoto(*photo, batchDescription, contentType, settings)
+	aiResult, err := q.aiService.AnalyzePhoto(ctx, *photo, batchDescription, contentType, settings, false)
 	if err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("photo processing interrupted: %w", ctx.Err())
+		}
 		log.Printf("Failed to analyze photo %s with AI: %v", photo.FileName, err)
 		q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_processing", "failed",
 			fmt.Sprintf("Ошибка AI анализа фото %s", photo.FileName), err.Error(), 30)
 		return fmt.Errorf("failed to analyze photo with AI: %w", err)
 	}
+	if aiResult.FromCache {
+		q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_cache", "hit",
+			fmt.Sprintf("Метаданные фото %s взяты из кеша AI без запроса к провайдеру", photo.FileName), "", 30)
+	}
 	log.Printf("Photo %s analyzed successfully, got title: %s", photo.FileName, aiResult.Title)
 
 	// Шаг 3: Сохраняем результаты AI
@@ -393,11 +545,10 @@ func (q *QueueManager) processPhoto(photo *models.Photo, batchDescription string
 		fmt.Sprintf("Сохранение результатов AI для фото %s", photo.FileName), "", 70)
 
 	// Обновляем прогресс в job
-	if photoInfo, exists := job.PhotoProgress[photo.ID]; exists {
+	q.updatePhotoProgress(job, photo.ID, func(photoInfo *models.PhotoProcessInfo) {
 		photoInfo.Step = "saving"
 		photoInfo.Progress = 70
-		job.PhotoProgress[photo.ID] = photoInfo
-	}
+	})
 
 	err = q.dbService.UpdatePhotoAIResults(photo.ID, *aiResult)
 	if err != nil {
@@ -408,35 +559,111 @@ func (q *QueueManager) processPhoto(photo *models.Photo, batchDescription string
 	}
 	log.Printf("AI results saved for photo %s", photo.FileName)
 
-	// Шаг 4: Записываем метаданные в EXIF оригинального файла
-	log.Printf("Step 4: Writing EXIF data to photo %s", photo.FileName)
-	q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_processing", "progress",
-		fmt.Sprintf("Запись EXIF данных в фото %s", photo.FileName), "", 90)
-
-	// Обновляем прогресс в job
-	if photoInfo, exists := job.PhotoProgress[photo.ID]; exists {
-		photoInfo.Step = "exif_writing"
-		photoInfo.Progress = 90
-		job.PhotoProgress[photo.ID] = photoInfo
+	// Шаг 3.1: Отдельные метаданные для стоков со своим промптом. Без этого режима
+	// сохраненные ранее варианты удаляются, чтобы все стоки получили новый общий результат.
+	var stockResults map[string]*models.AIResult
+	if settings.PerStockMetadata {
+		log.Printf("Step 3.1: Analyzing photo %s with stock prompts", photo.FileName)
+
+		// Обновляем прогресс в job
+		q.updatePhotoProgress(job, photo.ID, func(photoInfo *models.PhotoProcessInfo) {
+			photoInfo.Step = "stock_metadata"
+			photoInfo.Progress = 80
+		})
+
+		stockResults = q.analyzeStockMetadata(ctx, photo, batchDescription, contentType, settings)
+		if ctx.Err() != nil {
+			// Общий результат уже сохранен и при продолжении батча возьмется из кеша AI
+			return fmt.Errorf("photo processing interrupted: %w", ctx.Err())
+		}
+	}
+	if err := q.dbService.UpdatePhotoStockAIResults(photo.ID, stockResults); err != nil {
+		log.Printf("Failed to save stock AI results for photo %s: %v", photo.FileName, err)
+		q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_processing", "failed",
+			fmt.Sprintf("Ошибка сохранения метаданных стоков для фото %s", photo.FileName), err.Error(), 80)
+		return fmt.Errorf("failed to save stock AI results: %w", err)
 	}
 
-	err = q.imageProcessor.WriteExifToImage(photo.OriginalPath, *aiResult)
-	if err != nil {
-		log.Printf("Warning: failed to write EXIF to %s: %v", photo.OriginalPath, err)
-		q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_processing", "warning",
-			fmt.Sprintf("Предупреждение при записи EXIF в фото %s", photo.FileName), err.Error(), 90)
-		// Не фейлим весь процесс из-за EXIF ошибки
-	} else {
-		log.Printf("EXIF data written successfully to photo %s", photo.FileName)
-		q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_processing", "success",
-			fmt.Sprintf("AI обработка фото %s завершена успешно", photo.FileName),
-			fmt.Sprintf("Название: %s, Ключевых слов: %d", aiResult.Title, len(aiResult.Keywords)), 100)
+	// Шаг 4: Записываем метаданные в EXIF оригинального файла, только если пользователь это включил.
+	// Иначе исходный файл не изменяется, метаданные встраиваются в копии для стоков при загрузке.
+	if settings.WriteOriginals {
+		log.Printf("Step 4: Writing EXIF data to photo %s", photo.FileName)
+		q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_processing", "progress",
+			fmt.Sprintf("Запись EXIF данных в фото %s", photo.FileName), "", 90)
+
+		// Обновляем прогресс в job
+		q.updatePhotoProgress(job, photo.ID, func(photoInfo *models.PhotoProcessInfo) {
+			photoInfo.Step = "exif_writing"
+			photoInfo.Progress = 90
+		})
+
+		q.writeOriginalMetadata(photo, *aiResult)
 	}
 
+	q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_processing", "success",
+		fmt.Sprintf("AI обработка фото %s завершена успешно", photo.FileName),
+		fmt.Sprintf("Название: %s, Ключевых слов: %d", aiResult.Title, len(aiResult.Keywords)), 100)
+
 	log.Printf("Photo %s processing completed successfully", photo.FileName)
 	return nil
 }
 
+// propagateBatchSeries копирует метаданные лучших кадров серий батча остальным кадрам.
+// Если лучший кадр не обработан, кадры его серии помечаются как failed. Возвращает число кадров,
+// получивших метаданные.
+func (q *QueueManager) propagateBatchSeries(batchID string, settings models.AppSettings, job *ProcessingJob) int {
+	seriesList, err := q.dbService.GetBatchSeries(batchID)
+	if err != nil {
+		log.Printf("Failed to get series for batch %s: %v", batchID, err)
+		return 0
+	}
+
+	total := 0
+	for _, series := range seriesList {
+		updated, err := q.PropagateSeriesMetadata(series.ID, settings)
+		total += updated
+
+		for _, frame := range series.Frames {
+			if frame.PhotoID == series.BestPhotoID {
+				continue
+			}
+			if err != nil {
+				q.updatePhotoStatus(frame.PhotoID, "failed", err.Error())
+				q.dbService.LogEvent(batchID, frame.PhotoID, "series_propagate", "failed",
+					fmt.Sprintf("Метаданные серии не скопированы в %s", frame.FileName), err.Error(), 0)
+			}
+			q.updatePhotoProgress(job, frame.PhotoID, func(photoInfo *models.PhotoProcessInfo) {
+				if err != nil {
+					photoInfo.Status = "failed"
+					photoInfo.Error = err.Error()
+					return
+				}
+				photoInfo.Status = "completed"
+				photoInfo.Progress = 100
+				photoInfo.Step = "completed"
+			})
+		}
+	}
+	return total
+}
+
+// writeOriginalMetadata записывает метаданные во все файлы фото (JPEG/TIFF и XMP sidecar RAW)
+// и проверяет записанное. Ошибка записи не прерывает обработку фото.
+func (q *QueueManager) writeOriginalMetadata(photo *models.Photo, aiResult models.AIResult) {
+	for _, target := range MetadataTargets(*photo) {
+		err := q.imageProcessor.WriteExifToImage(target, aiResult)
+		if err != nil {
+			log.Printf("Warning: failed to write EXIF to %s: %v", target, err)
+			q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_processing", "warning",
+				fmt.Sprintf("Предупреждение при записи EXIF в файл %s", filepath.Base(target)), err.Error(), 90)
+			// Не фейлим весь процесс из-за EXIF ошибки
+		} else {
+			log.Printf("EXIF data written successfully to %s", target)
+		}
+		q.dbService.RecordMetadataVerification(photo.BatchID, photo.ID, q.imageProcessor.VerifyExifData(target, aiResult))
+	}
+}
+
 // GetQueueStatus возвращает текущий статус очереди
 func (q *QueueManager) GetQueueStatus() ([]models.BatchStatus, error) {
 	var statuses []models.BatchStatus
@@ -455,7 +682,7 @@ func (q *QueueManager) GetQueueStatus() ([]models.BatchStatus, error) {
 		       (SELECT COUNT(*) FROM photos WHERE batch_id = batches.id) as total_photos,
 		       (SELECT COUNT(*) FROM photos WHERE batch_id = batches.id AND status = 'processed') as processed_photos
 		FROM batches 
-		WHERE status IN ('queued', 'processing', 'processed')
+		WHERE status IN ('queued', 'processing', 'paused', 'processed')
 		ORDER BY created_at DESC`)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query queue status: %w", err)
@@ -481,6 +708,10 @@ func (q *QueueManager) GetQueueStatus() ([]models.BatchStatus, error) {
 			Status:          status,
 		}
 
+		if status == "paused" {
+			batchStatus.Error = q.PauseReason()
+		}
+
 		// Если задача активна, берем более точный прогресс
 		if job, exists := activeJobs[batchID]; exists {
 			batchStatus.Progress = job.Progress
@@ -538,7 +769,8 @@ func (q *QueueManager) updatePhotoStatus(photoID string, status string, errorMsg
 func (q *QueueManager) getPhotosForBatch(batchID string) ([]models.Photo, error) {
 	rows, err := q.db.Query(`
 		SELECT id, batch_id, content_type, original_path, thumbnail_path, file_name, file_size,
-		       exif_data, ai_results, upload_status, status, created_at
+		       exif_data, ai_results, upload_status, status, created_at, COALESCE(renditions, ''),
+		       COALESCE(content_hash, ''), COALESCE(perceptual_hash, '')
 		FROM photos 
 		WHERE batch_id = ? AND status IN ('pending', 'processing', 'processed', 'failed')`, batchID)
 	if err != nil {
@@ -549,12 +781,13 @@ func (q *QueueManager) getPhotosForBatch(batchID string) ([]models.Photo, error)
 	var photos []models.Photo
 	for rows.Next() {
 		var photo models.Photo
-		var exifJSON, aiResultsJSON, uploadStatusJSON string
+		var exifJSON, aiResultsJSON, uploadStatusJSON, renditionsJSON string
 
 		err := rows.Scan(&photo.ID, &photo.BatchID, &photo.ContentType, &photo.OriginalPath,
 			&photo.ThumbnailPath, &photo.FileName, &photo.FileSize,
 			&exifJSON, &aiResultsJSON, &uploadStatusJSON,
-			&photo.Status, &photo.CreatedAt)
+			&photo.Status, &photo.CreatedAt, &renditionsJSON,
+			&photo.ContentHash, &photo.PerceptualHash)
 		if err != nil {
 			return nil, err
 		}
@@ -573,6 +806,9 @@ func (q *QueueManager) getPhotosForBatch(batchID string) ([]models.Photo, error)
 		if uploadStatusJSON != "" {
 			json.Unmarshal([]byte(uploadStatusJSON), &photo.UploadStatus)
 		}
+		if renditionsJSON != "" {
+			json.Unmarshal([]byte(renditionsJSON), &photo.Renditions)
+		}
 
 		photos = append(photos, photo)
 	}
@@ -580,18 +816,30 @@ func (q *QueueManager) getPhotosForBatch(batchID string) ([]models.Photo, error)
 	return photos, nil
 }
 
-// photoWorker обрабатывает фотографии из канала
-func (q *QueueManager) photoWorker(workerID int, photoChannel <-chan models.Photo, resultChannel chan<- photoResult, batchID, batchDescription, contentType string, settings models.AppSettings, job *ProcessingJob) {
+// photoWorker обрабатывает фотографии из канала. После отмены ctx оставшиеся фото
+// возвращаются в результаты с ошибкой отмены без обработки.
+func (q *QueueManager) photoWorker(ctx context.Context, workerID int, photoChannel <-chan models.Photo, resultChannel chan<- photoResult, batchID, batchDescription, contentType string, settings models.AppSettings, job *ProcessingJob) {
 	log.Printf("Worker %d started", workerID)
 
 	for photo := range photoChannel {
+		if ctx.Err() != nil {
+			resultChannel <- photoResult{photo: photo, err: fmt.Errorf("photo processing interrupted: %w", ctx.Err())}
+			continue
+		}
+
 		log.Printf("Worker %d processing photo: %s", workerID, photo.FileName)
 
 		// Обновляем статус фотографии в job
-		if photoInfo, exists := job.PhotoProgress[photo.ID]; exists {
+		q.updatePhotoProgress(job, photo.ID, func(photoInfo *models.PhotoProcessInfo) {
 			photoInfo.Status = "processing"
 			photoInfo.Step = "ai_processing"
-			job.PhotoProgress[photo.ID] = photoInfo
+		})
+
+		// Перед каждым фото проверяем лимиты стоимости AI
+		if reason, exceeded := q.aiBudgetExceeded(batchID, settings); exceeded {
+			q.pauseForBudget(batchID, reason)
+			resultChannel <- photoResult{photo: photo, err: errAIBudgetExceeded}
+			continue
 		}
 
 		// Логируем начало обработки фото
@@ -599,7 +847,7 @@ func (q *QueueManager) photoWorker(workerID int, photoChannel <-chan models.Phot
 			fmt.Sprintf("Начата AI обработка фото %s (worker %d)", photo.FileName, workerID), "", 0)
 
 		// Обрабатываем фото
-		err := q.processPhoto(&photo, batchDescription, contentType, settings, job)
+		err := q.processPhoto(ctx, &photo, batchDescription, contentType, settings, job)
 
 		// Отправляем результат
 		resultChannel <- photoResult{photo: photo, err: err}
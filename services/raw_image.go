@@ -0,0 +1,383 @@
+package services
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"stock-photo-app/models"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// RAW форматы камер. CR2, NEF, ARW и DNG - контейнеры TIFF, CR3 - ISO BMFF (как MP4).
+var rawExtensions = map[string]bool{
+	".cr2": true,
+	".cr3": true,
+	".nef": true,
+	".arw": true,
+	".dng": true,
+}
+
+// Теги TIFF, по которым в RAW ищется встроенное превью
+const (
+	tiffTagCompression       = 0x0103
+	tiffTagStripOffsets      = 0x0111
+	tiffTagStripByteCounts   = 0x0117
+	tiffTagSubIFDs           = 0x014A
+	tiffTagJPEGOffset        = 0x0201 // JPEGInterchangeFormat
+	tiffTagJPEGLength        = 0x0202 // JPEGInterchangeFormatLength
+	tiffCompressionOldJPEG   = 6
+	tiffCompressionJPEG      = 7
+	rawMaxIFDs               = 32 // защита от зацикленных и испорченных цепочек IFD
+	rawMaxTagValues          = 64
+	cr3PreviewJPEGHeaderSize = 32 // в начале бокса PRVW размеры превью, затем JPEG
+)
+
+// UUID боксов CR3: метаданные Canon (CMT1-CMT4 с EXIF) и превью (PRVW)
+var (
+	cr3MetadataUUID = []byte{0x85, 0xc0, 0xb6, 0x87, 0x82, 0x0f, 0x11, 0xe0, 0x81, 0x11, 0xf4, 0xce, 0x46, 0x2b, 0x6a, 0x48}
+	cr3PreviewUUID  = []byte{0xea, 0xf4, 0x2b, 0x5e, 0x1c, 0x98, 0x4b, 0x88, 0xb9, 0xfb, 0xb7, 0xdc, 0x40, 0x6e, 0x4d, 0x16}
+)
+
+// IsRawFile сообщает, что файл - RAW камеры (по расширению)
+func IsRawFile(path string) bool {
+	return rawExtensions[strings.ToLower(filepath.Ext(path))]
+}
+
+// XMPSidecarPath возвращает путь к XMP sidecar RAW файла. Если sidecar уже есть
+// (IMG_0001.XMP или IMG_0001.CR3.xmp, как у darktable), возвращается он, иначе - имя без расширения и ".xmp",
+// как у Lightroom и Camera Raw.
+func XMPSidecarPath(rawPath string) string {
+	base := strings.TrimSuffix(rawPath, filepath.Ext(rawPath))
+	for _, candidate := range []string{base + ".xmp", base + ".XMP", rawPath + ".xmp"} {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return base + ".xmp"
+}
+
+// openRawPreview декодирует встроенное JPEG превью RAW файла и поворачивает его по Orientation из RAW
+func openRawPreview(path string) (image.Image, error) {
+	preview, err := extractRawPreview(path)
+	if err != nil {
+		return nil, err
+	}
+
+	img, err := jpeg.Decode(bytes.NewReader(preview))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode RAW preview: %w", err)
+	}
+
+	orientation := 1
+	if decoded, err := decodeRawExif(path); err == nil {
+		for _, x := range decoded {
+			if tag, err := x.Get(exif.Orientation); err == nil {
+				if value, err := tag.Int(0); err == nil {
+					orientation = value
+					break
+				}
+			}
+		}
+	}
+	return orientImage(img, orientation), nil
+}
+
+// orientImage поворачивает изображение по значению EXIF Orientation
+func orientImage(img image.Image, orientation int) image.Image {
+	switch orientation {
+	case 2:
+		return imaging.FlipH(img)
+	case 3:
+		return imaging.Rotate180(img)
+	case 4:
+		return imaging.FlipV(img)
+	case 5:
+		return imaging.Transpose(img)
+	case 6:
+		return imaging.Rotate270(img)
+	case 7:
+		return imaging.Transverse(img)
+	case 8:
+		return imaging.Rotate90(img)
+	}
+	return img
+}
+
+// extractRawPreview возвращает самое большое встроенное JPEG превью RAW файла
+func extractRawPreview(path string) ([]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open RAW file: %w", err)
+	}
+	defer file.Close()
+
+	if strings.ToLower(filepath.Ext(path)) == ".cr3" {
+		return extractCR3Preview(file)
+	}
+	return extractTIFFPreview(file)
+}
+
+// extractTIFFPreview обходит IFD0, следующие IFD и SubIFD и выбирает самый большой JPEG,
+// который декодируется стандартным декодером. Данные RAW в lossless JPEG (CR2, DNG) так отсеиваются.
+func extractTIFFPreview(file *os.File) ([]byte, error) {
+	tiff, err := readTIFFHeader(file)
+	if err != nil {
+		return nil, err
+	}
+
+	type candidate struct{ offset, length int64 }
+	var candidates []candidate
+
+	visited := make(map[uint32]bool)
+	queue := []uint32{tiff.ifdOffset}
+	for len(queue) > 0 && len(visited) < rawMaxIFDs {
+		offset := queue[0]
+		queue = queue[1:]
+		if offset == 0 || visited[offset] {
+			continue
+		}
+		visited[offset] = true
+
+		entries, next, err := readTIFFIFD(file, tiff.order, offset)
+		if err != nil {
+			continue
+		}
+		queue = append(queue, next)
+
+		values := func(tag uint16) []uint32 {
+			for _, entry := range entries {
+				if entry.tag == tag {
+					return tiffEntryUints(file, tiff.order, entry)
+				}
+			}
+			return nil
+		}
+		queue = append(queue, values(tiffTagSubIFDs)...)
+
+		if jpegOffset, jpegLength := values(tiffTagJPEGOffset), values(tiffTagJPEGLength); len(jpegOffset) > 0 && len(jpegLength) > 0 {
+			candidates = append(candidates, candidate{int64(jpegOffset[0]), int64(jpegLength[0])})
+		}
+		if compression := values(tiffTagCompression); len(compression) > 0 &&
+			(compression[0] == tiffCompressionOldJPEG || compression[0] == tiffCompressionJPEG) {
+			if offsets, counts := values(tiffTagStripOffsets), values(tiffTagStripByteCounts); len(offsets) == 1 && len(counts) == 1 {
+				candidates = append(candidates, candidate{int64(offsets[0]), int64(counts[0])})
+			}
+		}
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	var best candidate
+	for _, c := range candidates {
+		if c.length <= best.length || c.offset <= 0 || c.offset+c.length > info.Size() {
+			continue
+		}
+		if _, err := jpeg.DecodeConfig(io.NewSectionReader(file, c.offset, c.length)); err != nil {
+			continue
+		}
+		best = c
+	}
+	if best.length == 0 {
+		return nil, fmt.Errorf("в RAW файле нет встроенного JPEG превью")
+	}
+
+	preview := make([]byte, best.length)
+	if _, err := file.ReadAt(preview, best.offset); err != nil {
+		return nil, fmt.Errorf("failed to read RAW preview: %w", err)
+	}
+	return preview, nil
+}
+
+// tiffEntryUints читает значения SHORT или LONG записи IFD
+func tiffEntryUints(file *os.File, order binary.ByteOrder, entry tiffEntry) []uint32 {
+	if entry.count == 0 || entry.count > rawMaxTagValues || (entry.typ != 3 && entry.typ != 4 && entry.typ != 13) {
+		return nil
+	}
+	data, err := tiffEntryData(file, order, entry)
+	if err != nil {
+		return nil
+	}
+
+	values := make([]uint32, 0, entry.count)
+	for i := 0; i < int(entry.count); i++ {
+		if entry.typ == 3 {
+			values = append(values, uint32(order.Uint16(data[i*2:])))
+		} else {
+			values = append(values, order.Uint32(data[i*4:]))
+		}
+	}
+	return values
+}
+
+// extractCR3Preview читает JPEG из бокса PRVW внутри uuid бокса превью CR3 (1620x1080)
+func extractCR3Preview(file *os.File) ([]byte, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	start, end, ok := findBMFFBox(file, 0, info.Size(), "uuid", cr3PreviewUUID)
+	if !ok {
+		return nil, fmt.Errorf("в CR3 файле нет превью")
+	}
+	// Перед PRVW в uuid боксе 8 служебных байт
+	start, end, ok = findBMFFBox(file, start+8, end, "PRVW", nil)
+	if !ok {
+		return nil, fmt.Errorf("в CR3 файле нет превью")
+	}
+
+	header := make([]byte, cr3PreviewJPEGHeaderSize)
+	if _, err := file.ReadAt(header, start); err != nil {
+		return nil, fmt.Errorf("failed to read CR3 preview: %w", err)
+	}
+	soi := bytes.Index(header, []byte{0xFF, 0xD8, 0xFF})
+	if soi < 0 {
+		return nil, fmt.Errorf("превью CR3 не является JPEG")
+	}
+
+	// Перед JPEG записан его размер
+	length := end - (start + int64(soi))
+	if soi >= 4 {
+		if size := int64(binary.BigEndian.Uint32(header[soi-4 : soi])); size > 0 && size < length {
+			length = size
+		}
+	}
+
+	preview := make([]byte, length)
+	if _, err := file.ReadAt(preview, start+int64(soi)); err != nil {
+		return nil, fmt.Errorf("failed to read CR3 preview: %w", err)
+	}
+	return preview, nil
+}
+
+// findBMFFBox ищет бокс ISO BMFF типа boxType среди боксов между start и end и возвращает границы его данных.
+// Для боксов uuid сравнивается и uuid, данные начинаются после него.
+func findBMFFBox(file io.ReaderAt, start, end int64, boxType string, uuid []byte) (int64, int64, bool) {
+	header := make([]byte, 16)
+	for offset := start; offset+8 <= end; {
+		if _, err := file.ReadAt(header[:8], offset); err != nil {
+			return 0, 0, false
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:8])
+		headerSize := int64(8)
+
+		switch size {
+		case 0:
+			size = end - offset
+		case 1:
+			if _, err := file.ReadAt(header[8:16], offset+8); err != nil {
+				return 0, 0, false
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > end {
+			return 0, 0, false
+		}
+
+		if typ == boxType {
+			if uuid == nil {
+				return offset + headerSize, offset + size, true
+			}
+			boxUUID := make([]byte, 16)
+			if _, err := file.ReadAt(boxUUID, offset+headerSize); err == nil && bytes.Equal(boxUUID, uuid) {
+				return offset + headerSize + 16, offset + size, true
+			}
+		}
+		offset += size
+	}
+	return 0, 0, false
+}
+
+// decodeRawExif разбирает EXIF RAW файла. Для TIFF контейнеров это сам файл,
+// в CR3 IFD0 и EXIF IFD лежат отдельными TIFF в боксах CMT1 и CMT2.
+func decodeRawExif(path string) ([]*exif.Exif, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open RAW file: %w", err)
+	}
+	defer file.Close()
+
+	if strings.ToLower(filepath.Ext(path)) != ".cr3" {
+		x, err := exif.Decode(file)
+		if err != nil {
+			return nil, err
+		}
+		return []*exif.Exif{x}, nil
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	moovStart, moovEnd, ok := findBMFFBox(file, 0, info.Size(), "moov", nil)
+	if !ok {
+		return nil, fmt.Errorf("в CR3 файле нет бокса moov")
+	}
+	start, end, ok := findBMFFBox(file, moovStart, moovEnd, "uuid", cr3MetadataUUID)
+	if !ok {
+		return nil, fmt.Errorf("в CR3 файле нет метаданных Canon")
+	}
+
+	var result []*exif.Exif
+	for _, boxType := range []string{"CMT1", "CMT2"} {
+		boxStart, boxEnd, ok := findBMFFBox(file, start, end, boxType, nil)
+		if !ok {
+			continue
+		}
+		x, err := exif.Decode(io.NewSectionReader(file, boxStart, boxEnd-boxStart))
+		if err != nil {
+			continue
+		}
+		result = append(result, x)
+	}
+	if len(result) == 0 {
+		return nil, fmt.Errorf("в CR3 файле нет EXIF")
+	}
+	return result, nil
+}
+
+// writeXMPSidecar записывает метаданные в XMP sidecar RAW файла, сам RAW не изменяется.
+// Свойства существующего sidecar (например, настройки Lightroom) сохраняются.
+func writeXMPSidecar(rawPath string, aiResult models.AIResult) error {
+	sidecarPath := XMPSidecarPath(rawPath)
+	existing, err := os.ReadFile(sidecarPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to read XMP sidecar: %w", err)
+	}
+
+	xmpPacket, _ := buildMetadataPackets(existing, nil, aiResult)
+	if existing == nil {
+		if err := os.WriteFile(sidecarPath, xmpPacket, 0644); err != nil {
+			return fmt.Errorf("failed to write XMP sidecar: %w", err)
+		}
+		return nil
+	}
+	return replaceFile(sidecarPath, xmpPacket)
+}
+
+// readXMPSidecar читает XMP sidecar RAW файла; если его нет, возвращает nil без ошибки
+func readXMPSidecar(rawPath string) ([]byte, error) {
+	data, err := os.ReadFile(XMPSidecarPath(rawPath))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read XMP sidecar: %w", err)
+	}
+	return data, nil
+}
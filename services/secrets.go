@@ -0,0 +1,288 @@
+package services
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"stock-photo-app/models"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Префикс зашифрованных значений в БД. Значения без префикса считаются открытым текстом
+// (записаны до появления шифрования) и шифруются миграцией при старте.
+const secretPrefix = "enc:v1:"
+
+// SecretMask значение, которое frontend получает вместо секретов.
+// Если frontend присылает его обратно при сохранении, сохраненный секрет не меняется.
+const SecretMask = "••••••••"
+
+// Переменная окружения с мастер-паролем. Если задана, ключ шифрования выводится из нее,
+// иначе используется случайный ключ из файла рядом с базой данных.
+const secretPassphraseEnv = "STOCK_PHOTO_APP_PASSPHRASE"
+
+// ErrSecretDecrypt секрет из БД не удалось расшифровать текущим ключом (потерян secret.key
+// или изменен мастер-пароль). Такие секреты остаются в БД зашифрованными, их нужно ввести заново
+// или восстановить прежний ключ.
+var ErrSecretDecrypt = errors.New("failed to decrypt stored secret")
+
+// SecretStore шифрует пароли и API ключи для хранения в БД (AES-256-GCM)
+type SecretStore struct {
+	aead cipher.AEAD
+
+	mu sync.Mutex
+	// undecryptable шифротексты из БД, которые не удалось расшифровать текущим ключом.
+	// Encrypt возвращает их без изменений, чтобы повторное сохранение не потеряло секрет.
+	undecryptable map[string]bool
+}
+
+// NewSecretStore создает хранилище секретов с ключом из мастер-пароля или из файла keyPath.
+// Файл ключа создается при первом запуске с правами 0600. Если в БД уже есть зашифрованные
+// секреты (existingSecrets), новый ключ не создается: им эти секреты все равно не расшифровать.
+func NewSecretStore(keyPath string, existingSecrets bool) (*SecretStore, error) {
+	var key []byte
+	var err error
+
+	if passphrase := os.Getenv(secretPassphraseEnv); passphrase != "" {
+		key, err = passphraseKey(passphrase, keyPath+".salt", existingSecrets)
+	} else {
+		key, err = fileKey(keyPath, existingSecrets)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	return &SecretStore{aead: aead, undecryptable: make(map[string]bool)}, nil
+}
+
+// fileKey читает 32-байтный ключ из файла или создает новый
+func fileKey(keyPath string, existingSecrets bool) ([]byte, error) {
+	key, err := os.ReadFile(keyPath)
+	if err == nil {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid secret key file %s: expected 32 bytes, got %d", keyPath, len(key))
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read secret key: %w", err)
+	}
+	if existingSecrets {
+		return nil, fmt.Errorf("secret key file %s is missing but the database contains encrypted secrets: "+
+			"restore the key file or set %s", keyPath, secretPassphraseEnv)
+	}
+
+	key, err = randomBytes(32)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSecretFile(keyPath, key); err != nil {
+		return nil, err
+	}
+
+	log.Printf("Created new secret key file %s", keyPath)
+	return key, nil
+}
+
+// passphraseKey выводит ключ из мастер-пароля через Argon2id. Соль хранится в файле saltPath.
+func passphraseKey(passphrase, saltPath string, existingSecrets bool) ([]byte, error) {
+	salt, err := os.ReadFile(saltPath)
+	if os.IsNotExist(err) {
+		if existingSecrets {
+			return nil, fmt.Errorf("secret salt file %s is missing but the database contains encrypted secrets: "+
+				"restore the salt file or unset %s to use the key file", saltPath, secretPassphraseEnv)
+		}
+		salt, err = randomBytes(16)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeSecretFile(saltPath, salt); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read secret salt: %w", err)
+	}
+
+	return argon2.IDKey([]byte(passphrase), salt, 1, 64*1024, 4, 32), nil
+}
+
+func randomBytes(n int) ([]byte, error) {
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		return nil, fmt.Errorf("failed to generate random bytes: %w", err)
+	}
+	return data, nil
+}
+
+func writeSecretFile(path string, data []byte) error {
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+	}
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// Encrypt шифрует значение для записи в БД. Пустое значение не меняется. Значение с префиксом
+// шифрования тоже шифруется: это ввод пользователя. Без изменений возвращаются только шифротексты,
+// которые Decrypt прочитал из БД и не смог расшифровать; уже зашифрованные значения пропускает миграция.
+func (s *SecretStore) Encrypt(value string) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	s.mu.Lock()
+	undecryptable := s.undecryptable[value]
+	s.mu.Unlock()
+	if undecryptable {
+		return value, nil
+	}
+
+	nonce, err := randomBytes(s.aead.NonceSize())
+	if err != nil {
+		return "", err
+	}
+
+	sealed := s.aead.Seal(nonce, nonce, []byte(value), nil)
+	return secretPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt расшифровывает значение из БД. Значения без префикса возвращаются как есть.
+// Шифротекст, который не удалось расшифровать, запоминается для Encrypt.
+func (s *SecretStore) Decrypt(value string) (string, error) {
+	if !IsEncryptedSecret(value) {
+		return value, nil
+	}
+
+	plain, err := s.open(value)
+	if err != nil {
+		s.mu.Lock()
+		s.undecryptable[value] = true
+		s.mu.Unlock()
+		return "", err
+	}
+	return plain, nil
+}
+
+// open расшифровывает значение с префиксом шифрования
+func (s *SecretStore) open(value string) (string, error) {
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, secretPrefix))
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret: %w", err)
+	}
+
+	nonceSize := s.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", fmt.Errorf("secret is too short")
+	}
+
+	plain, err := s.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret (wrong key or passphrase?): %w", err)
+	}
+
+	return string(plain), nil
+}
+
+// HasEncryptedSecrets сообщает, что в БД уже есть зашифрованные секреты.
+// Вызывается до InitializeTables, поэтому отсутствующие таблицы не считаются ошибкой.
+func HasEncryptedSecrets(db *sql.DB) (bool, error) {
+	queries := []string{
+		"SELECT COUNT(*) FROM stock_configs WHERE connection_config LIKE '%" + secretPrefix + "%'",
+		"SELECT COUNT(*) FROM app_settings WHERE ai_api_key LIKE '" + secretPrefix + "%'",
+	}
+
+	for _, query := range queries {
+		var count int
+		if err := db.QueryRow(query).Scan(&count); err != nil {
+			if strings.Contains(err.Error(), "no such table") || strings.Contains(err.Error(), "no such column") {
+				continue
+			}
+			return false, fmt.Errorf("failed to check encrypted secrets: %w", err)
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// IsEncryptedSecret сообщает, что значение зашифровано SecretStore
+func IsEncryptedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+// connectionSecrets возвращает указатели на секретные поля подключения стока
+func connectionSecrets(connection *models.ConnectionConfig) []*string {
+	return []*string{
+		&connection.Password,
+		&connection.APIKey,
+		&connection.PrivateKey,
+		&connection.Passphrase,
+	}
+}
+
+// MaskStockConfigSecrets заменяет секреты подключения маской перед отправкой во frontend
+func MaskStockConfigSecrets(config models.StockConfig) models.StockConfig {
+	for _, field := range connectionSecrets(&config.Connection) {
+		if *field != "" {
+			*field = SecretMask
+		}
+	}
+	return config
+}
+
+// MergeStockConfigSecrets подставляет сохраненные секреты вместо маски из frontend.
+// Пустое значение удаляет секрет, любое другое заменяет его.
+// Закрепленный отпечаток SSH ключа сервера пустым значением не сбрасывается: форма, открытая
+// до первого подключения, иначе снимала бы закрепление. Сброс выполняет ResetStockHostKey.
+func MergeStockConfigSecrets(incoming, stored models.StockConfig) models.StockConfig {
+	incomingFields := connectionSecrets(&incoming.Connection)
+	storedFields := connectionSecrets(&stored.Connection)
+	for i, field := range incomingFields {
+		if *field == SecretMask {
+			*field = *storedFields[i]
+		}
+	}
+	if incoming.Connection.HostKeyFingerprint == "" {
+		incoming.Connection.HostKeyFingerprint = stored.Connection.HostKeyFingerprint
+	}
+	return incoming
+}
+
+// MaskSettingsSecrets заменяет API ключ маской перед отправкой во frontend
+func MaskSettingsSecrets(settings models.AppSettings) models.AppSettings {
+	if settings.AIAPIKey != "" {
+		settings.AIAPIKey = SecretMask
+	}
+	return settings
+}
+
+// MergeSettingsSecrets подставляет сохраненный API ключ вместо маски из frontend
+func MergeSettingsSecrets(incoming, stored models.AppSettings) models.AppSettings {
+	if incoming.AIAPIKey == SecretMask {
+		incoming.AIAPIKey = stored.AIAPIKey
+	}
+	return incoming
+}
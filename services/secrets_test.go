@@ -0,0 +1,91 @@
+package services
+
+import (
+	"path/filepath"
+	"stock-photo-app/models"
+	"testing"
+)
+
+func TestMergeStockConfigSecretsKeepsPinnedHostKey(t *testing.T) {
+	stored := models.StockConfig{ID: "adobe", Connection: models.ConnectionConfig{
+		Password:           "secret",
+		HostKeyFingerprint: "SHA256:pinned",
+	}}
+
+	// Форма открыта до первого подключения: отпечатка в ней нет, пароль замаскирован
+	incoming := models.StockConfig{ID: "adobe", Connection: models.ConnectionConfig{Password: SecretMask}}
+	merged := MergeStockConfigSecrets(incoming, stored)
+	if merged.Connection.HostKeyFingerprint != "SHA256:pinned" {
+		t.Errorf("empty fingerprint unpinned the host key: %q", merged.Connection.HostKeyFingerprint)
+	}
+	if merged.Connection.Password != "secret" {
+		t.Errorf("masked password = %q, want the stored one", merged.Connection.Password)
+	}
+
+	// Отпечаток, введенный пользователем, заменяет закрепленный
+	incoming.Connection.HostKeyFingerprint = "SHA256:new"
+	if merged := MergeStockConfigSecrets(incoming, stored); merged.Connection.HostKeyFingerprint != "SHA256:new" {
+		t.Errorf("fingerprint = %q, want the one entered by the user", merged.Connection.HostKeyFingerprint)
+	}
+}
+
+// newTestSecretStore создает хранилище с новым ключом во временном каталоге
+func newTestSecretStore(t *testing.T) *SecretStore {
+	t.Helper()
+	t.Setenv(secretPassphraseEnv, "")
+	store, err := NewSecretStore(filepath.Join(t.TempDir(), "secret.key"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestSecretStoreEncryptsPrefixedInput(t *testing.T) {
+	store := newTestSecretStore(t)
+
+	// Пароль, введенный пользователем, шифруется даже если похож на шифротекст
+	for _, value := range []string{"hunter2", "enc:v1:", "enc:v1:not-base64", "enc:v1:AAAA"} {
+		encrypted, err := store.Encrypt(value)
+		if err != nil {
+			t.Fatalf("Encrypt(%q): %v", value, err)
+		}
+		if encrypted == value {
+			t.Errorf("Encrypt(%q) stored the value as is", value)
+		}
+		if plain, err := store.Decrypt(encrypted); err != nil || plain != value {
+			t.Errorf("Decrypt(Encrypt(%q)) = %q, %v", value, plain, err)
+		}
+	}
+
+	if encrypted, err := store.Encrypt(""); err != nil || encrypted != "" {
+		t.Errorf("Encrypt(\"\") = %q, %v, want empty", encrypted, err)
+	}
+}
+
+func TestSecretStoreKeepsUndecryptableCiphertext(t *testing.T) {
+	previous := newTestSecretStore(t)
+	ciphertext, err := previous.Encrypt("lost password")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Ключ потерян: новым ключом шифротекст не расшифровать
+	store := newTestSecretStore(t)
+	if _, err := store.Decrypt(ciphertext); err == nil {
+		t.Fatal("ciphertext of another key was decrypted")
+	}
+
+	// При повторном сохранении прочитанный из БД шифротекст записывается без изменений
+	if encrypted, err := store.Encrypt(ciphertext); err != nil || encrypted != ciphertext {
+		t.Errorf("Encrypt(undecryptable ciphertext) = %q, %v, want it unchanged", encrypted, err)
+	}
+
+	// Шифротекст, которого не было в БД, - ввод пользователя
+	other, err := previous.Encrypt("other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encrypted, err := store.Encrypt(other); err != nil || encrypted == other {
+		t.Errorf("Encrypt(unknown ciphertext) = %q, %v, want it encrypted", encrypted, err)
+	}
+}
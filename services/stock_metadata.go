@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"stock-photo-app/models"
+	"strings"
+)
+
+// stockPrompt возвращает промпт стока для типа контента; пустая строка - сток получает общий AIResult фото
+func stockPrompt(config models.StockConfig, contentType string) string {
+	return strings.TrimSpace(config.Prompts[contentType])
+}
+
+// analyzeStockMetadata анализирует фото промптами активных стоков, поддерживающих тип батча,
+// и возвращает метаданные по stock_id. Стоки без своего промпта и стоки, для которых анализ
+// не удался, в результат не попадают и при загрузке получают общий AIResult. При отмене ctx возвращает nil.
+func (q *QueueManager) analyzeStockMetadata(ctx context.Context, photo *models.Photo, batchDescription, contentType string, settings models.AppSettings) map[string]*models.AIResult {
+	stocks, err := q.dbService.GetActiveStockConfigs(contentType)
+	if err != nil {
+		log.Printf("Warning: failed to get stocks for per-stock metadata of %s: %v", photo.FileName, err)
+		q.dbService.LogEvent(photo.BatchID, photo.ID, "stock_metadata", "warning",
+			fmt.Sprintf("Не удалось получить стоки для отдельных метаданных фото %s", photo.FileName), err.Error(), 80)
+		return nil
+	}
+
+	results := make(map[string]*models.AIResult)
+	var names []string
+	for _, stock := range stocks {
+		prompt := stockPrompt(stock, contentType)
+		if prompt == "" {
+			continue
+		}
+
+		log.Printf("Analyzing photo %s with prompt of stock %s", photo.FileName, stock.Name)
+		result, err := q.aiService.AnalyzePhotoWithPrompt(ctx, *photo, batchDescription, prompt, contentType, settings, false)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil // обработка фото прервана остановкой очереди
+			}
+			log.Printf("Warning: failed to analyze photo %s for stock %s: %v", photo.FileName, stock.Name, err)
+			q.dbService.LogEvent(photo.BatchID, photo.ID, "stock_metadata", "warning",
+				fmt.Sprintf("Метаданные фото %s для %s не созданы, сток получит общие метаданные", photo.FileName, stock.Name), err.Error(), 80)
+			continue
+		}
+
+		if result.FromCache {
+			q.dbService.LogEvent(photo.BatchID, photo.ID, "ai_cache", "hit",
+				fmt.Sprintf("Метаданные фото %s для %s взяты из кеша AI", photo.FileName, stock.Name), "", 80)
+		}
+		results[stock.ID] = result
+		names = append(names, stock.Name)
+	}
+
+	if len(names) > 0 {
+		q.dbService.LogEvent(photo.BatchID, photo.ID, "stock_metadata", "success",
+			fmt.Sprintf("Созданы отдельные метаданные фото %s для стоков: %s", photo.FileName, strings.Join(names, ", ")), "", 80)
+	}
+	return results
+}
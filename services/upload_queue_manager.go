@@ -1,52 +1,126 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log"
+	"sort"
 	"stock-photo-app/models"
 	"stock-photo-app/uploaders"
+	"strconv"
 	"sync"
 	"time"
 )
 
-// UploadQueueManager управляет очередью загрузки файлов на стоки
+// Интервал опроса таблицы upload_jobs, когда очередь пуста
+const uploadQueuePollInterval = 2 * time.Second
+
+// Лимит параллельных загрузок на сток, если maxConnections не задан в StockConfig.Settings
+const defaultStockMaxConnections = 2
+
+// Верхняя граница maxConnections, чтобы опечатка в настройках не открыла сотни сессий
+const maxStockConnections = 16
+
+// Окно, по которому считается пропускная способность стока
+const uploadThroughputWindow = 5 * time.Minute
+
+// Окно, по которому считается скорость передачи в байтах для ETA батча
+const uploadRateWindow = 30 * time.Second
+
+// UploadQueueManager управляет очередью загрузки файлов на стоки.
+// Задачи хранятся в таблице upload_jobs, у каждого стока свой пул worker'ов
+// со своим лимитом параллельности, поэтому медленный сток не блокирует остальные.
 type UploadQueueManager struct {
-	uploaderManager      *uploaders.UploaderManager
-	dbService            *DatabaseService
-	activeUploads        map[string]*UploadJob
-	uploadsMutex         sync.RWMutex
-	maxConcurrentUploads int
-	isProcessing         bool
-	processingMutex      sync.Mutex
-	jobChannel           chan *UploadJob
-	stopChannel          chan bool
+	uploaderManager *uploaders.UploaderManager
+	dbService       *DatabaseService
+	activeUploads   map[string]*UploadJob
+	uploadsMutex    sync.RWMutex
+	pools           map[string]*stockPool
+	poolsMutex      sync.Mutex
+	isProcessing    bool
+	processingMutex sync.Mutex
+	claimMutex      sync.Mutex
+	statusMutex     sync.Mutex
+	cancel          context.CancelFunc // отменяет загрузки текущего запуска очереди
+	runCtx          context.Context
+	workers         *sync.WaitGroup // worker'ы текущего запуска, их ждет Stop
+	emitEvent       func(name string, data interface{})
+	transfers       []transferSample // отправленные байты за окно uploadRateWindow, защищено uploadsMutex
+	pendingBytes    map[string]int64 // байт в ожидающих задачах по батчам, защищено uploadsMutex
+	csvExporter     *MetadataCSVExporter
+}
+
+// transferSample порция байт, отправленная в рамках батча
+type transferSample struct {
+	at      time.Time
+	batchID string
+	bytes   int64
+}
+
+// stockPool пул worker'ов одного стока. Поля защищены poolsMutex.
+type stockPool struct {
+	stockID        string
+	stockName      string
+	maxConnections int
+	workers        int
+	activeCount    int
+	uploadedCount  int
+	failedCount    int
+	retriedCount   int
+	completedAt    []time.Time // время успешных загрузок за окно uploadThroughputWindow
+	wake           chan struct{}
+	shrink         chan struct{}
 }
 
-// UploadJob представляет задачу загрузки файла
+// UploadJob представляет выполняемую загрузку фото на один сток
 type UploadJob struct {
-	PhotoID      string               `json:"photoId"`
-	BatchID      string               `json:"batchId"`
-	FileName     string               `json:"fileName"`
-	Photo        models.Photo         `json:"photo"`
-	StockConfigs []models.StockConfig `json:"stockConfigs"`
-	StartTime    time.Time            `json:"startTime"`
-	Status       string               `json:"status"`   // "pending", "uploading", "completed", "failed"
-	Progress     map[string]string    `json:"progress"` // stockID -> status
+	JobID     string            `json:"jobId"`
+	PhotoID   string            `json:"photoId"`
+	BatchID   string            `json:"batchId"`
+	StockID   string            `json:"stockId"`
+	StockName string            `json:"stockName"`
+	FileName  string            `json:"fileName"`
+	Attempt   int               `json:"attempt"`
+	StartTime time.Time         `json:"startTime"`
+	Status    string            `json:"status"`   // "uploading", "uploaded", "failed"
+	Progress  map[string]string `json:"progress"` // stockID -> status
+
+	BytesSent      int64   `json:"bytesSent"`
+	BytesTotal     int64   `json:"bytesTotal"`
+	BytesPerSecond float64 `json:"bytesPerSecond"`
+
+	rateStartBytes int64     // байт на момент первого отчета (при докачке не ноль)
+	rateStartTime  time.Time // время первого отчета о прогрессе
 }
 
 // NewUploadQueueManager создает новый менеджер очереди загрузки
 func NewUploadQueueManager(uploaderManager *uploaders.UploaderManager, dbService *DatabaseService) *UploadQueueManager {
 	return &UploadQueueManager{
-		uploaderManager:      uploaderManager,
-		dbService:            dbService,
-		activeUploads:        make(map[string]*UploadJob),
-		maxConcurrentUploads: 2, // Ограничение до 2 файлов параллельно
-		jobChannel:           make(chan *UploadJob, 100),
-		stopChannel:          make(chan bool),
+		uploaderManager: uploaderManager,
+		dbService:       dbService,
+		activeUploads:   make(map[string]*UploadJob),
+		pools:           make(map[string]*stockPool),
+		pendingBytes:    make(map[string]int64),
+		csvExporter:     NewMetadataCSVExporter(uploaderManager, dbService),
 	}
 }
 
+// SetEventEmitter задает функцию отправки событий в UI (upload:progress, upload:status)
+func (q *UploadQueueManager) SetEventEmitter(emit func(name string, data interface{})) {
+	q.emitEvent = emit
+}
+
+// emitStatus отправляет в UI актуальный статус очереди
+func (q *UploadQueueManager) emitStatus() {
+	if q.emitEvent == nil {
+		return
+	}
+	q.emitEvent("upload:status", q.GetStatus())
+}
+
 // StartUploadQueue запускает обработку очереди загрузки
 func (q *UploadQueueManager) StartUploadQueue() {
 	q.processingMutex.Lock()
@@ -57,32 +131,140 @@ func (q *UploadQueueManager) StartUploadQueue() {
 		return
 	}
 
+	log.Printf("Starting upload queue with per-stock worker pools")
+
+	q.startWorkers()
+}
+
+// startWorkers начинает новый запуск очереди со своим контекстом и создает пулы.
+// Вызывается под processingMutex.
+func (q *UploadQueueManager) startWorkers() {
+	q.runCtx, q.cancel = context.WithCancel(context.Background())
+	q.workers = &sync.WaitGroup{}
 	q.isProcessing = true
-	log.Printf("Starting upload queue with max %d concurrent uploads", q.maxConcurrentUploads)
 
-	// Запускаем worker'ов для обработки загрузок
-	for i := 0; i < q.maxConcurrentUploads; i++ {
-		go q.uploadWorker(i)
-	}
+	q.syncPools()
 }
 
-// StopUploadQueue останавливает обработку очереди загрузки
-func (q *UploadQueueManager) StopUploadQueue() {
-	q.processingMutex.Lock()
-	defer q.processingMutex.Unlock()
+// ResumePendingUploads возвращает в очередь загрузки, прерванные закрытием приложения,
+// и запускает worker'ов, если в upload_jobs остались незавершенные задачи
+func (q *UploadQueueManager) ResumePendingUploads() error {
+	interrupted, err := q.dbService.ResetInterruptedUploadJobs()
+	if err != nil {
+		return err
+	}
+	if interrupted > 0 {
+		log.Printf("Re-queued %d upload jobs interrupted by previous shutdown", interrupted)
+	}
+
+	pending, err := q.dbService.CountUploadJobs("pending")
+	if err != nil {
+		return err
+	}
+	if pending == 0 {
+		return nil
+	}
+
+	log.Printf("Resuming upload queue with %d pending jobs", pending)
+	q.StartUploadQueue()
+	return nil
+}
 
-	if !q.isProcessing {
+// syncPools создает пулы для стоков и приводит число worker'ов к текущему maxConnections.
+// Вызывается под processingMutex при запущенной очереди.
+func (q *UploadQueueManager) syncPools() {
+	// Для пулов секреты не нужны, поэтому ошибка расшифровки не мешает их создать
+	configs, err := q.dbService.GetAllStockConfigs()
+	if err != nil && !errors.Is(err, ErrSecretDecrypt) {
+		log.Printf("Warning: failed to load stock configs for upload pools: %v", err)
 		return
 	}
 
-	q.isProcessing = false
+	q.poolsMutex.Lock()
+	defer q.poolsMutex.Unlock()
+
+	for _, config := range configs {
+		limit := stockMaxConnections(config)
 
-	// Останавливаем всех worker'ов
-	for i := 0; i < q.maxConcurrentUploads; i++ {
-		q.stopChannel <- true
+		pool, exists := q.pools[config.ID]
+		if !exists {
+			pool = &stockPool{
+				stockID: config.ID,
+				wake:    make(chan struct{}, maxStockConnections),
+				shrink:  make(chan struct{}, maxStockConnections),
+			}
+			q.pools[config.ID] = pool
+		}
+		pool.stockName = config.Name
+
+		if pool.maxConnections != limit {
+			log.Printf("Upload pool for %s: %d parallel connections", config.Name, limit)
+		}
+		pool.maxConnections = limit
+
+		for pool.workers < limit {
+			pool.workers++
+			q.workers.Add(1)
+			go func(ctx context.Context, workers *sync.WaitGroup, workerID int) {
+				defer workers.Done()
+				q.stockWorker(ctx, pool, workerID)
+			}(q.runCtx, q.workers, pool.workers)
+		}
+
+		// Лишние worker'ы завершатся после текущей загрузки
+		for pool.workers > limit {
+			select {
+			case pool.shrink <- struct{}{}:
+				pool.workers--
+				continue
+			default:
+			}
+			break
+		}
 	}
+}
 
-	log.Printf("Upload queue stopped")
+// stockMaxConnections читает лимит параллельных загрузок из настроек стока
+func stockMaxConnections(config models.StockConfig) int {
+	limit := defaultStockMaxConnections
+
+	switch value := config.Settings["maxConnections"].(type) {
+	case float64:
+		limit = int(value)
+	case int:
+		limit = value
+	case string:
+		if parsed, err := strconv.Atoi(value); err == nil {
+			limit = parsed
+		}
+	}
+
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > maxStockConnections {
+		limit = maxStockConnections
+	}
+
+	return limit
+}
+
+// stopWorkers отменяет контекст текущего запуска, чтобы worker'ы прервали загрузки и остановились,
+// и сбрасывает пулы. Возвращает группу worker'ов этого запуска. Вызывается под processingMutex.
+func (q *UploadQueueManager) stopWorkers() *sync.WaitGroup {
+	q.cancel()
+	workers := q.workers
+
+	q.cancel = nil
+	q.runCtx = nil
+	q.workers = nil
+	q.isProcessing = false
+
+	q.poolsMutex.Lock()
+	q.pools = make(map[string]*stockPool)
+	q.poolsMutex.Unlock()
+
+	return workers
 }
 
 // QueuePhotosForUpload добавляет фотографии в очередь загрузки
@@ -104,7 +286,7 @@ func (q *UploadQueueManager) QueuePhotosForUpload(batchID string, photoIDs []str
 		return fmt.Errorf("no active stock configurations found for type: %s", batchType)
 	}
 
-	// Получаем данные фотографий
+	queuedCount := 0
 	for _, photoID := range photoIDs {
 		photo, err := q.getPhotoData(photoID)
 		if err != nil {
@@ -112,196 +294,573 @@ func (q *UploadQueueManager) QueuePhotosForUpload(batchID string, photoIDs []str
 			continue
 		}
 
-		// Создаем задачу загрузки
-		job := &UploadJob{
-			PhotoID:      photoID,
-			BatchID:      batchID,
-			FileName:     photo.FileName,
-			Photo:        photo,
-			StockConfigs: stockConfigs,
-			Status:       "pending",
-			Progress:     make(map[string]string),
-		}
-
-		// Инициализируем прогресс для каждого стока
+		// Создаем по задаче на каждый сток
 		for _, config := range stockConfigs {
-			job.Progress[config.ID] = "pending"
+			if err := q.dbService.EnqueueUploadJob(batchID, photoID, config.ID); err != nil {
+				return fmt.Errorf("failed to queue %s for %s: %w", photo.FileName, config.Name, err)
+			}
 		}
 
-		// Обновляем статус фотографии как "queued_for_upload"
-		q.dbService.UpdatePhotoUploadQueueStatus(photoID, "queued")
+		q.syncPhotoUploadStatus(batchID, photoID, photo.FileName)
+		queuedCount++
+		log.Printf("Photo %s queued for upload to %d stocks", photo.FileName, len(stockConfigs))
+	}
+
+	q.dbService.LogEvent(batchID, "", "stock_upload", "queued",
+		fmt.Sprintf("В очередь загрузки добавлено %d фото для %d стоков", queuedCount, len(stockConfigs)), "", 0)
+
+	// Подхватываем новые стоки и изменения maxConnections, затем будим worker'ов
+	q.processingMutex.Lock()
+	if q.isProcessing {
+		q.syncPools()
+	}
+	q.processingMutex.Unlock()
+
+	for _, config := range stockConfigs {
+		q.wakePool(config.ID)
+	}
+
+	q.emitStatus()
+	return nil
+}
+
+// wakePool будит простаивающих worker'ов стока после добавления задач
+func (q *UploadQueueManager) wakePool(stockID string) {
+	q.poolsMutex.Lock()
+	defer q.poolsMutex.Unlock()
 
-		// Добавляем в очередь
+	pool, exists := q.pools[stockID]
+	if !exists {
+		return
+	}
+
+	for i := 0; i < pool.workers; i++ {
 		select {
-		case q.jobChannel <- job:
-			log.Printf("Photo %s queued for upload to %d stocks", photo.FileName, len(stockConfigs))
+		case pool.wake <- struct{}{}:
 		default:
-			log.Printf("Upload queue is full, skipping photo %s", photo.FileName)
+			return
 		}
 	}
-
-	return nil
 }
 
-// uploadWorker обрабатывает задачи загрузки
-func (q *UploadQueueManager) uploadWorker(workerID int) {
-	log.Printf("Upload worker %d started", workerID)
+// stockWorker забирает из upload_jobs задачи своего стока и выполняет их до отмены ctx
+func (q *UploadQueueManager) stockWorker(ctx context.Context, pool *stockPool, workerID int) {
+	log.Printf("Upload worker %d for stock %s started", workerID, pool.stockName)
 
 	for {
 		select {
-		case job := <-q.jobChannel:
-			q.processUploadJob(workerID, job)
-		case <-q.stopChannel:
-			log.Printf("Upload worker %d stopped", workerID)
+		case <-ctx.Done():
+			log.Printf("Upload worker %d for stock %s stopped", workerID, pool.stockName)
+			return
+		case <-pool.shrink:
+			log.Printf("Upload worker %d for stock %s released", workerID, pool.stockName)
+			return
+		default:
+		}
+
+		q.claimMutex.Lock()
+		job, err := q.dbService.ClaimNextUploadJob(pool.stockID)
+		q.claimMutex.Unlock()
+		if err != nil {
+			log.Printf("Upload worker %d for stock %s: failed to claim job: %v", workerID, pool.stockName, err)
+		}
+
+		if job != nil {
+			q.processUploadJob(ctx, pool, workerID, job)
+			continue
+		}
+
+		// Задач нет - ждем новых или следующего опроса
+		select {
+		case <-pool.wake:
+		case <-time.After(uploadQueuePollInterval):
+		case <-ctx.Done():
+			log.Printf("Upload worker %d for stock %s stopped", workerID, pool.stockName)
+			return
+		case <-pool.shrink:
+			log.Printf("Upload worker %d for stock %s released", workerID, pool.stockName)
 			return
 		}
 	}
 }
 
-// processUploadJob обрабатывает одну задачу загрузки
-func (q *UploadQueueManager) processUploadJob(workerID int, job *UploadJob) {
-	log.Printf("Worker %d: Starting upload of %s", workerID, job.FileName)
+// processUploadJob выполняет загрузку одного фото на один сток. Загрузка, прерванная отменой ctx,
+// возвращается в очередь без учета попытки.
+func (q *UploadQueueManager) processUploadJob(ctx context.Context, pool *stockPool, workerID int, job *models.UploadJob) {
+	photo, err := q.getPhotoData(job.PhotoID)
+	if err != nil {
+		log.Printf("Worker %d: Failed to load photo %s for upload: %v", workerID, job.PhotoID, err)
+		q.dbService.FinishUploadJob(job.ID, "dead_letter", err.Error())
+		return
+	}
+
+	stockConfig, err := q.dbService.GetStockConfig(job.StockID)
+	if err != nil {
+		log.Printf("Worker %d: Failed to load stock config %s: %v", workerID, job.StockID, err)
+		q.dbService.FinishUploadJob(job.ID, "dead_letter", err.Error())
+		q.syncPhotoUploadStatus(job.BatchID, job.PhotoID, photo.FileName)
+		return
+	}
+
+	log.Printf("Worker %d: Uploading %s to %s (attempt %d)", workerID, photo.FileName, stockConfig.Name, job.Attempts)
 
 	// Добавляем в активные загрузки
+	active := &UploadJob{
+		JobID:     job.ID,
+		PhotoID:   job.PhotoID,
+		BatchID:   job.BatchID,
+		StockID:   job.StockID,
+		StockName: stockConfig.Name,
+		FileName:  photo.FileName,
+		Attempt:   job.Attempts,
+		StartTime: time.Now(),
+		Status:    "uploading",
+		Progress:  map[string]string{job.StockID: "uploading"},
+	}
 	q.uploadsMutex.Lock()
-	job.Status = "uploading"
-	job.StartTime = time.Now()
-	q.activeUploads[job.PhotoID] = job
+	q.activeUploads[job.ID] = active
 	q.uploadsMutex.Unlock()
 
+	q.poolsMutex.Lock()
+	pool.activeCount++
+	q.poolsMutex.Unlock()
+
 	defer func() {
 		// Удаляем из активных загрузок
 		q.uploadsMutex.Lock()
-		delete(q.activeUploads, job.PhotoID)
+		delete(q.activeUploads, job.ID)
 		q.uploadsMutex.Unlock()
+
+		q.poolsMutex.Lock()
+		pool.activeCount--
+		q.poolsMutex.Unlock()
+
+		q.emitStatus()
 	}()
 
-	// Обновляем статус фотографии
-	q.dbService.UpdatePhotoUploadQueueStatus(job.PhotoID, "uploading")
+	q.syncPhotoUploadStatus(job.BatchID, job.PhotoID, photo.FileName)
+
+	// Логируем начало загрузки на конкретный сток
+	q.dbService.LogEvent(job.BatchID, job.PhotoID, "stock_upload", "started",
+		fmt.Sprintf("Начата загрузка %s на %s (worker %d)", photo.FileName, stockConfig.Name, workerID), "", 0)
+
+	q.emitStatus()
 
-	successCount := 0
-	failedCount := 0
+	// Готовим копию фото с метаданными стока, исходный файл не изменяется
+	staging := q.newStagingProcessor()
+	stagedPhoto, err := q.stagePhoto(staging, photo, stockConfig)
 
-	// Загружаем на каждый сток последовательно
-	for _, stockConfig := range job.StockConfigs {
-		log.Printf("Worker %d: Uploading %s to %s", workerID, job.FileName, stockConfig.Name)
+	// Выполняем загрузку
+	var result models.UploadResult
+	if err == nil {
+		result, err = q.uploaderManager.UploadPhotoWithProgress(ctx, stagedPhoto, stockConfig, func(sent, total int64) {
+			q.reportProgress(active, sent, total)
+		})
+	}
+
+	if (err != nil || !result.Success) && ctx.Err() != nil {
+		log.Printf("Worker %d: Upload of %s to %s interrupted by queue stop", workerID, photo.FileName, stockConfig.Name)
 
-		// Обновляем прогресс
 		q.uploadsMutex.Lock()
-		job.Progress[stockConfig.ID] = "uploading"
+		active.Status = "interrupted"
+		active.Progress[job.StockID] = "pending"
 		q.uploadsMutex.Unlock()
 
-		// Обновляем статус в базе данных
-		q.dbService.UpdatePhotoUploadStatus(job.PhotoID, stockConfig.ID, "uploading")
+		// Копия фото сохраняется: загрузка продолжится при следующем запуске очереди
+		if dbErr := q.dbService.RequeueInterruptedUploadJob(job.ID); dbErr != nil {
+			log.Printf("Worker %d: %v", workerID, dbErr)
+		}
+		q.dbService.LogEvent(job.BatchID, job.PhotoID, "stock_upload", "interrupted",
+			fmt.Sprintf("Загрузка %s на %s прервана остановкой очереди и вернется в очередь", photo.FileName, stockConfig.Name), "", 0)
+	} else if err != nil || !result.Success {
+		log.Printf("Worker %d: Failed to upload %s to %s: %v", workerID, photo.FileName, stockConfig.Name, err)
+
+		// Неуспех без ошибки (Success=false) считаем временным сбоем
+		if err == nil {
+			err = uploaders.NewTransientError(fmt.Errorf("%s", result.Message))
+		}
+		errorMsg := err.Error()
 
-		// Логируем начало загрузки на конкретный сток
-		q.dbService.LogEvent(job.BatchID, job.PhotoID, "stock_upload", "started",
-			fmt.Sprintf("Начата загрузка %s на %s (worker %d)", job.FileName, stockConfig.Name, workerID), "", 0)
+		q.uploadsMutex.Lock()
+		active.Status = "failed"
+		active.Progress[job.StockID] = "failed"
+		q.uploadsMutex.Unlock()
 
-		// Выполняем загрузку
-		result, err := q.uploaderManager.UploadPhoto(job.Photo, stockConfig)
+		policy := q.retryPolicy()
+		if policy.ShouldRetry(err, job.Attempts) {
+			delay := policy.NextDelay(job.Attempts)
 
-		if err != nil || !result.Success {
-			log.Printf("Worker %d: Failed to upload %s to %s: %v", workerID, job.FileName, stockConfig.Name, err)
+			q.poolsMutex.Lock()
+			pool.retriedCount++
+			q.poolsMutex.Unlock()
 
-			// Обновляем прогресс
-			q.uploadsMutex.Lock()
-			job.Progress[stockConfig.ID] = "failed"
-			q.uploadsMutex.Unlock()
+			if dbErr := q.dbService.RescheduleUploadJob(job.ID, delay, errorMsg); dbErr != nil {
+				log.Printf("Worker %d: %v", workerID, dbErr)
+			}
 
-			// Обновляем статус в базе данных
-			q.dbService.UpdatePhotoUploadStatus(job.PhotoID, stockConfig.ID, "failed")
+			q.dbService.LogEvent(job.BatchID, job.PhotoID, "stock_upload", "retry_scheduled",
+				fmt.Sprintf("Ошибка загрузки %s на %s (попытка %d из %d), повтор через %s",
+					photo.FileName, stockConfig.Name, job.Attempts, policy.MaxAttempts, delay.Round(time.Second)), errorMsg, 0)
+		} else {
+			q.poolsMutex.Lock()
+			pool.failedCount++
+			q.poolsMutex.Unlock()
 
-			// Логируем ошибку
-			errorMsg := result.Message
-			if err != nil {
-				errorMsg = err.Error()
+			if dbErr := q.dbService.FinishUploadJob(job.ID, "dead_letter", errorMsg); dbErr != nil {
+				log.Printf("Worker %d: %v", workerID, dbErr)
 			}
-			q.dbService.LogEvent(job.BatchID, job.PhotoID, "stock_upload", "failed",
-				fmt.Sprintf("Ошибка загрузки %s на %s", job.FileName, stockConfig.Name), errorMsg, 0)
 
-			failedCount++
-		} else {
-			log.Printf("Worker %d: Successfully uploaded %s to %s", workerID, job.FileName, stockConfig.Name)
+			reason := "исчерпаны попытки"
+			if uploaders.IsPermanentError(err) {
+				reason = "постоянная ошибка"
+			}
+			q.dbService.LogEvent(job.BatchID, job.PhotoID, "stock_upload", "dead_letter",
+				fmt.Sprintf("Загрузка %s на %s остановлена после %d попыток: %s",
+					photo.FileName, stockConfig.Name, job.Attempts, reason), errorMsg, 0)
 
-			// Обновляем прогресс
-			q.uploadsMutex.Lock()
-			job.Progress[stockConfig.ID] = "uploaded"
-			q.uploadsMutex.Unlock()
+			// Повторов не будет, ручной повтор подготовит копию заново
+			q.cleanupStagedPhoto(staging, job)
+		}
+	} else {
+		log.Printf("Worker %d: Successfully uploaded %s to %s", workerID, photo.FileName, stockConfig.Name)
 
-			// Обновляем статус в базе данных
-			q.dbService.UpdatePhotoUploadStatus(job.PhotoID, stockConfig.ID, "uploaded")
+		q.uploadsMutex.Lock()
+		active.Status = "uploaded"
+		active.Progress[job.StockID] = "uploaded"
+		q.uploadsMutex.Unlock()
 
-			// Логируем успех
-			q.dbService.LogEvent(job.BatchID, job.PhotoID, "stock_upload", "success",
-				fmt.Sprintf("Файл %s успешно загружен на %s", job.FileName, stockConfig.Name), "", 100)
+		q.poolsMutex.Lock()
+		pool.uploadedCount++
+		pool.completedAt = append(pool.completedAt, time.Now())
+		q.poolsMutex.Unlock()
 
-			successCount++
+		q.dbService.FinishUploadJob(job.ID, "uploaded", "")
+		q.cleanupStagedPhoto(staging, job)
+
+		// Логируем успех
+		q.dbService.LogEvent(job.BatchID, job.PhotoID, "stock_upload", "success",
+			fmt.Sprintf("Файл %s успешно загружен на %s", photo.FileName, stockConfig.Name), "", 100)
+	}
+
+	q.syncPhotoUploadStatus(job.BatchID, job.PhotoID, photo.FileName)
+
+	// Когда все фото батча для стока загружены, отправляем CSV метаданных, если он включен
+	q.csvExporter.UploadAfterBatch(ctx, job.BatchID, stockConfig)
+}
+
+// stagePhoto копирует фото в папку подготовки стока и встраивает в копию метаданные,
+// прошедшие правила стока. Возвращает фото, в котором OriginalPath указывает на копию.
+func (q *UploadQueueManager) stagePhoto(staging *ImageProcessor, photo models.Photo, config models.StockConfig) (models.Photo, error) {
+	// Сток получает файл, который выбирает его загрузчик: например, JPEG из пары RAW+JPEG
+	photo.Renditions = photoRenditions(photo)
+	photo, err := q.uploaderManager.SelectRendition(photo, config)
+	if err != nil {
+		return photo, err
+	}
+
+	stagedPath, err := staging.StagePhoto(photo, config.ID)
+	if err != nil {
+		// Без исходного файла повтор не поможет
+		if errors.Is(err, fs.ErrNotExist) {
+			return photo, uploaders.NewPermanentError(err)
+		}
+		return photo, uploaders.NewTransientError(err)
+	}
+
+	staged := uploaders.ApplyMetadataRules(photo, config)
+	staged.OriginalPath = stagedPath
+	if IsRawFile(stagedPath) {
+		// Метаданные RAW записываются в sidecar, загрузчик отправит его следом за файлом
+		staged.SidecarPath = XMPSidecarPath(stagedPath)
+	}
+
+	if staged.AIResult != nil {
+		if err := staging.WriteExifToImage(stagedPath, *staged.AIResult); err != nil {
+			// Как и при записи в оригинал, ошибка EXIF не останавливает загрузку
+			log.Printf("Warning: failed to write EXIF to staged copy %s: %v", stagedPath, err)
+			q.dbService.LogEvent(photo.BatchID, photo.ID, "stock_upload", "warning",
+				fmt.Sprintf("Предупреждение при записи метаданных в копию %s для %s", photo.FileName, config.Name), err.Error(), 0)
+		}
+
+		// Проверяем, что в копию записано именно то, что уйдет на сток
+		verification := staging.VerifyExifData(stagedPath, *staged.AIResult)
+		verification.StockID = config.ID
+		q.dbService.RecordMetadataVerification(photo.BatchID, photo.ID, verification)
+		if MetadataVerificationBlocksUpload(verification) && !photo.MetadataOverride {
+			return staged, uploaders.NewPermanentError(fmt.Errorf("метаданные в копии для %s не совпадают с ожидаемыми, загрузка заблокирована до подтверждения: %s",
+				config.Name, metadataIssuesSummary(verification.Issues)))
 		}
 	}
 
-	// Определяем финальный статус
-	var finalStatus string
-	if successCount > 0 && failedCount == 0 {
-		finalStatus = "uploaded"
-		job.Status = "completed"
-	} else if successCount == 0 && failedCount > 0 {
-		finalStatus = "upload_failed"
-		job.Status = "failed"
+	return staged, nil
+}
+
+// newStagingProcessor создает обработчик изображений с временной папкой и способом записи метаданных из настроек
+func (q *UploadQueueManager) newStagingProcessor() *ImageProcessor {
+	settings, err := q.dbService.GetSettings()
+	if err != nil {
+		log.Printf("Warning: failed to get settings for staging: %v", err)
+	}
+	if settings.TempDirectory == "" {
+		settings.TempDirectory = "./temp"
+	}
+
+	staging := NewImageProcessor(settings.TempDirectory)
+	staging.SetMetadataBackend(settings.MetadataBackend)
+	return staging
+}
+
+// cleanupStagedPhoto удаляет копию фото, подготовленную для стока задачи
+func (q *UploadQueueManager) cleanupStagedPhoto(staging *ImageProcessor, job *models.UploadJob) {
+	if err := staging.CleanupStagedPhoto(job.PhotoID, job.StockID); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+}
+
+// reportProgress обновляет побайтовый прогресс активной загрузки и отправляет событие upload:progress
+func (q *UploadQueueManager) reportProgress(job *UploadJob, sent, total int64) {
+	now := time.Now()
+
+	q.uploadsMutex.Lock()
+	if job.rateStartTime.IsZero() {
+		// Первый отчет: при докачке sent уже включает загруженную ранее часть
+		job.rateStartBytes = sent
+		job.rateStartTime = now
 	} else {
-		finalStatus = "partially_uploaded"
-		job.Status = "completed"
+		if elapsed := now.Sub(job.rateStartTime).Seconds(); elapsed > 0 {
+			job.BytesPerSecond = float64(sent-job.rateStartBytes) / elapsed
+		}
+		if delta := sent - job.BytesSent; delta > 0 {
+			q.transfers = append(q.transfers, transferSample{at: now, batchID: job.BatchID, bytes: delta})
+		}
+	}
+	job.BytesSent = sent
+	job.BytesTotal = total
+
+	event := map[string]interface{}{
+		"jobId":           job.JobID,
+		"photoId":         job.PhotoID,
+		"batchId":         job.BatchID,
+		"stockId":         job.StockID,
+		"stockName":       job.StockName,
+		"fileName":        job.FileName,
+		"bytesSent":       sent,
+		"bytesTotal":      total,
+		"bytesPerSecond":  job.BytesPerSecond,
+		"batchEtaSeconds": q.batchETALocked(job.BatchID, now),
+	}
+	q.uploadsMutex.Unlock()
+
+	if q.emitEvent != nil {
+		q.emitEvent("upload:progress", event)
 	}
+}
+
+// batchRateLocked возвращает скорость передачи батча в байтах в секунду за окно uploadRateWindow.
+// Вызывается под uploadsMutex.
+func (q *UploadQueueManager) batchRateLocked(batchID string, now time.Time) float64 {
+	cutoff := now.Add(-uploadRateWindow)
 
-	// Обновляем финальный статус фотографии
-	q.dbService.UpdatePhotoUploadQueueStatus(job.PhotoID, finalStatus)
+	// Отбрасываем порции вне окна
+	recent := q.transfers[:0]
+	for _, sample := range q.transfers {
+		if sample.at.After(cutoff) {
+			recent = append(recent, sample)
+		}
+	}
+	q.transfers = recent
 
-	// Логируем завершение
-	q.dbService.LogEvent(job.BatchID, job.PhotoID, "stock_upload", "completed",
-		fmt.Sprintf("Загрузка %s завершена. Успешно: %d, Ошибок: %d", job.FileName, successCount, failedCount), "", 100)
+	var bytes int64
+	var oldest time.Time
+	for _, sample := range recent {
+		if sample.batchID != batchID {
+			continue
+		}
+		bytes += sample.bytes
+		if oldest.IsZero() || sample.at.Before(oldest) {
+			oldest = sample.at
+		}
+	}
+	if bytes == 0 {
+		return 0
+	}
 
-	log.Printf("Worker %d: Finished uploading %s. Success: %d, Failed: %d", workerID, job.FileName, successCount, failedCount)
+	// Пока окно не заполнено, делим на фактическое время передачи
+	elapsed := now.Sub(oldest)
+	if elapsed < time.Second {
+		elapsed = time.Second
+	}
+	return float64(bytes) / elapsed.Seconds()
 }
 
-// GetUploadStatus возвращает статус загрузки
-func (q *UploadQueueManager) GetUploadStatus() map[string]interface{} {
-	q.uploadsMutex.RLock()
-	defer q.uploadsMutex.RUnlock()
+// batchETALocked оценивает оставшееся время загрузки батча в секундах по суммарной скорости
+// всех его загрузок. -1, если оценить нельзя. Вызывается под uploadsMutex.
+func (q *UploadQueueManager) batchETALocked(batchID string, now time.Time) int64 {
+	rate := q.batchRateLocked(batchID, now)
+	if rate <= 0 {
+		return -1
+	}
 
-	activeJobs := make([]map[string]interface{}, 0, len(q.activeUploads))
+	remaining := q.pendingBytes[batchID]
 	for _, job := range q.activeUploads {
-		activeJobs = append(activeJobs, map[string]interface{}{
-			"photoId":   job.PhotoID,
-			"fileName":  job.FileName,
-			"status":    job.Status,
-			"progress":  job.Progress,
-			"startTime": job.StartTime,
-		})
+		if job.BatchID == batchID && job.BytesTotal > job.BytesSent {
+			remaining += job.BytesTotal - job.BytesSent
+		}
 	}
 
-	return map[string]interface{}{
-		"isProcessing":  q.isProcessing,
-		"activeUploads": len(q.activeUploads),
-		"maxConcurrent": q.maxConcurrentUploads,
-		"activeJobs":    activeJobs,
-		"queueLength":   len(q.jobChannel),
+	return int64(float64(remaining) / rate)
+}
+
+// syncPhotoUploadStatus пересчитывает photos.upload_status и общий статус фото по таблице upload_jobs
+func (q *UploadQueueManager) syncPhotoUploadStatus(batchID, photoID, fileName string) {
+	q.statusMutex.Lock()
+	defer q.statusMutex.Unlock()
+
+	jobs, err := q.dbService.GetPhotoUploadJobs(photoID)
+	if err != nil {
+		log.Printf("Warning: failed to load upload jobs for photo %s: %v", photoID, err)
+		return
+	}
+	if len(jobs) == 0 {
+		return
+	}
+
+	uploadStatus := make(map[string]string, len(jobs))
+	pendingCount, uploadingCount, successCount, failedCount := 0, 0, 0, 0
+	for _, job := range jobs {
+		uploadStatus[job.StockID] = job.State
+		switch job.State {
+		case "pending":
+			if job.Attempts > 0 {
+				uploadStatus[job.StockID] = "retrying"
+			}
+			pendingCount++
+		case "uploading":
+			uploadingCount++
+		case "uploaded":
+			successCount++
+		default:
+			// dead_letter и failed из старых версий
+			uploadStatus[job.StockID] = "failed"
+			failedCount++
+		}
+	}
+
+	if err := q.dbService.SavePhotoUploadStatus(photoID, uploadStatus); err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	// Определяем общий статус фото
+	var photoStatus string
+	switch {
+	case uploadingCount > 0:
+		photoStatus = "uploading"
+	case pendingCount > 0:
+		photoStatus = "queued"
+	case failedCount == 0:
+		photoStatus = "uploaded"
+	case successCount == 0:
+		photoStatus = "upload_failed"
+	default:
+		photoStatus = "partially_uploaded"
+	}
+
+	var currentStatus string
+	q.dbService.db.QueryRow("SELECT status FROM photos WHERE id = ?", photoID).Scan(&currentStatus)
+	if currentStatus == photoStatus {
+		return
+	}
+
+	q.dbService.UpdatePhotoUploadQueueStatus(photoID, photoStatus)
+
+	// Логируем завершение, когда по фото не осталось активных задач
+	if pendingCount == 0 && uploadingCount == 0 {
+		q.dbService.LogEvent(batchID, photoID, "stock_upload", "completed",
+			fmt.Sprintf("Загрузка %s завершена. Успешно: %d, Ошибок: %d", fileName, successCount, failedCount), "", 100)
+
+		log.Printf("Finished uploading %s. Success: %d, Failed: %d", fileName, successCount, failedCount)
+	}
+}
+
+// retryPolicy возвращает политику повторов по текущим настройкам приложения
+func (q *UploadQueueManager) retryPolicy() UploadRetryPolicy {
+	settings, err := q.dbService.GetSettings()
+	if err != nil {
+		log.Printf("Warning: failed to get settings for upload retry policy: %v", err)
 	}
+	return NewUploadRetryPolicy(settings)
+}
+
+// RetryFailedUploads возвращает в очередь загрузки батча, попавшие в dead-letter.
+// Пустой stockID перезапускает загрузки на все стоки. Возвращает число затронутых фото.
+func (q *UploadQueueManager) RetryFailedUploads(batchID, stockID string) (int, error) {
+	photoIDs, err := q.dbService.RequeueDeadLetterJobs(batchID, stockID)
+	if err != nil {
+		return 0, err
+	}
+	if len(photoIDs) == 0 {
+		return 0, nil
+	}
+
+	for _, photoID := range photoIDs {
+		photo, err := q.getPhotoData(photoID)
+		if err != nil {
+			log.Printf("Warning: failed to get photo data for %s: %v", photoID, err)
+			continue
+		}
+		q.syncPhotoUploadStatus(batchID, photoID, photo.FileName)
+	}
+
+	q.dbService.LogEvent(batchID, "", "stock_upload", "queued",
+		fmt.Sprintf("Повторная загрузка %d фото после ошибок", len(photoIDs)), "", 0)
+
+	// Запускаем очередь, если она остановлена, иначе подхватываем изменения стоков
+	q.processingMutex.Lock()
+	if q.isProcessing {
+		q.syncPools()
+	} else {
+		q.startWorkers()
+	}
+	q.processingMutex.Unlock()
+
+	q.poolsMutex.Lock()
+	stockIDs := make([]string, 0, len(q.pools))
+	for id := range q.pools {
+		if stockID == "" || id == stockID {
+			stockIDs = append(stockIDs, id)
+		}
+	}
+	q.poolsMutex.Unlock()
+
+	for _, id := range stockIDs {
+		q.wakePool(id)
+	}
+
+	q.emitStatus()
+
+	return len(photoIDs), nil
+}
+
+// GetUploadStatus возвращает статус загрузки
+func (q *UploadQueueManager) GetUploadStatus() map[string]interface{} {
+	return q.GetStatus()
 }
 
 // getPhotoData получает данные фотографии из базы данных
 func (q *UploadQueueManager) getPhotoData(photoID string) (models.Photo, error) {
 	var photo models.Photo
-	var exifJSON, aiResultsJSON, uploadStatusJSON string
+	var exifJSON, aiResultsJSON, uploadStatusJSON, renditionsJSON, stockAIResultsJSON string
 
 	err := q.dbService.db.QueryRow(`
 		SELECT id, batch_id, content_type, original_path, thumbnail_path, file_name, file_size,
-		       exif_data, ai_results, upload_status, status, created_at
+		       exif_data, ai_results, upload_status, status, created_at, COALESCE(metadata_override, 0),
+		       COALESCE(renditions, ''), COALESCE(stock_ai_results, '')
 		FROM photos 
 		WHERE id = ?`, photoID).Scan(
 		&photo.ID, &photo.BatchID, &photo.ContentType, &photo.OriginalPath,
 		&photo.ThumbnailPath, &photo.FileName, &photo.FileSize,
 		&exifJSON, &aiResultsJSON, &uploadStatusJSON,
-		&photo.Status, &photo.CreatedAt)
+		&photo.Status, &photo.CreatedAt, &photo.MetadataOverride, &renditionsJSON, &stockAIResultsJSON)
 
 	if err != nil {
 		return photo, fmt.Errorf("failed to get photo data: %w", err)
@@ -320,6 +879,12 @@ func (q *UploadQueueManager) getPhotoData(photoID string) (models.Photo, error)
 	if uploadStatusJSON != "" {
 		json.Unmarshal([]byte(uploadStatusJSON), &photo.UploadStatus)
 	}
+	if renditionsJSON != "" {
+		json.Unmarshal([]byte(renditionsJSON), &photo.Renditions)
+	}
+	if stockAIResultsJSON != "" {
+		json.Unmarshal([]byte(stockAIResultsJSON), &photo.StockAIResults)
+	}
 
 	// Инициализируем карты если они nil
 	if photo.ExifData == nil {
@@ -332,58 +897,148 @@ func (q *UploadQueueManager) getPhotoData(photoID string) (models.Photo, error)
 	return photo, nil
 }
 
-// GetStatus возвращает текущий статус очереди загрузки
+// GetStatus возвращает текущий статус очереди загрузки с разбивкой по стокам
 func (uqm *UploadQueueManager) GetStatus() map[string]interface{} {
-	uqm.uploadsMutex.RLock()
-	defer uqm.uploadsMutex.RUnlock()
+	pendingByStock, err := uqm.dbService.CountUploadJobsByStock("pending")
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	queueLength := 0
+	for _, count := range pendingByStock {
+		queueLength += count
+	}
 
+	deadLetterByStock, err := uqm.dbService.CountUploadJobsByStock("dead_letter")
+	if err != nil {
+		log.Printf("Warning: %v", err)
+	}
+
+	pendingBytes, err := uqm.dbService.SumPendingUploadBytesByBatch()
+	if err != nil {
+		log.Printf("Warning: %v", err)
+		pendingBytes = make(map[string]int64)
+	}
+
+	now := time.Now()
+	uqm.uploadsMutex.Lock()
+	uqm.pendingBytes = pendingBytes
 	activeJobs := make([]map[string]interface{}, 0)
+	batchIDs := make(map[string]bool)
+	var bytesPerSecond float64
 	for _, job := range uqm.activeUploads {
 		jobInfo := map[string]interface{}{
-			"photoId":  job.PhotoID,
-			"fileName": job.FileName,
-			"status":   job.Status,
-			"progress": job.Progress,
+			"jobId":          job.JobID,
+			"photoId":        job.PhotoID,
+			"batchId":        job.BatchID,
+			"fileName":       job.FileName,
+			"stockId":        job.StockID,
+			"stockName":      job.StockName,
+			"attempt":        job.Attempt,
+			"status":         job.Status,
+			"progress":       job.Progress,
+			"startTime":      job.StartTime,
+			"bytesSent":      job.BytesSent,
+			"bytesTotal":     job.BytesTotal,
+			"bytesPerSecond": job.BytesPerSecond,
 		}
 		activeJobs = append(activeJobs, jobInfo)
+		batchIDs[job.BatchID] = true
+		bytesPerSecond += job.BytesPerSecond
+	}
+	for batchID := range pendingBytes {
+		batchIDs[batchID] = true
 	}
 
+	// ETA по батчам из суммарной скорости их загрузок
+	batches := make([]map[string]interface{}, 0, len(batchIDs))
+	for batchID := range batchIDs {
+		batches = append(batches, map[string]interface{}{
+			"batchId":        batchID,
+			"bytesPerSecond": uqm.batchRateLocked(batchID, now),
+			"etaSeconds":     uqm.batchETALocked(batchID, now),
+		})
+	}
+	activeUploads := len(uqm.activeUploads)
+	uqm.uploadsMutex.Unlock()
+
+	// Статистика по стокам: глубина очереди, активные загрузки и пропускная способность
+	uqm.poolsMutex.Lock()
+	maxConcurrent := 0
+	stocks := make([]map[string]interface{}, 0, len(uqm.pools))
+	cutoff := time.Now().Add(-uploadThroughputWindow)
+	for _, pool := range uqm.pools {
+		// Отбрасываем загрузки вне окна
+		recent := pool.completedAt[:0]
+		for _, completed := range pool.completedAt {
+			if completed.After(cutoff) {
+				recent = append(recent, completed)
+			}
+		}
+		pool.completedAt = recent
+
+		maxConcurrent += pool.maxConnections
+		stocks = append(stocks, map[string]interface{}{
+			"stockId":             pool.stockID,
+			"stockName":           pool.stockName,
+			"maxConnections":      pool.maxConnections,
+			"activeUploads":       pool.activeCount,
+			"queueDepth":          pendingByStock[pool.stockID],
+			"uploadedCount":       pool.uploadedCount,
+			"failedCount":         pool.failedCount,
+			"retriedCount":        pool.retriedCount,
+			"deadLetterCount":     deadLetterByStock[pool.stockID],
+			"throughputPerMinute": float64(len(recent)) / uploadThroughputWindow.Minutes(),
+		})
+	}
+	uqm.poolsMutex.Unlock()
+
+	sort.Slice(stocks, func(i, j int) bool {
+		return stocks[i]["stockName"].(string) < stocks[j]["stockName"].(string)
+	})
+
+	uqm.processingMutex.Lock()
+	isProcessing := uqm.isProcessing
+	uqm.processingMutex.Unlock()
+
 	return map[string]interface{}{
-		"isProcessing":  uqm.isProcessing,
-		"activeUploads": len(uqm.activeUploads),
-		"queueLength":   len(uqm.jobChannel),
-		"maxConcurrent": uqm.maxConcurrentUploads,
-		"activeJobs":    activeJobs,
+		"isProcessing":   isProcessing,
+		"activeUploads":  activeUploads,
+		"queueLength":    queueLength,
+		"maxConcurrent":  maxConcurrent,
+		"activeJobs":     activeJobs,
+		"stocks":         stocks,
+		"batches":        batches,
+		"bytesPerSecond": bytesPerSecond,
 	}
 }
 
-// Stop останавливает очередь загрузки
-func (uqm *UploadQueueManager) Stop() {
+// Stop останавливает очередь загрузки, прерывает текущие загрузки и ждет, пока worker'ы
+// вернут их в очередь, но не дольше дедлайна ctx
+func (uqm *UploadQueueManager) Stop(ctx context.Context) error {
 	uqm.processingMutex.Lock()
-	defer uqm.processingMutex.Unlock()
-
 	if !uqm.isProcessing {
-		return
+		uqm.processingMutex.Unlock()
+		return nil
 	}
 
 	log.Println("Stopping upload queue...")
+	workers := uqm.stopWorkers()
+	uqm.processingMutex.Unlock()
 
-	// Отправляем сигнал остановки
-	close(uqm.stopChannel)
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
 
-	// Ждем завершения активных загрузок
-	uqm.uploadsMutex.Lock()
-	for len(uqm.activeUploads) > 0 {
-		uqm.uploadsMutex.Unlock()
-		time.Sleep(100 * time.Millisecond)
-		uqm.uploadsMutex.Lock()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		return fmt.Errorf("upload queue did not stop in time: %w", ctx.Err())
 	}
-	uqm.uploadsMutex.Unlock()
-
-	uqm.isProcessing = false
-
-	// Создаем новый канал остановки для следующего запуска
-	uqm.stopChannel = make(chan bool)
 
 	log.Println("Upload queue stopped")
+	uqm.emitStatus()
+	return nil
 }
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"math/rand"
+	"stock-photo-app/models"
+	"stock-photo-app/uploaders"
+	"time"
+)
+
+// Значения политики повторов, если в настройках ничего не задано
+const (
+	defaultUploadMaxAttempts = 5
+	defaultUploadRetryDelay  = 30 * time.Second
+	maxUploadRetryDelay      = 30 * time.Minute
+)
+
+// UploadRetryPolicy решает, повторять ли неудачную загрузку и через сколько.
+// Задержка растет экспоненциально от BaseDelay до MaxDelay, половина задержки случайная,
+// чтобы задачи одного стока после сбоя не возвращались на сервер одновременно.
+type UploadRetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// NewUploadRetryPolicy создает политику повторов из настроек приложения
+func NewUploadRetryPolicy(settings models.AppSettings) UploadRetryPolicy {
+	policy := UploadRetryPolicy{
+		MaxAttempts: defaultUploadMaxAttempts,
+		BaseDelay:   defaultUploadRetryDelay,
+		MaxDelay:    maxUploadRetryDelay,
+	}
+
+	if settings.UploadMaxAttempts > 0 {
+		policy.MaxAttempts = settings.UploadMaxAttempts
+	}
+	if settings.UploadRetryDelay > 0 {
+		policy.BaseDelay = time.Duration(settings.UploadRetryDelay) * time.Second
+	}
+	if policy.BaseDelay > policy.MaxDelay {
+		policy.MaxDelay = policy.BaseDelay
+	}
+
+	return policy
+}
+
+// ShouldRetry сообщает, нужно ли повторить загрузку после attempts неудачных попыток
+func (p UploadRetryPolicy) ShouldRetry(err error, attempts int) bool {
+	if uploaders.IsPermanentError(err) {
+		return false
+	}
+	return attempts < p.MaxAttempts
+}
+
+// NextDelay возвращает задержку перед следующей попыткой после attempts неудачных попыток
+func (p UploadRetryPolicy) NextDelay(attempts int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempts && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
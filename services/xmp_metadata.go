@@ -0,0 +1,423 @@
+package services
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Пространства имен XMP, с которыми работает приложение
+const (
+	nsX         = "adobe:ns:meta/"
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+	nsXML       = "http://www.w3.org/XML/1998/namespace"
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsXMP       = "http://ns.adobe.com/xap/1.0/"
+	nsXMPNote   = "http://ns.adobe.com/xmp/note/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+)
+
+// Префиксы по умолчанию для пространств имен, которых не было в исходном пакете
+var xmpDefaultPrefixes = map[string]string{
+	nsX:         "x",
+	nsRDF:       "rdf",
+	nsXML:       "xml",
+	nsDC:        "dc",
+	nsXMP:       "xmp",
+	nsXMPNote:   "xmpNote",
+	nsPhotoshop: "photoshop",
+}
+
+// Свойства XMP, которые записывает приложение. Перед записью они удаляются из пакета
+// вместе с xmpNote:HasExtendedXMP: расширенный XMP при перезаписи не сохраняется.
+var (
+	xmpTitle          = xml.Name{Space: nsDC, Local: "title"}
+	xmpDescription    = xml.Name{Space: nsDC, Local: "description"}
+	xmpSubject        = xml.Name{Space: nsDC, Local: "subject"}
+	xmpCategory       = xml.Name{Space: nsPhotoshop, Local: "Category"}
+	xmpRating         = xml.Name{Space: nsXMP, Local: "Rating"}
+	xmpCreatorTool    = xml.Name{Space: nsXMP, Local: "CreatorTool"}
+	xmpHasExtendedXMP = xml.Name{Space: nsXMPNote, Local: "HasExtendedXMP"}
+)
+
+// xmpNode элемент XMP пакета. Имена хранятся с URI пространства имен, префиксы назначаются при записи.
+type xmpNode struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	children []*xmpNode
+	text     string
+}
+
+// xmpDocument разобранный XMP пакет
+type xmpDocument struct {
+	root *xmpNode // x:xmpmeta
+	// prefixes префиксы из исходного пакета по URI, чтобы не переименовывать чужие пространства имен
+	prefixes map[string]string
+}
+
+// newXMPDocument создает пустой пакет с одним rdf:Description
+func newXMPDocument() *xmpDocument {
+	description := &xmpNode{
+		name:  xml.Name{Space: nsRDF, Local: "Description"},
+		attrs: []xml.Attr{{Name: xml.Name{Space: nsRDF, Local: "about"}, Value: ""}},
+	}
+	return &xmpDocument{
+		root: &xmpNode{
+			name: xml.Name{Space: nsX, Local: "xmpmeta"},
+			children: []*xmpNode{{
+				name:     xml.Name{Space: nsRDF, Local: "RDF"},
+				children: []*xmpNode{description},
+			}},
+		},
+		prefixes: make(map[string]string),
+	}
+}
+
+// parseXMP разбирает XMP пакет, в том числе обернутый в <?xpacket?>
+func parseXMP(data []byte) (*xmpDocument, error) {
+	doc := &xmpDocument{prefixes: make(map[string]string)}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+
+	var stack []*xmpNode
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid XMP packet: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			node := &xmpNode{name: t.Name}
+			for _, attr := range t.Attr {
+				switch {
+				case attr.Name.Space == "xmlns":
+					if _, exists := doc.prefixes[attr.Value]; !exists {
+						doc.prefixes[attr.Value] = attr.Name.Local
+					}
+				case attr.Name.Space == "" && attr.Name.Local == "xmlns":
+					// Пространство имен по умолчанию в XMP не используется
+				default:
+					node.attrs = append(node.attrs, attr)
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, node)
+			} else if doc.root == nil {
+				doc.root = node
+			}
+			stack = append(stack, node)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		}
+	}
+
+	if doc.root == nil {
+		return nil, fmt.Errorf("empty XMP packet")
+	}
+	// Пакеты без x:xmpmeta начинаются сразу с rdf:RDF
+	if doc.root.name != (xml.Name{Space: nsX, Local: "xmpmeta"}) {
+		doc.root = &xmpNode{name: xml.Name{Space: nsX, Local: "xmpmeta"}, children: []*xmpNode{doc.root}}
+	}
+	return doc, nil
+}
+
+// descriptions возвращает все rdf:Description пакета
+func (d *xmpDocument) descriptions() []*xmpNode {
+	var result []*xmpNode
+	for _, rdf := range d.root.findAll(xml.Name{Space: nsRDF, Local: "RDF"}) {
+		result = append(result, rdf.childrenNamed(xml.Name{Space: nsRDF, Local: "Description"})...)
+	}
+	return result
+}
+
+// description возвращает rdf:Description, в который пишутся свойства, создавая его при необходимости
+func (d *xmpDocument) description() *xmpNode {
+	if descriptions := d.descriptions(); len(descriptions) > 0 {
+		return descriptions[0]
+	}
+
+	rdfs := d.root.findAll(xml.Name{Space: nsRDF, Local: "RDF"})
+	if len(rdfs) == 0 {
+		rdfs = []*xmpNode{{name: xml.Name{Space: nsRDF, Local: "RDF"}}}
+		d.root.children = append(d.root.children, rdfs[0])
+	}
+	description := &xmpNode{
+		name:  xml.Name{Space: nsRDF, Local: "Description"},
+		attrs: []xml.Attr{{Name: xml.Name{Space: nsRDF, Local: "about"}, Value: ""}},
+	}
+	rdfs[0].children = append(rdfs[0].children, description)
+	return description
+}
+
+// remove удаляет свойство из всех rdf:Description, в полной и в сокращенной (атрибутом) форме
+func (d *xmpDocument) remove(name xml.Name) {
+	for _, description := range d.descriptions() {
+		attrs := description.attrs[:0]
+		for _, attr := range description.attrs {
+			if attr.Name != name {
+				attrs = append(attrs, attr)
+			}
+		}
+		description.attrs = attrs
+
+		children := description.children[:0]
+		for _, child := range description.children {
+			if child.name != name {
+				children = append(children, child)
+			}
+		}
+		description.children = children
+	}
+}
+
+// setSimple записывает простое текстовое свойство
+func (d *xmpDocument) setSimple(name xml.Name, value string) {
+	d.remove(name)
+	description := d.description()
+	description.children = append(description.children, &xmpNode{name: name, text: value})
+}
+
+// setLangAlt записывает свойство rdf:Alt с единственным значением x-default
+func (d *xmpDocument) setLangAlt(name xml.Name, value string) {
+	d.remove(name)
+	item := &xmpNode{
+		name:  xml.Name{Space: nsRDF, Local: "li"},
+		attrs: []xml.Attr{{Name: xml.Name{Space: nsXML, Local: "lang"}, Value: "x-default"}},
+		text:  value,
+	}
+	description := d.description()
+	description.children = append(description.children, &xmpNode{
+		name:     name,
+		children: []*xmpNode{{name: xml.Name{Space: nsRDF, Local: "Alt"}, children: []*xmpNode{item}}},
+	})
+}
+
+// setBag записывает неупорядоченный список rdf:Bag
+func (d *xmpDocument) setBag(name xml.Name, values []string) {
+	d.remove(name)
+	bag := &xmpNode{name: xml.Name{Space: nsRDF, Local: "Bag"}}
+	for _, value := range values {
+		bag.children = append(bag.children, &xmpNode{name: xml.Name{Space: nsRDF, Local: "li"}, text: value})
+	}
+	description := d.description()
+	description.children = append(description.children, &xmpNode{name: name, children: []*xmpNode{bag}})
+}
+
+// getSimple читает простое свойство из атрибута или элемента
+func (d *xmpDocument) getSimple(name xml.Name) string {
+	for _, description := range d.descriptions() {
+		for _, attr := range description.attrs {
+			if attr.Name == name {
+				return attr.Value
+			}
+		}
+		for _, child := range description.childrenNamed(name) {
+			if len(child.children) == 0 {
+				return strings.TrimSpace(child.text)
+			}
+		}
+	}
+	return ""
+}
+
+// getLangAlt читает rdf:Alt: значение x-default, иначе первое
+func (d *xmpDocument) getLangAlt(name xml.Name) string {
+	for _, description := range d.descriptions() {
+		for _, child := range description.childrenNamed(name) {
+			for _, alt := range child.childrenNamed(xml.Name{Space: nsRDF, Local: "Alt"}) {
+				items := alt.childrenNamed(xml.Name{Space: nsRDF, Local: "li"})
+				for _, item := range items {
+					if item.attr(xml.Name{Space: nsXML, Local: "lang"}) == "x-default" {
+						return item.text
+					}
+				}
+				if len(items) > 0 {
+					return items[0].text
+				}
+			}
+		}
+	}
+	return d.getSimple(name)
+}
+
+// getList читает rdf:Bag или rdf:Seq
+func (d *xmpDocument) getList(name xml.Name) []string {
+	var result []string
+	for _, description := range d.descriptions() {
+		for _, child := range description.childrenNamed(name) {
+			for _, list := range child.children {
+				if list.name.Space != nsRDF || (list.name.Local != "Bag" && list.name.Local != "Seq") {
+					continue
+				}
+				for _, item := range list.childrenNamed(xml.Name{Space: nsRDF, Local: "li"}) {
+					result = append(result, item.text)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// serialize записывает пакет в формате <?xpacket?> с запасом места для правки на месте
+func (d *xmpDocument) serialize() []byte {
+	prefixes := d.assignPrefixes()
+
+	var buf bytes.Buffer
+	buf.WriteString("<?xpacket begin=\"\ufeff\" id=\"W5M0MpCehiHzreSzNTczkc9d\"?>\n")
+	d.writeNode(&buf, d.root, prefixes, 0)
+	buf.WriteString("\n")
+	for i := 0; i < 20; i++ {
+		buf.WriteString(strings.Repeat(" ", 99))
+		buf.WriteString("\n")
+	}
+	buf.WriteString("<?xpacket end=\"w\"?>")
+	return buf.Bytes()
+}
+
+// assignPrefixes назначает префиксы всем пространствам имен пакета: исходные, стандартные или ns1, ns2...
+func (d *xmpDocument) assignPrefixes() map[string]string {
+	prefixes := map[string]string{nsXML: "xml"}
+	used := map[string]bool{"xml": true}
+
+	var uris []string
+	d.root.walk(func(node *xmpNode) {
+		uris = append(uris, node.name.Space)
+		for _, attr := range node.attrs {
+			uris = append(uris, attr.Name.Space)
+		}
+	})
+
+	next := 1
+	for _, uri := range uris {
+		if uri == "" {
+			continue
+		}
+		if _, exists := prefixes[uri]; exists {
+			continue
+		}
+		prefix := d.prefixes[uri]
+		if prefix == "" || used[prefix] {
+			prefix = xmpDefaultPrefixes[uri]
+		}
+		for prefix == "" || used[prefix] {
+			prefix = fmt.Sprintf("ns%d", next)
+			next++
+		}
+		prefixes[uri] = prefix
+		used[prefix] = true
+	}
+	return prefixes
+}
+
+// writeNode записывает элемент с отступом. Пространства имен объявляются на x:xmpmeta (x),
+// rdf:RDF (rdf) и на каждом rdf:Description (все остальные), как это делают Adobe и exiftool.
+func (d *xmpDocument) writeNode(buf *bytes.Buffer, node *xmpNode, prefixes map[string]string, depth int) {
+	indent := strings.Repeat(" ", depth)
+	buf.WriteString(indent + "<" + qualifiedName(node.name, prefixes))
+
+	var declare []string
+	switch node.name {
+	case xml.Name{Space: nsX, Local: "xmpmeta"}:
+		declare = []string{nsX}
+	case xml.Name{Space: nsRDF, Local: "RDF"}:
+		declare = []string{nsRDF}
+	case xml.Name{Space: nsRDF, Local: "Description"}:
+		for uri := range prefixes {
+			if uri != nsX && uri != nsRDF && uri != nsXML {
+				declare = append(declare, uri)
+			}
+		}
+		sort.Slice(declare, func(i, j int) bool { return prefixes[declare[i]] < prefixes[declare[j]] })
+	}
+	for _, uri := range declare {
+		buf.WriteString(" xmlns:" + prefixes[uri] + "=\"")
+		xml.EscapeText(buf, []byte(uri))
+		buf.WriteString("\"")
+	}
+
+	for _, attr := range node.attrs {
+		buf.WriteString(" " + qualifiedName(attr.Name, prefixes) + "=\"")
+		xml.EscapeText(buf, []byte(attr.Value))
+		buf.WriteString("\"")
+	}
+
+	if len(node.children) == 0 {
+		text := node.text
+		if strings.TrimSpace(text) == "" {
+			text = ""
+		}
+		if text == "" && node.name.Space != nsRDF {
+			buf.WriteString("/>")
+			return
+		}
+		buf.WriteString(">")
+		xml.EscapeText(buf, []byte(text))
+		buf.WriteString("</" + qualifiedName(node.name, prefixes) + ">")
+		return
+	}
+
+	buf.WriteString(">\n")
+	for _, child := range node.children {
+		d.writeNode(buf, child, prefixes, depth+1)
+		buf.WriteString("\n")
+	}
+	buf.WriteString(indent + "</" + qualifiedName(node.name, prefixes) + ">")
+}
+
+func qualifiedName(name xml.Name, prefixes map[string]string) string {
+	if name.Space == "" {
+		return name.Local
+	}
+	return prefixes[name.Space] + ":" + name.Local
+}
+
+// childrenNamed возвращает прямых потомков с указанным именем
+func (n *xmpNode) childrenNamed(name xml.Name) []*xmpNode {
+	var result []*xmpNode
+	for _, child := range n.children {
+		if child.name == name {
+			result = append(result, child)
+		}
+	}
+	return result
+}
+
+// findAll ищет элементы с указанным именем на любой глубине
+func (n *xmpNode) findAll(name xml.Name) []*xmpNode {
+	var result []*xmpNode
+	n.walk(func(node *xmpNode) {
+		if node.name == name {
+			result = append(result, node)
+		}
+	})
+	return result
+}
+
+func (n *xmpNode) walk(visit func(node *xmpNode)) {
+	visit(n)
+	for _, child := range n.children {
+		child.walk(visit)
+	}
+}
+
+func (n *xmpNode) attr(name xml.Name) string {
+	for _, attr := range n.attrs {
+		if attr.Name == name {
+			return attr.Value
+		}
+	}
+	return ""
+}
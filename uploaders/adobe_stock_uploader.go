@@ -0,0 +1,36 @@
+package uploaders
+
+// adobeStockProfile прием файлов Adobe Stock Contributor.
+// Логин и пароль для FTP/SFTP выдаются в портале contributor.stock.adobe.com (Upload -> FTP/SFTP),
+// они не совпадают с Adobe ID.
+var adobeStockProfile = agencyProfile{
+	Type:        "adobe_stock",
+	Name:        "Adobe Stock",
+	Description: "Загрузка на Adobe Stock Contributor через SFTP или FTP, метаданные из IPTC файла",
+	Website:     "https://contributor.stock.adobe.com/",
+	Protocols:   []string{"sftp", "ftp"},
+	Hosts: map[string]string{
+		"sftp": "sftp.contributor.adobestock.com",
+		"ftp":  "ftp.contributor.adobestock.com",
+	},
+	Ports: map[string]int{
+		"sftp": 22,
+		"ftp":  21,
+	},
+	Encryption:     "none",
+	Formats:        []string{".jpg", ".jpeg"},
+	MinMegapixels:  4,
+	MaxFileSize:    45 * 1024 * 1024,
+	MaxConnections: 2,
+	UsernameHelp:   "Числовой ID из раздела Upload -> FTP/SFTP портала Adobe Stock Contributor",
+	CSVFormat:      "adobe_stock",
+	Rules: map[string]interface{}{
+		"maxKeywords":    49,
+		"maxTitleLength": 200,
+	},
+}
+
+// NewAdobeStockUploader создает загрузчик Adobe Stock
+func NewAdobeStockUploader(dbService DatabaseService) *AgencyUploader {
+	return newAgencyUploader(adobeStockProfile, dbService)
+}
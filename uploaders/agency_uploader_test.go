@@ -0,0 +1,194 @@
+package uploaders
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"stock-photo-app/models"
+	"testing"
+)
+
+// writeTestJPEG создает JPEG заданного разрешения и возвращает фото и содержимое файла
+func writeTestJPEG(t *testing.T, name string, width, height int) (models.Photo, []byte) {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return models.Photo{ID: "photo-1", BatchID: "batch-1", FileName: name, OriginalPath: path}, buf.Bytes()
+}
+
+func TestAdobeStockUploadsOverSFTP(t *testing.T) {
+	server := newTestSFTPServer(t)
+	db := newTestDatabase()
+	uploader := NewAdobeStockUploader(db)
+	photo, data := writeTestJPEG(t, "IMG_0001.jpg", 2000, 2000)
+
+	config := server.config()
+	config.ID = "adobe"
+	config.Type = "adobe_stock"
+
+	result, err := uploader.Upload(context.Background(), photo, config)
+	if err != nil || !result.Success {
+		t.Fatalf("upload failed: %v (%s)", err, result.Message)
+	}
+
+	uploaded := server.file(t, "/upload/IMG_0001.jpg")
+	if checksum(uploaded) != checksum(data) {
+		t.Error("uploaded file differs from local file")
+	}
+	if db.hostKeys["adobe"] != server.fingerprint {
+		t.Errorf("host key pinned as %q, want %q", db.hostKeys["adobe"], server.fingerprint)
+	}
+}
+
+func TestAdobeStockRejectsFilesBeforeUpload(t *testing.T) {
+	uploader := NewAdobeStockUploader(newTestDatabase())
+	// Сервер не нужен: файл отклоняется до подключения
+	config := models.StockConfig{ID: "adobe", Type: "adobe_stock", Connection: models.ConnectionConfig{Host: "127.0.0.1", Port: 1}}
+
+	small, _ := writeTestJPEG(t, "small.jpg", 1000, 1000)
+	png, _ := writeTestFile(t, "IMG_0001.png", 1024)
+
+	for _, photo := range []models.Photo{small, png} {
+		result, err := uploader.Upload(context.Background(), photo, config)
+		if err == nil || result.Success {
+			t.Errorf("%s: upload must be rejected", photo.FileName)
+			continue
+		}
+		if !IsPermanentError(err) {
+			t.Errorf("%s: rejection must not be retried: %v", photo.FileName, err)
+		}
+	}
+}
+
+func TestShutterstockUploadsOverExplicitFTPS(t *testing.T) {
+	server := newTestFTPServer(t)
+	server.enableTLS(t)
+	uploader := NewShutterstockUploader(newTestDatabase())
+	photo, data := writeTestJPEG(t, "IMG_0002.jpg", 2500, 1700)
+
+	config := server.config()
+	config.Type = "shutterstock"
+
+	result, err := uploader.Upload(context.Background(), photo, config)
+	if err != nil || !result.Success {
+		t.Fatalf("upload failed: %v (%s)", err, result.Message)
+	}
+
+	for _, command := range []string{"AUTH TLS", "PROT P", "PASV"} {
+		if !server.received(command) {
+			t.Errorf("client did not send %s", command)
+		}
+	}
+	assertUploaded(t, server, "/IMG_0002.jpg", data)
+}
+
+func TestAlamyUploadsOverFTP(t *testing.T) {
+	server := newTestFTPServer(t)
+	uploader := NewAlamyUploader(newTestDatabase())
+	photo, data := writeTestJPEG(t, "IMG_0003.jpg", 3000, 2000)
+
+	config := server.config()
+	config.Type = "alamy"
+
+	result, err := uploader.Upload(context.Background(), photo, config)
+	if err != nil || !result.Success {
+		t.Fatalf("upload failed: %v (%s)", err, result.Message)
+	}
+	if server.received("AUTH") {
+		t.Error("Alamy upload must use plain FTP")
+	}
+	assertUploaded(t, server, "/IMG_0003.jpg", data)
+
+	// CSV метаданных загружается без проверки требований к фото
+	csv, csvData := writeTestFile(t, "metadata.csv", 512)
+	result, err = uploader.UploadFile(context.Background(), csv, config)
+	if err != nil || !result.Success {
+		t.Fatalf("CSV upload failed: %v (%s)", err, result.Message)
+	}
+	assertUploaded(t, server, "/metadata.csv", csvData)
+
+	// Alamy принимает только FTP
+	config.Settings = map[string]interface{}{"protocol": "sftp"}
+	if _, err := uploader.Upload(context.Background(), photo, config); err == nil || !IsPermanentError(err) {
+		t.Errorf("SFTP upload to Alamy must fail with a permanent error, got %v", err)
+	}
+}
+
+func TestAgencyResolveUsesProfileDefaults(t *testing.T) {
+	tests := []struct {
+		uploader   *AgencyUploader
+		protocol   string
+		host       string
+		port       int
+		encryption string
+	}{
+		{NewAdobeStockUploader(newTestDatabase()), "", "sftp.contributor.adobestock.com", 22, ""},
+		{NewAdobeStockUploader(newTestDatabase()), "ftp", "ftp.contributor.adobestock.com", 21, "none"},
+		{NewShutterstockUploader(newTestDatabase()), "", "ftps.shutterstock.com", 21, "explicit"},
+		{NewAlamyUploader(newTestDatabase()), "", "upload.alamy.com", 21, "none"},
+	}
+
+	for _, tt := range tests {
+		config := models.StockConfig{Type: tt.uploader.profile.Type}
+		if tt.protocol != "" {
+			config.Settings = map[string]interface{}{"protocol": tt.protocol}
+		}
+
+		_, resolved, err := tt.uploader.resolve(config)
+		if err != nil {
+			t.Fatalf("%s: resolve failed: %v", tt.uploader.profile.Type, err)
+		}
+
+		connection := resolved.Connection
+		if connection.Host != tt.host || connection.Port != tt.port || connection.Encryption != tt.encryption {
+			t.Errorf("%s/%s: resolved %s:%d encryption %q, want %s:%d encryption %q", tt.uploader.profile.Type, tt.protocol,
+				connection.Host, connection.Port, connection.Encryption, tt.host, tt.port, tt.encryption)
+		}
+		if !connection.VerifyCert {
+			t.Errorf("%s: agency server certificate must be verified", tt.uploader.profile.Type)
+		}
+		if tt.encryption != "" && !connection.Passive {
+			t.Errorf("%s: FTP upload to agency must use passive mode", tt.uploader.profile.Type)
+		}
+	}
+}
+
+func TestAgencyTemplateOffersKeyAuthForSFTP(t *testing.T) {
+	fields := func(template models.StockTemplate) map[string]models.TemplateField {
+		byName := make(map[string]models.TemplateField)
+		for _, field := range template.Fields {
+			byName[field.Name] = field
+		}
+		return byName
+	}
+
+	adobe := fields(adobeStockProfile.Template())
+	for _, name := range []string{"privateKeyPath", "privateKey", "passphrase", "useAgent", "hostKeyFingerprint"} {
+		if _, ok := adobe[name]; !ok {
+			t.Errorf("Adobe Stock template has no %s field", name)
+		}
+	}
+	if adobe["password"].Required {
+		t.Error("Adobe Stock password must be optional for key-based SFTP accounts")
+	}
+
+	shutterstock := fields(shutterstockProfile.Template())
+	if _, ok := shutterstock["privateKey"]; ok {
+		t.Error("FTP-only agency template must not offer SSH keys")
+	}
+	if !shutterstock["password"].Required {
+		t.Error("FTP-only agency template must require a password")
+	}
+}
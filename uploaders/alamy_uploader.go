@@ -0,0 +1,32 @@
+package uploaders
+
+// alamyProfile прием файлов Alamy через FTP.
+// Alamy требует несжатый размер не меньше 17 МБ, что для 8-битного RGB около 6 Мп.
+var alamyProfile = agencyProfile{
+	Type:        "alamy",
+	Name:        "Alamy",
+	Description: "Загрузка на Alamy через FTP, метаданные из IPTC файла",
+	Website:     "https://www.alamy.com/contributor/",
+	Protocols:   []string{"ftp"},
+	Hosts: map[string]string{
+		"ftp": "upload.alamy.com",
+	},
+	Ports: map[string]int{
+		"ftp": 21,
+	},
+	Encryption:     "none",
+	Formats:        []string{".jpg", ".jpeg"},
+	MinMegapixels:  6,
+	MaxConnections: 2,
+	UsernameHelp:   "FTP логин из раздела Upload портала Alamy Contributor",
+	// Alamy читает все ключевые слова из IPTC файла, отдельного поля дополнительных слов при загрузке по FTP нет,
+	// поэтому supplementaryKeywordsAfter не задается: иначе слова после N-го не попали бы в файл
+	Rules: map[string]interface{}{
+		"maxTitleLength": 200,
+	},
+}
+
+// NewAlamyUploader создает загрузчик Alamy
+func NewAlamyUploader(dbService DatabaseService) *AgencyUploader {
+	return newAgencyUploader(alamyProfile, dbService)
+}
@@ -1,16 +1,18 @@
 package uploaders
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"stock-photo-app/models"
+	"strings"
 	"time"
 )
 
@@ -34,16 +36,30 @@ func NewAPIUploader() *APIUploader {
 	}
 }
 
-// Upload загружает фото через API
-func (u *APIUploader) Upload(photo models.Photo, config models.StockConfig) (models.UploadResult, error) {
-	// Если это тестовый URL, имитируем загрузку
-	if config.Connection.APIUrl == "https://api.shutterstock.com/v2/images" ||
-		config.Connection.APIUrl == "https://demo.example.com/upload" ||
-		config.Connection.APIUrl == "" {
+// Upload загружает фото через API.
+// Повторы выполняет очередь загрузки, ошибки классифицируются для ее политики повторов.
+func (u *APIUploader) Upload(ctx context.Context, photo models.Photo, config models.StockConfig) (models.UploadResult, error) {
+	return u.UploadWithProgress(ctx, photo, config, nil)
+}
+
+// UploadWithProgress загружает фото через API, сообщая количество отправленных байт тела запроса
+func (u *APIUploader) UploadWithProgress(ctx context.Context, photo models.Photo, config models.StockConfig, progress ProgressFunc) (models.UploadResult, error) {
+	// В демо режиме имитируем загрузку. Настоящие фотобанки загружаются своими загрузчиками,
+	// поэтому адрес API агентства демо режим не включает.
+	if isDemoConfig(config) {
 		log.Printf("Demo mode: simulating upload of %s to %s", photo.FileName, config.Name)
 
-		// Имитируем время загрузки
-		time.Sleep(5 * time.Second)
+		// Имитируем время загрузки вместе с прогрессом
+		for step := int64(1); step <= 5; step++ {
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return u.CreateUploadResult(photo.ID, config.ID, "Загрузка прервана", false), NewTransientError(ctx.Err())
+			}
+			if progress != nil {
+				progress(photo.FileSize*step/5, photo.FileSize)
+			}
+		}
 
 		// Имитируем успешную загрузку
 		result := u.CreateUploadResult(photo.ID, config.ID, "Файл успешно загружен (демо режим)", true)
@@ -54,45 +70,47 @@ func (u *APIUploader) Upload(photo models.Photo, config models.StockConfig) (mod
 	// Открываем файл
 	file, err := os.Open(photo.OriginalPath)
 	if err != nil {
-		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка открытия файла: %v", err), false), err
+		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка открытия файла: %v", err), false), NewPermanentError(err)
 	}
 	defer file.Close()
 
-	// Создаем multipart форму
-	var requestBody bytes.Buffer
-	writer := multipart.NewWriter(&requestBody)
-
-	// Добавляем файл
-	filename := filepath.Base(photo.OriginalPath)
-	part, err := writer.CreateFormFile("file", filename)
-	if err != nil {
-		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка создания формы: %v", err), false), err
-	}
-
-	_, err = io.Copy(part, file)
+	info, err := file.Stat()
 	if err != nil {
-		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка копирования файла: %v", err), false), err
+		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка чтения файла: %v", err), false), NewPermanentError(err)
 	}
+	filename := filepath.Base(photo.OriginalPath)
 
-	// Добавляем метаданные если есть
-	if photo.AIResult != nil && photo.AIResult.Title != "" {
-		writer.WriteField("title", photo.AIResult.Title)
-	}
-	if photo.AIResult != nil && photo.AIResult.Description != "" {
-		writer.WriteField("description", photo.AIResult.Description)
-	}
-	if photo.AIResult != nil && len(photo.AIResult.Keywords) > 0 {
-		keywordsJSON, _ := json.Marshal(photo.AIResult.Keywords)
-		writer.WriteField("keywords", string(keywordsJSON))
+	// Размер тела считается заранее по форме без содержимого файла: тело отправляется потоком,
+	// и большой TIFF не держится в памяти целиком на каждое соединение
+	counter := &countingWriter{}
+	sizeWriter := multipart.NewWriter(counter)
+	if err := writeMultipartForm(sizeWriter, filename, strings.NewReader(""), photo); err != nil {
+		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка создания формы: %v", err), false), NewPermanentError(err)
 	}
-
-	writer.Close()
+	bodySize := counter.n + info.Size()
+
+	// Форма пишется в pipe по мере отправки, прогресс считается по прочитанным байтам файла.
+	// Перед возвратом pipe закрывается, и загрузка ждет завершения записи формы.
+	bodyReader, bodyWriter := io.Pipe()
+	writer := multipart.NewWriter(bodyWriter)
+	writer.SetBoundary(sizeWriter.Boundary())
+	written := make(chan struct{})
+	defer func() {
+		bodyReader.Close()
+		<-written
+	}()
+	go func() {
+		defer close(written)
+		fileReader := newProgressReader(newContextReader(ctx, file), 0, info.Size(), progress)
+		bodyWriter.CloseWithError(writeMultipartForm(writer, filename, fileReader, photo))
+	}()
 
 	// Создаем HTTP запрос
-	req, err := http.NewRequest("POST", config.Connection.APIUrl, &requestBody)
+	req, err := http.NewRequestWithContext(ctx, "POST", config.Connection.APIUrl, bodyReader)
 	if err != nil {
-		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка создания запроса: %v", err), false), err
+		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка создания запроса: %v", err), false), NewPermanentError(err)
 	}
+	req.ContentLength = bodySize
 
 	// Устанавливаем заголовки
 	req.Header.Set("Content-Type", writer.FormDataContentType())
@@ -103,31 +121,33 @@ func (u *APIUploader) Upload(photo models.Photo, config models.StockConfig) (mod
 		req.Header.Set(key, value)
 	}
 
-	// Создаем HTTP клиент с таймаутом
+	// Таймаут ограничивает подключение и ожидание ответа, но не передачу тела: большой файл
+	// может отправляться дольше. Зависшую передачу прерывает отмена ctx.
 	timeout := time.Duration(config.Connection.Timeout) * time.Second
 	if timeout == 0 {
 		timeout = 60 * time.Second
 	}
-	client := &http.Client{Timeout: timeout}
+	client := &http.Client{Transport: uploadTransport(timeout)}
+	defer client.CloseIdleConnections()
 
 	// Выполняем запрос
 	resp, err := client.Do(req)
 	if err != nil {
-		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка выполнения запроса: %v", err), false), err
+		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка выполнения запроса: %v", err), false), NewTransientError(err)
 	}
 	defer resp.Body.Close()
 
 	// Читаем ответ
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка чтения ответа: %v", err), false), err
+		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка чтения ответа: %v", err), false), NewTransientError(err)
 	}
 
 	// Проверяем статус ответа
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
 		return u.CreateUploadResult(photo.ID, config.ID,
 				fmt.Sprintf("Ошибка API (код %d): %s", resp.StatusCode, string(responseBody)), false),
-			fmt.Errorf("API вернул код %d", resp.StatusCode)
+			classifyHTTPStatus(resp.StatusCode, string(responseBody))
 	}
 
 	// Пытаемся распарсить ответ для получения URL
@@ -149,6 +169,54 @@ func (u *APIUploader) Upload(photo models.Photo, config models.StockConfig) (mod
 	return result, nil
 }
 
+// writeMultipartForm пишет форму загрузки: файл и метаданные фото, затем закрывает writer
+func writeMultipartForm(writer *multipart.Writer, filename string, file io.Reader, photo models.Photo) error {
+	part, err := writer.CreateFormFile("file", filename)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(part, file); err != nil {
+		return err
+	}
+
+	// Добавляем метаданные если есть. Ошибку записи в оборванный pipe вернет Close.
+	if photo.AIResult != nil && photo.AIResult.Title != "" {
+		writer.WriteField("title", photo.AIResult.Title)
+	}
+	if photo.AIResult != nil && photo.AIResult.Description != "" {
+		writer.WriteField("description", photo.AIResult.Description)
+	}
+	if photo.AIResult != nil && len(photo.AIResult.Keywords) > 0 {
+		keywordsJSON, _ := json.Marshal(photo.AIResult.Keywords)
+		writer.WriteField("keywords", string(keywordsJSON))
+	}
+	if photo.AIResult != nil && len(photo.AIResult.SupplementaryKeywords) > 0 {
+		supplementaryJSON, _ := json.Marshal(photo.AIResult.SupplementaryKeywords)
+		writer.WriteField("supplementary_keywords", string(supplementaryJSON))
+	}
+
+	return writer.Close()
+}
+
+// countingWriter считает записанные байты
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
+
+// uploadTransport HTTP транспорт с таймаутами подключения и ожидания заголовков ответа
+func uploadTransport(timeout time.Duration) *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).DialContext
+	transport.TLSHandshakeTimeout = timeout
+	transport.ResponseHeaderTimeout = timeout
+	return transport
+}
+
 // TestConnection тестирует подключение к API
 func (u *APIUploader) TestConnection(config models.StockConfig) error {
 	// Создаем тестовый запрос
@@ -192,3 +260,11 @@ func (u *APIUploader) ValidateConfig(config models.StockConfig) error {
 	requiredFields := []string{"apiUrl", "apiKey"}
 	return u.ValidateRequiredFields(config, requiredFields)
 }
+
+// isDemoConfig сообщает, что сток настроен для демонстрации и загрузку нужно имитировать
+func isDemoConfig(config models.StockConfig) bool {
+	if demo, ok := config.Settings["demo_mode"].(bool); ok && demo {
+		return true
+	}
+	return config.Connection.APIUrl == "https://demo.example.com/upload" || config.Connection.APIUrl == ""
+}
@@ -0,0 +1,113 @@
+package uploaders
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"stock-photo-app/models"
+	"testing"
+)
+
+func TestAPIUploaderStreamsMultipartBody(t *testing.T) {
+	photo, data := writeTestFile(t, "IMG_0001.TIF", resumeTestFileSize)
+	photo.AIResult = &models.AIResult{Title: "Mountain lake", Keywords: []string{"lake", "mountain"}}
+
+	type received struct {
+		contentLength int64
+		file          []byte
+		title         string
+		keywords      []string
+	}
+	requests := make(chan received, 1)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var got received
+		got.contentLength = r.ContentLength
+		reader, err := r.MultipartReader()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for {
+			part, err := reader.NextPart()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			content, _ := io.ReadAll(part)
+			switch part.FormName() {
+			case "file":
+				got.file = content
+			case "title":
+				got.title = string(content)
+			case "keywords":
+				json.Unmarshal(content, &got.keywords)
+			}
+		}
+		requests <- got
+		w.Write([]byte(`{"success": true, "url": "https://example.com/photos/1"}`))
+	}))
+	defer server.Close()
+
+	config := models.StockConfig{ID: "api", Type: "api", Connection: models.ConnectionConfig{APIUrl: server.URL, APIKey: "key", Timeout: 5}}
+
+	var lastSent, lastTotal int64
+	result, err := NewAPIUploader().UploadWithProgress(context.Background(), photo, config, func(sent, total int64) {
+		lastSent, lastTotal = sent, total
+	})
+	if err != nil || !result.Success {
+		t.Fatalf("upload failed: %v (%s)", err, result.Message)
+	}
+	if result.UploadURL != "https://example.com/photos/1" {
+		t.Errorf("upload URL = %q", result.UploadURL)
+	}
+
+	got := <-requests
+	if got.contentLength <= int64(len(data)) {
+		t.Errorf("Content-Length = %d, must be known and include the %d byte file", got.contentLength, len(data))
+	}
+	if checksum(got.file) != checksum(data) {
+		t.Errorf("received file: %d bytes, want %d", len(got.file), len(data))
+	}
+	if got.title != "Mountain lake" || len(got.keywords) != 2 {
+		t.Errorf("received title %q, keywords %v", got.title, got.keywords)
+	}
+	if lastSent != int64(len(data)) || lastTotal != int64(len(data)) {
+		t.Errorf("progress ended at %d/%d, want %d/%d", lastSent, lastTotal, len(data), len(data))
+	}
+}
+
+func TestAPIUploaderCancelsStalledServer(t *testing.T) {
+	photo, _ := writeTestFile(t, "IMG_0002.TIF", 1024)
+
+	// Сервер принимает тело, но не отвечает
+	release := make(chan struct{})
+	received := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		close(received)
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	config := models.StockConfig{ID: "api", Type: "api", Connection: models.ConnectionConfig{APIUrl: server.URL, APIKey: "key", Timeout: 60}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := NewAPIUploader().Upload(ctx, photo, config)
+		done <- err
+	}()
+	<-received
+	cancel()
+
+	if err := <-done; err == nil || IsPermanentError(err) {
+		t.Errorf("cancelled upload must fail with a retryable error, got %v", err)
+	}
+}
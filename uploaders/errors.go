@@ -0,0 +1,117 @@
+package uploaders
+
+import (
+	"errors"
+	"fmt"
+	"net/textproto"
+	"os"
+	"strings"
+)
+
+// UploadErrorKind классифицирует ошибку загрузки для политики повторов очереди
+type UploadErrorKind string
+
+const (
+	// ErrorTransient временная ошибка: обрыв соединения, таймаут, перегрузка сервера
+	ErrorTransient UploadErrorKind = "transient"
+	// ErrorPermanent постоянная ошибка: неверные учетные данные, нет прав, нет файла, неверная конфигурация
+	ErrorPermanent UploadErrorKind = "permanent"
+)
+
+// UploadError ошибка загрузки с классификацией для очереди
+type UploadError struct {
+	Kind UploadErrorKind
+	Err  error
+}
+
+func (e *UploadError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// NewTransientError помечает ошибку как временную, загрузку стоит повторить
+func NewTransientError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &UploadError{Kind: ErrorTransient, Err: err}
+}
+
+// NewPermanentError помечает ошибку как постоянную, повтор не поможет
+func NewPermanentError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &UploadError{Kind: ErrorPermanent, Err: err}
+}
+
+// IsPermanentError сообщает, что повтор загрузки не поможет.
+// Неклассифицированные ошибки считаются временными.
+func IsPermanentError(err error) bool {
+	var uploadErr *UploadError
+	if errors.As(err, &uploadErr) {
+		return uploadErr.Kind == ErrorPermanent
+	}
+	return false
+}
+
+// classifyFTPError классифицирует ошибку FTP по коду ответа сервера.
+// 530/532 (авторизация) и 550/553 (нет прав, недопустимое имя) постоянные, остальное временное.
+func classifyFTPError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var uploadErr *UploadError
+	if errors.As(err, &uploadErr) {
+		return err
+	}
+
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) {
+		switch protoErr.Code {
+		case 530, 532, 550, 553:
+			return NewPermanentError(err)
+		}
+	}
+
+	return NewTransientError(err)
+}
+
+// classifySSHError классифицирует ошибку SSH/SFTP
+func classifySSHError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var uploadErr *UploadError
+	if errors.As(err, &uploadErr) {
+		return err
+	}
+
+	var mismatchErr *HostKeyMismatchError
+	if errors.As(err, &mismatchErr) {
+		return NewPermanentError(err)
+	}
+
+	if errors.Is(err, os.ErrPermission) || strings.Contains(err.Error(), "unable to authenticate") {
+		return NewPermanentError(err)
+	}
+
+	return NewTransientError(err)
+}
+
+// classifyHTTPStatus классифицирует ответ API по HTTP коду.
+// 408, 429 и 5xx временные, остальные 4xx постоянные.
+func classifyHTTPStatus(statusCode int, body string) error {
+	err := fmt.Errorf("API вернул код %d: %s", statusCode, body)
+
+	if statusCode == 408 || statusCode == 429 || statusCode >= 500 {
+		return NewTransientError(err)
+	}
+
+	return NewPermanentError(err)
+}
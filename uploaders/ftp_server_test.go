@@ -0,0 +1,415 @@
+package uploaders
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"path"
+	"stock-photo-app/models"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Логин и пароль тестовых FTP и SFTP серверов
+const (
+	testUser     = "contributor"
+	testPassword = "secret"
+)
+
+// testFTPServer минимальный FTP сервер в памяти: пассивный режим (EPSV/PASV), SIZE, REST, STOR, APPE, RETR,
+// явный FTPS (AUTH TLS, PROT P), если задан tlsConfig. Файлы хранятся в files по абсолютному пути.
+type testFTPServer struct {
+	listener  net.Listener
+	tlsConfig *tls.Config
+
+	mu    sync.Mutex
+	files map[string][]byte
+	dirs  map[string]bool
+	// disableREST сервер отвечает 502 на REST, докачка возможна только через APPE
+	disableREST bool
+	// abortAfter следующая загрузка сохраняет только столько байт и обрывает соединение, 0 - без обрыва
+	abortAfter int64
+	// stall сервер не отвечает на STOR и ждет, пока клиент закроет управляющее соединение
+	stall    bool
+	commands []string
+}
+
+// newTestFTPServer запускает FTP сервер на случайном порту 127.0.0.1
+func newTestFTPServer(t *testing.T) *testFTPServer {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := &testFTPServer{
+		listener: listener,
+		files:    make(map[string][]byte),
+		dirs:     map[string]bool{"/": true},
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn)
+		}
+	}()
+
+	return server
+}
+
+// enableTLS включает явный FTPS с самоподписанным сертификатом
+func (s *testFTPServer) enableTLS(t *testing.T) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.tlsConfig = &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}},
+	}
+}
+
+// config возвращает конфигурацию стока, подключающуюся к серверу
+func (s *testFTPServer) config() models.StockConfig {
+	return models.StockConfig{
+		ID:   "ftp-stock",
+		Name: "Test FTP",
+		Type: "ftp",
+		Connection: models.ConnectionConfig{
+			Host:     "127.0.0.1",
+			Port:     s.listener.Addr().(*net.TCPAddr).Port,
+			Username: testUser,
+			Password: testPassword,
+			Timeout:  5,
+		},
+	}
+}
+
+// file возвращает копию содержимого файла на сервере
+func (s *testFTPServer) file(name string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.files[name]
+	return append([]byte(nil), data...), ok
+}
+
+// setFile кладет файл на сервер
+func (s *testFTPServer) setFile(name string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files[name] = append([]byte(nil), data...)
+}
+
+// received сообщает, что клиент отправлял команду с таким началом
+func (s *testFTPServer) received(prefix string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, command := range s.commands {
+		if strings.HasPrefix(command, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ftpSession состояние одного управляющего соединения
+type ftpSession struct {
+	server   *testFTPServer
+	conn     net.Conn
+	reader   *bufio.Reader
+	cwd      string
+	loggedIn bool
+	user     string
+	rest     int64
+	passive  net.Listener
+	// protected соединения данных тоже защищены TLS (PROT P)
+	protected bool
+}
+
+func (s *testFTPServer) serve(conn net.Conn) {
+	session := &ftpSession{server: s, conn: conn, reader: bufio.NewReader(conn), cwd: "/"}
+	defer conn.Close()
+	defer session.closePassive()
+
+	session.reply(220, "Test FTP server ready")
+	for {
+		line, err := session.reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		command, arg, _ := strings.Cut(line, " ")
+		command = strings.ToUpper(command)
+
+		s.mu.Lock()
+		s.commands = append(s.commands, command+" "+arg)
+		s.mu.Unlock()
+
+		if !session.handle(command, arg) {
+			return
+		}
+	}
+}
+
+// handle выполняет команду, false - закрыть соединение
+func (c *ftpSession) handle(command, arg string) bool {
+	switch command {
+	case "AUTH":
+		if c.server.tlsConfig == nil {
+			c.reply(502, "TLS not configured")
+			return true
+		}
+		c.reply(234, "AUTH TLS successful")
+		c.conn = tls.Server(c.conn, c.server.tlsConfig)
+		c.reader = bufio.NewReader(c.conn)
+		return true
+	case "USER":
+		c.user = arg
+		c.reply(331, "Password required")
+		return true
+	case "PASS":
+		if c.user != testUser || arg != testPassword {
+			c.reply(530, "Login incorrect")
+			return true
+		}
+		c.loggedIn = true
+		c.reply(230, "Logged in")
+		return true
+	case "QUIT":
+		c.reply(221, "Bye")
+		return false
+	}
+
+	if !c.loggedIn {
+		c.reply(530, "Not logged in")
+		return true
+	}
+
+	switch command {
+	case "FEAT":
+		features := []string{"SIZE", "EPSV", "PASV", "UTF8"}
+		c.server.mu.Lock()
+		if !c.server.disableREST {
+			features = append(features, "REST STREAM")
+		}
+		c.server.mu.Unlock()
+		fmt.Fprintf(c.conn, "211-Features:\r\n %s\r\n211 End\r\n", strings.Join(features, "\r\n "))
+	case "TYPE", "OPTS", "PBSZ":
+		c.reply(200, "OK")
+	case "PROT":
+		c.protected = arg == "P"
+		c.reply(200, "Protection level set")
+	case "PWD":
+		c.reply(257, strconv.Quote(c.cwd)+" is current directory")
+	case "CWD":
+		dir := c.resolve(arg)
+		c.server.mu.Lock()
+		exists := c.server.dirs[dir]
+		c.server.mu.Unlock()
+		if !exists {
+			c.reply(550, "No such directory")
+			return true
+		}
+		c.cwd = dir
+		c.reply(250, "Directory changed")
+	case "MKD":
+		dir := c.resolve(arg)
+		c.server.mu.Lock()
+		c.server.dirs[dir] = true
+		c.server.mu.Unlock()
+		c.reply(257, strconv.Quote(dir)+" created")
+	case "EPSV", "PASV":
+		c.openPassive(command)
+	case "SIZE":
+		data, ok := c.server.file(c.resolve(arg))
+		if !ok {
+			c.reply(550, "No such file")
+			return true
+		}
+		c.reply(213, strconv.Itoa(len(data)))
+	case "REST":
+		c.server.mu.Lock()
+		disabled := c.server.disableREST
+		c.server.mu.Unlock()
+		if disabled {
+			c.reply(502, "REST not implemented")
+			return true
+		}
+		offset, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			c.reply(501, "Invalid offset")
+			return true
+		}
+		c.rest = offset
+		c.reply(350, "Restarting at "+arg)
+	case "STOR", "APPE":
+		c.server.mu.Lock()
+		stall := c.server.stall
+		c.server.mu.Unlock()
+		if stall {
+			io.Copy(io.Discard, c.reader)
+			return false
+		}
+		c.store(c.resolve(arg), command == "APPE")
+	case "RETR":
+		c.retrieve(c.resolve(arg))
+	default:
+		c.reply(502, "Command not implemented")
+	}
+	return true
+}
+
+func (c *ftpSession) reply(code int, message string) {
+	fmt.Fprintf(c.conn, "%d %s\r\n", code, message)
+}
+
+func (c *ftpSession) resolve(name string) string {
+	if path.IsAbs(name) {
+		return path.Clean(name)
+	}
+	return path.Join(c.cwd, name)
+}
+
+// openPassive открывает порт для следующего соединения данных
+func (c *ftpSession) openPassive(command string) {
+	c.closePassive()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		c.reply(425, "Cannot open data connection")
+		return
+	}
+	c.passive = listener
+
+	port := listener.Addr().(*net.TCPAddr).Port
+	if command == "EPSV" {
+		c.reply(229, fmt.Sprintf("Entering Extended Passive Mode (|||%d|)", port))
+	} else {
+		c.reply(227, fmt.Sprintf("Entering Passive Mode (127,0,0,1,%d,%d)", port/256, port%256))
+	}
+}
+
+func (c *ftpSession) closePassive() {
+	if c.passive != nil {
+		c.passive.Close()
+		c.passive = nil
+	}
+}
+
+// acceptData принимает соединение данных, открытое последней командой EPSV/PASV
+func (c *ftpSession) acceptData() (net.Conn, error) {
+	if c.passive == nil {
+		return nil, fmt.Errorf("no passive listener")
+	}
+	defer c.closePassive()
+
+	c.passive.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := c.passive.Accept()
+	if err != nil || !c.protected {
+		return conn, err
+	}
+	return tls.Server(conn, c.server.tlsConfig), nil
+}
+
+// store принимает файл. REST задает смещение, APPE дописывает в конец.
+// Если задан abortAfter, сохраняется только начало файла, а передача обрывается.
+func (c *ftpSession) store(name string, appendMode bool) {
+	offset := c.rest
+	c.rest = 0
+
+	c.reply(150, "Ready to receive")
+	data, err := c.acceptData()
+	if err != nil {
+		c.reply(425, "Cannot open data connection")
+		return
+	}
+
+	c.server.mu.Lock()
+	limit := c.server.abortAfter
+	c.server.abortAfter = 0
+	c.server.mu.Unlock()
+
+	var reader io.Reader = data
+	if limit > 0 {
+		reader = io.LimitReader(data, limit)
+	}
+	received, err := io.ReadAll(reader)
+	data.Close()
+
+	c.server.mu.Lock()
+	existing := c.server.files[name]
+	switch {
+	case appendMode:
+		c.server.files[name] = append(append([]byte(nil), existing...), received...)
+	case offset > 0 && offset <= int64(len(existing)):
+		c.server.files[name] = append(append([]byte(nil), existing[:offset]...), received...)
+	default:
+		c.server.files[name] = received
+	}
+	c.server.mu.Unlock()
+
+	if err != nil || limit > 0 {
+		c.reply(426, "Connection closed; transfer aborted")
+		return
+	}
+	c.reply(226, "Transfer complete")
+}
+
+// retrieve отдает файл начиная со смещения REST
+func (c *ftpSession) retrieve(name string) {
+	offset := c.rest
+	c.rest = 0
+
+	content, ok := c.server.file(name)
+	if !ok || offset > int64(len(content)) {
+		c.reply(550, "No such file")
+		return
+	}
+
+	c.reply(150, "Opening data connection")
+	data, err := c.acceptData()
+	if err != nil {
+		c.reply(425, "Cannot open data connection")
+		return
+	}
+	_, err = data.Write(content[offset:])
+	data.Close()
+
+	if err != nil {
+		c.reply(426, "Connection closed; transfer aborted")
+		return
+	}
+	c.reply(226, "Transfer complete")
+}
@@ -1,11 +1,17 @@
 package uploaders
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
+	"net/textproto"
 	"os"
 	"stock-photo-app/models"
 	"strings"
+	"sync"
 	"time"
 
 	"crypto/tls"
@@ -19,9 +25,10 @@ type FTPUploader struct {
 	dbService DatabaseService
 }
 
-// DatabaseService интерфейс для логирования
+// DatabaseService интерфейс для логирования и сохранения данных, полученных при подключении
 type DatabaseService interface {
 	LogEvent(batchID, photoID, eventType, status, message, details string, progress int) error
+	SaveStockHostKey(stockID, fingerprint string) error
 }
 
 // NewFTPUploader создает новый FTP загрузчик
@@ -40,60 +47,33 @@ func NewFTPUploader(dbService DatabaseService) *FTPUploader {
 	}
 }
 
-// Upload загружает фото через FTP с retry логикой
-func (u *FTPUploader) Upload(photo models.Photo, config models.StockConfig) (models.UploadResult, error) {
-	maxRetries := 3
-	var lastErr error
-
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		// Логируем попытку подключения
-		if attempt > 1 {
-			log.Printf("FTP: Retry attempt %d/%d for %s", attempt, maxRetries, photo.FileName)
-			u.dbService.LogEvent(photo.BatchID, photo.ID, "ftp_upload", "progress",
-				fmt.Sprintf("Попытка загрузки %d/%d для %s", attempt, maxRetries, photo.FileName), "", (attempt-1)*25)
-
-			// Экспоненциальная задержка между попытками
-			time.Sleep(time.Duration(attempt) * time.Second)
-		}
-
-		// Подключаемся к FTP серверу
-		conn, err := u.connect(config)
-		if err != nil {
-			lastErr = err
-			if attempt == maxRetries {
-				// Логируем ошибку подключения после всех попыток
-				u.dbService.LogEvent(photo.BatchID, photo.ID, "ftp_upload", "failed",
-					fmt.Sprintf("Ошибка подключения к FTP %s после %d попыток", config.Connection.Host, maxRetries), err.Error(), 0)
-				return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка подключения к FTP %s после %d попыток: %v", config.Connection.Host, maxRetries, err), false), err
-			}
-			log.Printf("FTP: Connection attempt %d failed: %v", attempt, err)
-			continue
-		}
-
-		// Попытка загрузки
-		result, err := u.uploadFile(conn, photo, config)
-		conn.Quit()
-
-		if err == nil {
-			return result, nil
-		}
-
-		lastErr = err
-		if attempt == maxRetries {
-			// Логируем финальную ошибку
-			u.dbService.LogEvent(photo.BatchID, photo.ID, "ftp_upload", "failed",
-				fmt.Sprintf("Ошибка загрузки файла %s после %d попыток", photo.FileName, maxRetries), err.Error(), 0)
-			return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка загрузки после %d попыток: %v", maxRetries, err), false), err
-		}
+// Upload загружает фото через FTP.
+// Повторы выполняет очередь загрузки, ошибки классифицируются для ее политики повторов.
+func (u *FTPUploader) Upload(ctx context.Context, photo models.Photo, config models.StockConfig) (models.UploadResult, error) {
+	return u.UploadWithProgress(ctx, photo, config, nil)
+}
 
-		log.Printf("FTP: Upload attempt %d failed: %v", attempt, err)
+// UploadWithProgress загружает фото через FTP, сообщая количество отправленных байт
+func (u *FTPUploader) UploadWithProgress(ctx context.Context, photo models.Photo, config models.StockConfig, progress ProgressFunc) (models.UploadResult, error) {
+	// Подключаемся к FTP серверу
+	conn, conns, err := u.connect(ctx, config)
+	if err != nil {
+		u.dbService.LogEvent(photo.BatchID, photo.ID, "ftp_upload", "failed",
+			fmt.Sprintf("Ошибка подключения к FTP %s", config.Connection.Host), err.Error(), 0)
+		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка подключения к FTP %s: %v", config.Connection.Host, err), false), err
 	}
+	defer conn.Quit()
+
+	// Отмена ctx закрывает управляющее соединение и соединение данных и обрывает передачу,
+	// даже если сервер перестал отвечать или не принимает данные
+	stop := context.AfterFunc(ctx, conns.close)
+	defer stop()
 
-	return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Все попытки загрузки неудачны: %v", lastErr), false), lastErr
+	return u.uploadFile(ctx, conn, photo, config, progress)
 }
 
 // uploadFile выполняет загрузку файла через установленное соединение
-func (u *FTPUploader) uploadFile(conn *ftp.ServerConn, photo models.Photo, config models.StockConfig) (models.UploadResult, error) {
+func (u *FTPUploader) uploadFile(ctx context.Context, conn *ftp.ServerConn, photo models.Photo, config models.StockConfig, progress ProgressFunc) (models.UploadResult, error) {
 
 	// Логируем начало загрузки
 	u.dbService.LogEvent(photo.BatchID, photo.ID, "ftp_upload", "started",
@@ -107,7 +87,7 @@ func (u *FTPUploader) uploadFile(conn *ftp.ServerConn, photo models.Photo, confi
 			// Логируем ошибку смены директории
 			u.dbService.LogEvent(photo.BatchID, photo.ID, "ftp_upload", "failed",
 				"Ошибка смены директории FTP", err.Error(), 0)
-			return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка смены директории: %v", err), false), err
+			return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка смены директории: %v", err), false), classifyFTPError(err)
 		}
 	}
 
@@ -117,18 +97,50 @@ func (u *FTPUploader) uploadFile(conn *ftp.ServerConn, photo models.Photo, confi
 		// Логируем ошибку открытия файла
 		u.dbService.LogEvent(photo.BatchID, photo.ID, "ftp_upload", "failed",
 			"Ошибка открытия файла", err.Error(), 0)
-		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка открытия файла: %v", err), false), err
+		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка открытия файла: %v", err), false), NewPermanentError(err)
 	}
 	defer file.Close()
 
-	// Загружаем файл
-	log.Printf("FTP: Uploading file %s", photo.FileName)
-	err = conn.Stor(photo.FileName, file)
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка чтения файла: %v", err), false), NewPermanentError(err)
+	}
+	localSize := fileInfo.Size()
+
+	// Проверяем, не осталась ли на сервере часть файла от прерванной попытки
+	offset := u.resumeOffset(conn, file, photo.FileName, localSize)
+
+	if offset == localSize {
+		log.Printf("FTP: File %s already fully uploaded, skipping transfer", photo.FileName)
+		if progress != nil {
+			progress(localSize, localSize)
+		}
+	} else {
+		if offset > 0 {
+			log.Printf("FTP: Resuming upload of %s from byte %d of %d", photo.FileName, offset, localSize)
+			u.dbService.LogEvent(photo.BatchID, photo.ID, "ftp_upload", "progress",
+				fmt.Sprintf("Продолжение загрузки %s с %d из %d байт", photo.FileName, offset, localSize), "", int(offset*100/localSize))
+		} else {
+			log.Printf("FTP: Uploading file %s", photo.FileName)
+		}
+
+		err = u.storFrom(ctx, conn, file, photo.FileName, offset, localSize, progress)
+		if err != nil {
+			// Логируем ошибку загрузки
+			u.dbService.LogEvent(photo.BatchID, photo.ID, "ftp_upload", "failed",
+				fmt.Sprintf("Ошибка загрузки файла %s", photo.FileName), err.Error(), 0)
+			return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка загрузки: %v", err), false), classifyFTPError(err)
+		}
+	}
+
+	// Сверяем размер загруженного файла
+	remoteSize, err := conn.FileSize(photo.FileName)
 	if err != nil {
-		// Логируем ошибку загрузки
+		log.Printf("FTP: Warning - SIZE is not supported, upload of %s is not verified: %v", photo.FileName, err)
+	} else if err := verifyRemoteSize(localSize, remoteSize); err != nil {
 		u.dbService.LogEvent(photo.BatchID, photo.ID, "ftp_upload", "failed",
-			fmt.Sprintf("Ошибка загрузки файла %s", photo.FileName), err.Error(), 0)
-		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка загрузки: %v", err), false), err
+			fmt.Sprintf("Проверка загрузки %s не пройдена", photo.FileName), err.Error(), 0)
+		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Проверка загрузки не пройдена: %v", err), false), err
 	}
 
 	// Логируем успешную загрузку
@@ -140,9 +152,98 @@ func (u *FTPUploader) uploadFile(conn *ftp.ServerConn, photo models.Photo, confi
 	return u.CreateUploadResult(photo.ID, config.ID, successMessage, true), nil
 }
 
+// UploadFile загружает служебный файл (CSV метаданных) так же, как фото
+func (u *FTPUploader) UploadFile(ctx context.Context, file models.Photo, config models.StockConfig) (models.UploadResult, error) {
+	return u.Upload(ctx, file, config)
+}
+
+// resumeOffset определяет, с какого байта продолжить загрузку по размеру файла на сервере.
+// Хвост недозагруженного файла сверяется с локальным, при расхождении файл загружается заново.
+func (u *FTPUploader) resumeOffset(conn *ftp.ServerConn, file *os.File, remoteName string, localSize int64) int64 {
+	remoteSize, err := conn.FileSize(remoteName)
+	if err != nil {
+		// Файла нет или сервер не поддерживает SIZE
+		return 0
+	}
+
+	offset := resumeOffset(localSize, remoteSize)
+	if offset == 0 {
+		return 0
+	}
+
+	start, length := resumeCheckRange(offset)
+	resp, err := u.retrFrom(conn, remoteName, start)
+	if err != nil {
+		log.Printf("FTP: Cannot read partial file %s, uploading from scratch: %v", remoteName, err)
+		return 0
+	}
+	matches, err := matchesLocalRange(file, resp, start, length)
+	resp.Close()
+	if err != nil || !matches {
+		log.Printf("FTP: Partial file %s does not match local file, uploading from scratch", remoteName)
+		return 0
+	}
+
+	return offset
+}
+
+// retrFrom открывает чтение удаленного файла с offset. Если сервер не поддерживает REST,
+// файл читается с начала, а первые offset байт пропускаются: докачка через APPE остается возможной.
+func (u *FTPUploader) retrFrom(conn *ftp.ServerConn, remoteName string, offset int64) (*ftp.Response, error) {
+	resp, err := conn.RetrFrom(remoteName, uint64(offset))
+	var protoErr *textproto.Error
+	if offset == 0 || !errors.As(err, &protoErr) || !isFTPNotImplemented(protoErr.Code) {
+		return resp, err
+	}
+
+	resp, err = conn.Retr(remoteName)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.CopyN(io.Discard, resp, offset); err != nil {
+		resp.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// storFrom загружает файл начиная с offset: REST + STOR, а если сервер не поддерживает REST - APPE.
+// После отмены ctx чтение файла прекращается и передача обрывается.
+func (u *FTPUploader) storFrom(ctx context.Context, conn *ftp.ServerConn, file *os.File, remoteName string, offset, size int64, progress ProgressFunc) error {
+	source := newContextReader(ctx, file)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return NewPermanentError(fmt.Errorf("ошибка чтения файла: %w", err))
+	}
+
+	if offset == 0 {
+		return conn.Stor(remoteName, newProgressReader(source, 0, size, progress))
+	}
+
+	err := conn.StorFrom(remoteName, newProgressReader(source, offset, size, progress), uint64(offset))
+	var protoErr *textproto.Error
+	if errors.As(err, &protoErr) && isFTPNotImplemented(protoErr.Code) {
+		log.Printf("FTP: REST is not supported by server, appending %s with APPE", remoteName)
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return NewPermanentError(fmt.Errorf("ошибка чтения файла: %w", err))
+		}
+		return conn.Append(remoteName, newProgressReader(source, offset, size, progress))
+	}
+
+	return err
+}
+
+// isFTPNotImplemented сообщает, что сервер не поддерживает команду
+func isFTPNotImplemented(code int) bool {
+	switch code {
+	case 500, 501, 502, 504:
+		return true
+	}
+	return false
+}
+
 // TestConnection тестирует подключение к FTP серверу
 func (u *FTPUploader) TestConnection(config models.StockConfig) error {
-	conn, err := u.connect(config)
+	conn, _, err := u.connect(context.Background(), config)
 	if err != nil {
 		return err
 	}
@@ -165,8 +266,8 @@ func (u *FTPUploader) ValidateConfig(config models.StockConfig) error {
 	return u.ValidateRequiredFields(config, requiredFields)
 }
 
-// connect подключается к FTP серверу
-func (u *FTPUploader) connect(config models.StockConfig) (*ftp.ServerConn, error) {
+// connect подключается к FTP серверу; отмена ctx прерывает подключение
+func (u *FTPUploader) connect(ctx context.Context, config models.StockConfig) (*ftp.ServerConn, *ftpConns, error) {
 	// Устанавливаем таймаут
 	timeout := time.Duration(config.Connection.Timeout) * time.Second
 	if timeout == 0 {
@@ -179,8 +280,6 @@ func (u *FTPUploader) connect(config models.StockConfig) (*ftp.ServerConn, error
 		encryption = "none"
 	}
 
-	log.Printf("FTP: [DEBUG] Stock Config ID: %s, Name: %s", config.ID, config.Name)
-	log.Printf("FTP: [DEBUG] Connection config: %+v", config.Connection)
 	log.Printf("FTP: Connecting to %s:%d with encryption=%s, timeout=%v",
 		config.Connection.Host, config.Connection.Port, encryption, timeout)
 
@@ -206,50 +305,42 @@ func (u *FTPUploader) connect(config models.StockConfig) (*ftp.ServerConn, error
 	var conn *ftp.ServerConn
 	var err error
 	addr := fmt.Sprintf("%s:%d", config.Connection.Host, config.Connection.Port)
+	conns := &ftpConns{ctx: ctx, dialer: net.Dialer{Timeout: timeout}}
+
+	// dialOptions параметры подключения: все соединения (управляющее и данных) открываются через conns,
+	// чтобы отмена ctx могла их закрыть
+	dialOptions := func(tlsConfig *tls.Config, implicitTLS bool) []ftp.DialOption {
+		conns.tlsConfig, conns.implicitTLS, conns.control = tlsConfig, implicitTLS, false
+		options := []ftp.DialOption{ftp.DialWithDialFunc(conns.dial)}
+		if tlsConfig != nil {
+			if implicitTLS {
+				options = append(options, ftp.DialWithTLS(tlsConfig))
+			} else {
+				options = append(options, ftp.DialWithExplicitTLS(tlsConfig))
+			}
+		}
+		if config.Connection.Passive {
+			options = append(options, ftp.DialWithDisabledEPSV(true))
+		}
+		return options
+	}
 
 	// Выбираем режим подключения в зависимости от типа шифрования
 	switch encryption {
 	case "none":
 		// Обычный FTP без шифрования
-		dialOptions := []ftp.DialOption{ftp.DialWithTimeout(timeout)}
-		if config.Connection.Passive {
-			dialOptions = append(dialOptions, ftp.DialWithDisabledEPSV(true))
-		}
-		conn, err = ftp.Dial(addr, dialOptions...)
+		conn, err = ftp.Dial(addr, dialOptions(nil, false)...)
 
 	case "auto":
 		// Пробуем FTPS, если не получается - обычный FTP
-		dialOptionsSecure := []ftp.DialOption{
-			ftp.DialWithTimeout(timeout),
-			ftp.DialWithExplicitTLS(tlsConfig),
-		}
-		if config.Connection.Passive {
-			dialOptionsSecure = append(dialOptionsSecure, ftp.DialWithDisabledEPSV(true))
-		}
-
-		conn, err = ftp.Dial(addr, dialOptionsSecure...)
+		conn, err = ftp.Dial(addr, dialOptions(tlsConfig, false)...)
 		if err != nil {
-			// Если FTPS не работает, пробуем обычный FTP
-			dialOptionsPlain := []ftp.DialOption{ftp.DialWithTimeout(timeout)}
-			if config.Connection.Passive {
-				dialOptionsPlain = append(dialOptionsPlain, ftp.DialWithDisabledEPSV(true))
-			}
-			conn, err = ftp.Dial(addr, dialOptionsPlain...)
+			conn, err = ftp.Dial(addr, dialOptions(nil, false)...)
 		}
 
 	case "explicit":
 		// Явный FTPS (FTPS explicit) - подключение по обычному порту с последующим переходом на TLS
-		dialOptions := []ftp.DialOption{
-			ftp.DialWithTimeout(timeout),
-			ftp.DialWithExplicitTLS(tlsConfig),
-		}
-
-		// Добавляем дополнительные опции для пассивного режима
-		if config.Connection.Passive {
-			dialOptions = append(dialOptions, ftp.DialWithDisabledEPSV(true))
-		}
-
-		conn, err = ftp.Dial(addr, dialOptions...)
+		conn, err = ftp.Dial(addr, dialOptions(tlsConfig, false)...)
 
 	case "implicit":
 		// Неявный FTPS (FTPS implicit) - подключение сразу через TLS, обычно порт 990
@@ -257,11 +348,10 @@ func (u *FTPUploader) connect(config models.StockConfig) (*ftp.ServerConn, error
 			// Автоматически меняем порт для implicit FTPS
 			addr = fmt.Sprintf("%s:990", config.Connection.Host)
 		}
-		// Используем DialWithTLS для implicit FTPS (подключение сразу через TLS)
-		conn, err = ftp.Dial(addr, ftp.DialWithTimeout(timeout), ftp.DialWithTLS(tlsConfig))
+		conn, err = ftp.Dial(addr, dialOptions(tlsConfig, true)...)
 
 	default:
-		return nil, fmt.Errorf("неподдерживаемый тип шифрования: %s", encryption)
+		return nil, nil, NewPermanentError(fmt.Errorf("неподдерживаемый тип шифрования: %s", encryption))
 	}
 
 	if err != nil {
@@ -282,7 +372,7 @@ func (u *FTPUploader) connect(config models.StockConfig) (*ftp.ServerConn, error
 			errMsg += " - хост не найден (проверьте адрес сервера)"
 		}
 
-		return nil, fmt.Errorf("%s: %v", errMsg, err)
+		return nil, nil, NewTransientError(fmt.Errorf("%s: %w", errMsg, err))
 	}
 
 	// Авторизуемся
@@ -290,7 +380,7 @@ func (u *FTPUploader) connect(config models.StockConfig) (*ftp.ServerConn, error
 	err = conn.Login(config.Connection.Username, config.Connection.Password)
 	if err != nil {
 		conn.Quit()
-		return nil, fmt.Errorf("ошибка авторизации: %v", err)
+		return nil, nil, classifyFTPError(fmt.Errorf("ошибка авторизации: %w", err))
 	}
 
 	log.Printf("FTP: Successfully connected and logged in to %s", config.Connection.Host)
@@ -304,8 +394,52 @@ func (u *FTPUploader) connect(config models.StockConfig) (*ftp.ServerConn, error
 	_, err = conn.CurrentDir()
 	if err != nil {
 		conn.Quit()
-		return nil, fmt.Errorf("не удается получить текущую директорию (проблема с режимом FTP): %v", err)
+		return nil, nil, NewTransientError(fmt.Errorf("не удается получить текущую директорию (проблема с режимом FTP): %w", err))
 	}
 
+	return conn, conns, nil
+}
+
+// ftpConns открывает соединения FTP клиента и запоминает их, чтобы отмена загрузки могла закрыть
+// и управляющее соединение, и соединение данных. Первое соединение - управляющее.
+type ftpConns struct {
+	ctx    context.Context
+	dialer net.Dialer
+	// tlsConfig шифрование соединений данных (PROT P), а при implicitTLS - и управляющего
+	tlsConfig   *tls.Config
+	implicitTLS bool
+	control     bool
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+// dial открывает соединение; используется как ftp.DialWithDialFunc
+func (c *ftpConns) dial(network, address string) (net.Conn, error) {
+	conn, err := c.dialer.DialContext(c.ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Управляющее соединение при explicit TLS переводит на TLS сама библиотека после AUTH TLS
+	isControl := !c.control
+	c.control = true
+	if c.tlsConfig != nil && (!isControl || c.implicitTLS) {
+		conn = tls.Client(conn, c.tlsConfig)
+	}
+
+	c.conns = append(c.conns, conn)
 	return conn, nil
 }
+
+// close закрывает все открытые соединения
+func (c *ftpConns) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, conn := range c.conns {
+		conn.Close()
+	}
+}
@@ -0,0 +1,74 @@
+package uploaders
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"stock-photo-app/models"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// testDatabase записывает события загрузчиков и закрепленные ключи SSH серверов
+type testDatabase struct {
+	mu       sync.Mutex
+	events   []string
+	hostKeys map[string]string
+}
+
+func newTestDatabase() *testDatabase {
+	return &testDatabase{hostKeys: make(map[string]string)}
+}
+
+func (d *testDatabase) LogEvent(batchID, photoID, eventType, status, message, details string, progress int) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.events = append(d.events, eventType+":"+status+":"+message)
+	return nil
+}
+
+func (d *testDatabase) SaveStockHostKey(stockID, fingerprint string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hostKeys[stockID] = fingerprint
+	return nil
+}
+
+// hasEvent сообщает, что было событие со статусом status, сообщение которого содержит text
+func (d *testDatabase) hasEvent(status, text string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, event := range d.events {
+		parts := strings.SplitN(event, ":", 3)
+		if parts[1] == status && strings.Contains(parts[2], text) {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTestFile создает файл name со случайным содержимым размером size байт
+func writeTestFile(t *testing.T, name string, size int) (models.Photo, []byte) {
+	t.Helper()
+
+	data := make([]byte, size)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return models.Photo{ID: "photo-1", BatchID: "batch-1", FileName: name, OriginalPath: path}, data
+}
+
+// checksum возвращает SHA-256 данных в hex
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
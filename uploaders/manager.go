@@ -1,7 +1,10 @@
 package uploaders
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"stock-photo-app/models"
 	"sync"
 )
@@ -23,6 +26,11 @@ func NewUploaderManager(dbService DatabaseService) *UploaderManager {
 	manager.RegisterUploader("sftp", NewSFTPUploader(dbService))
 	manager.RegisterUploader("api", NewAPIUploader())
 
+	// Загрузчики фотобанков с их серверами приема и требованиями к файлам
+	manager.RegisterUploader("adobe_stock", NewAdobeStockUploader(dbService))
+	manager.RegisterUploader("shutterstock", NewShutterstockUploader(dbService))
+	manager.RegisterUploader("alamy", NewAlamyUploader(dbService))
+
 	return manager
 }
 
@@ -60,7 +68,15 @@ func (m *UploaderManager) GetAvailableUploaders() []models.UploaderInfo {
 }
 
 // UploadPhoto загружает фото используя соответствующий загрузчик
-func (m *UploaderManager) UploadPhoto(photo models.Photo, config models.StockConfig) (models.UploadResult, error) {
+func (m *UploaderManager) UploadPhoto(ctx context.Context, photo models.Photo, config models.StockConfig) (models.UploadResult, error) {
+	return m.UploadPhotoWithProgress(ctx, photo, config, nil)
+}
+
+// UploadPhotoWithProgress загружает фото и передает побайтовый прогресс в progress,
+// если загрузчик реализует ProgressUploader. Отмена ctx прерывает загрузку.
+// Правила метаданных стока к фото уже применены при подготовке копии (ApplyMetadataRules):
+// повторное применение не идемпотентно, например для цепочки categoryMap.
+func (m *UploaderManager) UploadPhotoWithProgress(ctx context.Context, photo models.Photo, config models.StockConfig, progress ProgressFunc) (models.UploadResult, error) {
 	// Определяем тип загрузчика
 	uploaderType := config.Type
 	if uploaderType == "" {
@@ -76,7 +92,7 @@ func (m *UploaderManager) UploadPhoto(photo models.Photo, config models.StockCon
 			StockID: config.ID,
 			Success: false,
 			Message: fmt.Sprintf("Загрузчик не найден: %v", err),
-		}, err
+		}, NewPermanentError(err)
 	}
 
 	// Валидируем конфигурацию
@@ -86,11 +102,128 @@ func (m *UploaderManager) UploadPhoto(photo models.Photo, config models.StockCon
 			StockID: config.ID,
 			Success: false,
 			Message: fmt.Sprintf("Ошибка конфигурации: %v", err),
-		}, err
+		}, NewPermanentError(err)
+	}
+
+	// RAW загружается вместе с XMP sidecar, для этого загрузчик должен уметь отправлять дополнительные файлы
+	sidecarUploader, canUploadSidecar := uploader.(FileUploader)
+	if photo.SidecarPath != "" && !canUploadSidecar {
+		err := fmt.Errorf("загрузчик '%s' не может отправить XMP sidecar вместе с RAW файлом", uploaderType)
+		return models.UploadResult{
+			PhotoID: photo.ID,
+			StockID: config.ID,
+			Success: false,
+			Message: err.Error(),
+		}, NewPermanentError(err)
 	}
 
 	// Выполняем загрузку
-	return uploader.Upload(photo, config)
+	var result models.UploadResult
+	if progressUploader, ok := uploader.(ProgressUploader); ok && progress != nil {
+		result, err = progressUploader.UploadWithProgress(ctx, photo, config, progress)
+	} else {
+		result, err = uploader.Upload(ctx, photo, config)
+	}
+	if err != nil || !result.Success || photo.SidecarPath == "" {
+		return result, err
+	}
+
+	return m.uploadSidecar(ctx, sidecarUploader, photo, config)
+}
+
+// SelectRendition возвращает копию фото, в которой OriginalPath, FileName и FileSize указывают на файл,
+// который получит сток: его выбирает загрузчик (RenditionSelector) или настройка стока rawUpload.
+// Фото без списка файлов возвращается без изменений.
+func (m *UploaderManager) SelectRendition(photo models.Photo, config models.StockConfig) (models.Photo, error) {
+	if len(photo.Renditions) == 0 {
+		return photo, nil
+	}
+
+	uploaderType := config.Type
+	if uploaderType == "" {
+		uploaderType = config.UploadMethod
+	}
+
+	var rendition models.PhotoRendition
+	var err error
+	if uploader, getErr := m.GetUploader(uploaderType); getErr == nil {
+		if selector, ok := uploader.(RenditionSelector); ok {
+			rendition, err = selector.SelectRendition(photo, config)
+		} else {
+			rendition, err = SelectRendition(photo, config)
+		}
+	} else {
+		rendition, err = SelectRendition(photo, config)
+	}
+	if err != nil {
+		return photo, NewPermanentError(err)
+	}
+
+	photo.OriginalPath = rendition.Path
+	photo.FileName = rendition.FileName
+	photo.FileSize = rendition.FileSize
+	return photo, nil
+}
+
+// uploadSidecar отправляет XMP sidecar загруженного RAW файла тем же загрузчиком
+func (m *UploaderManager) uploadSidecar(ctx context.Context, uploader FileUploader, photo models.Photo, config models.StockConfig) (models.UploadResult, error) {
+	info, err := os.Stat(photo.SidecarPath)
+	if err != nil {
+		err = fmt.Errorf("XMP sidecar для %s не найден: %w", photo.FileName, err)
+		return models.UploadResult{PhotoID: photo.ID, StockID: config.ID, Message: err.Error()}, NewPermanentError(err)
+	}
+
+	sidecar := models.Photo{
+		ID:           photo.ID,
+		BatchID:      photo.BatchID,
+		OriginalPath: photo.SidecarPath,
+		FileName:     filepath.Base(photo.SidecarPath),
+		FileSize:     info.Size(),
+	}
+	result, err := uploader.UploadFile(ctx, sidecar, config)
+	if err != nil || !result.Success {
+		return result, err
+	}
+
+	result.Message = fmt.Sprintf("%s загружен вместе с XMP sidecar", photo.FileName)
+	return result, nil
+}
+
+// FileUploader загрузчик, который может отправить на сток служебный файл, например CSV метаданных
+type FileUploader interface {
+	UploadFile(ctx context.Context, file models.Photo, config models.StockConfig) (models.UploadResult, error)
+}
+
+// UploadMetadataFile загружает файл метаданных батча на сток тем же загрузчиком, что и фото
+func (m *UploaderManager) UploadMetadataFile(ctx context.Context, batchID, localPath string, config models.StockConfig) (models.UploadResult, error) {
+	uploaderType := config.Type
+	if uploaderType == "" {
+		uploaderType = config.UploadMethod
+	}
+
+	uploader, err := m.GetUploader(uploaderType)
+	if err != nil {
+		return models.UploadResult{StockID: config.ID, Message: err.Error()}, NewPermanentError(err)
+	}
+
+	fileUploader, ok := uploader.(FileUploader)
+	if !ok {
+		err := fmt.Errorf("загрузчик '%s' не поддерживает загрузку файлов метаданных", uploaderType)
+		return models.UploadResult{StockID: config.ID, Message: err.Error()}, NewPermanentError(err)
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return models.UploadResult{StockID: config.ID, Message: err.Error()}, NewPermanentError(err)
+	}
+
+	file := models.Photo{
+		BatchID:      batchID,
+		OriginalPath: localPath,
+		FileName:     filepath.Base(localPath),
+		FileSize:     info.Size(),
+	}
+	return fileUploader.UploadFile(ctx, file, config)
 }
 
 // TestConnection тестирует подключение к стоку
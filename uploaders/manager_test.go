@@ -0,0 +1,50 @@
+package uploaders
+
+import (
+	"context"
+	"stock-photo-app/models"
+	"testing"
+)
+
+// recordingUploader запоминает фото, переданное на загрузку
+type recordingUploader struct {
+	photo models.Photo
+}
+
+func (u *recordingUploader) Upload(ctx context.Context, photo models.Photo, config models.StockConfig) (models.UploadResult, error) {
+	u.photo = photo
+	return models.UploadResult{PhotoID: photo.ID, StockID: config.ID, Success: true}, nil
+}
+
+func (u *recordingUploader) TestConnection(config models.StockConfig) error { return nil }
+
+func (u *recordingUploader) GetInfo() models.UploaderInfo {
+	return models.UploaderInfo{Type: "recording"}
+}
+
+func (u *recordingUploader) ValidateConfig(config models.StockConfig) error { return nil }
+
+func TestUploadPhotoAppliesMetadataRulesOnce(t *testing.T) {
+	uploader := &recordingUploader{}
+	manager := &UploaderManager{uploaders: map[string]models.StockUploader{"recording": uploader}}
+
+	config := models.StockConfig{
+		ID:       "stock",
+		Type:     "recording",
+		Settings: map[string]interface{}{"categoryMap": "Food=Drinks, Drinks=Beverages"},
+	}
+	photo := models.Photo{ID: "photo-1", AIResult: &models.AIResult{Title: "Breakfast", Category: "Food"}}
+
+	// Фото приходит в менеджер уже подготовленным, как копия из очереди загрузки
+	staged := ApplyMetadataRules(photo, config)
+	if staged.AIResult.Category != "Drinks" {
+		t.Fatalf("staged category = %q, want Drinks", staged.AIResult.Category)
+	}
+
+	if _, err := manager.UploadPhoto(context.Background(), staged, config); err != nil {
+		t.Fatal(err)
+	}
+	if got := uploader.photo.AIResult.Category; got != "Drinks" {
+		t.Errorf("uploaded category = %q, want the staged Drinks", got)
+	}
+}
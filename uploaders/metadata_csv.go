@@ -0,0 +1,225 @@
+package uploaders
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"path/filepath"
+	"stock-photo-app/models"
+	"strings"
+)
+
+// MetadataCSVFormat раскладка CSV с метаданными, который агентство принимает вместе с файлами
+type MetadataCSVFormat struct {
+	Name   string
+	Header []string
+	Row    func(photo models.Photo) []string
+}
+
+// Форматы CSV метаданных. generic подходит для агентств, которые принимают произвольный CSV
+// с именем файла, названием и ключевыми словами (например, Pond5).
+var metadataCSVFormats = map[string]MetadataCSVFormat{
+	"adobe_stock": {
+		Name:   "Adobe Stock",
+		Header: []string{"Filename", "Title", "Keywords", "Category", "Releases"},
+		Row: func(photo models.Photo) []string {
+			ai := photoAIResult(photo)
+			return []string{photo.FileName, ai.Title, strings.Join(ai.Keywords, ", "), adobeStockCategory(ai.Category), photoReleases(photo)}
+		},
+	},
+	"shutterstock": {
+		Name:   "Shutterstock",
+		Header: []string{"Filename", "Description", "Keywords", "Categories", "Editorial", "Mature content", "illustration"},
+		Row: func(photo models.Photo) []string {
+			ai := photoAIResult(photo)
+			description := ai.Description
+			if description == "" {
+				description = ai.Title
+			}
+			return []string{photo.FileName, description, strings.Join(ai.Keywords, ","), shutterstockCategory(ai.Category),
+				yesNo(isEditorialPhoto(photo)), "no", "no"}
+		},
+	},
+	"generic": {
+		Name:   "Generic",
+		Header: []string{"Filename", "Title", "Description", "Keywords", "Category", "Releases"},
+		Row: func(photo models.Photo) []string {
+			ai := photoAIResult(photo)
+			return []string{photo.FileName, ai.Title, ai.Description, strings.Join(ai.Keywords, ", "), ai.Category, photoReleases(photo)}
+		},
+	},
+}
+
+// GetMetadataCSVFormats возвращает названия поддерживаемых форматов CSV
+func GetMetadataCSVFormats() []string {
+	return []string{"adobe_stock", "shutterstock", "generic"}
+}
+
+// MetadataCSVFormatFor возвращает формат CSV для стока: из Settings["csvFormat"], иначе из профиля агентства.
+// false означает, что сток не принимает CSV метаданных ("none" или агентство читает только IPTC).
+func MetadataCSVFormatFor(config models.StockConfig) (string, bool) {
+	if format, ok := config.Settings["csvFormat"].(string); ok && format != "" {
+		if _, exists := metadataCSVFormats[format]; exists {
+			return format, true
+		}
+		return "", false
+	}
+
+	for _, profile := range agencyProfiles() {
+		if profile.Type == config.Type && profile.CSVFormat != "" {
+			return profile.CSVFormat, true
+		}
+	}
+
+	return "", false
+}
+
+// WriteMetadataCSV записывает CSV метаданных в формате format, по строке на фото.
+// Метаданные проходят правила стока config. Фото без результатов AI анализа пропускаются,
+// возвращается число записанных строк.
+func WriteMetadataCSV(w io.Writer, format string, config models.StockConfig, photos []models.Photo) (int, error) {
+	layout, ok := metadataCSVFormats[format]
+	if !ok {
+		return 0, fmt.Errorf("неизвестный формат CSV: %s", format)
+	}
+
+	writer := csv.NewWriter(w)
+	if err := writer.Write(layout.Header); err != nil {
+		return 0, fmt.Errorf("failed to write CSV header: %w", err)
+	}
+
+	count := 0
+	for _, photo := range photos {
+		if photo.AIResult == nil || photo.AIResult.Title == "" {
+			continue
+		}
+		photo = ApplyMetadataRules(photo, config)
+		if err := writer.Write(layout.Row(photo)); err != nil {
+			return count, fmt.Errorf("failed to write CSV row for %s: %w", photo.FileName, err)
+		}
+		count++
+	}
+
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return count, fmt.Errorf("failed to write CSV: %w", err)
+	}
+
+	return count, nil
+}
+
+func photoAIResult(photo models.Photo) models.AIResult {
+	if photo.AIResult == nil {
+		return models.AIResult{}
+	}
+	return *photo.AIResult
+}
+
+// photoReleases возвращает имена PDF релизов моделей и property release фото через запятую
+func photoReleases(photo models.Photo) string {
+	var releases []string
+	for _, rendition := range photo.Renditions {
+		if rendition.Kind != RenditionRelease {
+			continue
+		}
+		name := rendition.FileName
+		if name == "" {
+			name = filepath.Base(rendition.Path)
+		}
+		releases = append(releases, name)
+	}
+	return strings.Join(releases, ",")
+}
+
+func isEditorialPhoto(photo models.Photo) bool {
+	if photo.AIResult != nil && photo.AIResult.ContentType != "" {
+		return photo.AIResult.ContentType == "editorial"
+	}
+	return photo.ContentType == "editorial"
+}
+
+func yesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+// Коды категорий Adobe Stock для категорий AI анализа (см. "Категории стоков" в документации)
+var adobeStockCategories = map[string]string{
+	"animals":            "1",
+	"architecture":       "2",
+	"business":           "3",
+	"business & finance": "3",
+	"environment":        "5",
+	"disasters":          "5",
+	"food & drink":       "7",
+	"entertainment":      "9",
+	"sport & fitness":    "18",
+	"sports events":      "18",
+	"nature":             "11",
+	"lifestyle":          "12",
+	"family":             "12",
+	"fashion":            "12",
+	"health & wellness":  "12",
+	"people":             "13",
+	"celebrity":          "13",
+	"education":          "16",
+	"social issues":      "17",
+	"news":               "17",
+	"politics":           "17",
+	"current events":     "17",
+	"documentary":        "17",
+	"war & conflict":     "17",
+	"technology":         "19",
+	"travel":             "21",
+}
+
+// adobeStockCategory возвращает числовой код категории Adobe Stock. Пустая строка - категория не задана.
+func adobeStockCategory(category string) string {
+	category = strings.ToLower(strings.TrimSpace(category))
+	if code, ok := adobeStockCategories[category]; ok {
+		return code
+	}
+	// Категория уже задана кодом
+	if len(category) > 0 && len(category) <= 2 && strings.Trim(category, "0123456789") == "" {
+		return category
+	}
+	return ""
+}
+
+// Категории Shutterstock для категорий AI анализа
+var shutterstockCategories = map[string]string{
+	"animals":            "Animals/Wildlife",
+	"architecture":       "Buildings/Landmarks",
+	"business":           "Business/Finance",
+	"business & finance": "Business/Finance",
+	"celebrity":          "Celebrities",
+	"education":          "Education",
+	"entertainment":      "Arts",
+	"environment":        "Nature",
+	"disasters":          "Nature",
+	"nature":             "Nature",
+	"family":             "People",
+	"people":             "People",
+	"lifestyle":          "People",
+	"fashion":            "Beauty/Fashion",
+	"food & drink":       "Food and drink",
+	"health & wellness":  "Healthcare/Medical",
+	"sport & fitness":    "Sports/Recreation",
+	"sports events":      "Sports/Recreation",
+	"technology":         "Technology",
+	"travel":             "Parks/Outdoor",
+}
+
+// shutterstockCategory возвращает категорию Shutterstock, для новостных и прочих категорий - Miscellaneous
+func shutterstockCategory(category string) string {
+	category = strings.TrimSpace(category)
+	if category == "" {
+		return ""
+	}
+	if mapped, ok := shutterstockCategories[strings.ToLower(category)]; ok {
+		return mapped
+	}
+	return "Miscellaneous"
+}
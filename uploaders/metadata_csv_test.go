@@ -0,0 +1,52 @@
+package uploaders
+
+import (
+	"encoding/csv"
+	"stock-photo-app/models"
+	"strings"
+	"testing"
+)
+
+func TestWriteMetadataCSVFillsReleases(t *testing.T) {
+	photos := []models.Photo{
+		{
+			FileName: "IMG_0001.jpg",
+			AIResult: &models.AIResult{Title: "Woman in a park", Keywords: []string{"woman", "park"}, Category: "people"},
+			Renditions: []models.PhotoRendition{
+				{Kind: RenditionJPEG, Path: "/photos/IMG_0001.jpg", FileName: "IMG_0001.jpg"},
+				{Kind: RenditionRelease, Path: "/photos/IMG_0001.pdf", FileName: "IMG_0001.pdf"},
+				{Kind: RenditionRelease, Path: "/photos/IMG_0001_property.pdf"},
+			},
+		},
+		{
+			FileName: "IMG_0002.jpg",
+			AIResult: &models.AIResult{Title: "Empty beach", Keywords: []string{"beach"}, Category: "travel"},
+		},
+	}
+
+	for _, format := range []string{"adobe_stock", "generic"} {
+		var buf strings.Builder
+		if _, err := WriteMetadataCSV(&buf, format, models.StockConfig{}, photos); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		records, err := csv.NewReader(strings.NewReader(buf.String())).ReadAll()
+		if err != nil {
+			t.Fatalf("%s: invalid CSV: %v", format, err)
+		}
+		if len(records) != 3 {
+			t.Fatalf("%s: %d records, want header and 2 rows", format, len(records))
+		}
+
+		column := len(records[0]) - 1
+		if records[0][column] != "Releases" {
+			t.Fatalf("%s: last column is %q, want Releases", format, records[0][column])
+		}
+		if got := records[1][column]; got != "IMG_0001.pdf,IMG_0001_property.pdf" {
+			t.Errorf("%s: releases = %q", format, got)
+		}
+		if got := records[2][column]; got != "" {
+			t.Errorf("%s: photo without releases has %q", format, got)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+package uploaders
+
+import (
+	"regexp"
+	"stock-photo-app/models"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MetadataRules правила подготовки метаданных под требования конкретного стока.
+// Читаются из StockConfig.Settings, для фотобанков незаданные правила берутся из профиля агентства.
+type MetadataRules struct {
+	MaxKeywords          int               // maxKeywords: сколько ключевых слов оставить, 0 - без ограничения
+	SupplementaryAfter   int               // supplementaryKeywordsAfter: ключевые слова после N-го уходят в дополнительные
+	MaxTitleLength       int               // maxTitleLength: длина названия в символах
+	MaxDescriptionLength int               // maxDescriptionLength: длина описания в символах
+	TitleForbiddenChars  string            // titleForbiddenChars: символы, которые убираются из названия
+	ForbiddenWords       []string          // forbiddenWords: слова, которые убираются из названия, описания и ключевых слов
+	RequiredKeywords     []string          // requiredKeywords: ключевые слова, которые всегда ставятся в начало списка
+	CategoryMap          map[string]string // categoryMap: категория AI -> категория стока
+	KeywordCase          string            // keywordCase: "lower", "upper", "title"
+	TitleCase            string            // titleCase: "lower", "sentence", "title"
+}
+
+// Ключи правил в StockConfig.Settings
+var metadataRuleKeys = []string{
+	"maxKeywords", "supplementaryKeywordsAfter", "maxTitleLength", "maxDescriptionLength",
+	"titleForbiddenChars", "forbiddenWords", "requiredKeywords", "categoryMap", "keywordCase", "titleCase",
+}
+
+// metadataRuleFields поля шаблона стока для правил метаданных
+func metadataRuleFields() []models.TemplateField {
+	return []models.TemplateField{
+		{Name: "maxKeywords", Type: "number", Label: "Максимум ключевых слов", Help: "0 - без ограничения"},
+		{Name: "supplementaryKeywordsAfter", Type: "number", Label: "Дополнительные ключевые слова после", Help: "Ключевые слова после N-го передаются отдельным полем supplementaryKeywords API запроса"},
+		{Name: "maxTitleLength", Type: "number", Label: "Максимальная длина названия"},
+		{Name: "maxDescriptionLength", Type: "number", Label: "Максимальная длина описания"},
+		{Name: "titleForbiddenChars", Type: "text", Label: "Запрещенные символы в названии", Placeholder: ","},
+		{Name: "forbiddenWords", Type: "text", Label: "Запрещенные слова", Placeholder: "free, best", Help: "Через запятую, убираются из названия, описания и ключевых слов"},
+		{Name: "requiredKeywords", Type: "text", Label: "Обязательные ключевые слова", Help: "Через запятую, ставятся в начало списка"},
+		{Name: "categoryMap", Type: "textarea", Label: "Соответствие категорий", Placeholder: "Food & Drink=Food, Travel=Travel", Help: "Категория AI=категория стока, через запятую"},
+		{Name: "keywordCase", Type: "select", Label: "Регистр ключевых слов", Options: []string{"", "lower", "upper", "title"}},
+		{Name: "titleCase", Type: "select", Label: "Регистр названия", Options: []string{"", "lower", "sentence", "title"}},
+	}
+}
+
+// MetadataRulesFor собирает правила стока: Settings поверх правил профиля агентства
+func MetadataRulesFor(config models.StockConfig) MetadataRules {
+	settings := make(map[string]interface{})
+	for _, profile := range agencyProfiles() {
+		if profile.Type == config.Type {
+			for key, value := range profile.Rules {
+				settings[key] = value
+			}
+		}
+	}
+	for _, key := range metadataRuleKeys {
+		if value, ok := config.Settings[key]; ok && value != nil && value != "" {
+			settings[key] = value
+		}
+	}
+
+	return MetadataRules{
+		MaxKeywords:          settingInt(settings["maxKeywords"]),
+		SupplementaryAfter:   settingInt(settings["supplementaryKeywordsAfter"]),
+		MaxTitleLength:       settingInt(settings["maxTitleLength"]),
+		MaxDescriptionLength: settingInt(settings["maxDescriptionLength"]),
+		TitleForbiddenChars:  settingString(settings["titleForbiddenChars"]),
+		ForbiddenWords:       settingStrings(settings["forbiddenWords"]),
+		RequiredKeywords:     settingStrings(settings["requiredKeywords"]),
+		CategoryMap:          settingStringMap(settings["categoryMap"]),
+		KeywordCase:          settingString(settings["keywordCase"]),
+		TitleCase:            settingString(settings["titleCase"]),
+	}
+}
+
+// ApplyMetadataRules возвращает копию фото с метаданными, подготовленными для стока.
+// Если для стока созданы отдельные метаданные по его промпту, правила применяются к ним.
+// AIResult исходного фото не меняется.
+func ApplyMetadataRules(photo models.Photo, config models.StockConfig) models.Photo {
+	if stockResult := photo.StockAIResults[config.ID]; stockResult != nil {
+		photo.AIResult = stockResult
+	}
+	if photo.AIResult == nil {
+		return photo
+	}
+
+	result := MetadataRulesFor(config).Apply(*photo.AIResult)
+	photo.AIResult = &result
+	return photo
+}
+
+// Apply применяет правила к копии метаданных
+func (r MetadataRules) Apply(ai models.AIResult) models.AIResult {
+	forbidden := make(map[string]bool, len(r.ForbiddenWords))
+	for _, word := range r.ForbiddenWords {
+		forbidden[strings.ToLower(word)] = true
+	}
+
+	// Название
+	title := removeForbiddenWords(ai.Title, r.ForbiddenWords)
+	for _, char := range r.TitleForbiddenChars {
+		title = strings.ReplaceAll(title, string(char), " ")
+	}
+	title = applyTextCase(strings.Join(strings.Fields(title), " "), r.TitleCase)
+	ai.Title = truncateAtWord(title, r.MaxTitleLength)
+
+	// Описание
+	description := strings.Join(strings.Fields(removeForbiddenWords(ai.Description, r.ForbiddenWords)), " ")
+	ai.Description = truncateAtWord(description, r.MaxDescriptionLength)
+
+	// Ключевые слова: обязательные в начале, без запрещенных и повторов
+	keywords := make([]string, 0, len(r.RequiredKeywords)+len(ai.Keywords)+len(ai.SupplementaryKeywords))
+	seen := make(map[string]bool)
+	for _, keyword := range append(append(append([]string{}, r.RequiredKeywords...), ai.Keywords...), ai.SupplementaryKeywords...) {
+		keyword = applyKeywordCase(strings.TrimSpace(keyword), r.KeywordCase)
+		key := strings.ToLower(keyword)
+		if keyword == "" || seen[key] || forbidden[key] {
+			continue
+		}
+		seen[key] = true
+		keywords = append(keywords, keyword)
+	}
+
+	if r.MaxKeywords > 0 && len(keywords) > r.MaxKeywords {
+		keywords = keywords[:r.MaxKeywords]
+	}
+	ai.Keywords = keywords
+	ai.SupplementaryKeywords = nil
+	if r.SupplementaryAfter > 0 && len(keywords) > r.SupplementaryAfter {
+		ai.Keywords = keywords[:r.SupplementaryAfter:r.SupplementaryAfter]
+		ai.SupplementaryKeywords = keywords[r.SupplementaryAfter:]
+	}
+
+	// Категория
+	if mapped, ok := r.CategoryMap[strings.ToLower(strings.TrimSpace(ai.Category))]; ok {
+		ai.Category = mapped
+	}
+
+	return ai
+}
+
+// removeForbiddenWords убирает запрещенные слова целиком, без учета регистра
+func removeForbiddenWords(text string, words []string) string {
+	for _, word := range words {
+		if word == "" {
+			continue
+		}
+		pattern := regexp.MustCompile(`(?i)(^|[^\p{L}\p{N}])` + regexp.QuoteMeta(word) + `($|[^\p{L}\p{N}])`)
+		// Повторяем, пока есть совпадения: соседние вхождения делят разделитель
+		for pattern.MatchString(text) {
+			text = pattern.ReplaceAllString(text, "$1$2")
+		}
+	}
+	return text
+}
+
+// truncateAtWord обрезает текст до limit символов по границе слова
+func truncateAtWord(text string, limit int) string {
+	if limit <= 0 || utf8.RuneCountInString(text) <= limit {
+		return text
+	}
+
+	runes := []rune(text)[:limit]
+	cut := strings.LastIndexFunc(string(runes), unicode.IsSpace)
+	if cut > len(string(runes))/2 {
+		return strings.TrimRightFunc(string(runes)[:cut], func(r rune) bool {
+			return unicode.IsSpace(r) || unicode.IsPunct(r)
+		})
+	}
+	return string(runes)
+}
+
+// applyKeywordCase приводит ключевое слово к регистру "lower", "upper" или "title"
+func applyKeywordCase(keyword, mode string) string {
+	switch mode {
+	case "lower":
+		return strings.ToLower(keyword)
+	case "upper":
+		return strings.ToUpper(keyword)
+	case "title":
+		return titleWords(strings.ToLower(keyword))
+	}
+	return keyword
+}
+
+// applyTextCase приводит название к регистру "lower", "sentence" или "title"
+func applyTextCase(text, mode string) string {
+	switch mode {
+	case "lower":
+		return strings.ToLower(text)
+	case "sentence":
+		lower := []rune(strings.ToLower(text))
+		if len(lower) > 0 {
+			lower[0] = unicode.ToUpper(lower[0])
+		}
+		return string(lower)
+	case "title":
+		return titleWords(text)
+	}
+	return text
+}
+
+// titleWords переводит первую букву каждого слова в верхний регистр
+func titleWords(text string) string {
+	words := strings.Fields(text)
+	for i, word := range words {
+		runes := []rune(word)
+		runes[0] = unicode.ToUpper(runes[0])
+		words[i] = string(runes)
+	}
+	return strings.Join(words, " ")
+}
+
+// settingInt читает число из настроек: JSON присылает float64, шаблоны - int, формы - строку
+func settingInt(value interface{}) int {
+	switch v := value.(type) {
+	case float64:
+		return int(v)
+	case int:
+		return v
+	case string:
+		if parsed, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+			return parsed
+		}
+	}
+	return 0
+}
+
+func settingString(value interface{}) string {
+	if v, ok := value.(string); ok {
+		return v
+	}
+	return ""
+}
+
+// settingStrings читает список из массива или строки через запятую
+func settingStrings(value interface{}) []string {
+	var items []string
+	switch v := value.(type) {
+	case []string:
+		items = v
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				items = append(items, s)
+			}
+		}
+	case string:
+		items = strings.Split(v, ",")
+	}
+
+	var result []string
+	for _, item := range items {
+		if item = strings.TrimSpace(item); item != "" {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// settingStringMap читает таблицу соответствия из объекта или строк вида "from=to" через запятую.
+// Ключи приводятся к нижнему регистру.
+func settingStringMap(value interface{}) map[string]string {
+	result := make(map[string]string)
+	switch v := value.(type) {
+	case map[string]string:
+		for from, to := range v {
+			result[strings.ToLower(strings.TrimSpace(from))] = to
+		}
+	case map[string]interface{}:
+		for from, to := range v {
+			if s, ok := to.(string); ok {
+				result[strings.ToLower(strings.TrimSpace(from))] = s
+			}
+		}
+	case string:
+		for _, pair := range strings.Split(v, ",") {
+			if from, to, ok := strings.Cut(pair, "="); ok {
+				result[strings.ToLower(strings.TrimSpace(from))] = strings.TrimSpace(to)
+			}
+		}
+	}
+	return result
+}
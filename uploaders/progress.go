@@ -0,0 +1,77 @@
+package uploaders
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"stock-photo-app/models"
+	"time"
+)
+
+// Как часто progressReader сообщает о прогрессе, чтобы не засыпать UI событиями
+const progressReportInterval = 250 * time.Millisecond
+
+// ProgressFunc получает количество отправленных байт и общий размер загрузки
+type ProgressFunc func(sent, total int64)
+
+// ProgressUploader загрузчик, который умеет сообщать побайтовый прогресс загрузки.
+// Загрузчики без этого интерфейса показываются в UI только статусами.
+type ProgressUploader interface {
+	UploadWithProgress(ctx context.Context, photo models.Photo, config models.StockConfig, progress ProgressFunc) (models.UploadResult, error)
+}
+
+// progressReader считает прочитанные байты и периодически вызывает ProgressFunc
+type progressReader struct {
+	reader     io.Reader
+	sent       int64
+	total      int64
+	progress   ProgressFunc
+	lastReport time.Time
+}
+
+// newProgressReader оборачивает reader. offset - сколько байт уже загружено (при докачке).
+// Если progress не задан, возвращает исходный reader.
+func newProgressReader(reader io.Reader, offset, total int64, progress ProgressFunc) io.Reader {
+	if progress == nil {
+		return reader
+	}
+
+	progress(offset, total)
+	return &progressReader{
+		reader:     reader,
+		sent:       offset,
+		total:      total,
+		progress:   progress,
+		lastReport: time.Now(),
+	}
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.sent += int64(n)
+
+	if err == io.EOF || r.sent >= r.total || time.Since(r.lastReport) >= progressReportInterval {
+		r.progress(r.sent, r.total)
+		r.lastReport = time.Now()
+	}
+
+	return n, err
+}
+
+// contextReader прерывает чтение файла после отмены ctx, чтобы остановка очереди обрывала передачу
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+// newContextReader оборачивает reader, который перестает читать после отмены ctx
+func newContextReader(ctx context.Context, reader io.Reader) io.Reader {
+	return &contextReader{ctx: ctx, reader: reader}
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, fmt.Errorf("загрузка прервана: %w", err)
+	}
+	return r.reader.Read(p)
+}
@@ -0,0 +1,85 @@
+package uploaders
+
+import (
+	"fmt"
+	"path/filepath"
+	"stock-photo-app/models"
+	"strings"
+)
+
+// Виды файлов логического фото (models.PhotoRendition.Kind)
+const (
+	RenditionRAW     = "raw"
+	RenditionJPEG    = "jpeg"
+	RenditionTIFF    = "tiff"
+	RenditionImage   = "image"   // PNG, WEBP, BMP
+	RenditionXMP     = "xmp"     // XMP sidecar RAW файла
+	RenditionRelease = "release" // PDF релиза модели или property release
+)
+
+// Что загружать на сток для фото с RAW файлом (StockConfig.Settings["rawUpload"])
+const (
+	RawUploadJPEG = "jpeg" // обработанный файл: JPEG, TIFF или другое изображение (по умолчанию)
+	RawUploadRAW  = "raw"  // сам RAW и XMP sidecar с метаданными
+)
+
+// Порядок выбора файла для загрузки: сначала JPEG, затем TIFF и другие изображения
+var processedRenditionOrder = []string{RenditionJPEG, RenditionTIFF, RenditionImage}
+
+// RenditionSelector загрузчик, который сам выбирает, какой файл логического фото отправить на сток
+type RenditionSelector interface {
+	SelectRendition(photo models.Photo, config models.StockConfig) (models.PhotoRendition, error)
+}
+
+// SelectRendition выбирает файл фото для стока по настройке rawUpload: по умолчанию JPEG
+// (или другой обработанный файл), в режиме raw - RAW, если он есть. Фото, у которого есть только RAW,
+// в режиме jpeg загрузить нельзя.
+func SelectRendition(photo models.Photo, config models.StockConfig) (models.PhotoRendition, error) {
+	order := processedRenditionOrder
+	if settingString(config.Settings["rawUpload"]) == RawUploadRAW {
+		order = append([]string{RenditionRAW}, processedRenditionOrder...)
+	}
+
+	if rendition, ok := findRendition(photo, order, nil); ok {
+		return rendition, nil
+	}
+	if _, ok := findRendition(photo, []string{RenditionRAW}, nil); ok {
+		return models.PhotoRendition{}, fmt.Errorf("для RAW файла %s нет JPEG с тем же именем; чтобы загружать RAW с XMP sidecar, выберите rawUpload = %s в настройках стока %s",
+			photo.FileName, RawUploadRAW, config.Name)
+	}
+	return models.PhotoRendition{}, fmt.Errorf("у фото %s нет файла для загрузки", photo.FileName)
+}
+
+// SelectRendition выбирает файл фото в формате, который принимает агентство, предпочитая JPEG
+func (u *AgencyUploader) SelectRendition(photo models.Photo, config models.StockConfig) (models.PhotoRendition, error) {
+	order := append(append([]string{}, processedRenditionOrder...), RenditionRAW)
+	if rendition, ok := findRendition(photo, order, u.profile.Formats); ok {
+		return rendition, nil
+	}
+	return models.PhotoRendition{}, fmt.Errorf("у фото %s нет файла в формате, который принимает %s (%s)",
+		photo.FileName, u.profile.Name, strings.Join(u.profile.Formats, ", "))
+}
+
+// findRendition возвращает первый файл фото по порядку видов. formats ограничивает расширения, nil - любые.
+func findRendition(photo models.Photo, kinds []string, formats []string) (models.PhotoRendition, bool) {
+	for _, kind := range kinds {
+		for _, rendition := range photo.Renditions {
+			if rendition.Kind != kind {
+				continue
+			}
+			if formats != nil && !containsString(formats, strings.ToLower(filepath.Ext(rendition.Path))) {
+				continue
+			}
+			return rendition, true
+		}
+	}
+	return models.PhotoRendition{}, false
+}
+
+// RenditionPath возвращает путь к файлу фото указанного вида или пустую строку
+func RenditionPath(photo models.Photo, kind string) string {
+	if rendition, ok := findRendition(photo, []string{kind}, nil); ok {
+		return rendition.Path
+	}
+	return ""
+}
@@ -0,0 +1,56 @@
+package uploaders
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+)
+
+// resumeCheckSize сколько байт в конце недозагруженного файла сверяется с локальным перед докачкой
+const resumeCheckSize = 64 * 1024
+
+// resumeOffset возвращает смещение, с которого можно продолжить загрузку.
+// 0 означает загрузку с начала: удаленного файла нет или он больше локального, то есть это другой файл.
+func resumeOffset(localSize, remoteSize int64) int64 {
+	if remoteSize <= 0 || remoteSize > localSize {
+		return 0
+	}
+	return remoteSize
+}
+
+// resumeCheckRange возвращает начало и длину хвоста удаленного файла, который сверяется перед докачкой
+func resumeCheckRange(offset int64) (int64, int64) {
+	if offset < resumeCheckSize {
+		return 0, offset
+	}
+	return offset - resumeCheckSize, resumeCheckSize
+}
+
+// matchesLocalRange сравнивает SHA-256 участка удаленного файла с тем же участком локального.
+// Защищает от докачки поверх чужого файла с тем же именем.
+func matchesLocalRange(local io.ReaderAt, remote io.Reader, start, length int64) (bool, error) {
+	localHash := sha256.New()
+	if _, err := io.Copy(localHash, io.NewSectionReader(local, start, length)); err != nil {
+		return false, fmt.Errorf("не удается прочитать локальный файл: %w", err)
+	}
+
+	remoteHash := sha256.New()
+	n, err := io.Copy(remoteHash, io.LimitReader(remote, length))
+	if err != nil {
+		return false, fmt.Errorf("не удается прочитать удаленный файл: %w", err)
+	}
+	if n != length {
+		return false, nil
+	}
+
+	return bytes.Equal(localHash.Sum(nil), remoteHash.Sum(nil)), nil
+}
+
+// verifyRemoteSize проверяет, что размер загруженного файла совпадает с локальным
+func verifyRemoteSize(localSize, remoteSize int64) error {
+	if localSize != remoteSize {
+		return NewTransientError(fmt.Errorf("размер загруженного файла %d байт не совпадает с локальным %d байт", remoteSize, localSize))
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package uploaders
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Размеры файла и обрыва передачи: больше resumeCheckSize, чтобы сверялся только хвост
+const (
+	resumeTestFileSize = 300 * 1024
+	resumeTestAbortAt  = 100 * 1024
+)
+
+func TestResumeOffset(t *testing.T) {
+	tests := []struct {
+		localSize, remoteSize, want int64
+	}{
+		{1000, 0, 0},
+		{1000, -1, 0},
+		{1000, 400, 400},
+		{1000, 1000, 1000},
+		{1000, 1200, 0},
+	}
+
+	for _, tt := range tests {
+		if got := resumeOffset(tt.localSize, tt.remoteSize); got != tt.want {
+			t.Errorf("resumeOffset(%d, %d) = %d, want %d", tt.localSize, tt.remoteSize, got, tt.want)
+		}
+	}
+
+	if start, length := resumeCheckRange(1000); start != 0 || length != 1000 {
+		t.Errorf("resumeCheckRange(1000) = %d, %d, want 0, 1000", start, length)
+	}
+	if start, length := resumeCheckRange(resumeTestAbortAt); start != resumeTestAbortAt-resumeCheckSize || length != resumeCheckSize {
+		t.Errorf("resumeCheckRange(%d) = %d, %d", resumeTestAbortAt, start, length)
+	}
+}
+
+func TestFTPUploadResumesWithREST(t *testing.T) {
+	server := newTestFTPServer(t)
+	server.abortAfter = resumeTestAbortAt
+	uploader := NewFTPUploader(newTestDatabase())
+	photo, data := writeTestFile(t, "IMG_0001.TIF", resumeTestFileSize)
+
+	if _, err := uploader.Upload(context.Background(), photo, server.config()); err == nil {
+		t.Fatal("first upload must fail after the transfer is cut off")
+	}
+	if partial, _ := server.file("/IMG_0001.TIF"); len(partial) != resumeTestAbortAt {
+		t.Fatalf("partial file size = %d, want %d", len(partial), resumeTestAbortAt)
+	}
+
+	var lastSent int64
+	result, err := uploader.UploadWithProgress(context.Background(), photo, server.config(), func(sent, total int64) {
+		lastSent = sent
+	})
+	if err != nil || !result.Success {
+		t.Fatalf("resumed upload failed: %v (%s)", err, result.Message)
+	}
+
+	if !server.received("REST 102400") {
+		t.Error("resumed upload did not send REST with the partial size")
+	}
+	if lastSent != resumeTestFileSize {
+		t.Errorf("progress ended at %d bytes, want %d", lastSent, resumeTestFileSize)
+	}
+	assertUploaded(t, server, "/IMG_0001.TIF", data)
+}
+
+func TestFTPUploadResumesWithAPPE(t *testing.T) {
+	server := newTestFTPServer(t)
+	server.disableREST = true
+	server.abortAfter = resumeTestAbortAt
+	uploader := NewFTPUploader(newTestDatabase())
+	photo, data := writeTestFile(t, "IMG_0002.TIF", resumeTestFileSize)
+
+	if _, err := uploader.Upload(context.Background(), photo, server.config()); err == nil {
+		t.Fatal("first upload must fail after the transfer is cut off")
+	}
+
+	result, err := uploader.Upload(context.Background(), photo, server.config())
+	if err != nil || !result.Success {
+		t.Fatalf("resumed upload failed: %v (%s)", err, result.Message)
+	}
+
+	if !server.received("APPE IMG_0002.TIF") {
+		t.Error("resumed upload did not fall back to APPE")
+	}
+	assertUploaded(t, server, "/IMG_0002.TIF", data)
+}
+
+func TestFTPUploadRestartsForeignPartialFile(t *testing.T) {
+	server := newTestFTPServer(t)
+	uploader := NewFTPUploader(newTestDatabase())
+	photo, data := writeTestFile(t, "IMG_0003.TIF", resumeTestFileSize)
+
+	// На сервере лежит другой файл с тем же именем: докачка поверх него испортила бы фото
+	foreign := append([]byte(nil), data[:resumeTestAbortAt]...)
+	foreign[resumeTestAbortAt-1] ^= 0xFF
+	server.setFile("/IMG_0003.TIF", foreign)
+
+	result, err := uploader.Upload(context.Background(), photo, server.config())
+	if err != nil || !result.Success {
+		t.Fatalf("upload failed: %v (%s)", err, result.Message)
+	}
+
+	if server.received("REST 102400") || server.received("APPE") {
+		t.Error("upload resumed on top of a file with different content")
+	}
+	assertUploaded(t, server, "/IMG_0003.TIF", data)
+}
+
+func TestFTPUploadAbortsOnCancelWhenServerHangs(t *testing.T) {
+	server := newTestFTPServer(t)
+	server.stall = true
+	uploader := NewFTPUploader(newTestDatabase())
+	photo, _ := writeTestFile(t, "IMG_0005.TIF", resumeTestFileSize)
+
+	config := server.config()
+	config.Connection.Timeout = 60
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := uploader.Upload(ctx, photo, config)
+		done <- err
+	}()
+
+	for !server.received("STOR") {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Error("cancelled upload must fail")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("upload did not stop after cancel while the server was not responding")
+	}
+}
+
+func TestSFTPUploadResumesWithAppend(t *testing.T) {
+	server := newTestSFTPServer(t)
+	server.abortAfter = resumeTestAbortAt
+	db := newTestDatabase()
+	uploader := NewSFTPUploader(db)
+	photo, data := writeTestFile(t, "IMG_0004.TIF", resumeTestFileSize)
+
+	config := server.config()
+	config.Connection.HostKeyFingerprint = server.fingerprint
+
+	if _, err := uploader.Upload(context.Background(), photo, config); err == nil {
+		t.Fatal("first upload must fail after the transfer is cut off")
+	}
+	if partial := server.file(t, "/upload/IMG_0004.TIF"); len(partial) != resumeTestAbortAt {
+		t.Fatalf("partial file size = %d, want %d", len(partial), resumeTestAbortAt)
+	}
+
+	result, err := uploader.Upload(context.Background(), photo, config)
+	if err != nil || !result.Success {
+		t.Fatalf("resumed upload failed: %v (%s)", err, result.Message)
+	}
+
+	if !db.hasEvent("progress", "Продолжение загрузки IMG_0004.TIF с 102400") {
+		t.Error("second upload did not resume from the partial file")
+	}
+	uploaded := server.file(t, "/upload/IMG_0004.TIF")
+	if len(uploaded) != len(data) || checksum(uploaded) != checksum(data) {
+		t.Errorf("uploaded file: %d bytes, sha256 %s; want %d bytes, sha256 %s",
+			len(uploaded), checksum(uploaded), len(data), checksum(data))
+	}
+}
+
+// assertUploaded проверяет размер и SHA-256 файла на FTP сервере
+func assertUploaded(t *testing.T, server *testFTPServer, name string, want []byte) {
+	t.Helper()
+
+	uploaded, ok := server.file(name)
+	if !ok {
+		t.Fatalf("%s was not uploaded", name)
+	}
+	if len(uploaded) != len(want) || checksum(uploaded) != checksum(want) {
+		t.Errorf("uploaded %s: %d bytes, sha256 %s; want %d bytes, sha256 %s",
+			name, len(uploaded), checksum(uploaded), len(want), checksum(want))
+	}
+}
@@ -0,0 +1,196 @@
+package uploaders
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"stock-photo-app/models"
+	"sync"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSFTPServer SSH сервер с SFTP подсистемой на файловой системе в памяти (sftp.InMemHandler)
+type testSFTPServer struct {
+	listener    net.Listener
+	handlers    sftp.Handlers
+	fingerprint string
+
+	mu sync.Mutex
+	// abortAfter следующая загрузка сохраняет только столько байт, затем запись завершается ошибкой; 0 - без обрыва
+	abortAfter int64
+}
+
+// newTestSFTPServer запускает SFTP сервер на случайном порту 127.0.0.1
+func newTestSFTPServer(t *testing.T) *testSFTPServer {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sshConfig := &ssh.ServerConfig{
+		PasswordCallback: func(meta ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if meta.User() == testUser && string(password) == testPassword {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected for %s", meta.User())
+		},
+	}
+	sshConfig.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	server := &testSFTPServer{
+		listener:    listener,
+		handlers:    sftp.InMemHandler(),
+		fingerprint: ssh.FingerprintSHA256(signer.PublicKey()),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go server.serve(conn, sshConfig)
+		}
+	}()
+
+	return server
+}
+
+// config возвращает конфигурацию стока, подключающуюся к серверу
+func (s *testSFTPServer) config() models.StockConfig {
+	return models.StockConfig{
+		ID:   "sftp-stock",
+		Name: "Test SFTP",
+		Type: "sftp",
+		Connection: models.ConnectionConfig{
+			Host:     "127.0.0.1",
+			Port:     s.listener.Addr().(*net.TCPAddr).Port,
+			Username: testUser,
+			Password: testPassword,
+			Path:     "/upload",
+			Timeout:  5,
+		},
+	}
+}
+
+// file читает файл с сервера
+func (s *testSFTPServer) file(t *testing.T, name string) []byte {
+	t.Helper()
+
+	request := sftp.NewRequest("Get", name)
+	request.Flags = 1 // SSH_FXF_READ
+	reader, err := s.handlers.FileGet.Fileread(request)
+	if err != nil {
+		t.Fatalf("failed to open %s on SFTP server: %v", name, err)
+	}
+
+	var data []byte
+	buf := make([]byte, 32*1024)
+	for offset := int64(0); ; {
+		n, err := reader.ReadAt(buf, offset)
+		data = append(data, buf[:n]...)
+		offset += int64(n)
+		if err == io.EOF {
+			return data
+		}
+		if err != nil {
+			t.Fatalf("failed to read %s on SFTP server: %v", name, err)
+		}
+	}
+}
+
+func (s *testSFTPServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer serverConn.Close()
+	go ssh.DiscardRequests(requests)
+
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unknown channel type")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			for request := range channelRequests {
+				ok := request.Type == "subsystem" && string(request.Payload[4:]) == "sftp"
+				request.Reply(ok, nil)
+				if !ok {
+					continue
+				}
+
+				handlers := s.handlers
+				handlers.FilePut = &abortingFilePut{server: s, next: s.handlers.FilePut}
+				server := sftp.NewRequestServer(channel, handlers)
+				go func() {
+					server.Serve()
+					server.Close()
+				}()
+			}
+		}()
+	}
+}
+
+// abortingFilePut обрывает запись файла после abortAfter байт, имитируя разрыв соединения
+type abortingFilePut struct {
+	server *testSFTPServer
+	next   sftp.FileWriter
+}
+
+func (p *abortingFilePut) Filewrite(request *sftp.Request) (io.WriterAt, error) {
+	writer, err := p.next.Filewrite(request)
+	if err != nil {
+		return nil, err
+	}
+
+	p.server.mu.Lock()
+	limit := p.server.abortAfter
+	p.server.abortAfter = 0
+	p.server.mu.Unlock()
+
+	if limit == 0 {
+		return writer, nil
+	}
+	return &limitedWriterAt{writer: writer, limit: limit}, nil
+}
+
+// limitedWriterAt записывает только первые limit байт файла
+type limitedWriterAt struct {
+	writer io.WriterAt
+	limit  int64
+}
+
+func (w *limitedWriterAt) WriteAt(p []byte, offset int64) (int, error) {
+	if offset >= w.limit {
+		return 0, fmt.Errorf("connection lost")
+	}
+	if offset+int64(len(p)) > w.limit {
+		n, _ := w.writer.WriteAt(p[:w.limit-offset], offset)
+		return n, fmt.Errorf("connection lost")
+	}
+	return w.writer.WriteAt(p, offset)
+}
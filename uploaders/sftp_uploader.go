@@ -1,6 +1,8 @@
 package uploaders
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -35,8 +37,14 @@ func NewSFTPUploader(dbService DatabaseService) *SFTPUploader {
 	}
 }
 
-// Upload загружает фото через SFTP
-func (u *SFTPUploader) Upload(photo models.Photo, config models.StockConfig) (models.UploadResult, error) {
+// Upload загружает фото через SFTP.
+// Повторы выполняет очередь загрузки, ошибки классифицируются для ее политики повторов.
+func (u *SFTPUploader) Upload(ctx context.Context, photo models.Photo, config models.StockConfig) (models.UploadResult, error) {
+	return u.UploadWithProgress(ctx, photo, config, nil)
+}
+
+// UploadWithProgress загружает фото через SFTP, сообщая количество отправленных байт
+func (u *SFTPUploader) UploadWithProgress(ctx context.Context, photo models.Photo, config models.StockConfig, progress ProgressFunc) (models.UploadResult, error) {
 	// Логируем начало загрузки
 	u.dbService.LogEvent(photo.BatchID, photo.ID, "sftp_upload", "started",
 		fmt.Sprintf("Начата загрузка фото %s на %s", photo.FileName, config.Name), "", 0)
@@ -44,6 +52,14 @@ func (u *SFTPUploader) Upload(photo models.Photo, config models.StockConfig) (mo
 	// Подключаемся к SFTP серверу
 	sftpClient, sshClient, err := u.connect(config)
 	if err != nil {
+		var mismatchErr *HostKeyMismatchError
+		if errors.As(err, &mismatchErr) {
+			u.dbService.LogEvent(photo.BatchID, photo.ID, "sftp_upload", "host_key_mismatch",
+				fmt.Sprintf("Ключ SFTP сервера %s для %s изменился, загрузка остановлена", config.Connection.Host, config.Name),
+				fmt.Sprintf("ожидался %s, получен %s", mismatchErr.Expected, mismatchErr.Actual), 0)
+			return u.CreateUploadResult(photo.ID, config.ID, err.Error(), false), err
+		}
+
 		u.dbService.LogEvent(photo.BatchID, photo.ID, "sftp_upload", "failed",
 			fmt.Sprintf("Ошибка подключения к SFTP %s", config.Connection.Host), err.Error(), 0)
 		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка подключения к SFTP: %v", err), false), err
@@ -51,12 +67,16 @@ func (u *SFTPUploader) Upload(photo models.Photo, config models.StockConfig) (mo
 	defer sftpClient.Close()
 	defer sshClient.Close()
 
+	// Отмена ctx закрывает SSH соединение и обрывает передачу
+	stop := context.AfterFunc(ctx, func() { sshClient.Close() })
+	defer stop()
+
 	// Открываем локальный файл
 	localFile, err := os.Open(photo.OriginalPath)
 	if err != nil {
 		u.dbService.LogEvent(photo.BatchID, photo.ID, "sftp_upload", "failed",
 			"Ошибка открытия файла", err.Error(), 0)
-		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка открытия файла: %v", err), false), err
+		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка открытия файла: %v", err), false), NewPermanentError(err)
 	}
 	defer localFile.Close()
 
@@ -72,23 +92,70 @@ func (u *SFTPUploader) Upload(photo models.Photo, config models.StockConfig) (mo
 		}
 	}
 
-	log.Printf("Uploading %s to %s", photo.FileName, remotePath)
-
-	// Создаем удаленный файл
-	remoteFile, err := sftpClient.Create(remotePath)
+	fileInfo, err := localFile.Stat()
 	if err != nil {
-		u.dbService.LogEvent(photo.BatchID, photo.ID, "sftp_upload", "failed",
-			fmt.Sprintf("Ошибка создания удаленного файла %s", remotePath), err.Error(), 0)
-		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка создания удаленного файла: %v", err), false), err
+		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка чтения файла: %v", err), false), NewPermanentError(err)
 	}
-	defer remoteFile.Close()
+	localSize := fileInfo.Size()
+
+	// Проверяем, не осталась ли на сервере часть файла от прерванной попытки
+	offset := u.resumeOffset(sftpClient, localFile, remotePath, localSize)
+
+	if offset == localSize {
+		log.Printf("SFTP: File %s already fully uploaded, skipping transfer", photo.FileName)
+		if progress != nil {
+			progress(localSize, localSize)
+		}
+	} else {
+		var remoteFile *sftp.File
+		if offset > 0 {
+			log.Printf("SFTP: Resuming upload of %s to %s from byte %d of %d", photo.FileName, remotePath, offset, localSize)
+			u.dbService.LogEvent(photo.BatchID, photo.ID, "sftp_upload", "progress",
+				fmt.Sprintf("Продолжение загрузки %s с %d из %d байт", photo.FileName, offset, localSize), "", int(offset*100/localSize))
 
-	// Копируем содержимое файла
-	_, err = io.Copy(remoteFile, localFile)
+			// Открываем удаленный файл на дозапись
+			remoteFile, err = sftpClient.OpenFile(remotePath, os.O_WRONLY|os.O_APPEND)
+			if err == nil {
+				_, err = remoteFile.Seek(offset, io.SeekStart)
+			}
+		} else {
+			log.Printf("Uploading %s to %s", photo.FileName, remotePath)
+			remoteFile, err = sftpClient.Create(remotePath)
+		}
+		if err != nil {
+			if remoteFile != nil {
+				remoteFile.Close()
+			}
+			u.dbService.LogEvent(photo.BatchID, photo.ID, "sftp_upload", "failed",
+				fmt.Sprintf("Ошибка открытия удаленного файла %s", remotePath), err.Error(), 0)
+			return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка открытия удаленного файла: %v", err), false), classifySSHError(err)
+		}
+
+		// Копируем содержимое файла с места остановки
+		_, err = localFile.Seek(offset, io.SeekStart)
+		if err == nil {
+			_, err = io.Copy(remoteFile, newProgressReader(newContextReader(ctx, localFile), offset, localSize, progress))
+		}
+		closeErr := remoteFile.Close()
+		if err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			u.dbService.LogEvent(photo.BatchID, photo.ID, "sftp_upload", "failed",
+				fmt.Sprintf("Ошибка загрузки файла %s", photo.FileName), err.Error(), 0)
+			return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка загрузки файла: %v", err), false), classifySSHError(err)
+		}
+	}
+
+	// Сверяем размер загруженного файла
+	remoteInfo, err := sftpClient.Stat(remotePath)
+	if err == nil {
+		err = verifyRemoteSize(localSize, remoteInfo.Size())
+	}
 	if err != nil {
 		u.dbService.LogEvent(photo.BatchID, photo.ID, "sftp_upload", "failed",
-			fmt.Sprintf("Ошибка загрузки файла %s", photo.FileName), err.Error(), 0)
-		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Ошибка загрузки файла: %v", err), false), err
+			fmt.Sprintf("Проверка загрузки %s не пройдена", photo.FileName), err.Error(), 0)
+		return u.CreateUploadResult(photo.ID, config.ID, fmt.Sprintf("Проверка загрузки не пройдена: %v", err), false), classifySSHError(err)
 	}
 
 	// Логируем успешную загрузку
@@ -98,6 +165,41 @@ func (u *SFTPUploader) Upload(photo models.Photo, config models.StockConfig) (mo
 	return u.CreateUploadResult(photo.ID, config.ID, successMessage, true), nil
 }
 
+// resumeOffset определяет, с какого байта продолжить загрузку по размеру файла на сервере.
+// Хвост недозагруженного файла сверяется с локальным, при расхождении файл загружается заново.
+func (u *SFTPUploader) resumeOffset(client *sftp.Client, localFile *os.File, remotePath string, localSize int64) int64 {
+	remoteInfo, err := client.Stat(remotePath)
+	if err != nil {
+		return 0
+	}
+
+	offset := resumeOffset(localSize, remoteInfo.Size())
+	if offset == 0 {
+		return 0
+	}
+
+	remoteFile, err := client.Open(remotePath)
+	if err != nil {
+		log.Printf("SFTP: Cannot read partial file %s, uploading from scratch: %v", remotePath, err)
+		return 0
+	}
+	defer remoteFile.Close()
+
+	start, length := resumeCheckRange(offset)
+	matches, err := matchesLocalRange(localFile, io.NewSectionReader(remoteFile, start, length), start, length)
+	if err != nil || !matches {
+		log.Printf("SFTP: Partial file %s does not match local file, uploading from scratch", remotePath)
+		return 0
+	}
+
+	return offset
+}
+
+// UploadFile загружает служебный файл (CSV метаданных) так же, как фото
+func (u *SFTPUploader) UploadFile(ctx context.Context, file models.Photo, config models.StockConfig) (models.UploadResult, error) {
+	return u.Upload(ctx, file, config)
+}
+
 // TestConnection тестирует подключение к SFTP серверу
 func (u *SFTPUploader) TestConnection(config models.StockConfig) error {
 	sftpClient, sshClient, err := u.connect(config)
@@ -122,10 +224,20 @@ func (u *SFTPUploader) TestConnection(config models.StockConfig) error {
 	return nil
 }
 
-// ValidateConfig проверяет конфигурацию SFTP
+// ValidateConfig проверяет конфигурацию SFTP.
+// Пароль не обязателен, если задан приватный ключ или ssh-agent.
 func (u *SFTPUploader) ValidateConfig(config models.StockConfig) error {
-	requiredFields := []string{"host", "username", "password", "port"}
-	return u.ValidateRequiredFields(config, requiredFields)
+	requiredFields := []string{"host", "username", "port"}
+	if err := u.ValidateRequiredFields(config, requiredFields); err != nil {
+		return err
+	}
+
+	connection := config.Connection
+	if connection.Password == "" && connection.PrivateKey == "" && connection.PrivateKeyPath == "" && !connection.UseAgent {
+		return fmt.Errorf("укажите пароль, приватный ключ или включите ssh-agent")
+	}
+
+	return nil
 }
 
 // connect подключается к SFTP серверу
@@ -136,13 +248,20 @@ func (u *SFTPUploader) connect(config models.StockConfig) (*sftp.Client, *ssh.Cl
 		timeout = 30 * time.Second
 	}
 
-	// Настройка SSH клиента
+	// Способы авторизации: ssh-agent, приватный ключ, пароль
+	authMethods, agentConn, err := sshAuthMethods(config)
+	if err != nil {
+		return nil, nil, err
+	}
+	if agentConn != nil {
+		defer agentConn.Close()
+	}
+
+	// Настройка SSH клиента, ключ сервера проверяется по закрепленному отпечатку
 	sshConfig := &ssh.ClientConfig{
-		User: config.Connection.Username,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(config.Connection.Password),
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // В продакшене следует использовать проверку ключей
+		User:            config.Connection.Username,
+		Auth:            authMethods,
+		HostKeyCallback: u.hostKeyCallback(config),
 		Timeout:         timeout,
 	}
 
@@ -150,14 +269,14 @@ func (u *SFTPUploader) connect(config models.StockConfig) (*sftp.Client, *ssh.Cl
 	addr := fmt.Sprintf("%s:%d", config.Connection.Host, config.Connection.Port)
 	sshClient, err := ssh.Dial("tcp", addr, sshConfig)
 	if err != nil {
-		return nil, nil, fmt.Errorf("не удается подключиться к %s: %v", addr, err)
+		return nil, nil, classifySSHError(fmt.Errorf("не удается подключиться к %s: %w", addr, err))
 	}
 
 	// Создаем SFTP клиент
 	sftpClient, err := sftp.NewClient(sshClient)
 	if err != nil {
 		sshClient.Close()
-		return nil, nil, fmt.Errorf("не удается создать SFTP клиент: %v", err)
+		return nil, nil, NewTransientError(fmt.Errorf("не удается создать SFTP клиент: %w", err))
 	}
 
 	return sftpClient, sshClient, nil
@@ -0,0 +1,35 @@
+package uploaders
+
+// shutterstockProfile прием файлов Shutterstock Contributor через FTPS.
+// Для входа используются email и пароль аккаунта контрибьютора.
+var shutterstockProfile = agencyProfile{
+	Type:        "shutterstock",
+	Name:        "Shutterstock",
+	Description: "Загрузка на Shutterstock Contributor через FTPS, метаданные из IPTC файла",
+	Website:     "https://submit.shutterstock.com/",
+	Protocols:   []string{"ftp"},
+	Hosts: map[string]string{
+		"ftp": "ftps.shutterstock.com",
+	},
+	Ports: map[string]int{
+		"ftp": 21,
+	},
+	Encryption:     "explicit",
+	Formats:        []string{".jpg", ".jpeg"},
+	MinMegapixels:  4,
+	MaxFileSize:    50 * 1024 * 1024,
+	MaxConnections: 2,
+	UsernameHelp:   "Email аккаунта Shutterstock Contributor",
+	CSVFormat:      "shutterstock",
+	Rules: map[string]interface{}{
+		"maxKeywords":          50,
+		"maxTitleLength":       200,
+		"maxDescriptionLength": 200,
+		"titleForbiddenChars":  ",",
+	},
+}
+
+// NewShutterstockUploader создает загрузчик Shutterstock
+func NewShutterstockUploader(dbService DatabaseService) *AgencyUploader {
+	return newAgencyUploader(shutterstockProfile, dbService)
+}
@@ -0,0 +1,133 @@
+package uploaders
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"stock-photo-app/models"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// HostKeyMismatchError ключ SFTP сервера не совпадает с закрепленным в StockConfig.
+// Это может быть переустановка сервера или атака "человек посередине", поэтому загрузка останавливается.
+type HostKeyMismatchError struct {
+	Host     string
+	Expected string
+	Actual   string
+}
+
+func (e *HostKeyMismatchError) Error() string {
+	return fmt.Sprintf("ключ сервера %s изменился: ожидался %s, получен %s. Если сервер действительно сменил ключ, очистите отпечаток в настройках стока",
+		e.Host, e.Expected, e.Actual)
+}
+
+// sshAuthMethods собирает способы авторизации из конфигурации: ssh-agent, приватный ключ, пароль.
+// Возвращает io.Closer соединения с агентом, его нужно закрыть после установки SSH соединения.
+func sshAuthMethods(config models.StockConfig) ([]ssh.AuthMethod, io.Closer, error) {
+	var methods []ssh.AuthMethod
+	var agentConn io.Closer
+
+	if config.Connection.UseAgent {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, nil, NewPermanentError(fmt.Errorf("ssh-agent не запущен: переменная SSH_AUTH_SOCK не задана"))
+		}
+
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, nil, NewTransientError(fmt.Errorf("не удается подключиться к ssh-agent: %w", err))
+		}
+		agentConn = conn
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	if config.Connection.PrivateKey != "" || config.Connection.PrivateKeyPath != "" {
+		signer, err := parsePrivateKey(config.Connection)
+		if err != nil {
+			if agentConn != nil {
+				agentConn.Close()
+			}
+			return nil, nil, NewPermanentError(err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if config.Connection.Password != "" {
+		methods = append(methods, ssh.Password(config.Connection.Password))
+	}
+
+	if len(methods) == 0 {
+		return nil, nil, NewPermanentError(fmt.Errorf("не задан способ авторизации: укажите пароль, приватный ключ или ssh-agent"))
+	}
+
+	return methods, agentConn, nil
+}
+
+// parsePrivateKey читает приватный ключ PEM/OpenSSH из конфигурации или файла
+func parsePrivateKey(connection models.ConnectionConfig) (ssh.Signer, error) {
+	keyData := []byte(connection.PrivateKey)
+	if len(keyData) == 0 {
+		keyPath := connection.PrivateKeyPath
+		if strings.HasPrefix(keyPath, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				keyPath = filepath.Join(home, keyPath[2:])
+			}
+		}
+
+		data, err := os.ReadFile(keyPath)
+		if err != nil {
+			return nil, fmt.Errorf("не удается прочитать приватный ключ %s: %w", keyPath, err)
+		}
+		keyData = data
+	}
+
+	var signer ssh.Signer
+	var err error
+	if connection.Passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, []byte(connection.Passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(keyData)
+	}
+
+	var missingErr *ssh.PassphraseMissingError
+	if errors.As(err, &missingErr) {
+		return nil, fmt.Errorf("приватный ключ зашифрован, укажите пароль от ключа")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("неверный приватный ключ: %w", err)
+	}
+
+	return signer, nil
+}
+
+// hostKeyCallback проверяет ключ сервера по отпечатку из StockConfig.
+// При первом подключении (отпечаток не задан) ключ закрепляется и сохраняется в конфигурацию стока.
+func (u *SFTPUploader) hostKeyCallback(config models.StockConfig) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		pinned := config.Connection.HostKeyFingerprint
+
+		if pinned == "" {
+			log.Printf("SFTP: Trusting host key %s for %s on first use", fingerprint, hostname)
+			if config.ID != "" {
+				if err := u.dbService.SaveStockHostKey(config.ID, fingerprint); err != nil {
+					log.Printf("Warning: failed to pin host key for stock %s: %v", config.ID, err)
+				}
+			}
+			return nil
+		}
+
+		if pinned != fingerprint {
+			return &HostKeyMismatchError{Host: hostname, Expected: pinned, Actual: fingerprint}
+		}
+
+		return nil
+	}
+}